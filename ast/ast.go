@@ -2,6 +2,8 @@ package ast
 
 import (
 	"bytes"
+	"math/big"
+	"strings"
 
 	"github.com/oohira/monkey/token"
 )
@@ -41,15 +43,17 @@ func (p *Program) TokenLiteral() string {
 func (p *Program) String() string {
 	var out bytes.Buffer
 	for _, stmt := range p.Statements {
-		out.WriteString(stmt.String())
+		out.WriteString(str(stmt))
 	}
 	return out.String()
 }
 
 // LetStatement represents a let statement.
+// Type is the optional type annotation of the name, or nil.
 type LetStatement struct {
 	Token token.Token // token.LET
 	Name  *Identifier
+	Type  TypeExpression
 	Value Expression
 }
 
@@ -63,11 +67,12 @@ func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
 	out.WriteString(ls.TokenLiteral() + " ")
-	out.WriteString(ls.Name.String())
-	out.WriteString(" = ")
-	if ls.Value != nil {
-		out.WriteString(ls.Value.String())
+	out.WriteString(str(ls.Name))
+	if ls.Type != nil {
+		out.WriteString(": " + str(ls.Type))
 	}
+	out.WriteString(" = ")
+	out.WriteString(str(ls.Value))
 	out.WriteString(";")
 
 	return out.String()
@@ -92,9 +97,7 @@ func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 
 	out.WriteString(rs.TokenLiteral() + " ")
-	if rs.ReturnValue != nil {
-		out.WriteString(rs.ReturnValue.String())
-	}
+	out.WriteString(str(rs.ReturnValue))
 	out.WriteString(";")
 
 	return out.String()
@@ -116,14 +119,33 @@ func (es *ExpressionStatement) TokenLiteral() string {
 
 // String returns a text representation of the expression statement.
 func (es *ExpressionStatement) String() string {
+	return str(es.Expression)
+}
+
+func (es *ExpressionStatement) statementNode() {
+}
+
+// BlockStatement represents a block of statements enclosed in braces.
+type BlockStatement struct {
+	Token      token.Token // token.LBRACE
+	Statements []Statement
+}
+
+// TokenLiteral returns the first token literal of the block statement.
+func (bs *BlockStatement) TokenLiteral() string {
+	return bs.Token.Literal
+}
+
+// String returns a text representation of the block statement.
+func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
-	if es.Expression != nil {
-		out.WriteString(es.Expression.String())
+	for _, s := range bs.Statements {
+		out.WriteString(str(s))
 	}
 	return out.String()
 }
 
-func (es *ExpressionStatement) statementNode() {
+func (bs *BlockStatement) statementNode() {
 }
 
 // PrefixExpression represents an expression with prefix operator.
@@ -144,7 +166,7 @@ func (pe *PrefixExpression) String() string {
 
 	out.WriteString("(")
 	out.WriteString(pe.Operator)
-	out.WriteString(pe.Right.String())
+	out.WriteString(str(pe.Right))
 	out.WriteString(")")
 
 	return out.String()
@@ -171,9 +193,9 @@ func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
 	out.WriteString("(")
-	out.WriteString(ie.Left.String())
+	out.WriteString(str(ie.Left))
 	out.WriteString(" " + ie.Operator + " ")
-	out.WriteString(ie.Right.String())
+	out.WriteString(str(ie.Right))
 	out.WriteString(")")
 
 	return out.String()
@@ -183,9 +205,11 @@ func (ie *InfixExpression) expressionNode() {
 }
 
 // Identifier represents an identifier.
+// Type is the optional type annotation of a function parameter, or nil.
 type Identifier struct {
 	Token token.Token // token.IDENT
 	Value string
+	Type  TypeExpression
 }
 
 // TokenLiteral returns the token literal of the identifier.
@@ -202,9 +226,11 @@ func (i *Identifier) expressionNode() {
 }
 
 // IntegerLiteral represents an integer literal.
+// Big holds the value only when it does not fit in int64, nil otherwise.
 type IntegerLiteral struct {
 	Token token.Token // token.INT
 	Value int64
+	Big   *big.Int
 }
 
 // TokenLiteral returns the token literal of the integer.
@@ -219,3 +245,194 @@ func (i *IntegerLiteral) String() string {
 
 func (i *IntegerLiteral) expressionNode() {
 }
+
+// Boolean represents a boolean literal.
+type Boolean struct {
+	Token token.Token // token.TRUE or token.FALSE
+	Value bool
+}
+
+// TokenLiteral returns the token literal of the boolean.
+func (b *Boolean) TokenLiteral() string {
+	return b.Token.Literal
+}
+
+// String returns a text representation of the boolean.
+func (b *Boolean) String() string {
+	return b.Token.Literal
+}
+
+func (b *Boolean) expressionNode() {
+}
+
+// IfExpression represents an if expression with an optional else block.
+type IfExpression struct {
+	Token       token.Token // token.IF
+	Condition   Expression
+	Consequence *BlockStatement
+	Alternative *BlockStatement
+}
+
+// TokenLiteral returns the first token literal of the if expression.
+func (ie *IfExpression) TokenLiteral() string {
+	return ie.Token.Literal
+}
+
+// String returns a text representation of the if expression.
+func (ie *IfExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("if")
+	out.WriteString(str(ie.Condition))
+	out.WriteString(" ")
+	out.WriteString(str(ie.Consequence))
+	if ie.Alternative != nil {
+		out.WriteString("else ")
+		out.WriteString(str(ie.Alternative))
+	}
+
+	return out.String()
+}
+
+func (ie *IfExpression) expressionNode() {
+}
+
+// FunctionLiteral represents a function literal.
+// ReturnType is the optional type annotation of the result, or nil.
+type FunctionLiteral struct {
+	Token      token.Token // token.FUNCTION
+	Parameters []*Identifier
+	ReturnType TypeExpression
+	Body       *BlockStatement
+}
+
+// TokenLiteral returns the first token literal of the function literal.
+func (fl *FunctionLiteral) TokenLiteral() string {
+	return fl.Token.Literal
+}
+
+// String returns a text representation of the function literal.
+func (fl *FunctionLiteral) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range fl.Parameters {
+		if p.Type != nil {
+			params = append(params, str(p)+": "+str(p.Type))
+		} else {
+			params = append(params, str(p))
+		}
+	}
+	out.WriteString(fl.TokenLiteral())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") ")
+	if fl.ReturnType != nil {
+		out.WriteString("-> " + str(fl.ReturnType) + " ")
+	}
+	out.WriteString(str(fl.Body))
+
+	return out.String()
+}
+
+func (fl *FunctionLiteral) expressionNode() {
+}
+
+// CallExpression represents a function call.
+type CallExpression struct {
+	Token     token.Token // token.LPAREN
+	Function  Expression  // Identifier or FunctionLiteral
+	Arguments []Expression
+}
+
+// TokenLiteral returns the token literal of the call expression.
+func (ce *CallExpression) TokenLiteral() string {
+	return ce.Token.Literal
+}
+
+// String returns a text representation of the call expression.
+func (ce *CallExpression) String() string {
+	var out bytes.Buffer
+
+	args := []string{}
+	for _, a := range ce.Arguments {
+		args = append(args, str(a))
+	}
+	out.WriteString(str(ce.Function))
+	out.WriteString("(")
+	out.WriteString(strings.Join(args, ", "))
+	out.WriteString(")")
+
+	return out.String()
+}
+
+func (ce *CallExpression) expressionNode() {
+}
+
+// TypeExpression represents a type annotation.
+type TypeExpression interface {
+	Node
+	typeNode()
+}
+
+// NamedType represents a type denoted by its name, such as int.
+type NamedType struct {
+	Token token.Token // token.IDENT
+	Name  string
+}
+
+// TokenLiteral returns the token literal of the type name.
+func (nt *NamedType) TokenLiteral() string {
+	return nt.Token.Literal
+}
+
+// String returns a text representation of the named type.
+func (nt *NamedType) String() string {
+	return nt.Name
+}
+
+func (nt *NamedType) typeNode() {
+}
+
+// FunctionType represents a function type, such as fn(int, int) -> bool.
+type FunctionType struct {
+	Token      token.Token // token.FUNCTION
+	Parameters []TypeExpression
+	Result     TypeExpression
+}
+
+// TokenLiteral returns the first token literal of the function type.
+func (ft *FunctionType) TokenLiteral() string {
+	return ft.Token.Literal
+}
+
+// String returns a text representation of the function type.
+func (ft *FunctionType) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range ft.Parameters {
+		params = append(params, str(p))
+	}
+	out.WriteString(ft.TokenLiteral())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") -> ")
+	if ft.Result != nil {
+		out.WriteString(str(ft.Result))
+	}
+
+	return out.String()
+}
+
+func (ft *FunctionType) typeNode() {
+}
+
+// str returns the text representation of node, which is empty for a node
+// missing from an incomplete program.
+func str(node Node) string {
+	if isNil(node) {
+		return ""
+	}
+	return node.String()
+}
@@ -1,6 +1,8 @@
 package ast
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/oohira/monkey/token"
@@ -26,3 +28,89 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+// TestStringIncomplete checks that the nodes missing from an incomplete
+// program are written as empty.
+func TestStringIncomplete(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Token: token.Token{Type: token.LET, Literal: "let"},
+				Name:  &Identifier{Value: "b"},
+				Value: &PrefixExpression{Operator: "-"},
+			},
+			&ExpressionStatement{
+				Expression: &IfExpression{Condition: &InfixExpression{Operator: "+"}},
+			},
+			&ExpressionStatement{Expression: &CallExpression{Arguments: []Expression{nil}}},
+		},
+	}
+	if program.String() != "let b = (-);if( + ) ()" {
+		t.Errorf("program.String() wrong. got=%q", program.String())
+	}
+}
+
+func TestInspect(t *testing.T) {
+	ident := func(name string) *Identifier {
+		return &Identifier{Token: token.Token{Type: token.IDENT, Literal: name}, Value: name}
+	}
+	// let f = fn(x) { if (x) { return -x; } }; f(1 + 2);
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Token: token.Token{Type: token.LET, Literal: "let"},
+				Name:  ident("f"),
+				Value: &FunctionLiteral{
+					Token:      token.Token{Type: token.FUNCTION, Literal: "fn"},
+					Parameters: []*Identifier{ident("x")},
+					Body: &BlockStatement{
+						Statements: []Statement{
+							&ExpressionStatement{
+								Expression: &IfExpression{
+									Condition: ident("x"),
+									Consequence: &BlockStatement{
+										Statements: []Statement{
+											&ReturnStatement{
+												ReturnValue: &PrefixExpression{Operator: "-", Right: ident("x")},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			&ExpressionStatement{
+				Expression: &CallExpression{
+					Function: ident("f"),
+					Arguments: []Expression{
+						&InfixExpression{
+							Operator: "+",
+							Left:     &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "1"}, Value: 1},
+							Right:    &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "2"}, Value: 2},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	var visited []string
+	Inspect(program, func(n Node) bool {
+		name := strings.TrimPrefix(fmt.Sprintf("%T", n), "*ast.")
+		if ident, ok := n.(*Identifier); ok {
+			name += ":" + ident.Value
+		}
+		visited = append(visited, name)
+		_, isIf := n.(*IfExpression)
+		return !isIf
+	})
+
+	expected := "Program LetStatement Identifier:f FunctionLiteral Identifier:x BlockStatement " +
+		"ExpressionStatement IfExpression ExpressionStatement CallExpression Identifier:f " +
+		"InfixExpression IntegerLiteral IntegerLiteral"
+	if strings.Join(visited, " ") != expected {
+		t.Errorf("visited nodes wrong.\nwant=%s\ngot =%s", expected, strings.Join(visited, " "))
+	}
+}
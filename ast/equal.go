@@ -0,0 +1,372 @@
+package ast
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math/big"
+)
+
+// Equal reports whether two nodes have the same structure and values.
+// Tokens are ignored, so nodes at different positions or with different
+// enclosing parentheses are equal. Nil nodes are equal to each other.
+func Equal(a, b Node) bool {
+	if isNil(a) || isNil(b) {
+		return isNil(a) && isNil(b)
+	}
+
+	switch a := a.(type) {
+	case *Program:
+		b, ok := b.(*Program)
+		return ok && equalStatements(a.Statements, b.Statements)
+	case *LetStatement:
+		b, ok := b.(*LetStatement)
+		return ok && Equal(a.Name, b.Name) && Equal(a.Type, b.Type) && Equal(a.Value, b.Value)
+	case *ReturnStatement:
+		b, ok := b.(*ReturnStatement)
+		return ok && Equal(a.ReturnValue, b.ReturnValue)
+	case *ExpressionStatement:
+		b, ok := b.(*ExpressionStatement)
+		return ok && Equal(a.Expression, b.Expression)
+	case *BlockStatement:
+		b, ok := b.(*BlockStatement)
+		return ok && equalStatements(a.Statements, b.Statements)
+	case *PrefixExpression:
+		b, ok := b.(*PrefixExpression)
+		return ok && a.Operator == b.Operator && Equal(a.Right, b.Right)
+	case *InfixExpression:
+		b, ok := b.(*InfixExpression)
+		return ok && a.Operator == b.Operator && Equal(a.Left, b.Left) && Equal(a.Right, b.Right)
+	case *Identifier:
+		b, ok := b.(*Identifier)
+		return ok && a.Value == b.Value && Equal(a.Type, b.Type)
+	case *IntegerLiteral:
+		b, ok := b.(*IntegerLiteral)
+		return ok && a.Value == b.Value && (a.Big == nil) == (b.Big == nil) &&
+			(a.Big == nil || a.Big.Cmp(b.Big) == 0)
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	case *IfExpression:
+		b, ok := b.(*IfExpression)
+		return ok && Equal(a.Condition, b.Condition) &&
+			Equal(a.Consequence, b.Consequence) && Equal(a.Alternative, b.Alternative)
+	case *FunctionLiteral:
+		b, ok := b.(*FunctionLiteral)
+		if !ok || len(a.Parameters) != len(b.Parameters) {
+			return false
+		}
+		for i := range a.Parameters {
+			if !Equal(a.Parameters[i], b.Parameters[i]) {
+				return false
+			}
+		}
+		return Equal(a.ReturnType, b.ReturnType) && Equal(a.Body, b.Body)
+	case *CallExpression:
+		b, ok := b.(*CallExpression)
+		if !ok || len(a.Arguments) != len(b.Arguments) {
+			return false
+		}
+		for i := range a.Arguments {
+			if !Equal(a.Arguments[i], b.Arguments[i]) {
+				return false
+			}
+		}
+		return Equal(a.Function, b.Function)
+	case *NamedType:
+		b, ok := b.(*NamedType)
+		return ok && a.Name == b.Name
+	case *FunctionType:
+		b, ok := b.(*FunctionType)
+		if !ok || len(a.Parameters) != len(b.Parameters) {
+			return false
+		}
+		for i := range a.Parameters {
+			if !Equal(a.Parameters[i], b.Parameters[i]) {
+				return false
+			}
+		}
+		return Equal(a.Result, b.Result)
+	}
+	return false
+}
+
+func equalStatements(a, b []Statement) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Hash returns a hash of the structure and values of a node.
+// Equal nodes have the same hash.
+func Hash(node Node) uint64 {
+	h := &hasher{Hash64: fnv.New64a()}
+	h.node(node)
+	return h.Sum64()
+}
+
+type hasher struct {
+	hash.Hash64
+}
+
+// Tags of node types written by the hasher
+const (
+	tagNil byte = iota
+	tagProgram
+	tagLet
+	tagReturn
+	tagExpressionStatement
+	tagBlock
+	tagPrefix
+	tagInfix
+	tagIdentifier
+	tagInteger
+	tagBoolean
+	tagIf
+	tagFunction
+	tagCall
+	tagNamedType
+	tagFunctionType
+)
+
+func (h *hasher) tag(t byte) {
+	h.Write([]byte{t})
+}
+
+func (h *hasher) int(n int) {
+	h.Write(binary.AppendVarint(nil, int64(n)))
+}
+
+// string writes s with its length so that adjacent strings are not mixed.
+func (h *hasher) string(s string) {
+	h.int(len(s))
+	h.Write([]byte(s))
+}
+
+func (h *hasher) statements(stmts []Statement) {
+	h.int(len(stmts))
+	for _, stmt := range stmts {
+		h.node(stmt)
+	}
+}
+
+func (h *hasher) node(node Node) {
+	if isNil(node) {
+		h.tag(tagNil)
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		h.tag(tagProgram)
+		h.statements(n.Statements)
+	case *LetStatement:
+		h.tag(tagLet)
+		h.node(n.Name)
+		h.node(n.Type)
+		h.node(n.Value)
+	case *ReturnStatement:
+		h.tag(tagReturn)
+		h.node(n.ReturnValue)
+	case *ExpressionStatement:
+		h.tag(tagExpressionStatement)
+		h.node(n.Expression)
+	case *BlockStatement:
+		h.tag(tagBlock)
+		h.statements(n.Statements)
+	case *PrefixExpression:
+		h.tag(tagPrefix)
+		h.string(n.Operator)
+		h.node(n.Right)
+	case *InfixExpression:
+		h.tag(tagInfix)
+		h.string(n.Operator)
+		h.node(n.Left)
+		h.node(n.Right)
+	case *Identifier:
+		h.tag(tagIdentifier)
+		h.string(n.Value)
+		h.node(n.Type)
+	case *IntegerLiteral:
+		h.tag(tagInteger)
+		if n.Big != nil {
+			h.string(n.Big.String())
+		} else {
+			h.Write(binary.AppendVarint(nil, n.Value))
+		}
+	case *Boolean:
+		h.tag(tagBoolean)
+		if n.Value {
+			h.tag(1)
+		} else {
+			h.tag(0)
+		}
+	case *IfExpression:
+		h.tag(tagIf)
+		h.node(n.Condition)
+		h.node(n.Consequence)
+		h.node(n.Alternative)
+	case *FunctionLiteral:
+		h.tag(tagFunction)
+		h.int(len(n.Parameters))
+		for _, param := range n.Parameters {
+			h.node(param)
+		}
+		h.node(n.ReturnType)
+		h.node(n.Body)
+	case *CallExpression:
+		h.tag(tagCall)
+		h.node(n.Function)
+		h.int(len(n.Arguments))
+		for _, arg := range n.Arguments {
+			h.node(arg)
+		}
+	case *NamedType:
+		h.tag(tagNamedType)
+		h.string(n.Name)
+	case *FunctionType:
+		h.tag(tagFunctionType)
+		h.int(len(n.Parameters))
+		for _, param := range n.Parameters {
+			h.node(param)
+		}
+		h.node(n.Result)
+	}
+}
+
+// Clone returns a deep copy of a node that shares nothing with the original.
+// Tokens are copied as is, and nil children remain nil.
+func Clone[T Node](node T) T {
+	if isNil(node) {
+		return node
+	}
+	return clone(node).(T)
+}
+
+func clone(node Node) Node {
+	if isNil(node) {
+		return nil
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		return &Program{Statements: cloneStatements(n.Statements)}
+	case *LetStatement:
+		return &LetStatement{
+			Token: n.Token,
+			Name:  cloneIdentifier(n.Name),
+			Type:  cloneType(n.Type),
+			Value: cloneExpression(n.Value),
+		}
+	case *ReturnStatement:
+		return &ReturnStatement{Token: n.Token, ReturnValue: cloneExpression(n.ReturnValue)}
+	case *ExpressionStatement:
+		return &ExpressionStatement{Token: n.Token, Expression: cloneExpression(n.Expression)}
+	case *BlockStatement:
+		return cloneBlock(n)
+	case *PrefixExpression:
+		return &PrefixExpression{Token: n.Token, Operator: n.Operator, Right: cloneExpression(n.Right)}
+	case *InfixExpression:
+		return &InfixExpression{
+			Token:    n.Token,
+			Left:     cloneExpression(n.Left),
+			Operator: n.Operator,
+			Right:    cloneExpression(n.Right),
+		}
+	case *Identifier:
+		return cloneIdentifier(n)
+	case *IntegerLiteral:
+		c := &IntegerLiteral{Token: n.Token, Value: n.Value}
+		if n.Big != nil {
+			c.Big = new(big.Int).Set(n.Big)
+		}
+		return c
+	case *Boolean:
+		return &Boolean{Token: n.Token, Value: n.Value}
+	case *IfExpression:
+		return &IfExpression{
+			Token:       n.Token,
+			Condition:   cloneExpression(n.Condition),
+			Consequence: cloneBlock(n.Consequence),
+			Alternative: cloneBlock(n.Alternative),
+		}
+	case *FunctionLiteral:
+		c := &FunctionLiteral{Token: n.Token, ReturnType: cloneType(n.ReturnType), Body: cloneBlock(n.Body)}
+		if n.Parameters != nil {
+			c.Parameters = make([]*Identifier, len(n.Parameters))
+			for i, param := range n.Parameters {
+				c.Parameters[i] = cloneIdentifier(param)
+			}
+		}
+		return c
+	case *CallExpression:
+		c := &CallExpression{Token: n.Token, Function: cloneExpression(n.Function)}
+		if n.Arguments != nil {
+			c.Arguments = make([]Expression, len(n.Arguments))
+			for i, arg := range n.Arguments {
+				c.Arguments[i] = cloneExpression(arg)
+			}
+		}
+		return c
+	case *NamedType:
+		return &NamedType{Token: n.Token, Name: n.Name}
+	case *FunctionType:
+		c := &FunctionType{Token: n.Token, Result: cloneType(n.Result)}
+		if n.Parameters != nil {
+			c.Parameters = make([]TypeExpression, len(n.Parameters))
+			for i, param := range n.Parameters {
+				c.Parameters[i] = cloneType(param)
+			}
+		}
+		return c
+	}
+	panic(fmt.Sprintf("ast: cannot clone %T", node))
+}
+
+func cloneStatements(stmts []Statement) []Statement {
+	if stmts == nil {
+		return nil
+	}
+	c := make([]Statement, len(stmts))
+	for i, stmt := range stmts {
+		if s, ok := clone(stmt).(Statement); ok {
+			c[i] = s
+		}
+	}
+	return c
+}
+
+func cloneExpression(exp Expression) Expression {
+	if c, ok := clone(exp).(Expression); ok {
+		return c
+	}
+	return nil
+}
+
+func cloneIdentifier(ident *Identifier) *Identifier {
+	if ident == nil {
+		return nil
+	}
+	return &Identifier{Token: ident.Token, Value: ident.Value, Type: cloneType(ident.Type)}
+}
+
+func cloneType(t TypeExpression) TypeExpression {
+	if c, ok := clone(t).(TypeExpression); ok {
+		return c
+	}
+	return nil
+}
+
+func cloneBlock(block *BlockStatement) *BlockStatement {
+	if block == nil {
+		return nil
+	}
+	return &BlockStatement{Token: block.Token, Statements: cloneStatements(block.Statements)}
+}
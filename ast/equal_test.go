@@ -0,0 +1,161 @@
+package ast_test
+
+import (
+	"testing"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		equal bool
+	}{
+		{"let x = 1 + 2;", "let x=1+2", true},
+		{"let x = 1 + 2;", "\n\n  let x = (1 + (2));", true},
+		{"(1 + 2) * 3", "(1 + 2) * 3", true},
+		{"fn(a, b) { a + b }(1, 2)", "fn(a,b){a+b}(1,2)", true},
+		{"if (x) { 1 } else { 2 }", "if (x) { 1 } else { 2 }", true},
+		{"99999999999999999999", "99999999999999999999", true},
+		{"", "", true},
+		{"let x = 1;", "let y = 1;", false},
+		{"let x = 1;", "let x = 2;", false},
+		{"1 + 2", "1 - 2", false},
+		{"1 + 2 * 3", "(1 + 2) * 3", false},
+		{"-x", "!x", false},
+		{"true", "false", false},
+		{"x", "1", false},
+		{"let x = 1;", "x", false},
+		{"return x;", "x", false},
+		{"if (x) { 1 }", "if (x) { 1 } else { 1 }", false},
+		{"fn(a) { a }", "fn(a, b) { a }", false},
+		{"fn(a) { a }", "fn(b) { a }", false},
+		{"f(1)", "f(1, 2)", false},
+		{"f(1)", "g(1)", false},
+		{"99999999999999999999", "99999999999999999998", false},
+		{"1; 2", "1", false},
+		{"let x: int = 1;", "let x:int=1", true},
+		{"let x: int = 1;", "let x = 1;", false},
+		{"let x: int = 1;", "let x: bool = 1;", false},
+		{"fn(a: int) { a }", "fn(a) { a }", false},
+		{"fn(a) -> int { a }", "fn(a) { a }", false},
+		{"let f: fn(int) -> int = g;", "let f: fn(int, int) -> int = g;", false},
+		{"let f: fn(int) -> int = g;", "let f: fn(int) -> bool = g;", false},
+	}
+
+	for _, tt := range tests {
+		a, b := parse(t, tt.a), parse(t, tt.b)
+		if ast.Equal(a, b) != tt.equal {
+			t.Errorf("Equal(%q, %q) wrong. want=%t", tt.a, tt.b, tt.equal)
+		}
+		if ast.Equal(b, a) != tt.equal {
+			t.Errorf("Equal(%q, %q) wrong. want=%t", tt.b, tt.a, tt.equal)
+		}
+		if tt.equal && ast.Hash(a) != ast.Hash(b) {
+			t.Errorf("Hash(%q) != Hash(%q)", tt.a, tt.b)
+		}
+		if !tt.equal && ast.Hash(a) == ast.Hash(b) {
+			t.Errorf("Hash(%q) == Hash(%q)", tt.a, tt.b)
+		}
+	}
+}
+
+func TestEqualNil(t *testing.T) {
+	var ident *ast.Identifier
+	if !ast.Equal(nil, ident) {
+		t.Errorf("Equal(nil, typed nil) returned false")
+	}
+	if ast.Equal(nil, &ast.Identifier{Value: "x"}) {
+		t.Errorf("Equal(nil, x) returned true")
+	}
+	if ast.Equal(&ast.LetStatement{Name: &ast.Identifier{Value: "x"}}, &ast.LetStatement{}) {
+		t.Errorf("Equal of let statements with and without name returned true")
+	}
+	if ast.Hash(nil) != ast.Hash(ident) {
+		t.Errorf("Hash(nil) != Hash(typed nil)")
+	}
+}
+
+func TestHashAsMapKey(t *testing.T) {
+	cache := map[uint64]ast.Node{}
+	for _, input := range []string{"a + b * c", "a + (b * c)", "(a + b) * c", "a+b*c"} {
+		stmt := parse(t, input).Statements[0]
+		if cached, ok := cache[ast.Hash(stmt)]; ok {
+			if !ast.Equal(cached, stmt) {
+				t.Errorf("hash collision between %q and %q", cached, stmt)
+			}
+			continue
+		}
+		cache[ast.Hash(stmt)] = stmt
+	}
+	if len(cache) != 2 {
+		t.Errorf("cache has wrong number of entries. want=2, got=%d", len(cache))
+	}
+}
+
+func TestClone(t *testing.T) {
+	input := `let f = fn(x: int, y) -> fn(int) -> int { if (x < y) { return -x; } else { x * 99999999999999999999 } };
+f(1, !true)(); fn() {};`
+	program := parse(t, input)
+	c := ast.Clone(program)
+
+	if !ast.Equal(program, c) {
+		t.Fatalf("clone is not equal to the original")
+	}
+	if c.String() != program.String() {
+		t.Errorf("String() of clone wrong. want=%q, got=%q", program.String(), c.String())
+	}
+
+	original := map[ast.Node]bool{}
+	ast.Inspect(program, func(n ast.Node) bool {
+		original[n] = true
+		return true
+	})
+	ast.Inspect(c, func(n ast.Node) bool {
+		if original[n] {
+			t.Errorf("clone shares node %s with the original", n)
+		}
+		return true
+	})
+
+	// modifying the clone leaves the original as is
+	let := c.Statements[0].(*ast.LetStatement)
+	let.Name.Value = "g"
+	fn := let.Value.(*ast.FunctionLiteral)
+	fn.Parameters[0].Value = "z"
+	ifExp := fn.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IfExpression)
+	ifExp.Alternative.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.InfixExpression).
+		Right.(*ast.IntegerLiteral).Big.SetInt64(1)
+	c.Statements = c.Statements[:1]
+
+	if program.String() != parse(t, input).String() || !ast.Equal(program, parse(t, input)) {
+		t.Errorf("original modified through clone: %s", program)
+	}
+}
+
+func TestCloneNil(t *testing.T) {
+	var block *ast.BlockStatement
+	if ast.Clone(block) != nil {
+		t.Errorf("Clone(nil) returned non-nil")
+	}
+	ifExp := ast.Clone(&ast.IfExpression{Condition: &ast.Boolean{Value: true}})
+	if ifExp.Consequence != nil || ifExp.Alternative != nil || !ast.Equal(ifExp.Condition, &ast.Boolean{Value: true}) {
+		t.Errorf("Clone of partial if expression wrong: %#v", ifExp)
+	}
+	let := ast.Clone(&ast.LetStatement{Name: &ast.Identifier{Value: "x"}})
+	if let.Value != nil {
+		t.Errorf("Clone of let statement without value has value %#v", let.Value)
+	}
+}
@@ -0,0 +1,66 @@
+package ast
+
+import "reflect"
+
+// Inspect traverses an AST in depth-first order. It calls f(node) for each
+// node, and if f returns true, it continues with each non-nil child of node.
+func Inspect(node Node, f func(Node) bool) {
+	if isNil(node) || !f(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		for _, stmt := range n.Statements {
+			Inspect(stmt, f)
+		}
+	case *LetStatement:
+		Inspect(n.Name, f)
+		Inspect(n.Type, f)
+		Inspect(n.Value, f)
+	case *ReturnStatement:
+		Inspect(n.ReturnValue, f)
+	case *ExpressionStatement:
+		Inspect(n.Expression, f)
+	case *BlockStatement:
+		for _, stmt := range n.Statements {
+			Inspect(stmt, f)
+		}
+	case *PrefixExpression:
+		Inspect(n.Right, f)
+	case *InfixExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *IfExpression:
+		Inspect(n.Condition, f)
+		Inspect(n.Consequence, f)
+		Inspect(n.Alternative, f)
+	case *FunctionLiteral:
+		for _, param := range n.Parameters {
+			Inspect(param, f)
+		}
+		Inspect(n.ReturnType, f)
+		Inspect(n.Body, f)
+	case *CallExpression:
+		Inspect(n.Function, f)
+		for _, arg := range n.Arguments {
+			Inspect(arg, f)
+		}
+	case *Identifier:
+		Inspect(n.Type, f)
+	case *FunctionType:
+		for _, param := range n.Parameters {
+			Inspect(param, f)
+		}
+		Inspect(n.Result, f)
+	}
+}
+
+// isNil reports whether node is nil or a nil pointer to a node.
+func isNil(node Node) bool {
+	if node == nil {
+		return true
+	}
+	v := reflect.ValueOf(node)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
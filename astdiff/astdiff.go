@@ -0,0 +1,355 @@
+// Package astdiff compares two Monkey programs structurally.
+//
+// Statements are aligned by structural equality, so changes of layout,
+// comments or redundant parentheses are not reported. Statements that differ
+// are paired with a statement of the same kind, and the differences inside
+// them are reported as changed operators, renamed identifiers, changed values
+// and added or removed statements of blocks.
+package astdiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/token"
+)
+
+// Kind represents a kind of change.
+type Kind string
+
+// Kinds of changes
+const (
+	Added    Kind = "added"    // a statement or an argument is added
+	Removed  Kind = "removed"  // a statement or an argument is removed
+	Modified Kind = "modified" // a statement is modified or a node is replaced
+	Operator Kind = "operator" // the operator of an expression is changed
+	Renamed  Kind = "renamed"  // an identifier is renamed
+)
+
+// Position represents a position in a source file.
+type Position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Change represents a structural difference between two programs.
+// Path locates the changed node in the new program, or in the old program
+// if the node is removed, by the field names of the AST nodes. Old and New
+// are the text of the changed node, or the operators of an operator change.
+// A modified statement holds the differences inside it in Changes.
+type Change struct {
+	Kind    Kind      `json:"kind"`
+	Path    string    `json:"path"`
+	Old     string    `json:"old,omitempty"`
+	New     string    `json:"new,omitempty"`
+	OldPos  *Position `json:"oldPos,omitempty"`
+	NewPos  *Position `json:"newPos,omitempty"`
+	Changes []*Change `json:"changes,omitempty"`
+}
+
+// Diff returns the changes of the statements from old to new in the order of
+// the programs. It returns an empty slice if the programs are equal.
+func Diff(old, new *ast.Program) []*Change {
+	d := &differ{changes: []*Change{}}
+	d.statements("Statements", old.Statements, new.Statements, true)
+	return d.changes
+}
+
+type differ struct {
+	changes []*Change
+}
+
+func (d *differ) add(c *Change) {
+	d.changes = append(d.changes, c)
+}
+
+// statements reports the differences of two statement lists. If top is true,
+// the differences inside paired statements are grouped by statement.
+func (d *differ) statements(path string, old, new []ast.Statement, top bool) {
+	olds, news := make([]ast.Node, len(old)), make([]ast.Node, len(new))
+	for i, s := range old {
+		olds[i] = s
+	}
+	for i, s := range new {
+		news[i] = s
+	}
+
+	d.list(path, olds, news, func(p string, a, b ast.Node) {
+		if !top {
+			d.node(p, a, b)
+			return
+		}
+		inner := &differ{changes: []*Change{}}
+		inner.node(p, a, b)
+		c := change(Modified, p, a, b)
+		c.Changes = inner.changes
+		d.add(c)
+	})
+}
+
+// list aligns two node lists by the longest common subsequence of equal
+// nodes. Nodes between aligned ones are paired with a node of the same type
+// in order and compared by pair, and the rest are added or removed.
+func (d *differ) list(path string, old, new []ast.Node, pair func(path string, a, b ast.Node)) {
+	// lcs[i][j] is the length of the common subsequence of old[i:] and new[j:]
+	lcs := make([][]int, len(old)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(new)+1)
+	}
+	for i := len(old) - 1; i >= 0; i-- {
+		for j := len(new) - 1; j >= 0; j-- {
+			if ast.Equal(old[i], new[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	item := func(i int) string {
+		return fmt.Sprintf("%s[%d]", path, i)
+	}
+	gap := func(oldStart, oldEnd, newStart, newEnd int) {
+		j := newStart
+		for i := oldStart; i < oldEnd; i++ {
+			k := j
+			for k < newEnd && reflect.TypeOf(old[i]) != reflect.TypeOf(new[k]) {
+				k++
+			}
+			if k == newEnd {
+				d.add(change(Removed, item(i), old[i], nil))
+				continue
+			}
+			for ; j < k; j++ {
+				d.add(change(Added, item(j), nil, new[j]))
+			}
+			pair(item(k), old[i], new[k])
+			j = k + 1
+		}
+		for ; j < newEnd; j++ {
+			d.add(change(Added, item(j), nil, new[j]))
+		}
+	}
+
+	i, j, oldStart, newStart := 0, 0, 0, 0
+	for i < len(old) && j < len(new) {
+		switch {
+		case ast.Equal(old[i], new[j]) && lcs[i][j] == lcs[i+1][j+1]+1:
+			gap(oldStart, i, newStart, j)
+			i, j = i+1, j+1
+			oldStart, newStart = i, j
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	gap(oldStart, len(old), newStart, len(new))
+}
+
+// node reports the differences of two nodes at path.
+func (d *differ) node(path string, a, b ast.Node) {
+	if ast.Equal(a, b) {
+		return
+	}
+	if isNil(a) || isNil(b) || reflect.TypeOf(a) != reflect.TypeOf(b) {
+		d.add(change(Modified, path, a, b))
+		return
+	}
+
+	switch a := a.(type) {
+	case *ast.LetStatement:
+		b := b.(*ast.LetStatement)
+		d.node(path+".Name", a.Name, b.Name)
+		d.node(path+".Type", a.Type, b.Type)
+		d.node(path+".Value", a.Value, b.Value)
+	case *ast.ReturnStatement:
+		d.node(path+".ReturnValue", a.ReturnValue, b.(*ast.ReturnStatement).ReturnValue)
+	case *ast.ExpressionStatement:
+		d.node(path+".Expression", a.Expression, b.(*ast.ExpressionStatement).Expression)
+	case *ast.BlockStatement:
+		d.statements(path+".Statements", a.Statements, b.(*ast.BlockStatement).Statements, false)
+	case *ast.PrefixExpression:
+		b := b.(*ast.PrefixExpression)
+		d.operator(path, a.Token, b.Token, a.Operator, b.Operator)
+		d.node(path+".Right", a.Right, b.Right)
+	case *ast.InfixExpression:
+		b := b.(*ast.InfixExpression)
+		d.operator(path, a.Token, b.Token, a.Operator, b.Operator)
+		d.node(path+".Left", a.Left, b.Left)
+		d.node(path+".Right", a.Right, b.Right)
+	case *ast.Identifier:
+		b := b.(*ast.Identifier)
+		if a.Value != b.Value {
+			d.add(change(Renamed, path, a, b))
+		}
+		d.node(path+".Type", a.Type, b.Type)
+	case *ast.IfExpression:
+		b := b.(*ast.IfExpression)
+		d.node(path+".Condition", a.Condition, b.Condition)
+		d.node(path+".Consequence", a.Consequence, b.Consequence)
+		d.node(path+".Alternative", a.Alternative, b.Alternative)
+	case *ast.FunctionLiteral:
+		b := b.(*ast.FunctionLiteral)
+		d.list(path+".Parameters", identifiers(a.Parameters), identifiers(b.Parameters), d.node)
+		d.node(path+".ReturnType", a.ReturnType, b.ReturnType)
+		d.node(path+".Body", a.Body, b.Body)
+	case *ast.CallExpression:
+		b := b.(*ast.CallExpression)
+		d.node(path+".Function", a.Function, b.Function)
+		d.list(path+".Arguments", expressions(a.Arguments), expressions(b.Arguments), d.node)
+	default:
+		d.add(change(Modified, path, a, b))
+	}
+}
+
+func (d *differ) operator(path string, a, b token.Token, old, new string) {
+	if old != new {
+		d.add(&Change{
+			Kind:   Operator,
+			Path:   path,
+			Old:    old,
+			New:    new,
+			OldPos: position(a),
+			NewPos: position(b),
+		})
+	}
+}
+
+func identifiers(idents []*ast.Identifier) []ast.Node {
+	nodes := make([]ast.Node, len(idents))
+	for i, ident := range idents {
+		nodes[i] = ident
+	}
+	return nodes
+}
+
+func expressions(exps []ast.Expression) []ast.Node {
+	nodes := make([]ast.Node, len(exps))
+	for i, exp := range exps {
+		nodes[i] = exp
+	}
+	return nodes
+}
+
+func change(kind Kind, path string, a, b ast.Node) *Change {
+	c := &Change{Kind: kind, Path: path}
+	if !isNil(a) {
+		c.Old = a.String()
+		c.OldPos = position(startToken(a))
+	}
+	if !isNil(b) {
+		c.New = b.String()
+		c.NewPos = position(startToken(b))
+	}
+	return c
+}
+
+// isNil reports whether node is nil or a nil pointer to a node.
+func isNil(node ast.Node) bool {
+	if node == nil {
+		return true
+	}
+	v := reflect.ValueOf(node)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+// startToken returns the first token of a node.
+func startToken(node ast.Node) token.Token {
+	switch n := node.(type) {
+	case *ast.LetStatement:
+		return n.Token
+	case *ast.ReturnStatement:
+		return n.Token
+	case *ast.ExpressionStatement:
+		return n.Token
+	case *ast.BlockStatement:
+		return n.Token
+	case *ast.PrefixExpression:
+		return n.Token
+	case *ast.InfixExpression:
+		return startToken(n.Left)
+	case *ast.Identifier:
+		return n.Token
+	case *ast.IntegerLiteral:
+		return n.Token
+	case *ast.Boolean:
+		return n.Token
+	case *ast.IfExpression:
+		return n.Token
+	case *ast.FunctionLiteral:
+		return n.Token
+	case *ast.CallExpression:
+		return startToken(n.Function)
+	case *ast.NamedType:
+		return n.Token
+	case *ast.FunctionType:
+		return n.Token
+	}
+	return token.Token{}
+}
+
+func position(tok token.Token) *Position {
+	return &Position{Line: tok.Pos.Line, Column: tok.Pos.Column}
+}
+
+// WriteSummary writes the changes in a human readable form. oldName and
+// newName are the names of the files of the programs.
+func WriteSummary(w io.Writer, oldName, newName string, changes []*Change) error {
+	var out strings.Builder
+	if len(changes) == 0 {
+		out.WriteString("no structural changes\n")
+	}
+	counts := map[Kind]int{}
+	for _, c := range changes {
+		counts[c.Kind]++
+		writeChange(&out, oldName, newName, c, "")
+		for _, inner := range c.Changes {
+			writeChange(&out, oldName, newName, inner, "    ")
+		}
+	}
+	if len(changes) > 0 {
+		fmt.Fprintf(&out, "%d statements changed: %d added, %d removed, %d modified\n",
+			len(changes), counts[Added], counts[Removed], counts[Modified])
+	}
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+func writeChange(out *strings.Builder, oldName, newName string, c *Change, indent string) {
+	name, pos := newName, c.NewPos
+	if pos == nil {
+		name, pos = oldName, c.OldPos
+	}
+
+	fmt.Fprintf(out, "%s%s:%d:%d: ", indent, name, pos.Line, pos.Column)
+	switch {
+	case c.Kind == Operator:
+		fmt.Fprintf(out, "operator %s changed to %s\n", c.Old, c.New)
+	case c.Kind == Renamed:
+		fmt.Fprintf(out, "renamed %s to %s\n", c.Old, c.New)
+	case c.Kind == Added || c.NewPos != nil && c.OldPos == nil:
+		fmt.Fprintf(out, "added %s\n", c.New)
+	case c.Kind == Removed || c.NewPos == nil:
+		fmt.Fprintf(out, "removed %s\n", c.Old)
+	case c.Changes != nil:
+		fmt.Fprintf(out, "modified %s\n", c.New)
+	default:
+		fmt.Fprintf(out, "changed %s to %s\n", c.Old, c.New)
+	}
+}
+
+// WriteJSON writes the changes as a JSON object with the names of the files.
+func WriteJSON(w io.Writer, oldName, newName string, changes []*Change) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Old     string    `json:"old"`
+		New     string    `json:"new"`
+		Changes []*Change `json:"changes"`
+	}{oldName, newName, changes})
+}
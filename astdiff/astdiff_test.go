@@ -0,0 +1,178 @@
+package astdiff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+// describe returns the changes as lines of "kind path old -> new".
+func describe(changes []*Change) string {
+	var lines []string
+	var add func(c *Change, indent string)
+	add = func(c *Change, indent string) {
+		lines = append(lines, fmt.Sprintf("%s%s %s %q -> %q", indent, c.Kind, c.Path, c.Old, c.New))
+		for _, inner := range c.Changes {
+			add(inner, indent+"  ")
+		}
+	}
+	for _, c := range changes {
+		add(c, "")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		old, new string
+		expected string
+	}{
+		{
+			"let x = 1 + 2; f(x);",
+			"// comment\nlet x = (1 + 2);\n\n  f( x )",
+			"",
+		},
+		{
+			"let x = 1;",
+			"let x = 1; let y = 2;",
+			`added Statements[1] "" -> "let y = 2;"`,
+		},
+		{
+			"a; b; c;",
+			"a; c;",
+			`removed Statements[1] "b" -> ""`,
+		},
+		{
+			"a + b; c;",
+			"a * b; c;",
+			`modified Statements[0] "(a + b)" -> "(a * b)"
+  operator Statements[0].Expression "+" -> "*"`,
+		},
+		{
+			"let x = 1; x + x;",
+			"let y = 1; y + x;",
+			`modified Statements[0] "let x = 1;" -> "let y = 1;"
+  renamed Statements[0].Name "x" -> "y"
+modified Statements[1] "(x + x)" -> "(y + x)"
+  renamed Statements[1].Expression.Left "x" -> "y"`,
+		},
+		{
+			"let x = -1;",
+			"let x = !true;",
+			`modified Statements[0] "let x = (-1);" -> "let x = (!true);"
+  operator Statements[0].Value "-" -> "!"
+  modified Statements[0].Value.Right "1" -> "true"`,
+		},
+		{
+			"let x = 1; return x;",
+			"return x; let x = 2;",
+			`removed Statements[0] "let x = 1;" -> ""
+added Statements[1] "" -> "let x = 2;"`,
+		},
+		{
+			"f(1, 2); g(a);",
+			"f(1, 3, 2); g(a, b);",
+			`modified Statements[0] "f(1, 2)" -> "f(1, 3, 2)"
+  added Statements[0].Expression.Arguments[1] "" -> "3"
+modified Statements[1] "g(a)" -> "g(a, b)"
+  added Statements[1].Expression.Arguments[1] "" -> "b"`,
+		},
+		{
+			"if (x) { a; b; } else { c }",
+			"if (x) { a; d; }",
+			`modified Statements[0] "ifx abelse c" -> "ifx ad"
+  renamed Statements[0].Expression.Consequence.Statements[1].Expression "b" -> "d"
+  modified Statements[0].Expression.Alternative "c" -> ""`,
+		},
+		{
+			"let f = fn(a) { return a; };",
+			"let f = fn(a, b) { let c = a; return c; };",
+			`modified Statements[0] "let f = fn(a) return a;;" -> "let f = fn(a, b) let c = a;return c;;"
+  added Statements[0].Value.Parameters[1] "" -> "b"
+  added Statements[0].Value.Body.Statements[0] "" -> "let c = a;"
+  renamed Statements[0].Value.Body.Statements[1].ReturnValue "a" -> "c"`,
+		},
+		{
+			"x; 1;",
+			"f(x); 1;",
+			`modified Statements[0] "x" -> "f(x)"
+  modified Statements[0].Expression "x" -> "f(x)"`,
+		},
+	}
+
+	for _, tt := range tests {
+		got := describe(Diff(parse(t, tt.old), parse(t, tt.new)))
+		if got != tt.expected {
+			t.Errorf("Diff(%q, %q) wrong.\nwant=\n%s\ngot=\n%s", tt.old, tt.new, tt.expected, got)
+		}
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	old := parse(t, "let x = 1 + 2;\nlet unused = 5;\nf(x);")
+	new := parse(t, "let x = 1 - 2;\nf(x);\nlet y = x;")
+
+	var out bytes.Buffer
+	if err := WriteSummary(&out, "old.mk", "new.mk", Diff(old, new)); err != nil {
+		t.Fatal(err)
+	}
+	expected := `new.mk:1:1: modified let x = (1 - 2);
+    new.mk:1:11: operator + changed to -
+old.mk:2:1: removed let unused = 5;
+new.mk:3:1: added let y = x;
+3 statements changed: 1 added, 1 removed, 1 modified
+`
+	if out.String() != expected {
+		t.Errorf("summary wrong.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+
+	out.Reset()
+	if err := WriteSummary(&out, "old.mk", "new.mk", Diff(old, old)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "no structural changes\n" {
+		t.Errorf("summary of no changes wrong. got=%q", out.String())
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	old := parse(t, "a + b;")
+	new := parse(t, "\na - b;")
+
+	var out bytes.Buffer
+	if err := WriteJSON(&out, "old.mk", "new.mk", Diff(old, new)); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Old     string
+		New     string
+		Changes []*Change
+	}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %s\n%s", err, out.String())
+	}
+	if got.Old != "old.mk" || got.New != "new.mk" || len(got.Changes) != 1 {
+		t.Fatalf("JSON wrong: %s", out.String())
+	}
+	inner := got.Changes[0].Changes
+	if len(inner) != 1 || inner[0].Kind != Operator ||
+		*inner[0].OldPos != (Position{1, 3}) || *inner[0].NewPos != (Position{2, 3}) {
+		t.Errorf("JSON change wrong: %s", out.String())
+	}
+}
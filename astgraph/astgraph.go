@@ -0,0 +1,199 @@
+package astgraph
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/token"
+)
+
+// Node represents a node of an AST prepared for rendering.
+type Node struct {
+	Kind   string // type name of the AST node
+	Detail string // operator, name or literal value, if any
+	Pos    *token.Position
+	Edges  []Edge
+}
+
+// Edge represents a link from a node to its child labeled by the field name.
+type Edge struct {
+	Label string
+	Node  *Node
+}
+
+// Label returns a one-line description of the node, e.g. "InfixExpression + @1:3".
+func (n *Node) Label() string {
+	label := n.Kind
+	if n.Detail != "" {
+		label += " " + n.Detail
+	}
+	if n.Pos != nil {
+		label += " @" + n.Pos.String()
+	}
+	return label
+}
+
+// Build converts an AST into a Node tree. Nil children are omitted.
+func Build(node ast.Node) *Node {
+	n := &Node{Kind: strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")}
+	add := func(label string, child ast.Node) {
+		if child != nil && !reflect.ValueOf(child).IsNil() {
+			n.Edges = append(n.Edges, Edge{Label: label, Node: Build(child)})
+		}
+	}
+	at := func(tok token.Token) {
+		pos := tok.Pos
+		n.Pos = &pos
+	}
+
+	switch node := node.(type) {
+	case *ast.Program:
+		for i, stmt := range node.Statements {
+			add(fmt.Sprintf("Statements[%d]", i), stmt)
+		}
+	case *ast.LetStatement:
+		at(node.Token)
+		add("Name", node.Name)
+		add("Type", node.Type)
+		add("Value", node.Value)
+	case *ast.ReturnStatement:
+		at(node.Token)
+		add("ReturnValue", node.ReturnValue)
+	case *ast.ExpressionStatement:
+		at(node.Token)
+		add("Expression", node.Expression)
+	case *ast.BlockStatement:
+		at(node.Token)
+		for i, stmt := range node.Statements {
+			add(fmt.Sprintf("Statements[%d]", i), stmt)
+		}
+	case *ast.PrefixExpression:
+		at(node.Token)
+		n.Detail = node.Operator
+		add("Right", node.Right)
+	case *ast.InfixExpression:
+		at(node.Token)
+		n.Detail = node.Operator
+		add("Left", node.Left)
+		add("Right", node.Right)
+	case *ast.IfExpression:
+		at(node.Token)
+		add("Condition", node.Condition)
+		add("Consequence", node.Consequence)
+		add("Alternative", node.Alternative)
+	case *ast.FunctionLiteral:
+		at(node.Token)
+		for i, param := range node.Parameters {
+			add(fmt.Sprintf("Parameters[%d]", i), param)
+		}
+		add("ReturnType", node.ReturnType)
+		add("Body", node.Body)
+	case *ast.CallExpression:
+		at(node.Token)
+		add("Function", node.Function)
+		for i, arg := range node.Arguments {
+			add(fmt.Sprintf("Arguments[%d]", i), arg)
+		}
+	case *ast.Identifier:
+		at(node.Token)
+		n.Detail = node.Value
+		add("Type", node.Type)
+	case *ast.IntegerLiteral:
+		at(node.Token)
+		n.Detail = node.Token.Literal
+	case *ast.Boolean:
+		at(node.Token)
+		n.Detail = node.Token.Literal
+	case *ast.NamedType:
+		at(node.Token)
+		n.Detail = node.Name
+	case *ast.FunctionType:
+		at(node.Token)
+		for i, param := range node.Parameters {
+			add(fmt.Sprintf("Parameters[%d]", i), param)
+		}
+		add("Result", node.Result)
+	}
+	return n
+}
+
+// WriteTree writes the AST as an indented text tree.
+func WriteTree(w io.Writer, node ast.Node) error {
+	root := Build(node)
+	if _, err := fmt.Fprintln(w, root.Label()); err != nil {
+		return err
+	}
+	return writeTree(w, root, "")
+}
+
+func writeTree(w io.Writer, n *Node, prefix string) error {
+	for i, e := range n.Edges {
+		branch, indent := "├── ", "│   "
+		if i == len(n.Edges)-1 {
+			branch, indent = "└── ", "    "
+		}
+		if _, err := fmt.Fprintf(w, "%s%s%s: %s\n", prefix, branch, e.Label, e.Node.Label()); err != nil {
+			return err
+		}
+		if err := writeTree(w, e.Node, prefix+indent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteDot writes the AST as a Graphviz DOT digraph.
+func WriteDot(w io.Writer, node ast.Node) error {
+	var out strings.Builder
+	out.WriteString("digraph AST {\n")
+	out.WriteString("  node [shape=box, fontname=\"monospace\"];\n")
+	walk(Build(node), func(id int, n *Node) {
+		fmt.Fprintf(&out, "  n%d [label=%s];\n", id, dotQuote(n.Label()))
+	}, func(from, to int, label string) {
+		fmt.Fprintf(&out, "  n%d -> n%d [label=%s];\n", from, to, dotQuote(label))
+	})
+	out.WriteString("}\n")
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// WriteMermaid writes the AST as a Mermaid flowchart.
+func WriteMermaid(w io.Writer, node ast.Node) error {
+	var out strings.Builder
+	out.WriteString("graph TD\n")
+	walk(Build(node), func(id int, n *Node) {
+		fmt.Fprintf(&out, "  n%d[\"%s\"]\n", id, mermaidEscape(n.Label()))
+	}, func(from, to int, label string) {
+		fmt.Fprintf(&out, "  n%d -->|\"%s\"| n%d\n", from, mermaidEscape(label), to)
+	})
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// walk numbers nodes in depth-first order and calls node for each node and
+// edge for each edge.
+func walk(root *Node, node func(id int, n *Node), edge func(from, to int, label string)) {
+	next := 0
+	var visit func(n *Node) int
+	visit = func(n *Node) int {
+		id := next
+		next++
+		node(id, n)
+		for _, e := range n.Edges {
+			edge(id, visit(e.Node), e.Label)
+		}
+		return id
+	}
+	visit(root)
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func mermaidEscape(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;").Replace(s)
+}
@@ -0,0 +1,93 @@
+package astgraph
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/parser"
+)
+
+func TestWriters(t *testing.T) {
+	p := parser.New(lexer.New("let x = a + b * 2;\nf(!true)"))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	tests := []struct {
+		name     string
+		write    func(*bytes.Buffer) error
+		expected string
+	}{
+		{
+			"tree",
+			func(out *bytes.Buffer) error { return WriteTree(out, program) },
+			`Program
+├── Statements[0]: LetStatement @1:1
+│   ├── Name: Identifier x @1:5
+│   └── Value: InfixExpression + @1:11
+│       ├── Left: Identifier a @1:9
+│       └── Right: InfixExpression * @1:15
+│           ├── Left: Identifier b @1:13
+│           └── Right: IntegerLiteral 2 @1:17
+└── Statements[1]: ExpressionStatement @2:1
+    └── Expression: CallExpression @2:2
+        ├── Function: Identifier f @2:1
+        └── Arguments[0]: PrefixExpression ! @2:3
+            └── Right: Boolean true @2:4
+`,
+		},
+		{
+			"dot",
+			func(out *bytes.Buffer) error { return WriteDot(out, program.Statements[1]) },
+			`digraph AST {
+  node [shape=box, fontname="monospace"];
+  n0 [label="ExpressionStatement @2:1"];
+  n1 [label="CallExpression @2:2"];
+  n2 [label="Identifier f @2:1"];
+  n1 -> n2 [label="Function"];
+  n3 [label="PrefixExpression ! @2:3"];
+  n4 [label="Boolean true @2:4"];
+  n3 -> n4 [label="Right"];
+  n1 -> n3 [label="Arguments[0]"];
+  n0 -> n1 [label="Expression"];
+}
+`,
+		},
+		{
+			"mermaid",
+			func(out *bytes.Buffer) error { return WriteMermaid(out, program.Statements[1]) },
+			`graph TD
+  n0["ExpressionStatement @2:1"]
+  n1["CallExpression @2:2"]
+  n2["Identifier f @2:1"]
+  n1 -->|"Function"| n2
+  n3["PrefixExpression ! @2:3"]
+  n4["Boolean true @2:4"]
+  n3 -->|"Right"| n4
+  n1 -->|"Arguments[0]"| n3
+  n0 -->|"Expression"| n1
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := tt.write(&out); err != nil {
+			t.Fatalf("[%s] unexpected error: %v", tt.name, err)
+		}
+		if out.String() != tt.expected {
+			t.Errorf("[%s] output wrong.\nwant=\n%s\ngot=\n%s", tt.name, tt.expected, out.String())
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	if got := dotQuote(`a "b" \c`); got != `"a \"b\" \\c"` {
+		t.Errorf("dotQuote wrong. got=%s", got)
+	}
+	if got := mermaidEscape(`"<x>"`); got != "#quot;#lt;x#gt;#quot;" {
+		t.Errorf("mermaidEscape wrong. got=%s", got)
+	}
+}
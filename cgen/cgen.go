@@ -0,0 +1,522 @@
+// Package cgen translates Monkey programs into C99.
+//
+// The generated code runs on the runtime in monkey.h, whose values are
+// tagged and whose heap objects are freed by reference counting. Integers
+// are arbitrary-precision, and runtime errors have the messages of the
+// interpreter.
+//
+// Each function literal becomes a C function and every name declared in a
+// Monkey function is a variable of the C function, since a block shares the
+// environment of its function. The variables of the program are globals,
+// and a variable of a function which a nested function refers to is held
+// in a cell shared by the closures. An identifier which may be evaluated
+// before its variable is bound reads the variables of the enclosing
+// functions declaring the name as well, and evaluates to the first one
+// bound as in the interpreter. A C function holds a reference to the
+// value of every variable and intermediate result until it returns, which
+// is safe because a Monkey function has no loops.
+package cgen
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/env"
+)
+
+//go:embed monkey.h
+var header string
+
+// HeaderName is the file name of the runtime header included by the
+// generated code.
+const HeaderName = "monkey.h"
+
+// Header returns the runtime header.
+func Header() string {
+	return header
+}
+
+// Options controls the generated code.
+type Options struct {
+	Source string // name of the Monkey file in the header
+	Inline bool   // include the runtime in the code instead of monkey.h
+}
+
+// Generate returns C code of program. The code defines monkey_run and
+// monkey_free, and a main function printing the value of the program unless
+// MK_NO_MAIN is defined.
+func Generate(program *ast.Program, options Options) string {
+	g := &generator{
+		env:       env.Analyze(program),
+		fns:       map[ast.Node]*function{},
+		constants: map[string]string{},
+	}
+	for i, e := range g.env.Order {
+		f := &function{Function: e, id: i}
+		g.fns[e.Node] = f
+		g.order = append(g.order, f)
+	}
+	g.scan(g.fns[program], program.Statements)
+
+	var functions strings.Builder
+	main := g.body(program, program.Statements)
+	for _, f := range g.order[1:] {
+		functions.WriteString("\n" + f.code)
+	}
+
+	var out strings.Builder
+	if options.Source != "" {
+		fmt.Fprintf(&out, "/* Code generated by monkey cgen from %s. DO NOT EDIT. */\n\n", options.Source)
+	} else {
+		out.WriteString("/* Code generated by monkey cgen. DO NOT EDIT. */\n\n")
+	}
+	out.WriteString("#define MK_IMPLEMENTATION\n")
+	if options.Inline {
+		out.WriteString(header + "\n")
+	} else {
+		out.WriteString("#include \"" + HeaderName + "\"\n\n")
+	}
+	out.WriteString("#include <stdio.h>\n#include <stdlib.h>\n\n")
+
+	globals := g.fns[program].Vars
+	for _, v := range globals {
+		fmt.Fprintf(&out, "static mk_value g_%s;\n", v.Name)
+	}
+	for _, c := range g.constantList {
+		fmt.Fprintf(&out, "static mk_value %s;\n", c.name)
+	}
+	if len(globals)+len(g.constantList) > 0 {
+		out.WriteString("\n")
+	}
+	for _, f := range g.order[1:] {
+		fmt.Fprintf(&out, "static mk_value fn_%d(mk_func *self, const mk_value *args);\n", f.id)
+	}
+	if len(g.order) > 1 {
+		out.WriteString("\n")
+	}
+	out.WriteString("static mk_value program(void)\n" + main)
+	out.WriteString(functions.String())
+
+	out.WriteString(`
+/* monkey_run runs the program, setting result to its value. It returns -1
+ * on a runtime error, whose message is returned by mk_error. */
+int monkey_run(mk_value *result)
+{
+`)
+	for _, c := range g.constantList {
+		fmt.Fprintf(&out, "\tmk_set(&%s, mk_int_parse(\"%s\"));\n", c.name, c.value)
+	}
+	out.WriteString("\treturn mk_run(program, result);\n}\n")
+	out.WriteString(`
+/* monkey_free releases the variables of the program. */
+void monkey_free(void)
+{
+`)
+	for _, v := range globals {
+		fmt.Fprintf(&out, "\tmk_set(&g_%s, mk_null);\n", v.Name)
+	}
+	for _, c := range g.constantList {
+		fmt.Fprintf(&out, "\tmk_set(&%s, mk_null);\n", c.name)
+	}
+	out.WriteString(`}
+
+#ifndef MK_NO_MAIN
+int main(void)
+{
+	mk_value result;
+	if (monkey_run(&result) != 0) {
+		fprintf(stderr, "runtime error: %s\n", mk_error());
+		return 1;
+	}
+	if (result.tag != MK_NULL) {
+		char *s = mk_inspect(result);
+		puts(s);
+		free(s);
+	}
+	mk_release(result);
+	monkey_free();
+#ifdef MK_CHECK_LEAKS
+	fflush(stdout);
+	if (mk_live() != 0)
+		fprintf(stderr, "leaked %ld objects\n", mk_live());
+#endif
+	return 0;
+}
+#endif
+`)
+	return out.String()
+}
+
+// function represents the program or a function literal.
+type function struct {
+	*env.Function
+	id       int
+	captures []*env.Variable // variables of outer functions in the cells of the closure
+	temps    int
+	jumps    bool   // the body jumps to done
+	code     string // the C function
+}
+
+type constant struct {
+	name, value string
+}
+
+type generator struct {
+	env   *env.Info
+	fns   map[ast.Node]*function
+	order []*function // the program first, then outer functions first
+	fn    *function   // function being generated
+
+	constants    map[string]string // names of big integer constants by value
+	constantList []constant
+}
+
+// scan collects the variables of outer functions the identifiers in the
+// function f may refer to into the cells of the closures.
+func (g *generator) scan(f *function, stmts []ast.Statement) {
+	ast.Inspect(&ast.BlockStatement{Statements: stmts}, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			g.scan(g.fns[n], n.Body.Statements)
+			return false
+		case *ast.Identifier:
+			if ref := g.env.Refs[n]; ref != nil {
+				for _, v := range ref.Vars {
+					if v.Fn != f.Function && v.Fn.Outer != nil {
+						g.capture(f, v)
+					}
+				}
+			}
+		}
+		return true
+	})
+}
+
+// capture returns the index of the cell of the variable v of an outer
+// function in the closures of f, adding it to f and the functions between.
+func (g *generator) capture(f *function, v *env.Variable) int {
+	for i, c := range f.captures {
+		if c == v {
+			return i
+		}
+	}
+	if f.Outer != v.Fn {
+		g.capture(g.fns[f.Outer.Node], v)
+	}
+	f.captures = append(f.captures, v)
+	return len(f.captures) - 1
+}
+
+// variable returns the C lvalue of the variable v in the function being
+// generated.
+func (g *generator) variable(v *env.Variable) string {
+	switch {
+	case v.Fn.Outer == nil:
+		return "g_" + v.Name
+	case v.Fn != g.fn.Function:
+		return fmt.Sprintf("self->cells[%d]->value", g.capture(g.fn, v))
+	case v.Captured:
+		return "c_" + v.Name + "->value"
+	}
+	return "v_" + v.Name
+}
+
+// temp returns a new temporary variable of the function being generated.
+func (g *generator) temp() string {
+	g.fn.temps++
+	return fmt.Sprintf("t%d", g.fn.temps)
+}
+
+// body returns the body of the C function of node with the declarations
+// of its variables and the release of them at the end.
+func (g *generator) body(node ast.Node, stmts []ast.Statement) string {
+	outer := g.fn
+	g.fn = g.fns[node]
+	defer func() { g.fn = outer }()
+	f := g.fn
+
+	var body strings.Builder
+	for i, v := range f.Params {
+		if v != nil { // the last argument is bound to the name
+			fmt.Fprintf(&body, "\tmk_set(&%s, mk_retain(args[%d]));\n", g.variable(v), i)
+		}
+	}
+	g.statements(&body, stmts, "result", 1)
+
+	var out strings.Builder
+	out.WriteString("{\n\tmk_value result = mk_null;\n")
+	var locals, cells []string
+	if f.Outer != nil {
+		for _, v := range f.Vars {
+			if v.Captured {
+				cells = append(cells, "c_"+v.Name)
+			} else {
+				locals = append(locals, "v_"+v.Name)
+			}
+		}
+	}
+	for i := 1; i <= f.temps; i++ {
+		locals = append(locals, fmt.Sprintf("t%d", i))
+	}
+	for _, name := range locals {
+		fmt.Fprintf(&out, "\tmk_value %s = MK_UNBOUND_INIT;\n", name)
+	}
+	for _, name := range cells {
+		fmt.Fprintf(&out, "\tmk_cell *%s = mk_cell_new();\n", name)
+	}
+	if f.Outer != nil {
+		out.WriteString("\t(void)self;\n\t(void)args;\n")
+	}
+	out.WriteString(body.String())
+	if f.jumps {
+		out.WriteString("done:\n")
+	}
+	for _, name := range locals {
+		fmt.Fprintf(&out, "\tmk_release(%s);\n", name)
+	}
+	for _, name := range cells {
+		fmt.Fprintf(&out, "\tmk_cell_release(%s);\n", name)
+	}
+	out.WriteString("\treturn result;\n}\n")
+	return out.String()
+}
+
+// statements writes stmts. The value of the last statement is assigned to
+// dest unless dest is empty, and result ends the function.
+func (g *generator) statements(out *strings.Builder, stmts []ast.Statement, dest string, depth int) {
+	for i, stmt := range stmts {
+		d := ""
+		if i == len(stmts)-1 {
+			d = dest
+		}
+		if g.statement(out, stmt, d, depth) {
+			// the rest of the statements is never evaluated
+			return
+		}
+	}
+	if len(stmts) == 0 && dest != "" {
+		g.assign(out, dest, "mk_null", depth)
+	}
+}
+
+// assign writes the assignment of a new reference to value to dest, and a
+// jump to the end if dest is the result.
+func (g *generator) assign(out *strings.Builder, dest, value string, depth int) {
+	indent := strings.Repeat("\t", depth)
+	if value != "mk_null" {
+		value = "mk_retain(" + value + ")"
+	}
+	if dest == "result" {
+		fmt.Fprintf(out, "%sresult = %s;\n%sgoto done;\n", indent, value, indent)
+		g.fn.jumps = true
+		return
+	}
+	fmt.Fprintf(out, "%s%s = %s;\n", indent, dest, value)
+}
+
+// statement writes stmt and reports whether it returns.
+func (g *generator) statement(out *strings.Builder, stmt ast.Statement, dest string, depth int) bool {
+	indent := strings.Repeat("\t", depth)
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		value := g.expression(out, stmt.Value, depth)
+		variable := g.variable(g.fn.Lookup(stmt.Name.Value))
+		fmt.Fprintf(out, "%smk_set(&%s, mk_retain(%s));\n", indent, variable, value)
+		if dest != "" {
+			g.assign(out, dest, "mk_null", depth)
+		}
+	case *ast.ReturnStatement:
+		g.assign(out, "result", g.expression(out, stmt.ReturnValue, depth), depth)
+		return true
+	case *ast.ExpressionStatement:
+		if ie, ok := stmt.Expression.(*ast.IfExpression); ok {
+			g.ifStatement(out, ie, dest, depth)
+			return false
+		}
+		value := g.expression(out, stmt.Expression, depth)
+		if dest != "" {
+			g.assign(out, dest, value, depth)
+		}
+	case *ast.BlockStatement:
+		g.statements(out, stmt.Statements, dest, depth)
+	}
+	return false
+}
+
+// ifStatement writes an if expression as an if statement assigning the
+// value of the branch to dest unless dest is empty.
+func (g *generator) ifStatement(out *strings.Builder, ie *ast.IfExpression, dest string, depth int) {
+	indent := strings.Repeat("\t", depth)
+	cond := g.expression(out, ie.Condition, depth)
+	fmt.Fprintf(out, "%sif (mk_truthy(%s)) {\n", indent, cond)
+	g.statements(out, ie.Consequence.Statements, dest, depth+1)
+	if ie.Alternative != nil {
+		fmt.Fprintf(out, "%s} else {\n", indent)
+		g.statements(out, ie.Alternative.Statements, dest, depth+1)
+	} else if dest != "" {
+		fmt.Fprintf(out, "%s} else {\n", indent)
+		g.assign(out, dest, "mk_null", depth+1)
+	}
+	fmt.Fprintf(out, "%s}\n", indent)
+}
+
+var infixFuncs = map[string]string{
+	"+":  "mk_add",
+	"-":  "mk_sub",
+	"*":  "mk_mul",
+	"/":  "mk_div",
+	"<":  "mk_lt",
+	">":  "mk_gt",
+	"==": "mk_eq",
+	"!=": "mk_ne",
+}
+
+// expression writes the evaluation of exp and returns a C expression of its
+// value, which is owned by the function being generated.
+func (g *generator) expression(out *strings.Builder, exp ast.Expression, depth int) string {
+	indent := strings.Repeat("\t", depth)
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return g.integer(exp)
+	case *ast.Boolean:
+		if exp.Value {
+			return "mk_true"
+		}
+		return "mk_false"
+	case *ast.Identifier:
+		return g.identifier(out, exp, depth)
+	case *ast.PrefixExpression:
+		right := g.expression(out, exp.Right, depth)
+		t := g.temp()
+		fn := "mk_not"
+		if exp.Operator == "-" {
+			fn = "mk_neg"
+		}
+		fmt.Fprintf(out, "%s%s = %s(%s);\n", indent, t, fn, right)
+		return t
+	case *ast.InfixExpression:
+		left := g.expression(out, exp.Left, depth)
+		right := g.expression(out, exp.Right, depth)
+		t := g.temp()
+		fmt.Fprintf(out, "%s%s = %s(%s, %s);\n", indent, t, infixFuncs[exp.Operator], left, right)
+		return t
+	case *ast.IfExpression:
+		t := g.temp()
+		g.ifStatement(out, exp, t, depth)
+		return t
+	case *ast.FunctionLiteral:
+		return g.function(out, exp, depth)
+	case *ast.CallExpression:
+		fn := g.expression(out, exp.Function, depth)
+		args := make([]string, len(exp.Arguments))
+		for i, arg := range exp.Arguments {
+			args[i] = g.expression(out, arg, depth)
+		}
+		argv := "NULL"
+		if len(args) > 0 {
+			argv = "(mk_value[]){" + strings.Join(args, ", ") + "}"
+		}
+		t := g.temp()
+		fmt.Fprintf(out, "%s%s = mk_call(%s, %d, %s);\n", indent, t, fn, len(args), argv)
+		return t
+	}
+	panic(fmt.Sprintf("cgen: unexpected expression %T", exp))
+}
+
+// integer returns an integer literal, which is a global constant unless
+// it fits in 64 bits.
+func (g *generator) integer(lit *ast.IntegerLiteral) string {
+	if lit.Big == nil {
+		if lit.Value > 1<<31-1 || lit.Value < -1<<31 {
+			return fmt.Sprintf("mk_int(INT64_C(%d))", lit.Value)
+		}
+		return fmt.Sprintf("mk_int(%d)", lit.Value)
+	}
+	value := lit.Big.String()
+	if name, ok := g.constants[value]; ok {
+		return name
+	}
+	name := fmt.Sprintf("k_%d", len(g.constantList)+1)
+	g.constants[value] = name
+	g.constantList = append(g.constantList, constant{name, value})
+	return name
+}
+
+// identifier writes a new reference to the value of ident to a temporary,
+// so that the value outlives a later assignment to the variable. A single
+// variable bound whenever ident is evaluated is read directly, and
+// otherwise the runtime takes the first of the variables ident may refer
+// to which is bound.
+func (g *generator) identifier(out *strings.Builder, ident *ast.Identifier, depth int) string {
+	indent := strings.Repeat("\t", depth)
+	ref := g.env.Refs[ident]
+	t := g.temp()
+	switch {
+	case len(ref.Vars) == 0:
+		fmt.Fprintf(out, "%s%s = mk_not_found(%s);\n", indent, t, cString(ident.Value))
+	case len(ref.Vars) == 1 && ref.Bound:
+		fmt.Fprintf(out, "%s%s = mk_retain(%s);\n", indent, t, g.variable(ref.Vars[0]))
+	default:
+		vars := make([]string, len(ref.Vars))
+		for i, v := range ref.Vars {
+			vars[i] = g.variable(v)
+		}
+		fmt.Fprintf(out, "%s%s = mk_get(%s, %d, (mk_value[]){%s});\n",
+			indent, t, cString(ident.Value), len(vars), strings.Join(vars, ", "))
+	}
+	return t
+}
+
+// function generates the C function of fl and writes the creation of its
+// closure.
+func (g *generator) function(out *strings.Builder, fl *ast.FunctionLiteral, depth int) string {
+	indent := strings.Repeat("\t", depth)
+	f := g.fns[fl]
+	params := make([]string, len(fl.Parameters))
+	for i, p := range fl.Parameters {
+		params[i] = p.String()
+	}
+	source := "fn(" + strings.Join(params, ", ") + ") {\n" + fl.Body.String() + "\n}"
+	f.code = fmt.Sprintf("static mk_value fn_%d(mk_func *self, const mk_value *args)\n", f.id) +
+		g.body(fl, fl.Body.Statements)
+
+	cells := make([]string, len(f.captures))
+	for i, v := range f.captures {
+		if v.Fn == g.fn.Function {
+			cells[i] = "c_" + v.Name
+		} else {
+			cells[i] = fmt.Sprintf("self->cells[%d]", g.capture(g.fn, v))
+		}
+	}
+	cellv := "NULL"
+	if len(cells) > 0 {
+		cellv = "(mk_cell *[]){" + strings.Join(cells, ", ") + "}"
+	}
+	t := g.temp()
+	fmt.Fprintf(out, "%s%s = mk_func_new(fn_%d, %d, %s, %d, %s);\n",
+		indent, t, f.id, len(fl.Parameters), cString(source), len(cells), cellv)
+	return t
+}
+
+// cString returns s as a C string literal.
+func cString(s string) string {
+	var out strings.Builder
+	out.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\' || c == '?':
+			out.WriteByte('\\')
+			out.WriteByte(c)
+		case c == '\n':
+			out.WriteString("\\n")
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&out, "\\%03o", c)
+		default:
+			out.WriteByte(c)
+		}
+	}
+	out.WriteByte('"')
+	return out.String()
+}
@@ -0,0 +1,188 @@
+package cgen
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/corpus"
+	"github.com/oohira/monkey/env"
+	"github.com/oohira/monkey/evaluator"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/object"
+	"github.com/oohira/monkey/parser"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+// TestGolden compares the code generated from the programs
+// ../testdata/*.mk shared by the translators with the golden files
+// testdata/*.c.golden.
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob("../testdata/*.mk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := Generate(parse(t, string(src)), Options{Source: filepath.Base(file)})
+
+		golden := filepath.Join("testdata", strings.TrimSuffix(filepath.Base(file), ".mk")+".c.golden")
+		if *update {
+			if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != string(want) {
+			t.Errorf("%s differs from the generated code. got=\n%s", golden, got)
+		}
+	}
+}
+
+func TestCString(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn(x) {\nx\n}", `"fn(x) {\nx\n}"`},
+		{`a"b\c`, `"a\"b\\c"`},
+		{"??=", `"\?\?="`},
+		{"\té", `"\011\303\251"`},
+	}
+	for _, tt := range tests {
+		if got := cString(tt.input); got != tt.expected {
+			t.Errorf("cString(%q) wrong. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+// TestRun compiles the generated programs with the C compiler and compares
+// their output with the result of the evaluator. The programs are built
+// with MK_CHECK_LEAKS, so that a reference count error shows up as a
+// difference in the output, except the cycles which are never freed.
+func TestRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compiling programs in short mode")
+	}
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("cc is not installed")
+	}
+
+	inputs := corpus.Programs()
+	files, _ := filepath.Glob("../testdata/*.mk")
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, string(src))
+	}
+
+	leaked := regexp.MustCompile(`leaked \d+ objects\n$`)
+	dir := t.TempDir()
+	for i, input := range inputs {
+		program := parse(t, input)
+		want := ""
+		switch result := evaluator.Eval(program, object.NewEnvironment()).(type) {
+		case *object.Error:
+			want = "runtime error: " + result.Message + "\n"
+		case *object.Null:
+		default:
+			want = result.Inspect() + "\n"
+		}
+
+		src := filepath.Join(dir, fmt.Sprintf("p%d.c", i))
+		if err := os.WriteFile(src, []byte(Generate(program, Options{Inline: true})), 0644); err != nil {
+			t.Fatal(err)
+		}
+		bin := strings.TrimSuffix(src, ".c")
+		build := exec.Command(cc, "-std=c99", "-pedantic", "-Wall", "-Wextra", "-Werror",
+			"-DMK_CHECK_LEAKS", "-o", bin, src)
+		if out, err := build.CombinedOutput(); err != nil {
+			t.Errorf("cc failed for %q: %s\n%s", input, err, out)
+			continue
+		}
+		out, _ := exec.Command(bin).CombinedOutput()
+		if cyclic(program) {
+			// never freed, as TestCycle checks
+			out = leaked.ReplaceAll(out, nil)
+		}
+		if string(out) != want {
+			t.Errorf("output of %q wrong. want=%q, got=%q", input, want, out)
+		}
+	}
+}
+
+// cyclic reports whether program may make a cycle of a function and a cell,
+// that is, a function declared in another function may refer to itself.
+func cyclic(program *ast.Program) bool {
+	info := env.Analyze(program)
+	found := false
+	ast.Inspect(program, func(n ast.Node) bool {
+		let, ok := n.(*ast.LetStatement)
+		if !ok || let.Name == nil {
+			return true
+		}
+		if fl, ok := let.Value.(*ast.FunctionLiteral); ok {
+			if outer := info.Functions[fl].Outer; outer.Depth > 0 && outer.Lookup(let.Name.Value).Captured {
+				found = true
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// TestCycle checks that a closure referring to itself through a cell is
+// not freed, which reference counting cannot do.
+func TestCycle(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compiling programs in short mode")
+	}
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("cc is not installed")
+	}
+	input := "let f = fn() { let g = fn(n) { if (n == 0) { 0 } else { g(n - 1) } }; g(3) }; f()"
+	dir := t.TempDir()
+	src := filepath.Join(dir, "cycle.c")
+	if err := os.WriteFile(src, []byte(Generate(parse(t, input), Options{})), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, HeaderName), []byte(Header()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(dir, "cycle")
+	if out, err := exec.Command(cc, "-std=c99", "-DMK_CHECK_LEAKS", "-o", bin, src).CombinedOutput(); err != nil {
+		t.Fatalf("cc failed: %s\n%s", err, out)
+	}
+	out, _ := exec.Command(bin).CombinedOutput()
+	if want := "0\nleaked 2 objects\n"; string(out) != want {
+		t.Errorf("output wrong. want=%q, got=%q", want, out)
+	}
+}
@@ -0,0 +1,697 @@
+/*
+ * monkey.h - the runtime of C code generated by monkey cgen.
+ *
+ * A value is tagged: null, booleans and integers fitting in 64 bits are
+ * held in the value itself, while larger integers, functions and the cells
+ * holding variables captured by functions are on the heap and freed by
+ * reference counting. Cycles of functions and cells, made by a function
+ * declared in another function and referring to itself, are never freed.
+ *
+ * Operations borrow their operands and return new references. A runtime
+ * error longjmps to mk_run, leaking the values of the aborted calls.
+ *
+ * Define MK_IMPLEMENTATION in exactly one file before including this file
+ * to compile the implementation. The code is C99.
+ */
+#ifndef MONKEY_H
+#define MONKEY_H
+
+#include <stdbool.h>
+#include <stddef.h>
+#include <stdint.h>
+
+typedef enum {
+	MK_UNBOUND, /* a variable which is not bound yet */
+	MK_NULL,
+	MK_BOOL,
+	MK_INT,
+	MK_BIG,
+	MK_FUNC
+} mk_tag;
+
+typedef struct mk_big mk_big;
+typedef struct mk_func mk_func;
+typedef struct mk_cell mk_cell;
+
+typedef struct {
+	mk_tag tag;
+	union {
+		bool b;
+		int64_t i;
+		mk_big *big;
+		mk_func *func;
+	} as;
+} mk_value;
+
+/* mk_cell holds a variable captured by functions. */
+struct mk_cell {
+	long refs;
+	mk_value value;
+};
+
+typedef mk_value (*mk_code)(mk_func *self, const mk_value *args);
+
+/* mk_func represents a function with the cells of its captured variables. */
+struct mk_func {
+	long refs;
+	int arity;
+	const char *source;
+	mk_code code;
+	int ncells;
+	mk_cell *cells[];
+};
+
+#define MK_UNBOUND_INIT {MK_UNBOUND, {false}}
+#define mk_null ((mk_value){MK_NULL, {false}})
+#define mk_true ((mk_value){MK_BOOL, {true}})
+#define mk_false ((mk_value){MK_BOOL, {false}})
+
+mk_value mk_bool(bool b);
+mk_value mk_int(int64_t i);
+mk_value mk_int_parse(const char *decimal);
+
+mk_value mk_retain(mk_value v);
+void mk_release(mk_value v);
+void mk_set(mk_value *variable, mk_value v); /* takes the reference of v */
+mk_cell *mk_cell_new(void);
+void mk_cell_release(mk_cell *c);
+mk_value mk_func_new(mk_code code, int arity, const char *source, int ncells, mk_cell **cells);
+long mk_live(void); /* number of objects on the heap */
+
+bool mk_truthy(mk_value v);
+const char *mk_type_name(mk_value v);
+mk_value mk_neg(mk_value v);
+mk_value mk_not(mk_value v);
+mk_value mk_add(mk_value a, mk_value b);
+mk_value mk_sub(mk_value a, mk_value b);
+mk_value mk_mul(mk_value a, mk_value b);
+mk_value mk_div(mk_value a, mk_value b);
+mk_value mk_lt(mk_value a, mk_value b);
+mk_value mk_gt(mk_value a, mk_value b);
+mk_value mk_eq(mk_value a, mk_value b);
+mk_value mk_ne(mk_value a, mk_value b);
+mk_value mk_call(mk_value f, int argc, const mk_value *args);
+mk_value mk_get(const char *name, int n, const mk_value *vs); /* the first bound of vs */
+mk_value mk_not_found(const char *name);
+
+char *mk_inspect(mk_value v); /* to be freed by the caller */
+void mk_fail(const char *format, ...);
+int mk_run(mk_value (*program)(void), mk_value *result); /* 0, or -1 on a runtime error */
+const char *mk_error(void);
+
+#endif /* MONKEY_H */
+
+#ifdef MK_IMPLEMENTATION
+#undef MK_IMPLEMENTATION
+
+#include <setjmp.h>
+#include <stdarg.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+/* mk_big is an integer not fitting in 64 bits, a magnitude of n 32-bit
+ * digits from the least significant one with a sign. */
+struct mk_big {
+	long refs;
+	bool neg;
+	size_t n;
+	uint32_t d[];
+};
+
+static long mk_objects;
+static jmp_buf *mk_handler;
+static char mk_message[256];
+
+static void *mk_alloc(size_t size)
+{
+	void *p = calloc(1, size);
+	if (p == NULL) {
+		fputs("monkey: out of memory\n", stderr);
+		abort();
+	}
+	mk_objects++;
+	return p;
+}
+
+static void mk_free(void *p)
+{
+	free(p);
+	mk_objects--;
+}
+
+long mk_live(void) { return mk_objects; }
+
+void mk_fail(const char *format, ...)
+{
+	va_list ap;
+	va_start(ap, format);
+	vsnprintf(mk_message, sizeof mk_message, format, ap);
+	va_end(ap);
+	if (mk_handler == NULL) {
+		fprintf(stderr, "runtime error: %s\n", mk_message);
+		exit(1);
+	}
+	longjmp(*mk_handler, 1);
+}
+
+const char *mk_error(void) { return mk_message; }
+
+int mk_run(mk_value (*program)(void), mk_value *result)
+{
+	jmp_buf handler;
+	jmp_buf *outer = mk_handler;
+	mk_handler = &handler;
+	if (setjmp(handler) != 0) {
+		mk_handler = outer;
+		*result = mk_null;
+		return -1;
+	}
+	*result = program();
+	mk_handler = outer;
+	return 0;
+}
+
+mk_value mk_bool(bool b) { return b ? mk_true : mk_false; }
+
+mk_value mk_int(int64_t i)
+{
+	mk_value v = {MK_INT, {false}};
+	v.as.i = i;
+	return v;
+}
+
+mk_value mk_retain(mk_value v)
+{
+	switch (v.tag) {
+	case MK_BIG:
+		v.as.big->refs++;
+		break;
+	case MK_FUNC:
+		v.as.func->refs++;
+		break;
+	default:
+		break;
+	}
+	return v;
+}
+
+void mk_release(mk_value v)
+{
+	switch (v.tag) {
+	case MK_BIG:
+		if (--v.as.big->refs == 0)
+			mk_free(v.as.big);
+		break;
+	case MK_FUNC:
+		if (--v.as.func->refs == 0) {
+			int i;
+			for (i = 0; i < v.as.func->ncells; i++)
+				mk_cell_release(v.as.func->cells[i]);
+			mk_free(v.as.func);
+		}
+		break;
+	default:
+		break;
+	}
+}
+
+void mk_set(mk_value *variable, mk_value v)
+{
+	mk_value old = *variable;
+	*variable = v;
+	mk_release(old);
+}
+
+mk_cell *mk_cell_new(void)
+{
+	mk_cell *c = mk_alloc(sizeof *c);
+	c->refs = 1;
+	return c;
+}
+
+void mk_cell_release(mk_cell *c)
+{
+	if (--c->refs == 0) {
+		mk_release(c->value);
+		mk_free(c);
+	}
+}
+
+mk_value mk_func_new(mk_code code, int arity, const char *source, int ncells, mk_cell **cells)
+{
+	mk_value v = {MK_FUNC, {false}};
+	int i;
+	mk_func *f = mk_alloc(sizeof *f + (size_t)ncells * sizeof f->cells[0]);
+	f->refs = 1;
+	f->arity = arity;
+	f->source = source;
+	f->code = code;
+	f->ncells = ncells;
+	for (i = 0; i < ncells; i++) {
+		f->cells[i] = cells[i];
+		cells[i]->refs++;
+	}
+	v.as.func = f;
+	return v;
+}
+
+bool mk_truthy(mk_value v)
+{
+	return v.tag != MK_NULL && !(v.tag == MK_BOOL && !v.as.b);
+}
+
+const char *mk_type_name(mk_value v)
+{
+	switch (v.tag) {
+	case MK_NULL:
+		return "NULL";
+	case MK_BOOL:
+		return "BOOLEAN";
+	case MK_INT:
+	case MK_BIG:
+		return "INTEGER";
+	case MK_FUNC:
+		return "FUNCTION";
+	default:
+		return "UNBOUND";
+	}
+}
+
+static bool mk_is_int(mk_value v) { return v.tag == MK_INT || v.tag == MK_BIG; }
+
+/* Arithmetic of magnitudes */
+
+/* mk_mag is a signed magnitude viewing an integer of either kind. */
+typedef struct {
+	const uint32_t *d;
+	size_t n;
+	bool neg;
+	uint32_t buf[2];
+} mk_mag;
+
+static void mk_to_mag(mk_value v, mk_mag *m)
+{
+	if (v.tag == MK_BIG) {
+		m->d = v.as.big->d;
+		m->n = v.as.big->n;
+		m->neg = v.as.big->neg;
+		return;
+	} else {
+		uint64_t u = (uint64_t)v.as.i;
+		m->neg = v.as.i < 0;
+		if (m->neg)
+			u = (uint64_t)0 - u;
+		m->buf[0] = (uint32_t)u;
+		m->buf[1] = (uint32_t)(u >> 32);
+		m->d = m->buf;
+		m->n = m->buf[1] != 0 ? 2 : m->buf[0] != 0 ? 1 : 0;
+	}
+}
+
+static mk_big *mk_big_new(size_t n)
+{
+	mk_big *b = mk_alloc(sizeof *b + (n > 0 ? n : 1) * sizeof b->d[0]);
+	b->refs = 1;
+	b->n = n;
+	return b;
+}
+
+/* mk_normalize returns the value of b, which is an integer fitting in 64
+ * bits if possible. */
+static mk_value mk_normalize(mk_big *b)
+{
+	mk_value v = {MK_BIG, {false}};
+	while (b->n > 0 && b->d[b->n - 1] == 0)
+		b->n--;
+	if (b->n <= 2) {
+		uint64_t u = b->n == 0 ? 0 : b->d[0];
+		if (b->n == 2)
+			u |= (uint64_t)b->d[1] << 32;
+		if (!b->neg && u <= (uint64_t)INT64_MAX) {
+			mk_free(b);
+			return mk_int((int64_t)u);
+		}
+		if (b->neg && u <= (uint64_t)INT64_MAX + 1) {
+			mk_free(b);
+			return mk_int(u == (uint64_t)INT64_MAX + 1 ? INT64_MIN : -(int64_t)u);
+		}
+	}
+	v.as.big = b;
+	return v;
+}
+
+static int mk_mag_cmp(const mk_mag *a, const mk_mag *b)
+{
+	size_t i;
+	if (a->n != b->n)
+		return a->n < b->n ? -1 : 1;
+	for (i = a->n; i > 0; i--) {
+		if (a->d[i - 1] != b->d[i - 1])
+			return a->d[i - 1] < b->d[i - 1] ? -1 : 1;
+	}
+	return 0;
+}
+
+static mk_big *mk_mag_add(const mk_mag *a, const mk_mag *b)
+{
+	size_t n = (a->n > b->n ? a->n : b->n) + 1, i;
+	mk_big *r = mk_big_new(n);
+	uint64_t carry = 0;
+	for (i = 0; i < n; i++) {
+		uint64_t s = carry;
+		if (i < a->n)
+			s += a->d[i];
+		if (i < b->n)
+			s += b->d[i];
+		r->d[i] = (uint32_t)s;
+		carry = s >> 32;
+	}
+	return r;
+}
+
+/* mk_mag_sub returns |a| - |b| for |a| >= |b|. */
+static mk_big *mk_mag_sub(const mk_mag *a, const mk_mag *b)
+{
+	mk_big *r = mk_big_new(a->n);
+	int64_t borrow = 0;
+	size_t i;
+	for (i = 0; i < a->n; i++) {
+		int64_t s = (int64_t)a->d[i] - borrow - (i < b->n ? (int64_t)b->d[i] : 0);
+		borrow = s < 0;
+		r->d[i] = (uint32_t)(s + (borrow ? (int64_t)1 << 32 : 0));
+	}
+	return r;
+}
+
+static mk_big *mk_mag_mul(const mk_mag *a, const mk_mag *b)
+{
+	mk_big *r = mk_big_new(a->n + b->n);
+	size_t i, j;
+	for (i = 0; i < a->n; i++) {
+		uint64_t carry = 0;
+		for (j = 0; j < b->n; j++) {
+			uint64_t t = (uint64_t)a->d[i] * b->d[j] + r->d[i + j] + carry;
+			r->d[i + j] = (uint32_t)t;
+			carry = t >> 32;
+		}
+		r->d[i + b->n] = (uint32_t)carry;
+	}
+	return r;
+}
+
+/* mk_mag_div returns |a| / |b| for b != 0 by binary long division. */
+static mk_big *mk_mag_div(const mk_mag *a, const mk_mag *b)
+{
+	mk_big *q = mk_big_new(a->n);
+	uint32_t *rem = calloc(b->n + 1, sizeof *rem);
+	size_t bit;
+	if (rem == NULL) {
+		fputs("monkey: out of memory\n", stderr);
+		abort();
+	}
+	for (bit = a->n * 32; bit > 0; bit--) {
+		size_t k = bit - 1, i;
+		mk_mag r;
+		/* rem = rem << 1 | bit k of a */
+		for (i = b->n + 1; i > 0; i--)
+			rem[i - 1] = (rem[i - 1] << 1) | (i > 1 ? rem[i - 2] >> 31 : 0);
+		rem[0] |= (a->d[k / 32] >> (k % 32)) & 1;
+		r.d = rem;
+		r.n = b->n + 1;
+		while (r.n > 0 && rem[r.n - 1] == 0)
+			r.n--;
+		if (mk_mag_cmp(&r, b) >= 0) {
+			int64_t borrow = 0;
+			for (i = 0; i <= b->n; i++) {
+				int64_t s = (int64_t)rem[i] - borrow - (i < b->n ? (int64_t)b->d[i] : 0);
+				borrow = s < 0;
+				rem[i] = (uint32_t)(s + (borrow ? (int64_t)1 << 32 : 0));
+			}
+			q->d[k / 32] |= (uint32_t)1 << (k % 32);
+		}
+	}
+	free(rem);
+	return q;
+}
+
+/* mk_add_mags returns a + b, or a - b if negate is true. */
+static mk_value mk_add_mags(mk_value a, mk_value b, bool negate)
+{
+	mk_mag ma, mb;
+	mk_big *r;
+	mk_to_mag(a, &ma);
+	mk_to_mag(b, &mb);
+	if (negate)
+		mb.neg = !mb.neg;
+	if (ma.neg == mb.neg) {
+		r = mk_mag_add(&ma, &mb);
+		r->neg = ma.neg;
+	} else if (mk_mag_cmp(&ma, &mb) >= 0) {
+		r = mk_mag_sub(&ma, &mb);
+		r->neg = ma.neg;
+	} else {
+		r = mk_mag_sub(&mb, &ma);
+		r->neg = mb.neg;
+	}
+	return mk_normalize(r);
+}
+
+static int mk_cmp(mk_value a, mk_value b)
+{
+	mk_mag ma, mb;
+	int c;
+	if (a.tag == MK_INT && b.tag == MK_INT)
+		return a.as.i < b.as.i ? -1 : a.as.i > b.as.i;
+	mk_to_mag(a, &ma);
+	mk_to_mag(b, &mb);
+	if (ma.neg != mb.neg)
+		return ma.neg ? -1 : 1;
+	c = mk_mag_cmp(&ma, &mb);
+	return ma.neg ? -c : c;
+}
+
+mk_value mk_int_parse(const char *decimal)
+{
+	size_t len = strlen(decimal), i, j;
+	mk_big *b = mk_big_new(len / 9 + 1);
+	b->n = 0;
+	for (i = 0; i < len; i++) {
+		uint64_t carry = (uint64_t)(decimal[i] - '0');
+		for (j = 0; j < b->n; j++) {
+			uint64_t t = (uint64_t)b->d[j] * 10 + carry;
+			b->d[j] = (uint32_t)t;
+			carry = t >> 32;
+		}
+		if (carry != 0)
+			b->d[b->n++] = (uint32_t)carry;
+	}
+	return mk_normalize(b);
+}
+
+/* Operators */
+
+static void mk_check_ints(const char *op, mk_value a, mk_value b)
+{
+	if (mk_is_int(a) && mk_is_int(b))
+		return;
+	if (strcmp(mk_type_name(a), mk_type_name(b)) != 0)
+		mk_fail("type mismatch: %s %s %s", mk_type_name(a), op, mk_type_name(b));
+	mk_fail("unknown operator: %s %s %s", mk_type_name(a), op, mk_type_name(b));
+}
+
+mk_value mk_neg(mk_value v)
+{
+	if (v.tag == MK_INT && v.as.i != INT64_MIN)
+		return mk_int(-v.as.i);
+	if (!mk_is_int(v))
+		mk_fail("unknown operator: -%s", mk_type_name(v));
+	return mk_add_mags(mk_int(0), v, true);
+}
+
+mk_value mk_not(mk_value v) { return mk_bool(!mk_truthy(v)); }
+
+mk_value mk_add(mk_value a, mk_value b)
+{
+	mk_check_ints("+", a, b);
+	if (a.tag == MK_INT && b.tag == MK_INT &&
+	    !(b.as.i > 0 && a.as.i > INT64_MAX - b.as.i) &&
+	    !(b.as.i < 0 && a.as.i < INT64_MIN - b.as.i))
+		return mk_int(a.as.i + b.as.i);
+	return mk_add_mags(a, b, false);
+}
+
+mk_value mk_sub(mk_value a, mk_value b)
+{
+	mk_check_ints("-", a, b);
+	if (a.tag == MK_INT && b.tag == MK_INT &&
+	    !(b.as.i < 0 && a.as.i > INT64_MAX + b.as.i) &&
+	    !(b.as.i > 0 && a.as.i < INT64_MIN + b.as.i))
+		return mk_int(a.as.i - b.as.i);
+	return mk_add_mags(a, b, true);
+}
+
+mk_value mk_mul(mk_value a, mk_value b)
+{
+	mk_mag ma, mb;
+	mk_big *r;
+	mk_check_ints("*", a, b);
+	if (a.tag == MK_INT && b.tag == MK_INT &&
+	    a.as.i >= -INT32_MAX && a.as.i <= INT32_MAX &&
+	    b.as.i >= -INT32_MAX && b.as.i <= INT32_MAX)
+		return mk_int(a.as.i * b.as.i);
+	mk_to_mag(a, &ma);
+	mk_to_mag(b, &mb);
+	r = mk_mag_mul(&ma, &mb);
+	r->neg = ma.neg != mb.neg;
+	return mk_normalize(r);
+}
+
+mk_value mk_div(mk_value a, mk_value b)
+{
+	mk_mag ma, mb;
+	mk_big *r;
+	mk_check_ints("/", a, b);
+	if (b.tag == MK_INT && b.as.i == 0)
+		mk_fail("division by zero");
+	if (a.tag == MK_INT && b.tag == MK_INT && !(a.as.i == INT64_MIN && b.as.i == -1))
+		return mk_int(a.as.i / b.as.i);
+	mk_to_mag(a, &ma);
+	mk_to_mag(b, &mb);
+	r = mk_mag_div(&ma, &mb);
+	r->neg = ma.neg != mb.neg;
+	return mk_normalize(r);
+}
+
+mk_value mk_lt(mk_value a, mk_value b)
+{
+	mk_check_ints("<", a, b);
+	return mk_bool(mk_cmp(a, b) < 0);
+}
+
+mk_value mk_gt(mk_value a, mk_value b)
+{
+	mk_check_ints(">", a, b);
+	return mk_bool(mk_cmp(a, b) > 0);
+}
+
+/* mk_equal compares integers by value and other values by identity. */
+static bool mk_equal(mk_value a, mk_value b)
+{
+	if (mk_is_int(a) && mk_is_int(b))
+		return mk_cmp(a, b) == 0;
+	if (a.tag != b.tag)
+		return false;
+	switch (a.tag) {
+	case MK_BOOL:
+		return a.as.b == b.as.b;
+	case MK_FUNC:
+		return a.as.func == b.as.func;
+	default:
+		return true;
+	}
+}
+
+mk_value mk_eq(mk_value a, mk_value b) { return mk_bool(mk_equal(a, b)); }
+
+mk_value mk_ne(mk_value a, mk_value b) { return mk_bool(!mk_equal(a, b)); }
+
+mk_value mk_call(mk_value f, int argc, const mk_value *args)
+{
+	if (f.tag != MK_FUNC)
+		mk_fail("not a function: %s", mk_type_name(f));
+	if (argc != f.as.func->arity)
+		mk_fail("wrong number of arguments: want=%d, got=%d", f.as.func->arity, argc);
+	return f.as.func->code(f.as.func, args);
+}
+
+mk_value mk_get(const char *name, int n, const mk_value *vs)
+{
+	int i;
+	for (i = 0; i < n; i++) {
+		if (vs[i].tag != MK_UNBOUND)
+			return mk_retain(vs[i]);
+	}
+	mk_fail("identifier not found: %s", name);
+	return mk_null;
+}
+
+mk_value mk_not_found(const char *name)
+{
+	mk_fail("identifier not found: %s", name);
+	return mk_null;
+}
+
+static char *mk_strdup(const char *s)
+{
+	char *p = malloc(strlen(s) + 1);
+	if (p == NULL) {
+		fputs("monkey: out of memory\n", stderr);
+		abort();
+	}
+	return strcpy(p, s);
+}
+
+/* mk_big_string returns the decimal representation of b, dividing a copy
+ * of its magnitude by 10^9 repeatedly. */
+static char *mk_big_string(const mk_big *b)
+{
+	size_t n = b->n, len = 0, i;
+	uint32_t *d = malloc(n * sizeof *d);
+	char *digits = malloc(n * 10 + 2), *s;
+	if (d == NULL || digits == NULL) {
+		fputs("monkey: out of memory\n", stderr);
+		abort();
+	}
+	memcpy(d, b->d, n * sizeof *d);
+	while (n > 0) {
+		uint64_t rem = 0;
+		int k;
+		for (i = n; i > 0; i--) {
+			uint64_t t = rem << 32 | d[i - 1];
+			d[i - 1] = (uint32_t)(t / 1000000000);
+			rem = t % 1000000000;
+		}
+		while (n > 0 && d[n - 1] == 0)
+			n--;
+		for (k = 0; k < 9 && (n > 0 || rem > 0); k++) {
+			digits[len++] = (char)('0' + rem % 10);
+			rem /= 10;
+		}
+	}
+	if (b->neg)
+		digits[len++] = '-';
+	s = malloc(len + 1);
+	if (s == NULL) {
+		fputs("monkey: out of memory\n", stderr);
+		abort();
+	}
+	for (i = 0; i < len; i++)
+		s[i] = digits[len - 1 - i];
+	s[len] = '\0';
+	free(d);
+	free(digits);
+	return s;
+}
+
+char *mk_inspect(mk_value v)
+{
+	char buf[32];
+	switch (v.tag) {
+	case MK_NULL:
+		return mk_strdup("null");
+	case MK_BOOL:
+		return mk_strdup(v.as.b ? "true" : "false");
+	case MK_INT:
+		snprintf(buf, sizeof buf, "%lld", (long long)v.as.i);
+		return mk_strdup(buf);
+	case MK_BIG:
+		return mk_big_string(v.as.big);
+	case MK_FUNC:
+		return mk_strdup(v.as.func->source);
+	default:
+		return mk_strdup("unbound");
+	}
+}
+
+#endif /* MK_IMPLEMENTATION */
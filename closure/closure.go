@@ -0,0 +1,418 @@
+// Package closure runs Monkey programs by compiling them into trees of Go
+// closures.
+//
+// Each expression is converted once into a func taking the frame of the
+// running function, so that the program is not dispatched on the type of
+// the nodes again when it runs. A frame has a slot for every parameter and
+// every name declared by a let statement in its function, including those
+// of its blocks since they share the environment of the function, and
+// identifiers are resolved to slot indices at compile time. A name of
+// several parameters refers to the slot of the last one, which the
+// evaluator binds last.
+//
+// The frames are shared by the closures created in them, and a slot is nil
+// until its let statement runs. An identifier referring to an unset slot
+// falls back to the enclosing functions declaring the name, as the lookup in
+// an environment does, so that the results and the runtime errors are those
+// of the evaluator.
+package closure
+
+import (
+	"fmt"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/object"
+)
+
+// MaxDepth is the depth of calls at which a program fails with a stack
+// overflow.
+const MaxDepth = 1 << 14
+
+// Program represents a compiled program.
+type Program struct {
+	size int
+	run  eval
+}
+
+// Compile compiles program into closures.
+func Compile(program *ast.Program) *Program {
+	s := newScope(nil, nil, program.Statements)
+	return &Program{size: s.size, run: statements(s, program.Statements)}
+}
+
+// Run runs the program in a new frame and returns the result. A runtime
+// error is returned as an *object.Error.
+func (p *Program) Run() object.Object {
+	result := p.run(&frame{slots: make([]object.Object, p.size)})
+	if rv, ok := result.(*object.ReturnValue); ok {
+		return rv.Value
+	}
+	return result
+}
+
+// Function represents a function literal evaluated in a frame.
+type Function struct {
+	Literal *ast.FunctionLiteral
+	code    *function
+	env     *frame
+}
+
+// Type returns the type of the function.
+func (f *Function) Type() object.Type {
+	return object.FUNCTION
+}
+
+// Inspect returns the source of the function in the format of the
+// evaluator.
+func (f *Function) Inspect() string {
+	return (&object.Function{Parameters: f.Literal.Parameters, Body: f.Literal.Body}).Inspect()
+}
+
+// function is the compiled body of a function literal. The first slots of
+// its frames are the parameters.
+type function struct {
+	arity int
+	size  int
+	body  eval
+}
+
+// frame holds the values of the names declared in a running function.
+// depth is the number of the calls running.
+type frame struct {
+	slots []object.Object
+	outer *frame
+	depth int
+}
+
+// eval is a compiled expression or statement. It returns an *object.Error
+// or an *object.ReturnValue to abort the enclosing expressions, as the
+// evaluator does.
+type eval func(f *frame) object.Object
+
+// scope maps the names declared in the program or a function to slots.
+type scope struct {
+	names map[string]int
+	size  int // number of slots
+	outer *scope
+}
+
+// newScope returns the scope of a function with params and the let
+// statements of stmts and of the blocks in them.
+func newScope(outer *scope, params []*ast.Identifier, stmts []ast.Statement) *scope {
+	s := &scope{names: map[string]int{}, outer: outer}
+	for _, param := range params {
+		s.names[param.Value] = s.size
+		s.size++
+	}
+	for _, stmt := range stmts {
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.LetStatement:
+				if n.Name != nil {
+					s.declare(n.Name.Value)
+				}
+			case *ast.FunctionLiteral:
+				return false
+			}
+			return true
+		})
+	}
+	return s
+}
+
+func (s *scope) declare(name string) {
+	if _, ok := s.names[name]; !ok {
+		s.names[name] = s.size
+		s.size++
+	}
+}
+
+// location is a slot of the frame depth levels out of the current one.
+type location struct {
+	depth int
+	index int
+}
+
+// lookup returns the slots which name may refer to, innermost first.
+func (s *scope) lookup(name string) []location {
+	var locs []location
+	for depth := 0; s != nil; depth, s = depth+1, s.outer {
+		if index, ok := s.names[name]; ok {
+			locs = append(locs, location{depth, index})
+		}
+	}
+	return locs
+}
+
+func statements(s *scope, stmts []ast.Statement) eval {
+	evals := make([]eval, len(stmts))
+	for i, stmt := range stmts {
+		evals[i] = statement(s, stmt)
+	}
+	switch len(evals) {
+	case 0:
+		return func(*frame) object.Object { return object.NullValue }
+	case 1:
+		return evals[0]
+	}
+	return func(f *frame) object.Object {
+		var result object.Object
+		for _, e := range evals {
+			result = e(f)
+			if aborted(result) {
+				return result
+			}
+		}
+		return result
+	}
+}
+
+func statement(s *scope, stmt ast.Statement) eval {
+	switch stmt := stmt.(type) {
+	case *ast.ExpressionStatement:
+		return expression(s, stmt.Expression)
+	case *ast.LetStatement:
+		value := expression(s, stmt.Value)
+		index := s.names[stmt.Name.Value]
+		return func(f *frame) object.Object {
+			val := value(f)
+			if aborted(val) {
+				return val
+			}
+			f.slots[index] = val
+			return object.NullValue
+		}
+	case *ast.ReturnStatement:
+		value := expression(s, stmt.ReturnValue)
+		return func(f *frame) object.Object {
+			val := value(f)
+			if aborted(val) {
+				return val
+			}
+			return &object.ReturnValue{Value: val}
+		}
+	case *ast.BlockStatement:
+		return statements(s, stmt.Statements)
+	}
+	return failure(newError("cannot evaluate %T", stmt))
+}
+
+func expression(s *scope, exp ast.Expression) eval {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		var val object.Object
+		if exp.Big != nil {
+			val = object.NewBigInteger(exp.Big)
+		} else {
+			val = object.NewInteger(exp.Value)
+		}
+		return func(*frame) object.Object { return val }
+	case *ast.Boolean:
+		val := object.NativeBool(exp.Value)
+		return func(*frame) object.Object { return val }
+	case *ast.PrefixExpression:
+		return prefix(s, exp)
+	case *ast.InfixExpression:
+		return infix(s, exp)
+	case *ast.IfExpression:
+		return ifExpression(s, exp)
+	case *ast.Identifier:
+		return identifier(s, exp)
+	case *ast.FunctionLiteral:
+		return functionLiteral(s, exp)
+	case *ast.CallExpression:
+		return call(s, exp)
+	}
+	return failure(newError("cannot evaluate %T", exp))
+}
+
+func prefix(s *scope, pe *ast.PrefixExpression) eval {
+	right := expression(s, pe.Right)
+	if pe.Operator == "!" {
+		return func(f *frame) object.Object {
+			val := right(f)
+			if aborted(val) {
+				return val
+			}
+			return object.NativeBool(!object.IsTruthy(val))
+		}
+	}
+	operator := pe.Operator
+	return func(f *frame) object.Object {
+		val := right(f)
+		if aborted(val) {
+			return val
+		}
+		return result(object.Prefix(operator, val))
+	}
+}
+
+// integerOps are the infix operators on two integers, which are called
+// without the dispatch of object.Infix.
+var integerOps = map[string]func(l, r *object.Integer) object.Object{
+	"+": func(l, r *object.Integer) object.Object { return l.Add(r) },
+	"-": func(l, r *object.Integer) object.Object { return l.Sub(r) },
+	"*": func(l, r *object.Integer) object.Object { return l.Mul(r) },
+	"/": func(l, r *object.Integer) object.Object {
+		q, err := l.Div(r)
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		return q
+	},
+	"<":  func(l, r *object.Integer) object.Object { return object.NativeBool(l.Cmp(r) < 0) },
+	">":  func(l, r *object.Integer) object.Object { return object.NativeBool(l.Cmp(r) > 0) },
+	"==": func(l, r *object.Integer) object.Object { return object.NativeBool(l.Cmp(r) == 0) },
+	"!=": func(l, r *object.Integer) object.Object { return object.NativeBool(l.Cmp(r) != 0) },
+}
+
+func infix(s *scope, ie *ast.InfixExpression) eval {
+	left := expression(s, ie.Left)
+	right := expression(s, ie.Right)
+	operator := ie.Operator
+	op := integerOps[operator]
+	return func(f *frame) object.Object {
+		l := left(f)
+		if aborted(l) {
+			return l
+		}
+		r := right(f)
+		if aborted(r) {
+			return r
+		}
+		if li, ok := l.(*object.Integer); ok && op != nil {
+			if ri, ok := r.(*object.Integer); ok {
+				return op(li, ri)
+			}
+		}
+		return result(object.Infix(operator, l, r))
+	}
+}
+
+func ifExpression(s *scope, ie *ast.IfExpression) eval {
+	condition := expression(s, ie.Condition)
+	consequence := statements(s, ie.Consequence.Statements)
+	alternative := func(*frame) object.Object { return object.NullValue }
+	if ie.Alternative != nil {
+		alternative = statements(s, ie.Alternative.Statements)
+	}
+	return func(f *frame) object.Object {
+		cond := condition(f)
+		if aborted(cond) {
+			return cond
+		}
+		if object.IsTruthy(cond) {
+			return consequence(f)
+		}
+		return alternative(f)
+	}
+}
+
+func identifier(s *scope, ident *ast.Identifier) eval {
+	message := "identifier not found: " + ident.Value
+	locs := s.lookup(ident.Value)
+	switch {
+	case len(locs) == 0:
+		return func(*frame) object.Object { return &object.Error{Message: message} }
+	case len(locs) == 1 && locs[0].depth == 0:
+		index := locs[0].index
+		return func(f *frame) object.Object {
+			if val := f.slots[index]; val != nil {
+				return val
+			}
+			return &object.Error{Message: message}
+		}
+	}
+	return func(f *frame) object.Object {
+		depth := 0
+		for _, loc := range locs {
+			for ; depth < loc.depth; depth++ {
+				f = f.outer
+			}
+			if val := f.slots[loc.index]; val != nil {
+				return val
+			}
+		}
+		return &object.Error{Message: message}
+	}
+}
+
+func functionLiteral(s *scope, fl *ast.FunctionLiteral) eval {
+	inner := newScope(s, fl.Parameters, fl.Body.Statements)
+	code := &function{arity: len(fl.Parameters), body: statements(inner, fl.Body.Statements)}
+	code.size = inner.size
+	return func(f *frame) object.Object {
+		return &Function{Literal: fl, code: code, env: f}
+	}
+}
+
+func call(s *scope, ce *ast.CallExpression) eval {
+	callee := expression(s, ce.Function)
+	args := make([]eval, len(ce.Arguments))
+	for i, arg := range ce.Arguments {
+		args[i] = expression(s, arg)
+	}
+	return func(f *frame) object.Object {
+		val := callee(f)
+		if aborted(val) {
+			return val
+		}
+		fn, ok := val.(*Function)
+		size := len(args)
+		if ok && fn.code.size > size {
+			size = fn.code.size
+		}
+
+		// the arguments are evaluated into the frame of the call
+		slots := make([]object.Object, size)
+		for i, arg := range args {
+			v := arg(f)
+			if aborted(v) {
+				return v
+			}
+			slots[i] = v
+		}
+		if !ok {
+			return newError("not a function: %s", val.Type())
+		}
+		if len(args) != fn.code.arity {
+			return newError("wrong number of arguments: want=%d, got=%d", fn.code.arity, len(args))
+		}
+		if f.depth >= MaxDepth {
+			return newError("stack overflow")
+		}
+		result := fn.code.body(&frame{slots: slots, outer: fn.env, depth: f.depth + 1})
+		if rv, ok := result.(*object.ReturnValue); ok {
+			return rv.Value
+		}
+		return result
+	}
+}
+
+// failure returns an eval returning err, for nodes which cannot be
+// evaluated.
+func failure(err *object.Error) eval {
+	return func(*frame) object.Object { return err }
+}
+
+// result converts the result of an operator into an object.
+func result(obj object.Object, err error) object.Object {
+	if err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	return obj
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
+
+// aborted reports whether obj is an error or a return value, either of
+// which aborts the evaluation of the enclosing expressions.
+func aborted(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Error, *object.ReturnValue:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package closure
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/corpus"
+	"github.com/oohira/monkey/evaluator"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/object"
+	"github.com/oohira/monkey/parser"
+)
+
+func parse(t testing.TB, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+// TestRun compares the results of the programs of the corpus, compiled,
+// with those of the evaluator.
+func TestRun(t *testing.T) {
+	inputs := append([]string{""}, corpus.Programs()...)
+	for _, input := range inputs {
+		program := parse(t, input)
+		want := evaluator.Eval(program, object.NewEnvironment()).Inspect()
+		if got := Compile(program).Run().Inspect(); got != want {
+			t.Errorf("result of %q wrong. want=%s, got=%s", input, want, got)
+		}
+	}
+}
+
+func TestFunction(t *testing.T) {
+	fn, ok := Compile(parse(t, "let f = fn(x) { fn(y) { x + y } }; f(1)")).Run().(*Function)
+	if !ok {
+		t.Fatalf("object is not Function")
+	}
+	if fn.Literal.String() != "fn(y) (x + y)" {
+		t.Errorf("literal wrong. got=%s", fn.Literal)
+	}
+	if fn.Type() != object.FUNCTION {
+		t.Errorf("type wrong. got=%s", fn.Type())
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	got := Compile(parse(t, "let f = fn(n) { f(n + 1) + 1 }; f(0)")).Run()
+	if got.Inspect() != "ERROR: stack overflow" {
+		t.Errorf("result wrong. got=%s", got.Inspect())
+	}
+}
+
+// TestRunTwice checks that a compiled program can run again with new
+// frames.
+func TestRunTwice(t *testing.T) {
+	p := Compile(parse(t, "let x = if (true) { 1 } else { 2 }; let f = fn() { x }; f() + x"))
+	for i := 0; i < 2; i++ {
+		if got := p.Run().Inspect(); got != "2" {
+			t.Errorf("result of run %d wrong. got=%s", i, got)
+		}
+	}
+}
+
+// BenchmarkRun runs the programs ../testdata/*.mk shared by the
+// implementations, whose results must be those of the evaluator.
+func BenchmarkRun(b *testing.B) {
+	files, err := filepath.Glob("../testdata/*.mk")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			b.Fatal(err)
+		}
+		program := parse(b, string(src))
+		want := evaluator.Eval(program, object.NewEnvironment()).Inspect()
+		b.Run(strings.TrimSuffix(filepath.Base(file), ".mk"), func(b *testing.B) {
+			p := Compile(program)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if got := p.Run().Inspect(); got != want {
+					b.Fatalf("result wrong. want=%s, got=%s", want, got)
+				}
+			}
+		})
+	}
+}
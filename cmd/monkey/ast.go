@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/oohira/monkey/astgraph"
+	"github.com/oohira/monkey/sexpr"
+)
+
+func runAST(args []string) error {
+	fs := flag.NewFlagSet("ast", flag.ExitOnError)
+	format := fs.String("format", "tree", "output format: tree, dot, mermaid or sexpr")
+	fs.Parse(args)
+
+	program, err := parseFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	switch *format {
+	case "tree":
+		return astgraph.WriteTree(out, program)
+	case "dot":
+		return astgraph.WriteDot(out, program)
+	case "mermaid":
+		return astgraph.WriteMermaid(out, program)
+	case "sexpr":
+		_, err := fmt.Fprintln(out, sexpr.Encode(program))
+		return err
+	}
+	return fmt.Errorf("unknown format %q", *format)
+}
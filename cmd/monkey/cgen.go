@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/oohira/monkey/cgen"
+)
+
+func runCgen(args []string) error {
+	fs := flag.NewFlagSet("cgen", flag.ExitOnError)
+	output := fs.String("o", "", "output file, next to which monkey.h is written (default: stdout with the runtime inlined)")
+	inline := fs.Bool("inline", false, "include the runtime in the output file instead of writing monkey.h")
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		return errors.New("usage: monkey cgen [-inline] [-o out.c] [file.mk]")
+	}
+	program, err := parseFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	code := cgen.Generate(program, cgen.Options{
+		Source: inputName(fs.Arg(0)),
+		Inline: *inline || *output == "",
+	})
+	if *output == "" {
+		_, err := fmt.Print(code)
+		return err
+	}
+	if !*inline {
+		header := filepath.Join(filepath.Dir(*output), cgen.HeaderName)
+		if err := os.WriteFile(header, []byte(cgen.Header()), 0644); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(*output, []byte(code), 0644)
+}
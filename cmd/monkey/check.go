@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/types"
+)
+
+func runCheck(args []string) error {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	signatures := fs.Bool("signatures", false, "print the inferred types of top-level let bindings")
+	gradual := fs.Bool("gradual", false, "check only annotated code, giving other names the type any")
+	fs.Parse(args)
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	errors := 0
+	for _, path := range paths {
+		program, err := parseFile(path)
+		if err != nil {
+			return err
+		}
+		check := types.Check
+		if *gradual {
+			check = types.CheckGradual
+		}
+		info := check(program)
+		for _, err := range info.Errors {
+			fmt.Printf("%s:%s\n", inputName(path), err)
+		}
+		errors += len(info.Errors)
+
+		if *signatures {
+			for _, stmt := range program.Statements {
+				if let, ok := stmt.(*ast.LetStatement); ok {
+					fmt.Printf("%s: %s\n", let.Name.Value, info.Defs[let.Name])
+				}
+			}
+		}
+	}
+	if errors > 0 {
+		return fmt.Errorf("%d type errors", errors)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/oohira/monkey/astdiff"
+)
+
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	jsonOutput := fs.Bool("json", false, "print the changes as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return errors.New("usage: monkey diff [-json] old.mk new.mk")
+	}
+	oldName, newName := fs.Arg(0), fs.Arg(1)
+	old, err := parseFile(oldName)
+	if err != nil {
+		return err
+	}
+	new, err := parseFile(newName)
+	if err != nil {
+		return err
+	}
+
+	changes := astdiff.Diff(old, new)
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	if *jsonOutput {
+		return astdiff.WriteJSON(out, oldName, newName, changes)
+	}
+	return astdiff.WriteSummary(out, oldName, newName, changes)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/oohira/monkey/gogen"
+)
+
+func runGogen(args []string) error {
+	fs := flag.NewFlagSet("gogen", flag.ExitOnError)
+	output := fs.String("o", "", "output file (default: stdout)")
+	pkg := fs.String("pkg", "main", "package name of the generated code")
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		return errors.New("usage: monkey gogen [-pkg name] [-o out.go] [file.mk]")
+	}
+	program, err := parseFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	src, err := gogen.Generate(program, gogen.Options{
+		Package: *pkg,
+		Source:  inputName(fs.Arg(0)),
+	})
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err := os.Stdout.Write(src)
+		return err
+	}
+	return os.WriteFile(*output, src, 0644)
+}
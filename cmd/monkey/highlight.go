@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/oohira/monkey/highlight"
+)
+
+func runHighlight(args []string) error {
+	fs := flag.NewFlagSet("highlight", flag.ExitOnError)
+	format := fs.String("format", "ansi", "output format: ansi or html")
+	css := fs.Bool("css", false, "print the default style sheet for html and exit")
+	fs.Parse(args)
+
+	if *css {
+		fmt.Print(highlight.CSS)
+		return nil
+	}
+
+	if fs.NArg() > 1 {
+		return errors.New("usage: monkey highlight [-format ansi|html] [file.mk]")
+	}
+	in, _, err := openInput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	segments, err := highlight.Segments(in)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	switch *format {
+	case "ansi":
+		return highlight.WriteANSI(out, segments)
+	case "html":
+		return highlight.WriteHTML(out, segments)
+	}
+	return fmt.Errorf("unknown format %q", *format)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/oohira/monkey/object"
+	"github.com/oohira/monkey/rvm"
+	"github.com/oohira/monkey/ssa"
+)
+
+func runIR(args []string) error {
+	fs := flag.NewFlagSet("ir", flag.ExitOnError)
+	optimize := fs.Bool("O", false, "run all the optimization passes")
+	passes := fs.String("passes", "", "comma-separated optimization passes to run (copyprop, cse, dce)")
+	regs := fs.Bool("regs", false, "print the registers allocated to the values")
+	code := fs.Bool("code", false, "print the instructions of the register VM instead of the IR")
+	run := fs.Bool("run", false, "run the program on the register VM instead of printing it")
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		return errors.New("usage: monkey ir [-O | -passes list] [-regs | -code | -run] [file.mk]")
+	}
+	program, err := parseFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	p, err := ssa.Build(program)
+	if err != nil {
+		return fmt.Errorf("%s:%s", inputName(fs.Arg(0)), err)
+	}
+
+	selected := []ssa.Pass(nil)
+	if *optimize {
+		selected = ssa.Passes
+	} else if *passes != "" {
+		if selected, err = ssa.LookupPasses(*passes); err != nil {
+			return err
+		}
+	}
+	ssa.Optimize(p, selected)
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	switch {
+	case *run:
+		result, err := rvm.New(rvm.Compile(p)).Run()
+		if err != nil {
+			return fmt.Errorf("runtime error: %s", err)
+		}
+		if result != object.NullValue {
+			fmt.Fprintln(out, result.Inspect())
+		}
+		return nil
+	case *code:
+		return rvm.Fprint(out, rvm.Compile(p))
+	case *regs:
+		for _, f := range p.Funcs {
+			ssa.Allocate(f)
+		}
+	}
+	return ssa.Fprint(out, p)
+}
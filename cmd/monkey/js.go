@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/oohira/monkey/jsgen"
+)
+
+func runJS(args []string) error {
+	fs := flag.NewFlagSet("js", flag.ExitOnError)
+	output := fs.String("o", "", "output file (default: stdout)")
+	sourceMap := fs.Bool("map", false, "write a source map to the output file with the extension .map, or inline it to stdout")
+	module := fs.Bool("module", false, "export main as an ES module instead of running the program")
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		return errors.New("usage: monkey js [-map] [-module] [-o out.js] [file.mk]")
+	}
+	program, err := parseFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	result := jsgen.Generate(program, jsgen.Options{
+		Source: inputName(fs.Arg(0)),
+		Module: *module,
+	})
+	code := result.Code
+	if *sourceMap {
+		if *output != "" {
+			result.SourceMap.File = filepath.Base(*output)
+		}
+		data, err := json.Marshal(result.SourceMap)
+		if err != nil {
+			return err
+		}
+		url := "data:application/json;base64," + base64.StdEncoding.EncodeToString(data)
+		if *output != "" {
+			url = filepath.Base(*output) + ".map"
+			if err := os.WriteFile(*output+".map", data, 0644); err != nil {
+				return err
+			}
+		}
+		code += "//# sourceMappingURL=" + url + "\n"
+	}
+
+	if *output == "" {
+		_, err := fmt.Print(code)
+		return err
+	}
+	return os.WriteFile(*output, []byte(code), 0644)
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/oohira/monkey/lsp"
+)
+
+func runLSP(args []string) error {
+	fs := flag.NewFlagSet("lsp", flag.ExitOnError)
+	fs.Parse(args)
+
+	return lsp.NewServer(os.Stdin, os.Stdout).Serve()
+}
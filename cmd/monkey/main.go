@@ -1,14 +1,63 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/user"
+	"sort"
+	"strings"
 
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/parser"
 	"github.com/oohira/monkey/repl"
 )
 
+// command represents a subcommand of monkey.
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+var commands = map[string]command{
+	"ast":       {"print the AST of a program as a tree or a graph", runAST},
+	"build":     {"compile a program into a .mkc module", runBuild},
+	"check":     {"infer types of programs and report type errors", runCheck},
+	"cgen":      {"translate a program into C", runCgen},
+	"diff":      {"print structural differences between two programs", runDiff},
+	"disasm":    {"print the bytecode of a program or a module", runDisasm},
+	"gogen":     {"translate a program into Go", runGogen},
+	"highlight": {"print a program with syntax highlighting", runHighlight},
+	"ir":        {"print the SSA form of a program or run it on the register VM", runIR},
+	"js":        {"translate a program into JavaScript", runJS},
+	"lsp":       {"run the language server over stdio", runLSP},
+	"run":       {"run a program or a module on the virtual machine", runRun},
+	"vet":       {"report suspicious constructs in programs", runVet},
+	"wat":       {"compile integer and boolean functions into WebAssembly", runWat},
+}
+
 func main() {
+	if len(os.Args) < 2 {
+		startREPL()
+		return
+	}
+
+	name := os.Args[1]
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "monkey: unknown command %q\n", name)
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "monkey %s: %s\n", name, err)
+		os.Exit(1)
+	}
+}
+
+func startREPL() {
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
@@ -17,3 +66,61 @@ func main() {
 
 	repl.Start(os.Stdin, os.Stdout)
 }
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage: monkey [command] [arguments]")
+	fmt.Fprintln(os.Stderr, "\nWithout a command, monkey starts the REPL. The commands are:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].summary)
+	}
+}
+
+// openInput opens the file at path, or stdin if path is empty or "-".
+// It also returns the name of the input used in messages.
+func openInput(path string) (io.ReadCloser, string, error) {
+	if path == "" || path == "-" {
+		return io.NopCloser(os.Stdin), inputName(path), nil
+	}
+	f, err := os.Open(path)
+	return f, inputName(path), err
+}
+
+// inputName returns the name of the input at path used in messages.
+func inputName(path string) string {
+	if path == "" || path == "-" {
+		return "<stdin>"
+	}
+	return path
+}
+
+// parseFile parses the program in the file at path, or stdin if path is
+// empty or "-". Parse errors are reported with the file name and positions.
+func parseFile(path string) (*ast.Program, error) {
+	in, name, err := openInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	return parseInput(name, in)
+}
+
+// parseInput parses the program read from in. name is the name of the
+// input used in messages.
+func parseInput(name string, in io.Reader) (*ast.Program, error) {
+	p := parser.New(lexer.NewReader(in))
+	program := p.ParseProgram()
+	if errs := p.ErrorList(); len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for i, err := range errs {
+			msgs[i] = name + ":" + err.Error()
+		}
+		return nil, errors.New("parse errors:\n" + strings.Join(msgs, "\n"))
+	}
+	return program, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/compiler"
+	"github.com/oohira/monkey/mkc"
+	"github.com/oohira/monkey/object"
+	"github.com/oohira/monkey/optimizer"
+	"github.com/oohira/monkey/vm"
+)
+
+func runBuild(args []string) error {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	output := fs.String("o", "", "output file (default: the input file with the extension .mkc)")
+	opts := optimizeFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("usage: monkey build [-O [-v]] [-o out.mkc] file.mk")
+	}
+	path := fs.Arg(0)
+	bytecode, err := compileFile(path, opts)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		*output = strings.TrimSuffix(path, ".mk") + ".mkc"
+	}
+	var out bytes.Buffer
+	if err := mkc.Write(&out, bytecode); err != nil {
+		return err
+	}
+	return os.WriteFile(*output, out.Bytes(), 0644)
+}
+
+func runRun(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	opts := optimizeFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		return errors.New("usage: monkey run [-O [-v]] [file.mk | file.mkc]")
+	}
+	bytecode, err := loadBytecode(fs.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+
+	machine := vm.New(bytecode)
+	if err := machine.Run(); err != nil {
+		return fmt.Errorf("runtime error: %s", err)
+	}
+	if result := machine.LastPoppedStackElem(); result != object.NullValue {
+		fmt.Println(result.Inspect())
+	}
+	return nil
+}
+
+func runDisasm(args []string) error {
+	fs := flag.NewFlagSet("disasm", flag.ExitOnError)
+	opts := optimizeFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		return errors.New("usage: monkey disasm [-O [-v]] [file.mk | file.mkc]")
+	}
+	bytecode, err := loadBytecode(fs.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	return compiler.Disassemble(out, bytecode)
+}
+
+// optimizeOptions holds the optimization flags of a command.
+type optimizeOptions struct {
+	optimize *bool
+	verbose  *bool
+}
+
+func optimizeFlags(fs *flag.FlagSet) optimizeOptions {
+	return optimizeOptions{
+		optimize: fs.Bool("O", false, "optimize the program before compiling it"),
+		verbose:  fs.Bool("v", false, "report the code removed by the optimizer"),
+	}
+}
+
+// loadBytecode reads a compiled module, or compiles a program, in the file
+// at path, or stdin if path is empty or "-". A module is never optimized
+// again.
+func loadBytecode(path string, opts optimizeOptions) (*compiler.Bytecode, error) {
+	in, name, err := openInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	src, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	if mkc.IsModule(src) {
+		bytecode, err := mkc.Read(bytes.NewReader(src))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		return bytecode, nil
+	}
+	return compileSource(name, src, opts)
+}
+
+// compileFile compiles the program in the file at path, or stdin if path
+// is empty or "-".
+func compileFile(path string, opts optimizeOptions) (*compiler.Bytecode, error) {
+	program, err := parseFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return compile(inputName(path), program, opts)
+}
+
+func compileSource(name string, src []byte, opts optimizeOptions) (*compiler.Bytecode, error) {
+	program, err := parseInput(name, bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	return compile(name, program, opts)
+}
+
+func compile(name string, program *ast.Program, opts optimizeOptions) (*compiler.Bytecode, error) {
+	if *opts.optimize {
+		var removed []*optimizer.Removal
+		program, removed = optimizer.Optimize(program)
+		if *opts.verbose {
+			for _, r := range removed {
+				fmt.Fprintf(os.Stderr, "%s:%s\n", name, r)
+			}
+		}
+	}
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return nil, fmt.Errorf("%s:%s", name, err)
+	}
+	return comp.Bytecode(), nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/oohira/monkey/lint"
+)
+
+func runVet(args []string) error {
+	fs := flag.NewFlagSet("vet", flag.ExitOnError)
+	configPath := fs.String("config", "", "JSON config file enabling or disabling rules")
+	disable := fs.String("disable", "", "comma-separated rules to disable")
+	enable := fs.String("enable", "", "comma-separated rules to enable")
+	list := fs.Bool("list", false, "list the rules and exit")
+	fs.Parse(args)
+
+	rules := lint.DefaultRules()
+	if *list {
+		for _, rule := range rules {
+			fmt.Printf("%-14s %s\n", rule.Name(), rule.Doc())
+		}
+		return nil
+	}
+
+	config := &lint.Config{Rules: map[string]bool{}}
+	if *configPath != "" {
+		f, err := os.Open(*configPath)
+		if err != nil {
+			return err
+		}
+		config, err = lint.LoadConfig(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", *configPath, err)
+		}
+		if config.Rules == nil {
+			config.Rules = map[string]bool{}
+		}
+	}
+	for _, flagRules := range []struct {
+		names   string
+		enabled bool
+	}{{*disable, false}, {*enable, true}} {
+		for _, name := range strings.Split(flagRules.names, ",") {
+			if name != "" {
+				config.Rules[name] = flagRules.enabled
+			}
+		}
+	}
+	if err := config.Validate(rules); err != nil {
+		return err
+	}
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	problems := 0
+	for _, path := range paths {
+		in, name, err := openInput(path)
+		if err != nil {
+			return err
+		}
+		src, err := io.ReadAll(in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+		for _, d := range lint.CheckSource(string(src), rules, config) {
+			fmt.Printf("%s:%s\n", name, d)
+			problems++
+		}
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d problems found", problems)
+	}
+	return nil
+}
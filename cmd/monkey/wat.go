@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/oohira/monkey/wasm"
+	"github.com/oohira/monkey/watgen"
+)
+
+func runWat(args []string) error {
+	fs := flag.NewFlagSet("wat", flag.ExitOnError)
+	output := fs.String("o", "", "output file (default: stdout)")
+	binary := fs.Bool("wasm", false, "write the binary format instead of the text format")
+	run := fs.Bool("run", false, "run main with the interpreter and print its value instead of writing the module")
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		return errors.New("usage: monkey wat [-wasm] [-o out.wat] [file.mk]\n       monkey wat -run [file.mk]")
+	}
+	program, err := parseFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	m, errs := watgen.Compile(program)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", inputName(fs.Arg(0)), err)
+		}
+		return fmt.Errorf("%d errors", len(errs))
+	}
+
+	if *run {
+		return runWasm(m)
+	}
+	var out bytes.Buffer
+	if *binary {
+		out.Write(wasm.Encode(m))
+	} else if err := wasm.WriteText(&out, m); err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err := os.Stdout.Write(out.Bytes())
+		return err
+	}
+	return os.WriteFile(*output, out.Bytes(), 0644)
+}
+
+// runWasm runs main of m and prints its value, which is a boolean if it is
+// an i32.
+func runWasm(m *wasm.Module) error {
+	in, err := wasm.Instantiate(m)
+	if err != nil {
+		return err
+	}
+	results, err := in.Invoke(watgen.Main)
+	if err != nil {
+		return fmt.Errorf("runtime error: %s", err)
+	}
+	if len(results) == 0 {
+		return nil
+	}
+	main := m.Funcs[m.Exports[len(m.Exports)-1].Func]
+	if m.Types[main.Type].Results[0] == wasm.I32 {
+		fmt.Println(results[0] != 0)
+	} else {
+		fmt.Println(int64(results[0]))
+	}
+	return nil
+}
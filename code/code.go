@@ -0,0 +1,193 @@
+// Package code defines the bytecode instruction set of the Monkey virtual
+// machine.
+//
+// An instruction is an opcode byte followed by its operands. Operands are
+// unsigned big-endian integers whose widths are given by the definition of
+// the opcode.
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Instructions represents a sequence of encoded instructions.
+type Instructions []byte
+
+// String returns the instructions disassembled one per line, e.g.
+// "0000 OpConstant 1".
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s", i, def.Name)
+		for _, o := range operands {
+			fmt.Fprintf(&out, " %d", o)
+		}
+		out.WriteString("\n")
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+// Opcode represents an operation of the virtual machine.
+type Opcode byte
+
+// Opcodes
+const (
+	OpConstant      Opcode = iota // push the constant at operand 0
+	OpPop                         // pop the top of the stack
+	OpAdd                         // pop b and a, push a + b
+	OpSub                         // pop b and a, push a - b
+	OpMul                         // pop b and a, push a * b
+	OpDiv                         // pop b and a, push a / b
+	OpEqual                       // pop b and a, push a == b
+	OpNotEqual                    // pop b and a, push a != b
+	OpLessThan                    // pop b and a, push a < b
+	OpGreaterThan                 // pop b and a, push a > b
+	OpMinus                       // pop a, push -a
+	OpBang                        // pop a, push !a
+	OpTrue                        // push true
+	OpFalse                       // push false
+	OpNull                        // push null
+	OpJump                        // jump to operand 0
+	OpJumpNotTruthy               // pop a, jump to operand 0 unless a is truthy
+	OpGetGlobal                   // push the global at operand 0
+	OpSetGlobal                   // pop a and store it in the global at operand 0
+	OpGetLocal                    // push the local at operand 0
+	OpSetLocal                    // pop a and store it in the local at operand 0
+	OpMakeCell                    // replace the local at operand 0 with a cell holding it
+	OpGetCell                     // push the value of the cell in the local at operand 0
+	OpSetCell                     // pop a and store it in the cell in the local at operand 0
+	OpGetFree                     // push the value of the cell of the free variable at operand 0
+	OpGetFreeCell                 // push the cell of the free variable at operand 0
+	OpTryLocal                    // if the local at operand 0 is bound, push it and jump to operand 1
+	OpTryCell                     // if the cell in the local at operand 0 is bound, push its value and jump to operand 1
+	OpTryFree                     // if the cell of the free variable at operand 0 is bound, push its value and jump to operand 1
+	OpClosure                     // push a closure of the function constant at operand 0 over operand 1 cells
+	OpCall                        // call the function below operand 0 arguments
+	OpReturnValue                 // return the top of the stack from the function
+	OpReturn                      // return null from the function
+)
+
+// Definition represents the name and the operand widths in bytes of an opcode.
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant:      {"OpConstant", []int{2}},
+	OpPop:           {"OpPop", []int{}},
+	OpAdd:           {"OpAdd", []int{}},
+	OpSub:           {"OpSub", []int{}},
+	OpMul:           {"OpMul", []int{}},
+	OpDiv:           {"OpDiv", []int{}},
+	OpEqual:         {"OpEqual", []int{}},
+	OpNotEqual:      {"OpNotEqual", []int{}},
+	OpLessThan:      {"OpLessThan", []int{}},
+	OpGreaterThan:   {"OpGreaterThan", []int{}},
+	OpMinus:         {"OpMinus", []int{}},
+	OpBang:          {"OpBang", []int{}},
+	OpTrue:          {"OpTrue", []int{}},
+	OpFalse:         {"OpFalse", []int{}},
+	OpNull:          {"OpNull", []int{}},
+	OpJump:          {"OpJump", []int{2}},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+	OpGetGlobal:     {"OpGetGlobal", []int{2}},
+	OpSetGlobal:     {"OpSetGlobal", []int{2}},
+	OpGetLocal:      {"OpGetLocal", []int{1}},
+	OpSetLocal:      {"OpSetLocal", []int{1}},
+	OpMakeCell:      {"OpMakeCell", []int{1}},
+	OpGetCell:       {"OpGetCell", []int{1}},
+	OpSetCell:       {"OpSetCell", []int{1}},
+	OpGetFree:       {"OpGetFree", []int{1}},
+	OpGetFreeCell:   {"OpGetFreeCell", []int{1}},
+	OpTryLocal:      {"OpTryLocal", []int{1, 2}},
+	OpTryCell:       {"OpTryCell", []int{1, 2}},
+	OpTryFree:       {"OpTryFree", []int{1, 2}},
+	OpClosure:       {"OpClosure", []int{2, 1}},
+	OpCall:          {"OpCall", []int{1}},
+	OpReturnValue:   {"OpReturnValue", []int{}},
+	OpReturn:        {"OpReturn", []int{}},
+}
+
+// Lookup returns the definition of the opcode op.
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	return def, nil
+}
+
+// Make returns the instruction of the opcode op with the operands.
+// It returns an empty instruction if op is undefined.
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	length := 1
+	for _, w := range def.OperandWidths {
+		length += w
+	}
+
+	instruction := make([]byte, length)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+// ReadOperands decodes the operands of an instruction defined by def from
+// ins, which starts just after the opcode. It also returns the number of
+// bytes read.
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += width
+	}
+
+	return operands, offset
+}
+
+// ReadUint16 decodes a two-byte operand.
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+// ReadUint8 decodes a one-byte operand.
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
@@ -0,0 +1,488 @@
+// Package compiler compiles Monkey programs into bytecode for the virtual
+// machine.
+//
+// Top-level let statements define globals, and let statements and
+// parameters in functions define locals of the function, since a block
+// shares the environment of its function. Locals referenced by nested
+// functions are kept in cells, which the closures share with the function.
+//
+// As in the evaluator, a name refers to the innermost variable bound at
+// runtime: a function may refer to a name defined later, and a name
+// defined in a branch not taken refers to the variable of the enclosing
+// function or the global. An unbound name is a runtime error.
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/code"
+	"github.com/oohira/monkey/object"
+	"github.com/oohira/monkey/token"
+)
+
+// Limits of the operands of instructions
+const (
+	MaxConstants = 1<<16 - 1
+	MaxGlobals   = 1<<16 - 1
+	MaxLocals    = 1<<8 - 1
+	MaxArguments = 1<<8 - 1
+	MaxJump      = 1<<16 - 1
+)
+
+// Bytecode represents a compiled program.
+// Globals holds the names of the global variables by index.
+type Bytecode struct {
+	Instructions code.Instructions
+	Constants    []object.Object
+	Globals      []string
+}
+
+// Error represents a compile error.
+type Error struct {
+	Pos token.Position
+	Msg string
+}
+
+// Error returns the message prefixed with the position, e.g. "1:5: ...".
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
+// EmittedInstruction represents an instruction emitted at Position.
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+// CompilationScope holds the instructions of the function being compiled.
+type CompilationScope struct {
+	instructions        code.Instructions
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+}
+
+// Compiler represents a compiler of a program.
+type Compiler struct {
+	constants []object.Object
+
+	globals     *SymbolTable
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+}
+
+// New returns a new compiler.
+func New() *Compiler {
+	globals := NewSymbolTable()
+	return &Compiler{
+		constants:   []object.Object{},
+		globals:     globals,
+		symbolTable: globals,
+		scopes:      []CompilationScope{{instructions: code.Instructions{}}},
+	}
+}
+
+// Compile compiles node. Compiling a program leaves the value of the
+// program, which is the value of the last statement if it is an expression
+// statement and null otherwise, as the last popped element of the VM.
+func (c *Compiler) Compile(node ast.Node) error {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+		if !c.lastInstructionIs(code.OpPop) {
+			c.emit(code.OpNull)
+			c.emit(code.OpPop)
+		}
+
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.ExpressionStatement:
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.LetStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		// the locals are defined on entering the function
+		symbol := c.symbolTable.Define(node.Name.Value)
+		switch symbol.Scope {
+		case GlobalScope:
+			if symbol.Index > MaxGlobals {
+				return c.errorf(node.Name.Token, "too many global variables")
+			}
+			c.emit(code.OpSetGlobal, symbol.Index)
+		case LocalScope:
+			c.emit(code.OpSetLocal, symbol.Index)
+		case CellScope:
+			c.emit(code.OpSetCell, symbol.Index)
+		}
+
+	case *ast.ReturnStatement:
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		default:
+			return c.errorf(node.Token, "unknown operator %s", node.Operator)
+		}
+
+	case *ast.InfixExpression:
+		op, ok := infixOpcodes[node.Operator]
+		if !ok {
+			return c.errorf(node.Token, "unknown operator %s", node.Operator)
+		}
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emit(op)
+
+	case *ast.IntegerLiteral:
+		integer := object.NewInteger(node.Value)
+		if node.Big != nil {
+			integer = object.NewBigInteger(node.Big)
+		}
+		index, err := c.addConstant(node.Token, integer)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpConstant, index)
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.IfExpression:
+		return c.compileIfExpression(node)
+
+	case *ast.Identifier:
+		return c.compileIdentifier(node)
+
+	case *ast.FunctionLiteral:
+		return c.compileFunction(node)
+
+	case *ast.CallExpression:
+		if len(node.Arguments) > MaxArguments {
+			return c.errorf(node.Token, "too many arguments")
+		}
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+		for _, a := range node.Arguments {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpCall, len(node.Arguments))
+
+	default:
+		return fmt.Errorf("cannot compile %T", node)
+	}
+
+	return nil
+}
+
+var infixOpcodes = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+	"<":  code.OpLessThan,
+	">":  code.OpGreaterThan,
+}
+
+// Bytecode returns the compiled program.
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Constants:    c.constants,
+		Globals:      c.globals.Names(),
+	}
+}
+
+func (c *Compiler) compileIfExpression(node *ast.IfExpression) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	// the operand is patched after compiling the consequence
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if err := c.compileBlockValue(node.Consequence); err != nil {
+		return err
+	}
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else if err := c.compileBlockValue(node.Alternative); err != nil {
+		return err
+	}
+	if len(c.currentInstructions()) > MaxJump {
+		return c.errorf(node.Token, "function too large")
+	}
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// compileBlockValue compiles block leaving its value on the stack.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+// compileIdentifier compiles ident into loads of the symbols it may refer
+// to, each of which but the last jumps over the rest if it is bound.
+func (c *Compiler) compileIdentifier(ident *ast.Identifier) error {
+	symbols := c.symbolTable.Resolve(ident.Value)
+	last := symbols[len(symbols)-1]
+	if last.Scope == GlobalScope && last.Index > MaxGlobals {
+		return c.errorf(ident.Token, "too many global variables")
+	}
+
+	// the jump operands are patched after the last load
+	tries := make([]int, len(symbols)-1)
+	for i, s := range symbols[:len(symbols)-1] {
+		tries[i] = c.emit(tryOpcodes[s.Scope], s.Index, 9999)
+	}
+	c.loadSymbol(last)
+	end := len(c.currentInstructions())
+	if end > MaxJump {
+		return c.errorf(ident.Token, "function too large")
+	}
+	for i, pos := range tries {
+		s := symbols[i]
+		c.replaceInstruction(pos, code.Make(tryOpcodes[s.Scope], s.Index, end))
+	}
+	return nil
+}
+
+var tryOpcodes = map[SymbolScope]code.Opcode{
+	LocalScope: code.OpTryLocal,
+	CellScope:  code.OpTryCell,
+	FreeScope:  code.OpTryFree,
+}
+
+// compileFunction compiles fn into a closure.
+func (c *Compiler) compileFunction(fn *ast.FunctionLiteral) error {
+	if len(fn.Parameters) > MaxArguments {
+		return c.errorf(fn.Token, "too many parameters")
+	}
+
+	lets, referenced := scanFunction(fn.Body)
+	c.enterScope(referenced)
+	for _, p := range fn.Parameters {
+		c.symbolTable.DefineParameter(p.Value)
+	}
+	for _, name := range lets {
+		c.symbolTable.Define(name)
+	}
+	if c.symbolTable.NumDefinitions() > MaxLocals {
+		c.leaveScope()
+		return c.errorf(fn.Token, "too many local variables")
+	}
+	for i, name := range c.symbolTable.Names() {
+		if referenced[name] {
+			c.emit(code.OpMakeCell, i)
+		}
+	}
+
+	if err := c.Compile(fn.Body); err != nil {
+		return err
+	}
+	if c.lastInstructionIs(code.OpPop) {
+		c.replaceLastPopWithReturn()
+	}
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.NumDefinitions()
+	instructions := c.leaveScope()
+	if len(freeSymbols) > MaxLocals {
+		return c.errorf(fn.Token, "too many free variables")
+	}
+
+	for _, s := range freeSymbols {
+		if s.Scope == CellScope {
+			c.emit(code.OpGetLocal, s.Index)
+		} else {
+			c.emit(code.OpGetFreeCell, s.Index)
+		}
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Instructions:  instructions,
+		NumLocals:     numLocals,
+		NumParameters: len(fn.Parameters),
+		Source:        (&object.Function{Parameters: fn.Parameters, Body: fn.Body}).Inspect(),
+	}
+	index, err := c.addConstant(fn.Token, compiledFn)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpClosure, index, len(freeSymbols))
+	return nil
+}
+
+// scanFunction returns the names defined by the let statements of the
+// function of body, in the order of the statements, and the names
+// referenced by the functions nested in body.
+func scanFunction(body *ast.BlockStatement) ([]string, map[string]bool) {
+	var lets []string
+	referenced := map[string]bool{}
+	var scan func(node ast.Node, nested bool)
+	scan = func(node ast.Node, nested bool) {
+		ast.Inspect(node, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.LetStatement:
+				if !nested {
+					lets = append(lets, n.Name.Value)
+				}
+				scan(n.Value, nested)
+				return false
+			case *ast.FunctionLiteral:
+				scan(n.Body, true)
+				return false
+			case *ast.Identifier:
+				if nested {
+					referenced[n.Value] = true
+				}
+			}
+			return true
+		})
+	}
+	scan(body, false)
+	return lets, referenced
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case CellScope:
+		c.emit(code.OpGetCell, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	}
+}
+
+func (c *Compiler) addConstant(tok token.Token, obj object.Object) (int, error) {
+	if len(c.constants) >= MaxConstants {
+		return 0, c.errorf(tok, "too many constants")
+	}
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1, nil
+}
+
+// emit appends an instruction and returns its position.
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+	c.setLastInstruction(op, pos)
+	return pos
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), ins...)
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	scope := &c.scopes[c.scopeIndex]
+	scope.previousInstruction = scope.lastInstruction
+	scope.lastInstruction = EmittedInstruction{Opcode: op, Position: pos}
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	scope := &c.scopes[c.scopeIndex]
+	scope.instructions = scope.instructions[:scope.lastInstruction.Position]
+	scope.lastInstruction = scope.previousInstruction
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+	copy(ins[pos:], newInstruction)
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	c.replaceInstruction(opPos, code.Make(op, operand))
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) enterScope(captured map[string]bool) {
+	c.scopes = append(c.scopes, CompilationScope{instructions: code.Instructions{}})
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable, captured)
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+	return instructions
+}
+
+func (c *Compiler) errorf(tok token.Token, format string, a ...interface{}) *Error {
+	return &Error{Pos: tok.Pos, Msg: fmt.Sprintf(format, a...)}
+}
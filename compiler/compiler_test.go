@@ -0,0 +1,479 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/oohira/monkey/code"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/object"
+	"github.com/oohira/monkey/parser"
+)
+
+type compilerTestCase struct {
+	input                string
+	expectedConstants    []interface{}
+	expectedInstructions []code.Instructions
+}
+
+func TestCompiler(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2",
+			expectedConstants: []interface{}{"1", "2"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 < 2; -1",
+			expectedConstants: []interface{}{"1", "2", "1"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLessThan),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpMinus),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "!true == false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpBang),
+				code.Make(code.OpFalse),
+				code.Make(code.OpEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (true) { 10 }; 3333;",
+			expectedConstants: []interface{}{"10", "3333"},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 11),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpPop),
+				// 0012
+				code.Make(code.OpConstant, 1),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (true) { } else { let x = 1; }",
+			expectedConstants: []interface{}{"1"},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 8),
+				// 0004
+				code.Make(code.OpNull),
+				// 0005
+				code.Make(code.OpJump, 15),
+				// 0008
+				code.Make(code.OpConstant, 0),
+				// 0011
+				code.Make(code.OpSetGlobal, 0),
+				// 0014
+				code.Make(code.OpNull),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let one = 1; let two = one; let one = 3;",
+			expectedConstants: []interface{}{"1", "3"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "return 99999999999999999999;",
+			expectedConstants: []interface{}{"99999999999999999999"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpReturnValue),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn() { return 5 + 10 }",
+			expectedConstants: []interface{}{
+				"5",
+				"10",
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { 1; 2 }",
+			expectedConstants: []interface{}{
+				"1",
+				"2",
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// a let statement may not be executed before the name is used
+			input: "fn() { let x = 1; x }",
+			expectedConstants: []interface{}{
+				"1",
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpConstant, 0),
+					// 0003
+					code.Make(code.OpSetLocal, 0),
+					// 0005
+					code.Make(code.OpTryLocal, 0, 12),
+					// 0009
+					code.Make(code.OpGetGlobal, 0),
+					// 0012
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "let id = fn(a) { a }; id(24);",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+				"24",
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(a) { fn(b) { a + b } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpMakeCell, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// a name not always bound falls back to the enclosing
+			// functions and the global
+			input: "fn() { let loop = fn(x) { loop(x) }; loop }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpTryFree, 0, 7),
+					// 0004
+					code.Make(code.OpGetGlobal, 0),
+					// 0007
+					code.Make(code.OpGetLocal, 0),
+					// 0009
+					code.Make(code.OpCall, 1),
+					// 0011
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpMakeCell, 0),
+					// 0002
+					code.Make(code.OpGetLocal, 0),
+					// 0004
+					code.Make(code.OpClosure, 0, 1),
+					// 0008
+					code.Make(code.OpSetCell, 0),
+					// 0010
+					code.Make(code.OpTryCell, 0, 17),
+					// 0014
+					code.Make(code.OpGetGlobal, 0),
+					// 0017
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// functions may refer to globals declared later
+			input: "let f = fn() { g }; let g = 1;",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpReturnValue),
+				},
+				"1",
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn(" + strings.Repeat("a, ", MaxArguments) + "a) {}", "1:1: too many parameters"},
+		{"f(" + strings.Repeat("1, ", MaxArguments) + "1)", "1:2: too many arguments"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		err := New().Compile(program)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("error for %q wrong. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestSymbolTable(t *testing.T) {
+	global := NewSymbolTable()
+	a := global.Define("a")
+	global.Define("b")
+	if again := global.Define("a"); again != a {
+		t.Errorf("redefinition should reuse the symbol. want=%+v, got=%+v", a, again)
+	}
+
+	local := NewEnclosedSymbolTable(global, map[string]bool{"c": true, "e": true})
+	local.DefineParameter("c")
+	local.Define("e")
+	nested := NewEnclosedSymbolTable(local, nil)
+	nested.Define("d")
+
+	expected := map[string][]Symbol{
+		"a": {{Name: "a", Scope: GlobalScope, Index: 0}},
+		"b": {{Name: "b", Scope: GlobalScope, Index: 1}},
+		"c": {{Name: "c", Scope: FreeScope, Index: 0, Bound: true}},
+		"d": {{Name: "d", Scope: LocalScope, Index: 0}, {Name: "d", Scope: GlobalScope, Index: 2}},
+		"e": {{Name: "e", Scope: FreeScope, Index: 1}, {Name: "e", Scope: GlobalScope, Index: 3}},
+		"f": {{Name: "f", Scope: GlobalScope, Index: 4}},
+	}
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		result := nested.Resolve(name)
+		if fmt.Sprint(result) != fmt.Sprint(expected[name]) {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", name, expected[name], result)
+		}
+	}
+	freeSymbols := []Symbol{
+		{Name: "c", Scope: CellScope, Index: 0, Bound: true},
+		{Name: "e", Scope: CellScope, Index: 1},
+	}
+	if fmt.Sprint(nested.FreeSymbols) != fmt.Sprint(freeSymbols) {
+		t.Errorf("free symbols wrong. want=%+v, got=%+v", freeSymbols, nested.FreeSymbols)
+	}
+	if names := global.Names(); strings.Join(names, " ") != "a b d e f" {
+		t.Errorf("names wrong. got=%v", names)
+	}
+}
+
+func TestDuplicateParameters(t *testing.T) {
+	table := NewEnclosedSymbolTable(NewSymbolTable(), nil)
+	table.DefineParameter("x")
+	table.DefineParameter("x")
+	if got := table.Resolve("x"); len(got) != 1 || got[0].Index != 1 {
+		t.Errorf("x should refer to the last parameter. got=%+v", got)
+	}
+	if table.NumDefinitions() != 2 {
+		t.Errorf("each parameter should have its storage. got=%d", table.NumDefinitions())
+	}
+}
+
+func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("parser errors for %q: %v", tt.input, p.Errors())
+		}
+
+		compiler := New()
+		if err := compiler.Compile(program); err != nil {
+			t.Fatalf("compiler error for %q: %s", tt.input, err)
+		}
+
+		bytecode := compiler.Bytecode()
+		testInstructions(t, tt.input, tt.expectedInstructions, bytecode.Instructions)
+		testConstants(t, tt.input, tt.expectedConstants, bytecode.Constants)
+	}
+}
+
+func concatInstructions(s []code.Instructions) code.Instructions {
+	out := code.Instructions{}
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+	return out
+}
+
+func testInstructions(t *testing.T, input string, expected []code.Instructions, actual code.Instructions) {
+	t.Helper()
+	concatted := concatInstructions(expected)
+	if actual.String() != concatted.String() {
+		t.Errorf("wrong instructions for %q.\nwant=\n%s\ngot=\n%s", input, concatted, actual)
+	}
+}
+
+// testConstants compares the constants with the expected values, which are
+// the text of integers or the instructions of compiled functions.
+func testConstants(t *testing.T, input string, expected []interface{}, actual []object.Object) {
+	t.Helper()
+	if len(expected) != len(actual) {
+		t.Errorf("wrong number of constants for %q. want=%d, got=%d", input, len(expected), len(actual))
+		return
+	}
+
+	for i, constant := range expected {
+		switch constant := constant.(type) {
+		case string:
+			integer, ok := actual[i].(*object.Integer)
+			if !ok || integer.Inspect() != constant {
+				t.Errorf("constant %d of %q wrong. want=%s, got=%v", i, input, constant, actual[i])
+			}
+		case []code.Instructions:
+			fn, ok := actual[i].(*object.CompiledFunction)
+			if !ok {
+				t.Errorf("constant %d of %q is not a function. got=%T", i, input, actual[i])
+				continue
+			}
+			testInstructions(t, input, constant, fn.Instructions)
+		}
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	program := parser.New(lexer.New("let one = 1; let f = fn(x) { x + one }; f(2)")).ParseProgram()
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var out strings.Builder
+	if err := Disassemble(&out, compiler.Bytecode()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `globals:
+  0 one
+  1 f
+constants:
+  0 INTEGER 1
+  1 fn#1 (params=1, locals=1)
+  2 INTEGER 2
+main:
+  0000 OpConstant 0              ; 1
+  0003 OpSetGlobal 0             ; one
+  0006 OpClosure 1 0             ; fn#1
+  0010 OpSetGlobal 1             ; f
+  0013 OpGetGlobal 1             ; f
+  0016 OpConstant 2              ; 2
+  0019 OpCall 1
+  0021 OpPop
+fn#1 (params=1, locals=1):
+  0000 OpGetLocal 0
+  0002 OpGetGlobal 0             ; one
+  0005 OpAdd
+  0006 OpReturnValue
+`
+	if out.String() != expected {
+		t.Errorf("disassembly wrong.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
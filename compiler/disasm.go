@@ -0,0 +1,98 @@
+package compiler
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/oohira/monkey/code"
+	"github.com/oohira/monkey/object"
+)
+
+// Disassemble writes the bytecode in a human readable form: the globals,
+// the constants, the main instructions and the instructions of each
+// function. Operands referring to constants and globals are annotated with
+// their values and names.
+func Disassemble(w io.Writer, bytecode *Bytecode) error {
+	var out strings.Builder
+
+	out.WriteString("globals:\n")
+	for i, name := range bytecode.Globals {
+		fmt.Fprintf(&out, "  %d %s\n", i, name)
+	}
+
+	out.WriteString("constants:\n")
+	for i, c := range bytecode.Constants {
+		fmt.Fprintf(&out, "  %d %s\n", i, describeConstant(i, c))
+	}
+
+	out.WriteString("main:\n")
+	disassembleInstructions(&out, bytecode, bytecode.Instructions)
+
+	for i, c := range bytecode.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			fmt.Fprintf(&out, "%s:\n", describeConstant(i, fn))
+			disassembleInstructions(&out, bytecode, fn.Instructions)
+		}
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+func describeConstant(index int, obj object.Object) string {
+	switch obj := obj.(type) {
+	case *object.CompiledFunction:
+		return fmt.Sprintf("fn#%d (params=%d, locals=%d)", index, obj.NumParameters, obj.NumLocals)
+	}
+	return fmt.Sprintf("%s %s", obj.Type(), obj.Inspect())
+}
+
+func disassembleInstructions(out *strings.Builder, bytecode *Bytecode, ins code.Instructions) {
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(out, "  %04d ERROR: %s\n", i, err)
+			i++
+			continue
+		}
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if i+1+width > len(ins) {
+			fmt.Fprintf(out, "  %04d ERROR: truncated %s\n", i, def.Name)
+			return
+		}
+
+		operands, read := code.ReadOperands(def, ins[i+1:])
+		line := fmt.Sprintf("  %04d %s", i, def.Name)
+		for _, o := range operands {
+			line += fmt.Sprintf(" %d", o)
+		}
+		if comment := annotate(bytecode, code.Opcode(ins[i]), operands); comment != "" {
+			line = fmt.Sprintf("%-32s ; %s", line, comment)
+		}
+		out.WriteString(line + "\n")
+		i += 1 + read
+	}
+}
+
+// annotate returns what the operands of an instruction refer to.
+func annotate(bytecode *Bytecode, op code.Opcode, operands []int) string {
+	switch op {
+	case code.OpConstant, code.OpClosure:
+		if operands[0] < len(bytecode.Constants) {
+			c := bytecode.Constants[operands[0]]
+			if _, ok := c.(*object.CompiledFunction); ok {
+				return fmt.Sprintf("fn#%d", operands[0])
+			}
+			return c.Inspect()
+		}
+	case code.OpGetGlobal, code.OpSetGlobal:
+		if operands[0] < len(bytecode.Globals) {
+			return bytecode.Globals[operands[0]]
+		}
+	}
+	return ""
+}
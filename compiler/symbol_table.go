@@ -0,0 +1,133 @@
+package compiler
+
+// SymbolScope represents where the value of a symbol is stored at runtime.
+type SymbolScope string
+
+// Scopes of symbols
+const (
+	GlobalScope SymbolScope = "GLOBAL" // in the globals of the VM
+	LocalScope  SymbolScope = "LOCAL"  // in the frame of the function
+	CellScope   SymbolScope = "CELL"   // in a cell in the frame, shared with closures
+	FreeScope   SymbolScope = "FREE"   // in a cell of the free variables of the closure
+)
+
+// Symbol represents a name bound to a storage location. Bound reports
+// whether the location is bound whenever the function runs, as that of a
+// parameter is.
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+	Bound bool
+}
+
+// SymbolTable holds the symbols defined in a function, or the global
+// symbols if Outer is nil. FreeSymbols are the symbols of the enclosing
+// function whose cells the closures of the function hold, in the order of
+// their indexes.
+type SymbolTable struct {
+	Outer       *SymbolTable
+	FreeSymbols []Symbol
+
+	store    map[string]Symbol
+	free     map[Symbol]Symbol // free symbols by the symbols of the enclosing function
+	captured map[string]bool   // names kept in cells
+	names    []string          // names of the definitions by index
+}
+
+// NewSymbolTable returns an empty global symbol table.
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{store: make(map[string]Symbol), free: make(map[Symbol]Symbol)}
+}
+
+// NewEnclosedSymbolTable returns an empty symbol table of a function
+// enclosed by outer. The names in captured are referenced by the nested
+// functions, so they are kept in cells.
+func NewEnclosedSymbolTable(outer *SymbolTable, captured map[string]bool) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	s.captured = captured
+	return s
+}
+
+// Define defines name in the table and returns its symbol. Defining a name
+// again reuses the storage of the previous definition.
+func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.store[name]; ok {
+		return symbol
+	}
+	return s.define(name, false)
+}
+
+// DefineParameter defines the next parameter name in the table and returns
+// its symbol. Each parameter has its own storage, and a name of several
+// parameters refers to the last one, which is bound to the last of the
+// arguments.
+func (s *SymbolTable) DefineParameter(name string) Symbol {
+	return s.define(name, true)
+}
+
+// Resolve returns the symbols name may refer to at runtime, innermost
+// first: that of the table if the function defines name, those of the
+// enclosing functions defining name, and the global symbol, which is
+// defined if needed. As the evaluator looks up the environments of the
+// functions, name refers to the first symbol bound at runtime, so the
+// symbols end at the first one always bound.
+func (s *SymbolTable) Resolve(name string) []Symbol {
+	symbol, ok := s.store[name]
+	if s.Outer == nil {
+		if !ok {
+			symbol = s.Define(name)
+		}
+		return []Symbol{symbol}
+	}
+
+	var symbols []Symbol
+	if ok {
+		symbols = append(symbols, symbol)
+		if symbol.Bound {
+			return symbols
+		}
+	}
+	for _, symbol := range s.Outer.Resolve(name) {
+		if symbol.Scope != GlobalScope {
+			symbol = s.defineFree(symbol)
+		}
+		symbols = append(symbols, symbol)
+	}
+	return symbols
+}
+
+// NumDefinitions returns the number of storage locations of the table.
+func (s *SymbolTable) NumDefinitions() int {
+	return len(s.names)
+}
+
+// Names returns the names of the definitions by index.
+func (s *SymbolTable) Names() []string {
+	return append([]string(nil), s.names...)
+}
+
+func (s *SymbolTable) define(name string, bound bool) Symbol {
+	symbol := Symbol{Name: name, Index: len(s.names), Scope: LocalScope, Bound: bound}
+	switch {
+	case s.Outer == nil:
+		symbol.Scope = GlobalScope
+	case s.captured[name]:
+		symbol.Scope = CellScope
+	}
+	s.store[name] = symbol
+	s.names = append(s.names, name)
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	if symbol, ok := s.free[original]; ok {
+		return symbol
+	}
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Scope: FreeScope, Bound: original.Bound}
+	s.free[original] = symbol
+	return symbol
+}
@@ -0,0 +1,26 @@
+// Package corpus holds Monkey programs shared by the tests of the
+// compilers, translators and virtual machines, whose results must be those
+// of the evaluator.
+package corpus
+
+import (
+	_ "embed"
+	"strings"
+)
+
+// programs.txt holds a program on each line. Blank lines and lines starting
+// with // are skipped.
+//
+//go:embed programs.txt
+var programs string
+
+// Programs returns the programs of the corpus.
+func Programs() []string {
+	var out []string
+	for _, line := range strings.Split(programs, "\n") {
+		if line != "" && !strings.HasPrefix(line, "//") {
+			out = append(out, line)
+		}
+	}
+	return out
+}
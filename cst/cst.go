@@ -0,0 +1,138 @@
+package cst
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/token"
+)
+
+// Node represents a node of a lossless concrete syntax tree.
+// A leaf holds a token with its trivia, and an inner node holds
+// the tokens and nodes that make up the corresponding AST node.
+type Node struct {
+	Kind     string       // type name of the AST node, or token type of the leaf
+	Token    *token.Token // non-nil only for a leaf
+	AST      ast.Node     // corresponding AST node, nil for a leaf
+	Children []*Node
+	Errors   []string // parse errors of the node itself, set for statements and the root
+}
+
+// Span represents an AST node that spans tokens from Start to End inclusive.
+// A nil Node represents a statement that failed to parse.
+type Span struct {
+	Start  int
+	End    int
+	Node   ast.Node
+	Errors []string
+}
+
+// ErrorKind is the Kind of a node that groups tokens of a statement
+// that failed to parse.
+const ErrorKind = "Error"
+
+// Text returns the source text of the node including its trivia.
+func (n *Node) Text() string {
+	var out bytes.Buffer
+	n.writeText(&out)
+	return out.String()
+}
+
+func (n *Node) writeText(out *bytes.Buffer) {
+	if n.Token != nil {
+		out.WriteString(n.Token.Leading)
+		out.WriteString(n.Token.Literal)
+		out.WriteString(n.Token.Trailing)
+		return
+	}
+	for _, child := range n.Children {
+		child.writeText(out)
+	}
+}
+
+// Len returns the length of the source text of the node in bytes.
+func (n *Node) Len() int {
+	if n.Token != nil {
+		return len(n.Token.Leading) + len(n.Token.Literal) + len(n.Token.Trailing)
+	}
+	length := 0
+	for _, child := range n.Children {
+		length += child.Len()
+	}
+	return length
+}
+
+// CollectErrors returns all parse errors in the tree in source order.
+func (n *Node) CollectErrors() []string {
+	var errors []string
+	for _, child := range n.Children {
+		errors = append(errors, child.CollectErrors()...)
+	}
+	return append(errors, n.Errors...)
+}
+
+// Tokens returns the tokens under the node in source order.
+func (n *Node) Tokens() []token.Token {
+	if n.Token != nil {
+		return []token.Token{*n.Token}
+	}
+	var tokens []token.Token
+	for _, child := range n.Children {
+		tokens = append(tokens, child.Tokens()...)
+	}
+	return tokens
+}
+
+// Build builds a tree rooted at root from all tokens of the program and
+// the spans of AST nodes. Spans must nest properly; spans of the same range
+// are nested in reverse order of appearance, i.e. inner nodes come first.
+// Tokens not covered by any span become children of the root.
+func Build(tokens []token.Token, spans []Span, root ast.Node) *Node {
+	order := make([]int, len(spans))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := spans[order[i]], spans[order[j]]
+		if a.Start != b.Start {
+			return a.Start < b.Start
+		}
+		if a.End != b.End {
+			return a.End > b.End
+		}
+		return order[i] > order[j]
+	})
+
+	top := &Node{Kind: kindOf(root), AST: root}
+	stack := []*Node{top}
+	ends := []int{len(tokens)}
+	next := 0
+	for i := range tokens {
+		for len(stack) > 1 && ends[len(ends)-1] < i {
+			stack = stack[:len(stack)-1]
+			ends = ends[:len(ends)-1]
+		}
+		for ; next < len(order) && spans[order[next]].Start == i; next++ {
+			span := spans[order[next]]
+			node := &Node{Kind: kindOf(span.Node), AST: span.Node, Errors: span.Errors}
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, node)
+			stack = append(stack, node)
+			ends = append(ends, span.End)
+		}
+		tok := tokens[i]
+		parent := stack[len(stack)-1]
+		parent.Children = append(parent.Children, &Node{Kind: string(tok.Type), Token: &tok})
+	}
+	return top
+}
+
+func kindOf(n ast.Node) string {
+	if n == nil {
+		return ErrorKind
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", n), "*ast.")
+}
@@ -0,0 +1,72 @@
+package cst_test
+
+import (
+	"testing"
+
+	"github.com/oohira/monkey/cst"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/parser"
+)
+
+func parse(input string) *cst.Node {
+	l := lexer.New(input)
+	l.PreserveTrivia()
+	p := parser.New(l)
+	return p.ParseConcreteProgram()
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []string{
+		"",
+		"   \n\t",
+		"// only a comment",
+		"let x = 5;",
+		"let  x=5 ;  // five\n\nreturn   10 ;\n",
+		"\r\n-a*b  +  !c // trailing\r\n// leading\r\n3 + 4; -5 * 5\n",
+		"5 + ;; @ let 1",
+		"a + b / c\n\n\n",
+	}
+
+	for i, input := range tests {
+		tree := parse(input)
+		if tree.Text() != input {
+			t.Errorf("[%d] Text() wrong. want=%q, got=%q", i, input, tree.Text())
+		}
+	}
+}
+
+func TestStructure(t *testing.T) {
+	tree := parse("// sum\nlet x = 1;\n-a * b; // product\n")
+
+	if tree.Kind != "Program" {
+		t.Fatalf("tree.Kind is not Program. got=%s", tree.Kind)
+	}
+	if len(tree.Children) != 3 {
+		t.Fatalf("tree has wrong number of children. got=%d", len(tree.Children))
+	}
+
+	let := tree.Children[0]
+	if let.Kind != "LetStatement" || let.Text() != "// sum\nlet x = 1;" {
+		t.Errorf("first child wrong. kind=%s, text=%q", let.Kind, let.Text())
+	}
+
+	stmt := tree.Children[1]
+	if stmt.Kind != "ExpressionStatement" || stmt.AST.String() != "((-a) * b)" {
+		t.Fatalf("second child wrong. kind=%s, ast=%v", stmt.Kind, stmt.AST)
+	}
+	infix := stmt.Children[0]
+	if infix.Kind != "InfixExpression" || infix.Text() != "\n-a * b" {
+		t.Fatalf("infix wrong. kind=%s, text=%q", infix.Kind, infix.Text())
+	}
+	prefix := infix.Children[0]
+	if prefix.Kind != "PrefixExpression" || prefix.Text() != "\n-a " {
+		t.Errorf("prefix wrong. kind=%s, text=%q", prefix.Kind, prefix.Text())
+	}
+	if semicolon := stmt.Children[1]; semicolon.Token == nil || semicolon.Token.Trailing != " // product" {
+		t.Errorf("semicolon wrong. got=%+v", semicolon.Token)
+	}
+
+	if eof := tree.Children[2]; eof.Kind != "EOF" || eof.Token.Leading != "\n" {
+		t.Errorf("last child is not EOF with trivia. got=%+v", eof.Token)
+	}
+}
@@ -0,0 +1,220 @@
+// Package env analyzes the environments a Monkey program creates at
+// runtime, for the translators into other languages.
+//
+// As in the evaluator, the program and each call of a function have an
+// environment, which the blocks in them share. A let statement binds a
+// name in the environment of its function and a parameter binds it on the
+// call, so a function has a variable for each name declared in it. An
+// identifier refers to the variable of the innermost function whose
+// environment has bound the name when the identifier is evaluated, which is
+// a variable of an enclosing function if the let statement in the function
+// has not been evaluated yet.
+package env
+
+import (
+	"github.com/oohira/monkey/ast"
+)
+
+// Info holds the environments of a program.
+type Info struct {
+	Functions map[ast.Node]*Function         // by *ast.Program and *ast.FunctionLiteral
+	Order     []*Function                    // the program first, then outer functions first
+	Refs      map[*ast.Identifier]*Reference // identifiers evaluated as expressions
+}
+
+// Function represents the environment of the program or a function literal.
+// Params are the variables of the parameters by position, where a
+// parameter followed by another of the same name is nil, since the last
+// argument is bound to the name. Vars are the variables of the parameters
+// and then those of the let statements in order of declaration.
+type Function struct {
+	Node   ast.Node // *ast.Program or *ast.FunctionLiteral
+	Outer  *Function
+	Depth  int // 0 for the program
+	Params []*Variable
+	Vars   []*Variable
+
+	vars map[string]*Variable
+}
+
+// Lookup returns the variable of name in f, or nil if f declares no name.
+func (f *Function) Lookup(name string) *Variable {
+	return f.vars[name]
+}
+
+func (f *Function) declare(name string, param bool) *Variable {
+	if v, ok := f.vars[name]; ok {
+		return v
+	}
+	v := &Variable{Name: name, Fn: f, Param: param}
+	f.vars[name] = v
+	f.Vars = append(f.Vars, v)
+	return v
+}
+
+// Variable represents a name in the environment of a function. A parameter
+// is bound whenever the function runs. Captured reports whether nested
+// functions refer to the variable.
+type Variable struct {
+	Name     string
+	Fn       *Function
+	Param    bool
+	Captured bool
+}
+
+// Reference represents the variables an identifier may refer to, innermost
+// first. At runtime the identifier refers to the first of them which is
+// bound, and fails with "identifier not found" if none is. Bound reports
+// whether the last one is bound whenever the identifier is evaluated, so
+// that the identifier never fails.
+type Reference struct {
+	Vars  []*Variable
+	Bound bool
+}
+
+// Analyze returns the environments of a program.
+func Analyze(program *ast.Program) *Info {
+	a := &analyzer{info: &Info{
+		Functions: map[ast.Node]*Function{},
+		Refs:      map[*ast.Identifier]*Reference{},
+	}}
+	f := a.declare(program, nil, nil, program.Statements)
+	a.statements(&context{fn: f, bound: map[string]bool{}}, program.Statements)
+	return a.info
+}
+
+type analyzer struct {
+	info *Info
+}
+
+// context represents a point of the evaluation of a function, where the
+// names in bound have been bound by let statements of the function.
+type context struct {
+	fn    *Function
+	bound map[string]bool
+	outer *context // the point where the closure has been created
+}
+
+// with returns a copy of c where name is also bound, if name is not empty.
+func (c *context) with(name string) *context {
+	bound := make(map[string]bool, len(c.bound)+1)
+	for k := range c.bound {
+		bound[k] = true
+	}
+	if name != "" {
+		bound[name] = true
+	}
+	return &context{fn: c.fn, bound: bound, outer: c.outer}
+}
+
+// declare collects the variables of a function and its nested functions.
+func (a *analyzer) declare(node ast.Node, outer *Function, params []*ast.Identifier, stmts []ast.Statement) *Function {
+	f := &Function{Node: node, Outer: outer, Params: make([]*Variable, len(params)), vars: map[string]*Variable{}}
+	if outer != nil {
+		f.Depth = outer.Depth + 1
+	}
+	a.info.Functions[node] = f
+	a.info.Order = append(a.info.Order, f)
+
+	last := map[string]int{}
+	for i, param := range params {
+		last[param.Value] = i
+	}
+	for i, param := range params {
+		if last[param.Value] == i {
+			f.Params[i] = f.declare(param.Value, true)
+		}
+	}
+	ast.Inspect(&ast.BlockStatement{Statements: stmts}, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.LetStatement:
+			if n.Name != nil {
+				f.declare(n.Name.Value, false)
+			}
+		case *ast.FunctionLiteral:
+			var body []ast.Statement
+			if n.Body != nil {
+				body = n.Body.Statements
+			}
+			a.declare(n, f, n.Parameters, body)
+			return false
+		}
+		return true
+	})
+	return f
+}
+
+// statements resolves the identifiers of stmts evaluated in c. The names
+// bound in a block may be unbound after it, since it is a branch.
+func (a *analyzer) statements(c *context, stmts []ast.Statement) {
+	c = c.with("")
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *ast.LetStatement:
+			if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok && stmt.Name != nil {
+				// the function runs only after it is bound to the name
+				a.expression(c.with(stmt.Name.Value), fl)
+			} else {
+				a.expression(c, stmt.Value)
+			}
+			if stmt.Name != nil {
+				c.bound[stmt.Name.Value] = true
+			}
+		case *ast.ReturnStatement:
+			a.expression(c, stmt.ReturnValue)
+		case *ast.ExpressionStatement:
+			a.expression(c, stmt.Expression)
+		case *ast.BlockStatement:
+			a.statements(c, stmt.Statements)
+		}
+	}
+}
+
+func (a *analyzer) expression(c *context, exp ast.Expression) {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		a.reference(c, exp)
+	case *ast.PrefixExpression:
+		a.expression(c, exp.Right)
+	case *ast.InfixExpression:
+		a.expression(c, exp.Left)
+		a.expression(c, exp.Right)
+	case *ast.IfExpression:
+		a.expression(c, exp.Condition)
+		if exp.Consequence != nil {
+			a.statements(c, exp.Consequence.Statements)
+		}
+		if exp.Alternative != nil {
+			a.statements(c, exp.Alternative.Statements)
+		}
+	case *ast.FunctionLiteral:
+		if exp.Body != nil {
+			inner := &context{fn: a.info.Functions[exp], bound: map[string]bool{}, outer: c}
+			a.statements(inner, exp.Body.Statements)
+		}
+	case *ast.CallExpression:
+		a.expression(c, exp.Function)
+		for _, arg := range exp.Arguments {
+			a.expression(c, arg)
+		}
+	}
+}
+
+func (a *analyzer) reference(c *context, ident *ast.Identifier) {
+	ref := &Reference{}
+	for o := c; o != nil; o = o.outer {
+		v := o.fn.Lookup(ident.Value)
+		if v == nil {
+			continue
+		}
+		if o != c {
+			v.Captured = true
+		}
+		ref.Vars = append(ref.Vars, v)
+		if v.Param || o.bound[ident.Value] {
+			ref.Bound = true
+			break
+		}
+	}
+	a.info.Refs[ident] = ref
+}
@@ -0,0 +1,142 @@
+package env
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+// references returns the references of identifiers in order as
+// "name@pos->depth,depth" with "!" appended if the reference is bound.
+func references(program *ast.Program, info *Info) string {
+	var out []string
+	ast.Inspect(program, func(n ast.Node) bool {
+		ident, ok := n.(*ast.Identifier)
+		if !ok {
+			return true
+		}
+		ref := info.Refs[ident]
+		if ref == nil {
+			return true
+		}
+		var depths []string
+		for _, v := range ref.Vars {
+			depths = append(depths, fmt.Sprint(v.Fn.Depth))
+		}
+		s := ident.Value + "@" + ident.Token.Pos.String() + "->" + strings.Join(depths, ",")
+		if ref.Bound {
+			s += "!"
+		}
+		out = append(out, s)
+		return true
+	})
+	return strings.Join(out, " ")
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		input      string
+		references string
+	}{
+		{
+			"let x = 1; x;",
+			"x@1:12->0!",
+		},
+		{
+			"x; let x = 1;",
+			"x@1:1->0",
+		},
+		{
+			"y;",
+			"y@1:1->",
+		},
+		{
+			"let f = fn(n) { f(n) };",
+			"f@1:17->0! n@1:19->1!",
+		},
+		{
+			"let x = 1; let f = fn() { if (true) { let x = 2; } x }; f() + x",
+			"x@1:52->1,0! f@1:57->0! x@1:63->0!",
+		},
+		{
+			"let f = fn() { let x = 2; if (true) { x } }",
+			"x@1:39->1!",
+		},
+		{
+			"let f = fn() { let g = fn() { x }; let x = 4; g() }",
+			"x@1:31->1 g@1:47->1!",
+		},
+		{
+			"let f = fn(x, y, x) { fn() { x + y } }",
+			"x@1:30->1! y@1:34->1!",
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		if got := references(program, Analyze(program)); got != tt.references {
+			t.Errorf("wrong references for %q.\nwant=%s\ngot =%s", tt.input, tt.references, got)
+		}
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	program := parse(t, "let a = 1; let f = fn(x, y, x) { let z = x; if (y) { let w = 1; } fn() { z } }")
+	info := Analyze(program)
+
+	if len(info.Order) != 3 {
+		t.Fatalf("wrong number of functions. want=3, got=%d", len(info.Order))
+	}
+	tests := []struct {
+		params string
+		vars   string
+	}{
+		{"", "a f"},
+		{"_ y x", "y x z w"},
+		{"", ""},
+	}
+	for i, tt := range tests {
+		f := info.Order[i]
+		if f.Depth != i {
+			t.Errorf("functions[%d]: wrong depth. want=%d, got=%d", i, i, f.Depth)
+		}
+		var params, vars []string
+		for _, v := range f.Params {
+			if v == nil {
+				params = append(params, "_")
+			} else {
+				params = append(params, v.Name)
+			}
+		}
+		for _, v := range f.Vars {
+			vars = append(vars, v.Name)
+		}
+		if got := strings.Join(params, " "); got != tt.params {
+			t.Errorf("functions[%d]: wrong params. want=%q, got=%q", i, tt.params, got)
+		}
+		if got := strings.Join(vars, " "); got != tt.vars {
+			t.Errorf("functions[%d]: wrong vars. want=%q, got=%q", i, tt.vars, got)
+		}
+	}
+
+	if z := info.Order[1].Lookup("z"); z == nil || !z.Captured {
+		t.Errorf("z is not captured")
+	}
+	if x := info.Order[1].Lookup("x"); x == nil || x.Captured {
+		t.Errorf("x is captured")
+	}
+}
@@ -0,0 +1,100 @@
+package evaluator_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/closure"
+	"github.com/oohira/monkey/compiler"
+	"github.com/oohira/monkey/corpus"
+	"github.com/oohira/monkey/evaluator"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/object"
+	"github.com/oohira/monkey/parser"
+	"github.com/oohira/monkey/rvm"
+	"github.com/oohira/monkey/ssa"
+	"github.com/oohira/monkey/vm"
+)
+
+// runVM compiles and runs program on the stack-based virtual machine.
+func runVM(program *ast.Program) object.Object {
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	machine := vm.New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	return machine.LastPoppedStackElem()
+}
+
+// runClosure compiles program into closures and runs them.
+func runClosure(program *ast.Program) object.Object {
+	return closure.Compile(program).Run()
+}
+
+// runRVM builds program into SSA form, optimizes it and runs it on the
+// register-based virtual machine.
+func runRVM(program *ast.Program) object.Object {
+	p, err := ssa.Build(program)
+	if err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	ssa.Optimize(p, ssa.Passes)
+	result, err := rvm.New(rvm.Compile(p)).Run()
+	if err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	return result
+}
+
+func TestDifferential(t *testing.T) {
+	implementations := []struct {
+		name string
+		run  func(*ast.Program) object.Object
+	}{
+		{"vm", runVM},
+		{"closure", runClosure},
+		{"rvm", runRVM},
+	}
+
+	for _, input := range corpus.Programs() {
+		p := parser.New(lexer.New(input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Errorf("parser errors for %q: %v", input, p.Errors())
+			continue
+		}
+		expected := evaluator.Eval(program, object.NewEnvironment()).Inspect()
+		for _, impl := range implementations {
+			if got := impl.run(program).Inspect(); got != expected {
+				t.Errorf("result of %q on %s differs from the evaluator. want=%s, got=%s", input, impl.name, expected, got)
+			}
+		}
+	}
+}
+
+// BenchmarkEval evaluates the programs ../testdata/*.mk, which the other
+// implementations benchmark as well.
+func BenchmarkEval(b *testing.B) {
+	files, err := filepath.Glob("../testdata/*.mk")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			b.Fatal(err)
+		}
+		program := parser.New(lexer.New(string(src))).ParseProgram()
+		b.Run(strings.TrimSuffix(filepath.Base(file), ".mk"), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				evaluator.Eval(program, object.NewEnvironment())
+			}
+		})
+	}
+}
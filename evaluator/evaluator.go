@@ -0,0 +1,182 @@
+// Package evaluator interprets Monkey programs by walking the AST.
+//
+// Blocks share the environment of the enclosing function, and functions
+// close over the environment they are defined in.
+package evaluator
+
+import (
+	"fmt"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/object"
+)
+
+// Eval evaluates node in env and returns the result. A runtime error is
+// returned as an *object.Error. Statements other than expression
+// statements evaluate to null.
+func Eval(node ast.Node, env *object.Environment) object.Object {
+	switch node := node.(type) {
+	// Statements
+	case *ast.Program:
+		return evalProgram(node, env)
+	case *ast.BlockStatement:
+		return evalBlockStatement(node, env)
+	case *ast.ExpressionStatement:
+		return Eval(node.Expression, env)
+	case *ast.ReturnStatement:
+		val := Eval(node.ReturnValue, env)
+		if aborted(val) {
+			return val
+		}
+		return &object.ReturnValue{Value: val}
+	case *ast.LetStatement:
+		val := Eval(node.Value, env)
+		if aborted(val) {
+			return val
+		}
+		env.Set(node.Name.Value, val)
+		return object.NullValue
+
+	// Expressions
+	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return object.NewBigInteger(node.Big)
+		}
+		return object.NewInteger(node.Value)
+	case *ast.Boolean:
+		return object.NativeBool(node.Value)
+	case *ast.PrefixExpression:
+		right := Eval(node.Right, env)
+		if aborted(right) {
+			return right
+		}
+		return result(object.Prefix(node.Operator, right))
+	case *ast.InfixExpression:
+		left := Eval(node.Left, env)
+		if aborted(left) {
+			return left
+		}
+		right := Eval(node.Right, env)
+		if aborted(right) {
+			return right
+		}
+		return result(object.Infix(node.Operator, left, right))
+	case *ast.IfExpression:
+		return evalIfExpression(node, env)
+	case *ast.Identifier:
+		if val, ok := env.Get(node.Value); ok {
+			return val
+		}
+		return newError("identifier not found: %s", node.Value)
+	case *ast.FunctionLiteral:
+		return &object.Function{Parameters: node.Parameters, Body: node.Body, Env: env}
+	case *ast.CallExpression:
+		function := Eval(node.Function, env)
+		if aborted(function) {
+			return function
+		}
+		args := evalExpressions(node.Arguments, env)
+		if len(args) == 1 && aborted(args[0]) {
+			return args[0]
+		}
+		return applyFunction(function, args)
+	}
+	return newError("cannot evaluate %T", node)
+}
+
+func evalProgram(program *ast.Program, env *object.Environment) object.Object {
+	var result object.Object = object.NullValue
+	for _, stmt := range program.Statements {
+		result = Eval(stmt, env)
+		switch result := result.(type) {
+		case *object.ReturnValue:
+			return result.Value
+		case *object.Error:
+			return result
+		}
+	}
+	return result
+}
+
+// evalBlockStatement evaluates the statements of block until a return
+// statement or an error, which is returned as is to the caller.
+func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+	var result object.Object = object.NullValue
+	for _, stmt := range block.Statements {
+		result = Eval(stmt, env)
+		if aborted(result) {
+			return result
+		}
+	}
+	return result
+}
+
+func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
+	condition := Eval(ie.Condition, env)
+	if aborted(condition) {
+		return condition
+	}
+
+	switch {
+	case object.IsTruthy(condition):
+		return Eval(ie.Consequence, env)
+	case ie.Alternative != nil:
+		return Eval(ie.Alternative, env)
+	default:
+		return object.NullValue
+	}
+}
+
+func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
+	result := make([]object.Object, 0, len(exps))
+	for _, e := range exps {
+		evaluated := Eval(e, env)
+		if aborted(evaluated) {
+			return []object.Object{evaluated}
+		}
+		result = append(result, evaluated)
+	}
+	return result
+}
+
+func applyFunction(fn object.Object, args []object.Object) object.Object {
+	function, ok := fn.(*object.Function)
+	if !ok {
+		return newError("not a function: %s", fn.Type())
+	}
+	if len(args) != len(function.Parameters) {
+		return newError("wrong number of arguments: want=%d, got=%d", len(function.Parameters), len(args))
+	}
+
+	env := object.NewEnclosedEnvironment(function.Env)
+	for i, param := range function.Parameters {
+		env.Set(param.Value, args[i])
+	}
+	evaluated := Eval(function.Body, env)
+	if rv, ok := evaluated.(*object.ReturnValue); ok {
+		return rv.Value
+	}
+	return evaluated
+}
+
+// result converts the result of an operator into an object.
+func result(obj object.Object, err error) object.Object {
+	if err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	return obj
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
+
+// aborted reports whether obj is an error or a return value, either of
+// which aborts the evaluation of the enclosing expressions.
+func aborted(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Error, *object.ReturnValue:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package evaluator
+
+import (
+	"testing"
+
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/object"
+	"github.com/oohira/monkey/parser"
+)
+
+func testEval(t *testing.T, input string) object.Object {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return Eval(program, object.NewEnvironment())
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5", "5"},
+		{"-5 + 10 * 2", "15"},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", "50"},
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"!true; !!5", "true"},
+		{"1 < 2 == true", "true"},
+		{"1 == true", "false"},
+		{"if (0) { 10 }", "10"},
+		{"if (1 > 2) { 10 }", "null"},
+		{"if (1 > 2) { 10 } else { 20 }", "20"},
+		{"if (true) {}", "null"},
+		{"let a = 5; let b = a * 2; b", "10"},
+		{"let a = 5;", "null"},
+		{"", "null"},
+		{"9; return 2 * 5; 9;", "10"},
+		{"if (true) { if (true) { return 10; } return 1; }", "10"},
+		{"let f = fn(x) { if (x) { return 1; } 2 }; f(true) + f(false)", "3"},
+		{"let f = fn() { let x = 1; }; f()", "null"},
+		{"let add = fn(a, b) { a + b }; add(add(1, 2), 3)", "6"},
+		{"fn(x) { x }(5)", "5"},
+		{"let adder = fn(x) { fn(y) { x + y } }; let addTwo = adder(2); addTwo(3)", "5"},
+		{"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(25)", "15511210043330985984000000"},
+		{"let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } }; let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; odd(7)", "true"},
+		{"let f = fn(x) { 1 + if (x) { return 10; } else { 2 } }; f(true) + f(false)", "13"},
+		{"5 + true;", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+		{"-true", "ERROR: unknown operator: -BOOLEAN"},
+		{"true + false; 5", "ERROR: unknown operator: BOOLEAN + BOOLEAN"},
+		{"if (10 > 1) { true + false; 5 }", "ERROR: unknown operator: BOOLEAN + BOOLEAN"},
+		{"foobar", "ERROR: identifier not found: foobar"},
+		{"1 / 0", "ERROR: division by zero"},
+		{"5()", "ERROR: not a function: INTEGER"},
+		{"fn(x) { x }(1, 2)", "ERROR: wrong number of arguments: want=1, got=2"},
+		{"fn(x) { x }(1 / 0, y)", "ERROR: division by zero"},
+	}
+
+	for _, tt := range tests {
+		if got := testEval(t, tt.input).Inspect(); got != tt.expected {
+			t.Errorf("result of %q wrong. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestFunctionObject(t *testing.T) {
+	fn, ok := testEval(t, "fn(x) { x + 2; };").(*object.Function)
+	if !ok {
+		t.Fatalf("object is not Function")
+	}
+	if len(fn.Parameters) != 1 || fn.Parameters[0].String() != "x" {
+		t.Errorf("parameters wrong. got=%v", fn.Parameters)
+	}
+	if fn.Body.String() != "(x + 2)" {
+		t.Errorf("body wrong. got=%q", fn.Body.String())
+	}
+}
@@ -0,0 +1,199 @@
+package format
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"github.com/oohira/monkey/cst"
+	"github.com/oohira/monkey/parser"
+	"github.com/oohira/monkey/token"
+)
+
+// Indent is the indentation of a block.
+const Indent = "    "
+
+// Source formats the Monkey program src in the canonical style:
+// one statement per line, blocks indented by Indent, single spaces around
+// binary operators, and at most one blank line between statements.
+// Comments are preserved. It returns an error if src has parse errors.
+func Source(src string) (string, error) {
+	tree := parser.ParseConcrete(src)
+	if errs := tree.CollectErrors(); len(errs) > 0 {
+		return "", errors.New(errs[0])
+	}
+	return Tree(tree), nil
+}
+
+// Tree formats a concrete syntax tree without parse errors.
+func Tree(tree *cst.Node) string {
+	p := &printer{lineStart: true, blockStart: true}
+	for _, child := range tree.Children {
+		if child.Token != nil {
+			// EOF keeps the comments at the end of the program
+			p.comments(child.Token.Leading)
+			continue
+		}
+		p.statement(child)
+	}
+	out := p.out.String()
+	if out != "" && !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	return out
+}
+
+type printer struct {
+	out          bytes.Buffer
+	indent       int
+	lineStart    bool // nothing is written on the current line
+	blockStart   bool // nothing is written in the current block
+	continuation bool // in the middle of a statement
+	prev         *cst.Node
+	prevParent   *cst.Node
+}
+
+func (p *printer) statement(n *cst.Node) {
+	if !p.lineStart {
+		p.newline()
+	}
+	saved := p.continuation
+	p.continuation = false
+	p.node(n, nil)
+	if !p.lineStart {
+		p.newline()
+	}
+	p.continuation = saved
+}
+
+func (p *printer) node(n *cst.Node, parent *cst.Node) {
+	if n.Token != nil {
+		p.comments(n.Token.Leading)
+		p.token(n, parent)
+		p.trailing(n.Token.Trailing)
+		return
+	}
+	if n.Kind == "BlockStatement" {
+		p.block(n)
+		return
+	}
+	for _, child := range n.Children {
+		p.node(child, n)
+	}
+}
+
+func (p *printer) block(n *cst.Node) {
+	lbrace, rbrace := n.Children[0], n.Children[len(n.Children)-1]
+	stmts := n.Children[1 : len(n.Children)-1]
+
+	p.node(lbrace, n)
+	if len(stmts) == 0 && !hasComment(lbrace.Token.Trailing) && !hasComment(rbrace.Token.Leading) {
+		p.token(rbrace, n)
+		p.trailing(rbrace.Token.Trailing)
+		return
+	}
+
+	p.indent++
+	p.blockStart = true
+	if !p.lineStart {
+		p.newline()
+	}
+	for _, stmt := range stmts {
+		p.statement(stmt)
+	}
+	p.continuation = false
+	p.comments(rbrace.Token.Leading)
+	p.indent--
+	if !p.lineStart {
+		p.newline()
+	}
+	p.token(rbrace, n)
+	p.trailing(rbrace.Token.Trailing)
+}
+
+// comments writes the comments in leading trivia on their own lines.
+func (p *printer) comments(trivia string) {
+	newlines := 0
+	for _, line := range strings.SplitAfter(trivia, "\n") {
+		i := strings.Index(line, "//")
+		if i < 0 {
+			newlines += strings.Count(line, "\n")
+			continue
+		}
+		if !p.lineStart {
+			p.newline()
+		}
+		if newlines >= 2 && !p.blockStart {
+			p.newline()
+		}
+		p.writeIndent()
+		p.out.WriteString(strings.TrimRight(line[i:], " \t\r\n"))
+		p.newline()
+		p.blockStart = false
+		newlines = strings.Count(line, "\n")
+	}
+	if newlines >= 2 && !p.blockStart && !p.continuation && p.lineStart {
+		p.newline()
+	}
+}
+
+// trailing writes the comment in trailing trivia at the end of the line.
+func (p *printer) trailing(trivia string) {
+	if i := strings.Index(trivia, "//"); i >= 0 {
+		p.out.WriteString(" ")
+		p.out.WriteString(strings.TrimRight(trivia[i:], " \t\r"))
+		p.newline()
+	}
+}
+
+func (p *printer) token(n *cst.Node, parent *cst.Node) {
+	if n.Token.Type == token.EOF {
+		return
+	}
+	if p.lineStart {
+		p.writeIndent()
+	} else if p.needSpace(n, parent) {
+		p.out.WriteString(" ")
+	}
+	p.out.WriteString(n.Token.Literal)
+	p.lineStart = false
+	p.blockStart = false
+	p.continuation = true
+	p.prev, p.prevParent = n, parent
+}
+
+func (p *printer) needSpace(n *cst.Node, parent *cst.Node) bool {
+	prev, cur := p.prev.Token.Type, n.Token.Type
+	switch {
+	case cur == token.RPAREN || cur == token.COMMA || cur == token.SEMICOLON || cur == token.COLON:
+		return false
+	case prev == token.LPAREN:
+		return false
+	case prev == token.LBRACE && cur == token.RBRACE:
+		return false
+	case p.prevParent != nil && p.prevParent.Kind == "PrefixExpression" && p.prevParent.Children[0] == p.prev:
+		return false
+	case cur == token.LPAREN && parent != nil && (parent.Kind == "CallExpression" || parent.Kind == "FunctionLiteral" ||
+		parent.Kind == "FunctionType"):
+		return false
+	}
+	return true
+}
+
+func (p *printer) writeIndent() {
+	level := p.indent
+	if p.continuation {
+		level++
+	}
+	p.out.WriteString(strings.Repeat(Indent, level))
+	p.lineStart = false
+}
+
+func (p *printer) newline() {
+	p.out.WriteString("\n")
+	p.lineStart = true
+}
+
+func hasComment(trivia string) bool {
+	return strings.Contains(trivia, "//")
+}
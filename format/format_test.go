@@ -0,0 +1,56 @@
+package format
+
+import "testing"
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", ""},
+		{"let   x=5 ;", "let x = 5;\n"},
+		{"-a*b+!c;x", "-a * b + !c;\nx\n"},
+		{"let add = fn(a,b){a+b};add( 1 , 2*3 )", "let add = fn(a, b) {\n    a + b\n};\nadd(1, 2 * 3)\n"},
+		{"if(x<y){x}else{y}", "if (x < y) {\n    x\n} else {\n    y\n}\n"},
+		{"fn(){}", "fn() {}\n"},
+		{"-(5 + 5) * (2)", "-(5 + 5) * (2)\n"},
+		{"f(fn(x){x})(1)", "f(fn(x) {\n    x\n})(1)\n"},
+		{
+			"// header\n\n\n\nlet x = 1; // one\nlet y = 2;\n\n\n// tail\n",
+			"// header\n\nlet x = 1; // one\nlet y = 2;\n\n// tail\n",
+		},
+		{
+			"let f = fn(x) { // body\n  // first\n  let y = x;\n\n\n  return y; // done\n  // last\n};",
+			"let f = fn(x) { // body\n    // first\n    let y = x;\n\n    return y; // done\n    // last\n};\n",
+		},
+		{
+			"let f = fn() {\nif (true) { return 1; } else { // never\n}\n}",
+			"let f = fn() {\n    if (true) {\n        return 1;\n    } else { // never\n    }\n}\n",
+		},
+		{"1 + // one\n2", "1 + // one\n    2\n"},
+		{
+			"let f=fn(a:int,b :fn(int)->bool)->bool{b(a)};let x :int=1",
+			"let f = fn(a: int, b: fn(int) -> bool) -> bool {\n    b(a)\n};\nlet x: int = 1\n",
+		},
+	}
+
+	for i, tt := range tests {
+		actual, err := Source(tt.input)
+		if err != nil {
+			t.Fatalf("[%d] unexpected error: %v", i, err)
+		}
+		if actual != tt.expected {
+			t.Errorf("[%d] want=%q, got=%q", i, tt.expected, actual)
+		}
+		again, err := Source(actual)
+		if err != nil || again != actual {
+			t.Errorf("[%d] formatting is not idempotent. got=%q, err=%v", i, again, err)
+		}
+	}
+}
+
+func TestSourceError(t *testing.T) {
+	if _, err := Source("let = 5;"); err == nil {
+		t.Errorf("Source should fail on a parse error")
+	}
+}
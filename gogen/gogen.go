@@ -0,0 +1,424 @@
+// Package gogen translates Monkey programs into Go source code.
+//
+// The generated file is a package with a function Run evaluating the
+// program on the runtime in the rt package, and a function main printing the
+// result if the package is main. Integer literals are package-level values,
+// each name declared in a Monkey function is a variable of the Go function,
+// and an if expression whose value is used is a function literal called in
+// place. An identifier which may be evaluated before its variable is bound
+// reads the variables of the enclosing functions declaring the name as well,
+// and evaluates to the first one bound as in the interpreter.
+package gogen
+
+import (
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/env"
+)
+
+// Options controls the generated code.
+type Options struct {
+	Package string // name of the package, "main" if empty
+	Source  string // name of the Monkey file in the header
+}
+
+// Generate returns the gofmt'ed Go source of program.
+func Generate(program *ast.Program, options Options) ([]byte, error) {
+	if options.Package == "" {
+		options.Package = "main"
+	}
+	g := &generator{
+		env:       env.Analyze(program),
+		fns:       map[ast.Node]*function{},
+		taken:     map[string]bool{},
+		constants: map[string]string{},
+	}
+	for name := range goReserved {
+		g.taken[name] = true
+	}
+	ast.Inspect(program, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Identifier); ok {
+			g.taken[ident.Value] = true
+		}
+		return true
+	})
+	for _, e := range g.env.Order {
+		f := &function{
+			Function: e,
+			names:    map[*env.Variable]string{},
+			read:     map[string]bool{},
+			assigned: map[string]bool{},
+		}
+		g.rename(f)
+		g.fns[e.Node] = f
+	}
+
+	body := g.body(program, program.Statements)
+
+	var out strings.Builder
+	if options.Source != "" {
+		fmt.Fprintf(&out, "// Code generated by monkey gogen from %s. DO NOT EDIT.\n\n", options.Source)
+	} else {
+		out.WriteString("// Code generated by monkey gogen. DO NOT EDIT.\n\n")
+	}
+	fmt.Fprintf(&out, "package %s\n\n", options.Package)
+	if options.Package == "main" {
+		out.WriteString("import (\n\"fmt\"\n\"os\"\n\n\"github.com/oohira/monkey/gogen/rt\"\n)\n\n")
+	} else {
+		out.WriteString("import \"github.com/oohira/monkey/gogen/rt\"\n\n")
+	}
+	out.WriteString("// Run runs the program and returns its value or the runtime error.\n")
+	out.WriteString("func Run() (rt.Value, error) {\nreturn rt.Run(program)\n}\n\n")
+	out.WriteString("func program() " + body + "\n")
+	if options.Package == "main" {
+		out.WriteString(`
+func main() {
+	result, err := Run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "runtime error: %s\n", err)
+		os.Exit(1)
+	}
+	if result != rt.Null {
+		fmt.Println(result.Inspect())
+	}
+}
+`)
+	}
+	if len(g.constantList) > 0 {
+		out.WriteString("\nvar (\n")
+		for _, c := range g.constantList {
+			out.WriteString(c + "\n")
+		}
+		out.WriteString(")\n")
+	}
+	return format.Source([]byte(out.String()))
+}
+
+// goReserved holds the keywords and predeclared identifiers of Go and the
+// names used by the generated code.
+var goReserved = map[string]bool{}
+
+func init() {
+	for _, name := range strings.Fields(`break case chan const continue default
+		defer else fallthrough for func go goto if import interface map package
+		range return select struct switch type var
+		any append bool byte cap clear close comparable complex complex64
+		complex128 copy delete error false float32 float64 imag int int8 int16
+		int32 int64 iota len make max min new nil panic print println real
+		recover rune string true uint uint8 uint16 uint32 uint64 uintptr
+		args fmt init main os program result rt Run _`) {
+		goReserved[name] = true
+	}
+}
+
+// function represents the program or a function literal.
+type function struct {
+	*env.Function
+	names    map[*env.Variable]string // Go names of the variables
+	read     map[string]bool          // Go names read in the function or nested ones
+	assigned map[string]bool          // Go names assigned by let statements
+	catches  bool                     // a return statement is evaluated in an expression
+}
+
+type generator struct {
+	env    *env.Info
+	fns    map[ast.Node]*function
+	fn     *function // function being generated
+	inFunc int       // depth of function literals generated for if expressions
+	taken  map[string]bool
+
+	constants    map[string]string // names of integer constants by value
+	constantList []string          // declarations of the constants
+}
+
+// rename gives Go names to the variables of f. A name which is reserved or
+// declared also in an outer function gets a fresh name, so that the
+// variables of the outer functions remain accessible in f.
+func (g *generator) rename(f *function) {
+	for _, v := range f.Vars {
+		goName := v.Name
+		shadows := false
+		for o := f.Outer; o != nil; o = o.Outer {
+			if o.Lookup(v.Name) != nil {
+				shadows = true
+				break
+			}
+		}
+		if goReserved[v.Name] || shadows {
+			goName = g.fresh(v.Name)
+		}
+		f.names[v] = goName
+	}
+}
+
+// fresh returns a name derived from name which is used nowhere else.
+func (g *generator) fresh(name string) string {
+	for i := 1; ; i++ {
+		s := fmt.Sprintf("%s_%d", strings.TrimRight(name, "_"), i)
+		if !g.taken[s] {
+			g.taken[s] = true
+			return s
+		}
+	}
+}
+
+// body returns the body of the function node in braces with the
+// declarations of its variables.
+func (g *generator) body(node ast.Node, stmts []ast.Statement) string {
+	outer := g.fn
+	g.fn = g.fns[node]
+	defer func() { g.fn = outer }()
+
+	var body strings.Builder
+	g.statements(&body, stmts, true)
+
+	f := g.fn
+	result := "rt.Value"
+	if f.catches {
+		result = "(result rt.Value)"
+	}
+	var out strings.Builder
+	out.WriteString("" + result + " {\n")
+	for i, v := range f.Params {
+		if v == nil {
+			continue // the last argument is bound to the name
+		}
+		goName := f.names[v]
+		if f.read[goName] || f.assigned[goName] {
+			fmt.Fprintf(&out, "%s := args[%d]\n", goName, i)
+		}
+	}
+	var locals []string
+	for _, v := range f.Vars {
+		if !v.Param {
+			locals = append(locals, f.names[v])
+		}
+	}
+	if len(locals) > 0 {
+		fmt.Fprintf(&out, "var %s rt.Value\n", strings.Join(locals, ", "))
+	}
+	for _, v := range f.Vars {
+		goName := f.names[v]
+		if f.assigned[goName] && !f.read[goName] {
+			fmt.Fprintf(&out, "_ = %s\n", goName)
+		}
+	}
+	if f.catches {
+		out.WriteString("defer rt.Catch(&result)\n")
+	}
+	out.WriteString(body.String())
+	out.WriteString("}")
+	return out.String()
+}
+
+// statements writes stmts as statements. tail reports whether the value
+// of stmts is returned.
+func (g *generator) statements(out *strings.Builder, stmts []ast.Statement, tail bool) {
+	for i, stmt := range stmts {
+		g.statement(out, stmt, tail && i == len(stmts)-1)
+	}
+	if !tail {
+		return
+	}
+	if len(stmts) > 0 {
+		switch stmt := stmts[len(stmts)-1].(type) {
+		case *ast.ReturnStatement:
+			return
+		case *ast.ExpressionStatement:
+			if ie, ok := stmt.Expression.(*ast.IfExpression); !ok || exhaustive(ie) {
+				return
+			}
+		}
+	}
+	out.WriteString("return rt.Null\n")
+}
+
+// exhaustive reports whether an if statement generated by ifStatement has
+// a branch for every condition.
+func exhaustive(ie *ast.IfExpression) bool {
+	if ie.Alternative == nil {
+		return false
+	}
+	if elseIf := elseIf(ie); elseIf != nil {
+		return exhaustive(elseIf)
+	}
+	return true
+}
+
+// elseIf returns the if expression which is the only statement of the
+// alternative of ie, or nil.
+func elseIf(ie *ast.IfExpression) *ast.IfExpression {
+	if stmts := ie.Alternative.Statements; len(stmts) == 1 {
+		if es, ok := stmts[0].(*ast.ExpressionStatement); ok {
+			if elseIf, ok := es.Expression.(*ast.IfExpression); ok {
+				return elseIf
+			}
+		}
+	}
+	return nil
+}
+
+func (g *generator) statement(out *strings.Builder, stmt ast.Statement, tail bool) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		name := g.fn.names[g.fn.Lookup(stmt.Name.Value)]
+		g.fn.assigned[name] = true
+		fmt.Fprintf(out, "%s = %s\n", name, g.expression(stmt.Value))
+	case *ast.ReturnStatement:
+		value := g.expression(stmt.ReturnValue)
+		if g.inFunc > 0 {
+			g.fn.catches = true
+			fmt.Fprintf(out, "return rt.Return(%s)\n", value)
+			return
+		}
+		fmt.Fprintf(out, "return %s\n", value)
+	case *ast.ExpressionStatement:
+		if ie, ok := stmt.Expression.(*ast.IfExpression); ok {
+			g.ifStatement(out, ie, tail)
+			out.WriteString("\n")
+			return
+		}
+		if tail {
+			fmt.Fprintf(out, "return %s\n", g.expression(stmt.Expression))
+			return
+		}
+		fmt.Fprintf(out, "_ = %s\n", g.expression(stmt.Expression))
+	case *ast.BlockStatement:
+		g.statements(out, stmt.Statements, tail)
+	}
+}
+
+// ifStatement writes an if expression as an if statement. If tail is true,
+// each branch returns its value.
+func (g *generator) ifStatement(out *strings.Builder, ie *ast.IfExpression, tail bool) {
+	fmt.Fprintf(out, "if rt.Truthy(%s) {\n", g.expression(ie.Condition))
+	g.statements(out, ie.Consequence.Statements, tail)
+	out.WriteString("}")
+	if ie.Alternative == nil {
+		return
+	}
+	out.WriteString(" else ")
+	if elseIf := elseIf(ie); elseIf != nil {
+		g.ifStatement(out, elseIf, tail)
+		return
+	}
+	out.WriteString("{\n")
+	g.statements(out, ie.Alternative.Statements, tail)
+	out.WriteString("}")
+}
+
+var infixFuncs = map[string]string{
+	"+":  "rt.Add",
+	"-":  "rt.Sub",
+	"*":  "rt.Mul",
+	"/":  "rt.Div",
+	"<":  "rt.Lt",
+	">":  "rt.Gt",
+	"==": "rt.Eq",
+	"!=": "rt.NotEq",
+}
+
+func (g *generator) expression(exp ast.Expression) string {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return g.constant(exp)
+	case *ast.Boolean:
+		if exp.Value {
+			return "rt.True"
+		}
+		return "rt.False"
+	case *ast.Identifier:
+		return g.identifier(exp)
+	case *ast.PrefixExpression:
+		if exp.Operator == "-" {
+			return "rt.Neg(" + g.expression(exp.Right) + ")"
+		}
+		return "rt.Not(" + g.expression(exp.Right) + ")"
+	case *ast.InfixExpression:
+		return fmt.Sprintf("%s(%s, %s)", infixFuncs[exp.Operator], g.expression(exp.Left), g.expression(exp.Right))
+	case *ast.IfExpression:
+		var out strings.Builder
+		g.inFunc++
+		out.WriteString("func() rt.Value {\n")
+		g.ifStatement(&out, exp, true)
+		out.WriteString("\n")
+		if !exhaustive(exp) {
+			out.WriteString("return rt.Null\n")
+		}
+		out.WriteString("}()")
+		g.inFunc--
+		return out.String()
+	case *ast.FunctionLiteral:
+		return g.function(exp)
+	case *ast.CallExpression:
+		args := []string{g.expression(exp.Function)}
+		for _, arg := range exp.Arguments {
+			args = append(args, g.expression(arg))
+		}
+		return "rt.Call(" + strings.Join(args, ", ") + ")"
+	}
+	panic(fmt.Sprintf("gogen: unexpected expression %T", exp))
+}
+
+// constant returns the name of the package-level value of an integer
+// literal.
+func (g *generator) constant(lit *ast.IntegerLiteral) string {
+	value := strconv.FormatInt(lit.Value, 10)
+	if lit.Big != nil {
+		value = lit.Big.String()
+	}
+	if name, ok := g.constants[value]; ok {
+		return name
+	}
+	name := "int" + strings.Replace(value, "-", "Neg", 1)
+	for g.taken[name] {
+		name += "_"
+	}
+	g.taken[name] = true
+	g.constants[value] = name
+	if lit.Big != nil {
+		g.constantList = append(g.constantList, fmt.Sprintf("%s = rt.BigInt(%q)", name, value))
+	} else {
+		g.constantList = append(g.constantList, fmt.Sprintf("%s = rt.Int(%s)", name, value))
+	}
+	return name
+}
+
+// identifier returns a reference to the variables ident may refer to. A
+// single variable bound whenever ident is evaluated is read directly, and
+// otherwise the runtime evaluates to the first variable bound.
+func (g *generator) identifier(ident *ast.Identifier) string {
+	ref := g.env.Refs[ident]
+	if len(ref.Vars) == 0 {
+		return fmt.Sprintf("rt.NotFound(%q)", ident.Value)
+	}
+	names := make([]string, len(ref.Vars))
+	for i, v := range ref.Vars {
+		f := g.fns[v.Fn.Node]
+		names[i] = f.names[v]
+		f.read[names[i]] = true
+	}
+	if len(names) == 1 && ref.Bound {
+		return names[0]
+	}
+	return fmt.Sprintf("rt.Get(%q, %s)", ident.Value, strings.Join(names, ", "))
+}
+
+// function returns a function literal as an *rt.Func.
+func (g *generator) function(fl *ast.FunctionLiteral) string {
+	params := make([]string, len(fl.Parameters))
+	for i, p := range fl.Parameters {
+		params[i] = p.String()
+	}
+	source := "fn(" + strings.Join(params, ", ") + ") {\n" + fl.Body.String() + "\n}"
+
+	inFunc := g.inFunc
+	g.inFunc = 0
+	body := g.body(fl, fl.Body.Statements)
+	g.inFunc = inFunc
+	return fmt.Sprintf("&rt.Func{Arity: %d, Source: %q, Fn: func(args ...rt.Value) %s}", len(fl.Parameters), source, body)
+}
@@ -0,0 +1,139 @@
+package gogen
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/corpus"
+	"github.com/oohira/monkey/evaluator"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/object"
+	"github.com/oohira/monkey/parser"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+// TestGolden compares the code generated from the programs
+// ../testdata/*.mk shared by the translators with the golden files
+// testdata/*.go.golden.
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob("../testdata/*.mk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Generate(parse(t, string(src)), Options{Source: filepath.Base(file)})
+		if err != nil {
+			t.Fatalf("%s: %s", file, err)
+		}
+
+		golden := filepath.Join("testdata", strings.TrimSuffix(filepath.Base(file), ".mk")+".go.golden")
+		if *update {
+			if err := os.WriteFile(golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s differs from the generated code. got=\n%s", golden, got)
+		}
+	}
+}
+
+// TestRun builds the generated programs and compares their output with the
+// result of the evaluator.
+func TestRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("building programs in short mode")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go is not installed")
+	}
+
+	// names reserved in the generated code, besides the corpus
+	inputs := []string{
+		"let f = fn(func, x, unused) { let x = func; x }; f(3, 1, 2)",
+		"let _ = 1; let int = 2; let nil = fn(rt) { rt }; _ + nil(int)",
+	}
+	inputs = append(inputs, corpus.Programs()...)
+	files, _ := filepath.Glob("../testdata/*.mk")
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, string(src))
+	}
+
+	// build all programs at once in a directory of the package, so that
+	// they import the runtime of this tree
+	dir, err := os.MkdirTemp("testdata", "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	want := make([]string, len(inputs))
+	for i, input := range inputs {
+		program := parse(t, input)
+		switch result := evaluator.Eval(program, object.NewEnvironment()).(type) {
+		case *object.Error:
+			want[i] = "runtime error: " + result.Message + "\n"
+		case *object.Null:
+		default:
+			want[i] = result.Inspect() + "\n"
+		}
+
+		src, err := Generate(program, Options{})
+		if err != nil {
+			t.Fatalf("%q: %s", input, err)
+		}
+		pkg := filepath.Join(dir, fmt.Sprintf("p%d", i))
+		if err := os.Mkdir(pkg, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(pkg, "main.go"), src, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bin, err := filepath.Abs(filepath.Join(dir, "bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	build := exec.Command(goTool, "build", "-o", bin+string(filepath.Separator), "./"+filepath.ToSlash(dir)+"/...")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("go build failed: %s\n%s", err, out)
+	}
+
+	for i, input := range inputs {
+		out, _ := exec.Command(filepath.Join(bin, fmt.Sprintf("p%d", i))).CombinedOutput()
+		if string(out) != want[i] {
+			t.Errorf("output of %q wrong. want=%q, got=%q", input, want[i], out)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// Package rt is the runtime of Go code generated from Monkey programs by
+// the gogen package.
+//
+// Values are the objects of the interpreter, so that the generated code
+// shares the semantics and the error messages of the interpreter. A runtime
+// error panics with *Error, which Run recovers.
+package rt
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/oohira/monkey/object"
+)
+
+// Value represents a Monkey value.
+type Value = object.Object
+
+// The only instances of booleans and null
+var (
+	True  Value = object.TrueValue
+	False Value = object.FalseValue
+	Null  Value = object.NullValue
+)
+
+// Int returns an integer.
+func Int(v int64) Value {
+	return object.NewInteger(v)
+}
+
+// BigInt returns an integer of a decimal string.
+func BigInt(s string) Value {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("rt: invalid integer " + s)
+	}
+	return object.NewBigInteger(v)
+}
+
+// Func represents a function. Source is the text of the function literal
+// printed by Inspect.
+type Func struct {
+	Arity  int
+	Source string
+	Fn     func(args ...Value) Value
+}
+
+// Type returns the type of the function.
+func (f *Func) Type() object.Type {
+	return object.FUNCTION
+}
+
+// Inspect returns the source of the function.
+func (f *Func) Inspect() string {
+	return f.Source
+}
+
+// Error represents a runtime error.
+type Error struct {
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func fail(format string, a ...interface{}) Value {
+	panic(&Error{Message: fmt.Sprintf(format, a...)})
+}
+
+// Truthy reports whether v is neither false nor null.
+func Truthy(v Value) bool {
+	return object.IsTruthy(v)
+}
+
+func prefix(operator string, right Value) Value {
+	result, err := object.Prefix(operator, right)
+	if err != nil {
+		fail("%s", err)
+	}
+	return result
+}
+
+func infix(operator string, left, right Value) Value {
+	result, err := object.Infix(operator, left, right)
+	if err != nil {
+		fail("%s", err)
+	}
+	return result
+}
+
+// Neg returns -v.
+func Neg(v Value) Value { return prefix("-", v) }
+
+// Not returns !v.
+func Not(v Value) Value { return object.NativeBool(!object.IsTruthy(v)) }
+
+// Add returns a + b.
+func Add(a, b Value) Value { return infix("+", a, b) }
+
+// Sub returns a - b.
+func Sub(a, b Value) Value { return infix("-", a, b) }
+
+// Mul returns a * b.
+func Mul(a, b Value) Value { return infix("*", a, b) }
+
+// Div returns a / b.
+func Div(a, b Value) Value { return infix("/", a, b) }
+
+// Lt returns a < b.
+func Lt(a, b Value) Value { return infix("<", a, b) }
+
+// Gt returns a > b.
+func Gt(a, b Value) Value { return infix(">", a, b) }
+
+// Eq returns a == b.
+func Eq(a, b Value) Value { return infix("==", a, b) }
+
+// NotEq returns a != b.
+func NotEq(a, b Value) Value { return infix("!=", a, b) }
+
+// Call calls the function f with args.
+func Call(f Value, args ...Value) Value {
+	fn, ok := f.(*Func)
+	if !ok {
+		return fail("not a function: %s", f.Type())
+	}
+	if len(args) != fn.Arity {
+		return fail("wrong number of arguments: want=%d, got=%d", fn.Arity, len(args))
+	}
+	return fn.Fn(args...)
+}
+
+// Get returns the value of the first of the variables of name which is
+// bound, and fails if none is.
+func Get(name string, vs ...Value) Value {
+	for _, v := range vs {
+		if v != nil {
+			return v
+		}
+	}
+	return fail("identifier not found: %s", name)
+}
+
+// NotFound fails for a name which is not declared.
+func NotFound(name string) Value {
+	return fail("identifier not found: %s", name)
+}
+
+// returnValue carries the value of a return statement evaluated inside an
+// expression to the enclosing function.
+type returnValue struct {
+	value Value
+}
+
+// Return returns v from the enclosing function, which defers Catch.
+func Return(v Value) Value {
+	panic(&returnValue{v})
+}
+
+// Catch sets the result of a function to the value passed to Return.
+// It must be deferred directly.
+func Catch(result *Value) {
+	if r := recover(); r != nil {
+		ret, ok := r.(*returnValue)
+		if !ok {
+			panic(r)
+		}
+		*result = ret.value
+	}
+}
+
+// Run runs a program and returns its value or the runtime error.
+func Run(program func() Value) (result Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			result, err = nil, e
+		}
+	}()
+	return program(), nil
+}
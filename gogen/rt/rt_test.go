@@ -0,0 +1,50 @@
+package rt
+
+import (
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	double := &Func{Arity: 1, Source: "fn(x) {\n(x * 2)\n}", Fn: func(args ...Value) Value {
+		return Mul(args[0], Int(2))
+	}}
+	early := &Func{Arity: 0, Fn: func(args ...Value) (result Value) {
+		defer Catch(&result)
+		return Add(Int(1), Return(Int(5)))
+	}}
+
+	tests := []struct {
+		program  func() Value
+		expected string
+		err      string
+	}{
+		{func() Value { return Call(double, BigInt("9223372036854775807")) }, "18446744073709551614", ""},
+		{func() Value { return Call(early) }, "5", ""},
+		{func() Value { return double }, "fn(x) {\n(x * 2)\n}", ""},
+		{func() Value { return Not(Null) }, "true", ""},
+		{func() Value { return Call(double) }, "", "wrong number of arguments: want=1, got=0"},
+		{func() Value { return Call(Int(1)) }, "", "not a function: INTEGER"},
+		{func() Value { return Add(True, Int(1)) }, "", "type mismatch: BOOLEAN + INTEGER"},
+		{func() Value { return Div(Int(1), Int(0)) }, "", "division by zero"},
+		{func() Value { return Get("x", nil, Int(2), Int(1)) }, "2", ""},
+		{func() Value { return Get("x", nil, nil) }, "", "identifier not found: x"},
+		{func() Value { return Call(early, NotFound("y")) }, "", "identifier not found: y"},
+	}
+
+	for i, tt := range tests {
+		result, err := Run(tt.program)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("tests[%d]: wrong error. want=%q, got=%v", i, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("tests[%d]: unexpected error: %s", i, err)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("tests[%d]: wrong result. want=%q, got=%q", i, tt.expected, result.Inspect())
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package highlight
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/token"
+)
+
+// Class represents a syntactic class of a piece of source text.
+type Class string
+
+// Class constants. Plain is used for whitespace.
+// String is reserved for string literals, which are not in the language yet.
+const (
+	Plain      Class = "plain"
+	Keyword    Class = "keyword"
+	Identifier Class = "identifier"
+	Number     Class = "number"
+	String     Class = "string"
+	Operator   Class = "operator"
+	Comment    Class = "comment"
+	Illegal    Class = "illegal"
+)
+
+// Segment represents a piece of source text and its class.
+type Segment struct {
+	Class Class
+	Text  string
+}
+
+// Segments splits the program read from r into classified segments.
+// Concatenating the Text of all segments reproduces the program.
+func Segments(r io.Reader) ([]Segment, error) {
+	l := lexer.NewReader(r)
+	l.PreserveTrivia()
+
+	var segments []Segment
+	for {
+		tok := l.NextToken()
+		segments = appendTrivia(segments, tok.Leading)
+		if tok.Type == token.EOF {
+			break
+		}
+		segments = append(segments, Segment{Class: Classify(tok), Text: tok.Literal})
+		segments = appendTrivia(segments, tok.Trailing)
+	}
+	return segments, l.Err()
+}
+
+// Classify returns the class of a token.
+func Classify(tok token.Token) Class {
+	switch tok.Type {
+	case token.IDENT:
+		return Identifier
+	case token.INT:
+		return Number
+	case token.ILLEGAL:
+		return Illegal
+	case token.FUNCTION, token.LET, token.TRUE, token.FALSE, token.IF, token.ELSE, token.RETURN:
+		return Keyword
+	}
+	return Operator
+}
+
+// appendTrivia splits trivia into whitespace and comments.
+func appendTrivia(segments []Segment, trivia string) []Segment {
+	for trivia != "" {
+		i := strings.Index(trivia, "//")
+		if i < 0 {
+			return append(segments, Segment{Class: Plain, Text: trivia})
+		}
+		if i > 0 {
+			segments = append(segments, Segment{Class: Plain, Text: trivia[:i]})
+		}
+		trivia = trivia[i:]
+		end := strings.IndexAny(trivia, "\r\n")
+		if end < 0 {
+			end = len(trivia)
+		}
+		segments = append(segments, Segment{Class: Comment, Text: trivia[:end]})
+		trivia = trivia[end:]
+	}
+	return segments
+}
+
+// ANSI escape sequences of each class
+var ansiColors = map[Class]string{
+	Keyword:  "\x1b[1;35m",
+	Number:   "\x1b[33m",
+	String:   "\x1b[32m",
+	Operator: "\x1b[36m",
+	Comment:  "\x1b[90m",
+	Illegal:  "\x1b[4;31m",
+}
+
+const ansiReset = "\x1b[0m"
+
+// WriteANSI writes segments colored with ANSI escape sequences.
+func WriteANSI(w io.Writer, segments []Segment) error {
+	for _, seg := range segments {
+		var err error
+		if color, ok := ansiColors[seg.Class]; ok {
+			_, err = io.WriteString(w, color+seg.Text+ansiReset)
+		} else {
+			_, err = io.WriteString(w, seg.Text)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CSS is a default style sheet for the output of WriteHTML.
+const CSS = `pre.monkey { background: #fafafa; color: #24292e; }
+pre.monkey .mk-keyword { color: #a626a4; font-weight: bold; }
+pre.monkey .mk-identifier { color: #24292e; }
+pre.monkey .mk-number { color: #986801; }
+pre.monkey .mk-string { color: #50a14f; }
+pre.monkey .mk-operator { color: #0184bc; }
+pre.monkey .mk-comment { color: #a0a1a7; font-style: italic; }
+pre.monkey .mk-illegal { color: #e45649; text-decoration: underline wavy; }
+`
+
+// WriteHTML writes segments as a pre element with spans whose CSS class is
+// "mk-" followed by the class name. Whitespace is not wrapped in spans.
+func WriteHTML(w io.Writer, segments []Segment) error {
+	if _, err := io.WriteString(w, `<pre class="monkey">`); err != nil {
+		return err
+	}
+	for _, seg := range segments {
+		var err error
+		if seg.Class == Plain {
+			_, err = io.WriteString(w, html.EscapeString(seg.Text))
+		} else {
+			_, err = fmt.Fprintf(w, `<span class="mk-%s">%s</span>`, seg.Class, html.EscapeString(seg.Text))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "</pre>\n")
+	return err
+}
@@ -0,0 +1,68 @@
+package highlight
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSegments(t *testing.T) {
+	input := "let x = 5; // five\nif (x < 10) { @ }"
+
+	segments, err := Segments(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []Segment{
+		{Keyword, "let"}, {Plain, " "}, {Identifier, "x"}, {Plain, " "},
+		{Operator, "="}, {Plain, " "}, {Number, "5"}, {Operator, ";"},
+		{Plain, " "}, {Comment, "// five"}, {Plain, "\n"},
+		{Keyword, "if"}, {Plain, " "}, {Operator, "("}, {Identifier, "x"},
+		{Plain, " "}, {Operator, "<"}, {Plain, " "}, {Number, "10"},
+		{Operator, ")"}, {Plain, " "}, {Operator, "{"}, {Plain, " "},
+		{Illegal, "@"}, {Plain, " "}, {Operator, "}"},
+	}
+	if !reflect.DeepEqual(segments, expected) {
+		t.Errorf("segments wrong.\nwant=%v\ngot =%v", expected, segments)
+	}
+
+	var text strings.Builder
+	for _, seg := range segments {
+		text.WriteString(seg.Text)
+	}
+	if text.String() != input {
+		t.Errorf("segments do not reproduce input. got=%q", text.String())
+	}
+}
+
+func TestWriteANSI(t *testing.T) {
+	segments := []Segment{{Keyword, "fn"}, {Operator, "("}, {Identifier, "a"}, {Plain, " "}, {Illegal, "#"}}
+
+	var out bytes.Buffer
+	if err := WriteANSI(&out, segments); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "\x1b[1;35mfn\x1b[0m\x1b[36m(\x1b[0ma \x1b[4;31m#\x1b[0m"
+	if out.String() != expected {
+		t.Errorf("output wrong. want=%q, got=%q", expected, out.String())
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	segments, err := Segments(strings.NewReader("1 < 2 // <b>&\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := WriteHTML(&out, segments); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `<pre class="monkey"><span class="mk-number">1</span> ` +
+		`<span class="mk-operator">&lt;</span> <span class="mk-number">2</span> ` +
+		`<span class="mk-comment">// &lt;b&gt;&amp;</span>` + "\n</pre>\n"
+	if out.String() != expected {
+		t.Errorf("output wrong.\nwant=%q\ngot =%q", expected, out.String())
+	}
+}
@@ -0,0 +1,691 @@
+// Package jsgen translates Monkey programs into JavaScript (ES2020).
+//
+// The generated code consists of the runtime in runtime.js and a function
+// main evaluating the program. Integers are BigInts, so that they are
+// arbitrary-precision as in Monkey. Operations are checked by the runtime
+// and fail with the messages of the interpreter, unless type inference
+// proves that the operands are integers or booleans, in which case plain
+// JavaScript operators are used.
+//
+// Every name declared in a function becomes a variable of the JavaScript
+// function, since a block shares the environment of its function in Monkey.
+// An identifier which may be evaluated before its variable is bound reads
+// the variables of the enclosing functions declaring the name as well, and
+// evaluates to the first one bound as in the interpreter. The JavaScript
+// code differs from the interpreter in that a function is printed as "fn".
+package jsgen
+
+import (
+	_ "embed"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/env"
+	"github.com/oohira/monkey/token"
+	"github.com/oohira/monkey/types"
+)
+
+//go:embed runtime.js
+var runtime string
+
+// Runtime returns the source of the runtime included in the generated code.
+func Runtime() string {
+	return runtime
+}
+
+// Options controls the generated code.
+type Options struct {
+	Source string // name of the Monkey file in the header and the source map
+	Module bool   // export main as an ES module instead of running it
+}
+
+// Result holds the generated code and its source map.
+type Result struct {
+	Code      string
+	SourceMap *SourceMap
+}
+
+// Generate returns JavaScript code evaluating program.
+func Generate(program *ast.Program, options Options) *Result {
+	g := &generator{
+		env:   env.Analyze(program),
+		fns:   map[ast.Node]*function{},
+		names: map[string]int{},
+	}
+	if info := types.Check(program); len(info.Errors) == 0 {
+		g.types = info
+	}
+	for _, e := range g.env.Order {
+		f := &function{Function: e, names: map[*env.Variable]string{}}
+		f.rename()
+		g.fns[e.Node] = f
+	}
+	g.scan(g.fns[program], program.Statements, false)
+
+	if options.Source != "" {
+		g.write("// Code generated by monkey js from " + options.Source + ". DO NOT EDIT.\n")
+	} else {
+		g.write("// Code generated by monkey js. DO NOT EDIT.\n")
+	}
+	g.write("\"use strict\";\n\n")
+	g.write(runtime)
+	g.write("\n")
+	if options.Module {
+		g.write("export default ")
+	}
+	g.write("function main() ")
+	g.body(program, program.Statements)
+	if options.Module {
+		g.write("\n")
+	} else {
+		g.write("\n\n$.run(main);\n")
+	}
+
+	return &Result{
+		Code:      g.out.String(),
+		SourceMap: newSourceMap(options.Source, g.nameList, g.mappings),
+	}
+}
+
+// function represents the program or a function literal.
+type function struct {
+	*env.Function
+	names   map[*env.Variable]string // JavaScript names of the variables
+	params  []string                 // JavaScript names of the parameters
+	locals  []string                 // JavaScript names declared by let statements
+	catches bool                     // a return statement is evaluated in an expression
+}
+
+// rename gives JavaScript names to the variables of f. A name declared also
+// in an outer function is suffixed with the depth of f, so that the
+// variables of the outer functions remain accessible in f. A parameter
+// hidden by a later one of the same name is named after its position.
+func (f *function) rename() {
+	for _, v := range f.Vars {
+		js := v.Name
+		if reserved[js] {
+			js += "$"
+		}
+		for o := f.Outer; o != nil; o = o.Outer {
+			if o.Lookup(v.Name) != nil {
+				js = fmt.Sprintf("%s$%d", v.Name, f.Depth)
+				break
+			}
+		}
+		f.names[v] = js
+		if !v.Param {
+			f.locals = append(f.locals, js)
+		}
+	}
+	for i, v := range f.Params {
+		if v != nil {
+			f.params = append(f.params, f.names[v])
+		} else {
+			f.params = append(f.params, fmt.Sprintf("$%d", i))
+		}
+	}
+}
+
+// reserved words and globals of JavaScript which are valid Monkey names
+var reserved = map[string]bool{}
+
+func init() {
+	for _, name := range strings.Fields(`arguments await break case catch class
+		const continue debugger default delete do else enum eval export extends
+		false finally for function if implements import in instanceof interface
+		let new null package private protected public return static super switch
+		this throw true try typeof undefined var void while with yield
+		Infinity NaN`) {
+		reserved[name] = true
+	}
+}
+
+// precedences of JavaScript expressions
+const (
+	precComma = iota + 1
+	precAssign
+	precCondition
+	precEquality
+	precRelation
+	precAdditive
+	precMultiplicative
+	precUnary
+	precCall
+)
+
+var infixOperators = map[string]struct {
+	js      string
+	runtime string
+	prec    int
+}{
+	"+":  {"+", "add", precAdditive},
+	"-":  {"-", "sub", precAdditive},
+	"*":  {"*", "mul", precMultiplicative},
+	"/":  {"/", "div", precMultiplicative},
+	"<":  {"<", "lt", precRelation},
+	">":  {">", "gt", precRelation},
+	"==": {"===", "", precEquality},
+	"!=": {"!==", "", precEquality},
+}
+
+type generator struct {
+	env   *env.Info
+	types *types.Info // nil if the program is not well-typed
+	fns   map[ast.Node]*function
+	fn    *function // function being generated
+
+	out          strings.Builder
+	line, column int // 0-based position of the next byte
+	indent       int
+
+	mappings []mapping
+	names    map[string]int
+	nameList []string
+}
+
+// scan finds the return statements of f in stmts evaluated in an
+// expression. inExpression reports whether stmts are generated as an
+// expression.
+func (g *generator) scan(f *function, stmts []ast.Statement, inExpression bool) {
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *ast.LetStatement:
+			g.scanExpression(f, stmt.Value)
+		case *ast.ReturnStatement:
+			if inExpression {
+				f.catches = true
+			}
+			g.scanExpression(f, stmt.ReturnValue)
+		case *ast.ExpressionStatement:
+			if ie, ok := stmt.Expression.(*ast.IfExpression); ok && !inExpression {
+				g.scanExpression(f, ie.Condition)
+				g.scan(f, ie.Consequence.Statements, false)
+				if ie.Alternative != nil {
+					g.scan(f, ie.Alternative.Statements, false)
+				}
+				continue
+			}
+			g.scanExpression(f, stmt.Expression)
+		case *ast.BlockStatement:
+			g.scan(f, stmt.Statements, inExpression)
+		}
+	}
+}
+
+func (g *generator) scanExpression(f *function, exp ast.Expression) {
+	switch exp := exp.(type) {
+	case *ast.PrefixExpression:
+		g.scanExpression(f, exp.Right)
+	case *ast.InfixExpression:
+		g.scanExpression(f, exp.Left)
+		g.scanExpression(f, exp.Right)
+	case *ast.IfExpression:
+		g.scanExpression(f, exp.Condition)
+		g.scan(f, exp.Consequence.Statements, true)
+		if exp.Alternative != nil {
+			g.scan(f, exp.Alternative.Statements, true)
+		}
+	case *ast.FunctionLiteral:
+		g.scan(g.fns[exp], exp.Body.Statements, false)
+	case *ast.CallExpression:
+		g.scanExpression(f, exp.Function)
+		for _, arg := range exp.Arguments {
+			g.scanExpression(f, arg)
+		}
+	}
+}
+
+// write writes s, which is indented by the caller.
+func (g *generator) write(s string) {
+	g.out.WriteString(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		g.line += strings.Count(s, "\n")
+		g.column = len(s) - i - 1
+	} else {
+		g.column += len(s)
+	}
+}
+
+// newline starts an indented line.
+func (g *generator) newline() {
+	g.write("\n" + strings.Repeat("  ", g.indent))
+}
+
+// mark maps the current position to pos. name is the Monkey name at pos,
+// or empty.
+func (g *generator) mark(pos token.Position, name string) {
+	if pos.Line == 0 {
+		return
+	}
+	index := -1
+	if name != "" {
+		i, ok := g.names[name]
+		if !ok {
+			i = len(g.nameList)
+			g.names[name] = i
+			g.nameList = append(g.nameList, name)
+		}
+		index = i
+	}
+	g.mappings = append(g.mappings, mapping{line: g.line, column: g.column, pos: pos, name: index})
+}
+
+// body writes the body of the function node in braces.
+func (g *generator) body(node ast.Node, stmts []ast.Statement) {
+	outer := g.fn
+	g.fn = g.fns[node]
+	defer func() { g.fn = outer }()
+
+	g.write("{")
+	g.indent++
+	if len(g.fn.locals) > 0 {
+		g.newline()
+		g.write("let " + strings.Join(g.fn.locals, ", ") + ";")
+	}
+	if g.fn.catches {
+		g.newline()
+		g.write("try {")
+		g.indent++
+	}
+	g.statements(stmts, true)
+	if g.fn.catches {
+		g.indent--
+		g.newline()
+		g.write("} catch (e) {")
+		g.indent++
+		g.newline()
+		g.write("return $.returned(e);")
+		g.indent--
+		g.newline()
+		g.write("}")
+	}
+	g.indent--
+	g.newline()
+	g.write("}")
+}
+
+// statements writes stmts as statements. tail reports whether the value
+// of stmts is returned.
+func (g *generator) statements(stmts []ast.Statement, tail bool) {
+	for i, stmt := range stmts {
+		g.statement(stmt, tail && i == len(stmts)-1)
+	}
+	if !tail {
+		return
+	}
+	if len(stmts) > 0 {
+		switch stmt := stmts[len(stmts)-1].(type) {
+		case *ast.ReturnStatement:
+			return
+		case *ast.ExpressionStatement:
+			if ie, ok := stmt.Expression.(*ast.IfExpression); !ok || exhaustive(ie) {
+				return
+			}
+		}
+	}
+	g.newline()
+	g.write("return null;")
+}
+
+// exhaustive reports whether an if statement generated by ifStatement has
+// a branch for every condition.
+func exhaustive(ie *ast.IfExpression) bool {
+	if ie.Alternative == nil {
+		return false
+	}
+	if elseIf := elseIf(ie); elseIf != nil {
+		return exhaustive(elseIf)
+	}
+	return true
+}
+
+// elseIf returns the if expression which is the only statement of the
+// alternative of ie, or nil.
+func elseIf(ie *ast.IfExpression) *ast.IfExpression {
+	if stmts := ie.Alternative.Statements; len(stmts) == 1 {
+		if es, ok := stmts[0].(*ast.ExpressionStatement); ok {
+			if elseIf, ok := es.Expression.(*ast.IfExpression); ok {
+				return elseIf
+			}
+		}
+	}
+	return nil
+}
+
+func (g *generator) statement(stmt ast.Statement, tail bool) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		g.newline()
+		g.let(stmt)
+		g.write(";")
+	case *ast.ReturnStatement:
+		g.newline()
+		g.mark(stmt.Token.Pos, "")
+		g.write("return ")
+		g.expression(stmt.ReturnValue, precComma)
+		g.write(";")
+	case *ast.ExpressionStatement:
+		if ie, ok := stmt.Expression.(*ast.IfExpression); ok {
+			g.newline()
+			g.ifStatement(ie, tail)
+			return
+		}
+		g.newline()
+		if tail {
+			g.write("return ")
+		}
+		g.expression(stmt.Expression, precComma)
+		g.write(";")
+	case *ast.BlockStatement:
+		g.statements(stmt.Statements, tail)
+	}
+}
+
+func (g *generator) let(let *ast.LetStatement) {
+	g.mark(let.Token.Pos, "")
+	g.identifierName(let.Name, g.fn.names[g.fn.Lookup(let.Name.Value)])
+	g.write(" = ")
+	g.expression(let.Value, precAssign)
+}
+
+// ifStatement writes an if expression as an if statement. If tail is true,
+// each branch returns its value.
+func (g *generator) ifStatement(ie *ast.IfExpression, tail bool) {
+	g.mark(ie.Token.Pos, "")
+	g.write("if (")
+	g.condition(ie.Condition, precComma)
+	g.write(") {")
+	g.indent++
+	g.statements(ie.Consequence.Statements, tail)
+	g.indent--
+	g.newline()
+	g.write("}")
+	if ie.Alternative == nil {
+		return
+	}
+
+	g.write(" else ")
+	if elseIf := elseIf(ie); elseIf != nil {
+		g.ifStatement(elseIf, tail)
+		return
+	}
+	g.write("{")
+	g.indent++
+	g.statements(ie.Alternative.Statements, tail)
+	g.indent--
+	g.newline()
+	g.write("}")
+}
+
+// condition writes the truthiness of exp.
+func (g *generator) condition(exp ast.Expression, prec int) {
+	if g.isBool(exp) {
+		g.expression(exp, prec)
+		return
+	}
+	g.write("$.truthy(")
+	g.expression(exp, precAssign)
+	g.write(")")
+}
+
+// expression writes exp, in parentheses if its precedence is lower than
+// prec.
+func (g *generator) expression(exp ast.Expression, prec int) {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		s := strconv.FormatInt(exp.Value, 10)
+		if exp.Big != nil {
+			s = exp.Big.String()
+		}
+		g.mark(exp.Token.Pos, "")
+		g.parenthesize(strings.HasPrefix(s, "-") && prec > precUnary, func() {
+			g.write(s + "n")
+		})
+	case *ast.Boolean:
+		g.mark(exp.Token.Pos, "")
+		g.write(exp.Token.Literal)
+	case *ast.Identifier:
+		g.identifier(exp)
+	case *ast.PrefixExpression:
+		g.prefix(exp, prec)
+	case *ast.InfixExpression:
+		g.infix(exp, prec)
+	case *ast.IfExpression:
+		g.parenthesize(prec > precCondition, func() {
+			g.mark(exp.Token.Pos, "")
+			g.condition(exp.Condition, precCondition+1)
+			g.write(" ? ")
+			g.block(exp.Consequence)
+			g.write(" : ")
+			g.block(exp.Alternative)
+		})
+	case *ast.FunctionLiteral:
+		g.parenthesize(prec > precAssign, func() {
+			g.function(exp)
+		})
+	case *ast.CallExpression:
+		g.call(exp)
+	}
+}
+
+func (g *generator) parenthesize(paren bool, f func()) {
+	if paren {
+		g.write("(")
+	}
+	f()
+	if paren {
+		g.write(")")
+	}
+}
+
+// identifier writes a reference to the variables ident may refer to. A
+// single variable bound whenever ident is evaluated is read directly, and
+// otherwise the runtime evaluates to the first variable bound.
+func (g *generator) identifier(ident *ast.Identifier) {
+	ref := g.env.Refs[ident]
+	if len(ref.Vars) == 0 {
+		g.mark(ident.Token.Pos, ident.Value)
+		g.write(fmt.Sprintf("$.notFound(%q)", ident.Value))
+		return
+	}
+	if len(ref.Vars) == 1 && ref.Bound {
+		g.identifierName(ident, g.fns[ref.Vars[0].Fn.Node].names[ref.Vars[0]])
+		return
+	}
+	g.write(fmt.Sprintf("$.get(%q", ident.Value))
+	for _, v := range ref.Vars {
+		g.write(", ")
+		g.identifierName(ident, g.fns[v.Fn.Node].names[v])
+	}
+	g.write(")")
+}
+
+func (g *generator) identifierName(ident *ast.Identifier, name string) {
+	g.mark(ident.Token.Pos, ident.Value)
+	g.write(name)
+}
+
+func (g *generator) prefix(exp *ast.PrefixExpression, prec int) {
+	g.mark(exp.Token.Pos, "")
+	switch {
+	case exp.Operator == "-" && g.isInt(exp.Right):
+		g.parenthesize(prec > precUnary, func() {
+			g.write("-")
+			g.expression(exp.Right, precUnary+1)
+		})
+	case exp.Operator == "-":
+		g.write("$.neg(")
+		g.expression(exp.Right, precAssign)
+		g.write(")")
+	case g.isBool(exp.Right):
+		g.parenthesize(prec > precUnary, func() {
+			g.write("!")
+			g.expression(exp.Right, precUnary+1)
+		})
+	default:
+		g.parenthesize(prec > precUnary, func() {
+			g.write("!")
+			g.condition(exp.Right, precUnary+1)
+		})
+	}
+}
+
+func (g *generator) infix(exp *ast.InfixExpression, prec int) {
+	op := infixOperators[exp.Operator]
+	if op.runtime != "" && !g.native(exp) {
+		g.write("$." + op.runtime + "(")
+		g.expression(exp.Left, precAssign)
+		g.write(", ")
+		g.mark(exp.Token.Pos, "")
+		g.expression(exp.Right, precAssign)
+		g.write(")")
+		return
+	}
+	g.parenthesize(prec > op.prec, func() {
+		g.expression(exp.Left, op.prec)
+		g.write(" ")
+		g.mark(exp.Token.Pos, "")
+		g.write(op.js + " ")
+		g.expression(exp.Right, op.prec+1)
+	})
+}
+
+// native reports whether an arithmetic or relational operator can be
+// applied to its operands in JavaScript without checks.
+func (g *generator) native(exp *ast.InfixExpression) bool {
+	if !g.isInt(exp.Left) || !g.isInt(exp.Right) {
+		return false
+	}
+	if exp.Operator != "/" {
+		return true
+	}
+	lit, ok := exp.Right.(*ast.IntegerLiteral)
+	return ok && (lit.Big != nil || lit.Value != 0)
+}
+
+func (g *generator) call(exp *ast.CallExpression) {
+	if fn, ok := g.typeOf(exp.Function).(*types.Func); ok && len(fn.Params) == len(exp.Arguments) {
+		g.expression(exp.Function, precCall)
+		g.mark(exp.Token.Pos, "")
+		g.write("(")
+	} else {
+		g.mark(exp.Token.Pos, "")
+		g.write("$.call(")
+		g.expression(exp.Function, precAssign)
+		if len(exp.Arguments) > 0 {
+			g.write(", ")
+		}
+	}
+	for i, arg := range exp.Arguments {
+		if i > 0 {
+			g.write(", ")
+		}
+		g.expression(arg, precAssign)
+	}
+	g.write(")")
+}
+
+// function writes a function literal as an arrow function.
+func (g *generator) function(fl *ast.FunctionLiteral) {
+	f := g.fns[fl]
+	g.mark(fl.Token.Pos, "")
+	g.write("(")
+	for i, param := range fl.Parameters {
+		if i > 0 {
+			g.write(", ")
+		}
+		g.identifierName(param, f.params[i])
+	}
+	g.write(") => ")
+
+	// fn(x) { x + 1 } => (x) => x + 1n
+	if stmts := fl.Body.Statements; len(stmts) == 1 && len(f.locals) == 0 && !f.catches {
+		if es, ok := stmts[0].(*ast.ExpressionStatement); ok {
+			if _, ok := es.Expression.(*ast.IfExpression); !ok {
+				outer := g.fn
+				g.fn = f
+				g.expression(es.Expression, precAssign)
+				g.fn = outer
+				return
+			}
+		}
+	}
+	g.body(fl, fl.Body.Statements)
+}
+
+// block writes the value of a block as an expression, where let statements
+// are assignments and return statements throw their values.
+func (g *generator) block(block *ast.BlockStatement) {
+	if block == nil || len(block.Statements) == 0 {
+		g.write("null")
+		return
+	}
+	stmts := block.Statements
+	if es, ok := stmts[0].(*ast.ExpressionStatement); ok && len(stmts) == 1 {
+		g.expression(es.Expression, precAssign)
+		return
+	}
+
+	_, let := stmts[len(stmts)-1].(*ast.LetStatement)
+	g.parenthesize(len(stmts) > 1 || let, func() {
+		g.sequence(stmts)
+		if let {
+			g.write(", null")
+		}
+	})
+}
+
+// sequence writes stmts as a comma-separated sequence of expressions.
+func (g *generator) sequence(stmts []ast.Statement) {
+	for i, stmt := range stmts {
+		if i > 0 {
+			g.write(", ")
+		}
+		switch stmt := stmt.(type) {
+		case *ast.LetStatement:
+			g.let(stmt)
+		case *ast.ReturnStatement:
+			g.mark(stmt.Token.Pos, "")
+			g.write("$.ret(")
+			g.expression(stmt.ReturnValue, precAssign)
+			g.write(")")
+		case *ast.ExpressionStatement:
+			g.expression(stmt.Expression, precAssign)
+		case *ast.BlockStatement:
+			g.block(stmt)
+		}
+	}
+}
+
+// isInt reports whether exp evaluates to an integer unless it fails.
+func (g *generator) isInt(exp ast.Expression) bool {
+	if _, ok := exp.(*ast.IntegerLiteral); ok {
+		return true
+	}
+	return g.typeOf(exp) == types.Int
+}
+
+// isBool reports whether exp evaluates to a boolean unless it fails.
+func (g *generator) isBool(exp ast.Expression) bool {
+	switch exp := exp.(type) {
+	case *ast.Boolean:
+		return true
+	case *ast.PrefixExpression:
+		return exp.Operator == "!"
+	case *ast.InfixExpression:
+		switch exp.Operator {
+		case "==", "!=", "<", ">":
+			return true
+		}
+	}
+	return g.typeOf(exp) == types.Bool
+}
+
+func (g *generator) typeOf(exp ast.Expression) types.Type {
+	if g.types == nil {
+		return nil
+	}
+	return g.types.TypeOf(exp)
+}
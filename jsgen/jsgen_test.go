@@ -0,0 +1,234 @@
+package jsgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/corpus"
+	"github.com/oohira/monkey/evaluator"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/object"
+	"github.com/oohira/monkey/parser"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+// TestGolden compares the code and the source maps generated from the
+// programs ../testdata/*.mk shared by the translators with the golden files
+// testdata/*.js and testdata/*.js.map.
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob("../testdata/*.mk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := Generate(parse(t, string(src)), Options{Source: filepath.Base(file)})
+		sourceMap, err := json.MarshalIndent(result.SourceMap, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		golden := filepath.Join("testdata", strings.TrimSuffix(filepath.Base(file), ".mk")+".js")
+		compareGolden(t, golden, []byte(result.Code))
+		compareGolden(t, golden+".map", append(sourceMap, '\n'))
+	}
+}
+
+func compareGolden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the generated code. got=\n%s", path, got)
+	}
+}
+
+// TestRun runs the generated code with Node.js and compares the output with
+// the result of the evaluator, where a function is printed as "fn".
+func TestRun(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+
+	// names reserved in the generated code, besides the corpus
+	inputs := []string{
+		"let f = fn(new, this) { new - this }; f(3, 1)",
+		"let f = fn(var, y, var) { fn() { var + y } }; f(1, 2, 3)()",
+	}
+	inputs = append(inputs, corpus.Programs()...)
+	files, _ := filepath.Glob("../testdata/*.mk")
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, string(src))
+	}
+
+	for _, input := range inputs {
+		program := parse(t, input)
+		want := ""
+		switch result := evaluator.Eval(program, object.NewEnvironment()).(type) {
+		case *object.Error:
+			want = "runtime error: " + result.Message + "\n"
+		case *object.Null:
+		case *object.Function:
+			want = "fn\n"
+		default:
+			want = result.Inspect() + "\n"
+		}
+
+		cmd := exec.Command(node)
+		cmd.Stdin = strings.NewReader(Generate(program, Options{}).Code)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Errorf("node failed for %q: %s\n%s", input, err, out)
+			continue
+		}
+		if string(out) != want {
+			t.Errorf("output of %q wrong. want=%q, got=%q", input, want, out)
+		}
+	}
+}
+
+// TestSourceMap checks that the names in the source map are at the
+// identifiers of both the generated code and the program.
+func TestSourceMap(t *testing.T) {
+	input := "let double = fn(x) { x * 2 };\nlet new = double(4);\nnew"
+	result := Generate(parse(t, input), Options{Source: "double.mk"})
+	sm := result.SourceMap
+	if sm.Version != 3 || len(sm.Sources) != 1 || sm.Sources[0] != "double.mk" {
+		t.Fatalf("wrong header of source map: %+v", sm)
+	}
+
+	code := strings.Split(result.Code, "\n")
+	src := strings.Split(input, "\n")
+	named := 0
+	for _, seg := range decodeMappings(t, sm.Mappings) {
+		if seg.name < 0 {
+			continue
+		}
+		named++
+		name := sm.Names[seg.name]
+		if got := code[seg.line][seg.column:]; !strings.HasPrefix(got, name) {
+			t.Errorf("generated code at %d:%d is %q, not %s", seg.line, seg.column, got, name)
+		}
+		if got := src[seg.srcLine][seg.srcColumn:]; !strings.HasPrefix(got, name) {
+			t.Errorf("source at %d:%d is %q, not %s", seg.srcLine, seg.srcColumn, got, name)
+		}
+	}
+	// double, x, x, new, double, new
+	if named != 6 {
+		t.Errorf("wrong number of named mappings. want=6, got=%d", named)
+	}
+}
+
+type segment struct {
+	line, column       int
+	srcLine, srcColumn int
+	name               int
+}
+
+func decodeMappings(t *testing.T, mappings string) []segment {
+	t.Helper()
+	var segments []segment
+	var srcLine, srcColumn, name int
+	for line, group := range strings.Split(mappings, ";") {
+		column := 0
+		if group == "" {
+			continue
+		}
+		for _, s := range strings.Split(group, ",") {
+			fields := decodeVLQ(t, s)
+			if len(fields) != 4 && len(fields) != 5 {
+				t.Fatalf("wrong segment %q", s)
+			}
+			column += fields[0]
+			srcLine += fields[2]
+			srcColumn += fields[3]
+			seg := segment{line, column, srcLine, srcColumn, -1}
+			if len(fields) == 5 {
+				name += fields[4]
+				seg.name = name
+			}
+			segments = append(segments, seg)
+		}
+	}
+	return segments
+}
+
+func decodeVLQ(t *testing.T, s string) []int {
+	t.Helper()
+	var values []int
+	v, shift := 0, 0
+	for _, c := range s {
+		digit := strings.IndexRune(base64Digits, c)
+		if digit < 0 {
+			t.Fatalf("invalid base64 digit %q", c)
+		}
+		v |= (digit & 31) << shift
+		shift += 5
+		if digit&32 != 0 {
+			continue
+		}
+		if v&1 != 0 {
+			values = append(values, -(v >> 1))
+		} else {
+			values = append(values, v>>1)
+		}
+		v, shift = 0, 0
+	}
+	return values
+}
+
+func TestVLQ(t *testing.T) {
+	tests := []struct {
+		n        int
+		expected string
+	}{
+		{0, "A"},
+		{1, "C"},
+		{-1, "D"},
+		{15, "e"},
+		{16, "gB"},
+		{-16, "hB"},
+		{1000, "w+B"},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		writeVLQ(&out, tt.n)
+		if out.String() != tt.expected {
+			t.Errorf("VLQ of %d wrong. want=%q, got=%q", tt.n, tt.expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Runtime support for Monkey. Integers are BigInts, booleans and null are
+// themselves and functions are JavaScript functions. Errors carry the same
+// messages as the Monkey interpreter.
+const $ = (() => {
+  class MonkeyError extends Error {}
+
+  // Return carries the value of a return statement evaluated inside an
+  // expression to the enclosing function.
+  class Return {
+    constructor(value) {
+      this.value = value;
+    }
+  }
+
+  const type = (v) => {
+    switch (typeof v) {
+      case "bigint":
+        return "INTEGER";
+      case "boolean":
+        return "BOOLEAN";
+      case "function":
+        return "FUNCTION";
+    }
+    return "NULL";
+  };
+
+  const fail = (message) => {
+    throw new MonkeyError(message);
+  };
+
+  const integers = (op, a, b) => {
+    if (typeof a === "bigint" && typeof b === "bigint") {
+      return true;
+    }
+    const kind = type(a) === type(b) ? "unknown operator" : "type mismatch";
+    return fail(`${kind}: ${type(a)} ${op} ${type(b)}`);
+  };
+
+  const inspect = (v) => (typeof v === "function" ? "fn" : String(v));
+
+  return {
+    Error: MonkeyError,
+    truthy: (v) => v !== false && v !== null,
+    add: (a, b) => integers("+", a, b) && a + b,
+    sub: (a, b) => integers("-", a, b) && a - b,
+    mul: (a, b) => integers("*", a, b) && a * b,
+    div: (a, b) => integers("/", a, b) && (b === 0n ? fail("division by zero") : a / b),
+    lt: (a, b) => integers("<", a, b) && a < b,
+    gt: (a, b) => integers(">", a, b) && a > b,
+    neg: (v) => (typeof v === "bigint" ? -v : fail(`unknown operator: -${type(v)}`)),
+    call: (f, ...args) => {
+      if (typeof f !== "function") {
+        fail(`not a function: ${type(f)}`);
+      }
+      if (f.length !== args.length) {
+        fail(`wrong number of arguments: want=${f.length}, got=${args.length}`);
+      }
+      return f(...args);
+    },
+    // get returns the first of the variables of name which is bound.
+    get: (name, ...vs) => {
+      for (const v of vs) {
+        if (v !== undefined) {
+          return v;
+        }
+      }
+      return fail(`identifier not found: ${name}`);
+    },
+    notFound: (name) => fail(`identifier not found: ${name}`),
+    ret: (v) => {
+      throw new Return(v);
+    },
+    returned: (e) => {
+      if (e instanceof Return) {
+        return e.value;
+      }
+      throw e;
+    },
+    inspect,
+    run: (main) => {
+      try {
+        const v = main();
+        if (v !== null) {
+          console.log(inspect(v));
+        }
+      } catch (e) {
+        if (!(e instanceof MonkeyError)) {
+          throw e;
+        }
+        console.error(`runtime error: ${e.message}`);
+      }
+    },
+  };
+})();
@@ -0,0 +1,82 @@
+package jsgen
+
+import (
+	"strings"
+
+	"github.com/oohira/monkey/token"
+)
+
+// SourceMap represents a source map (revision 3) linking positions of the
+// generated code to tokens of the Monkey program.
+type SourceMap struct {
+	Version  int      `json:"version"`
+	File     string   `json:"file,omitempty"`
+	Sources  []string `json:"sources"`
+	Names    []string `json:"names"`
+	Mappings string   `json:"mappings"`
+}
+
+// mapping links a 0-based position of the generated code to a token.
+type mapping struct {
+	line, column int
+	pos          token.Position
+	name         int // index in Names, or -1
+}
+
+// newSourceMap returns the source map of mappings in order of generated
+// positions.
+func newSourceMap(source string, names []string, mappings []mapping) *SourceMap {
+	var out strings.Builder
+	var column, srcLine, srcColumn, name int
+	line := 0
+	for i, m := range mappings {
+		if i > 0 && m.line == line {
+			out.WriteByte(',')
+		}
+		for ; line < m.line; line++ {
+			out.WriteByte(';')
+			column = 0
+		}
+		writeVLQ(&out, m.column-column)
+		writeVLQ(&out, 0) // the only source
+		writeVLQ(&out, m.pos.Line-1-srcLine)
+		writeVLQ(&out, m.pos.Column-1-srcColumn)
+		column, srcLine, srcColumn = m.column, m.pos.Line-1, m.pos.Column-1
+		if m.name >= 0 {
+			writeVLQ(&out, m.name-name)
+			name = m.name
+		}
+	}
+	if names == nil {
+		names = []string{}
+	}
+	return &SourceMap{
+		Version:  3,
+		Sources:  []string{source},
+		Names:    names,
+		Mappings: out.String(),
+	}
+}
+
+const base64Digits = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// writeVLQ writes n as a base64 variable-length quantity: the sign is the
+// lowest bit of the first digit and each digit holds 5 bits with a
+// continuation bit.
+func writeVLQ(out *strings.Builder, n int) {
+	v := n << 1
+	if n < 0 {
+		v = -n<<1 | 1
+	}
+	for {
+		digit := v & 31
+		v >>= 5
+		if v > 0 {
+			digit |= 32
+		}
+		out.WriteByte(base64Digits[digit])
+		if v == 0 {
+			return
+		}
+	}
+}
@@ -1,27 +1,126 @@
 package lexer
 
-import "github.com/oohira/monkey/token"
+import (
+	"bufio"
+	"io"
+	"iter"
+	"strings"
+
+	"github.com/oohira/monkey/token"
+)
 
 // Lexer represents a lexer of Monkey programming language.
 type Lexer struct {
-	input        string
-	position     int
-	readPosition int
-	ch           byte
+	r   *bufio.Reader
+	ch  byte
+	pos token.Position // position of ch
+	err error
+
+	last    token.Token   // token most recently returned by NextToken
+	pending []token.Token // tokens pushed back by Peek or Backup
+
+	trivia bool // whether to attach trivia to tokens
 }
 
 // New returns a Lexer for the specified input program.
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewReader(strings.NewReader(input))
+}
+
+// NewReader returns a Lexer that incrementally scans the program read from r.
+// Only a bounded window of the input is buffered at a time.
+func NewReader(r io.Reader) *Lexer {
+	l := &Lexer{r: bufio.NewReader(r), pos: token.Position{Offset: -1, Line: 1}}
 	l.readChar()
 	return l
 }
 
+// NewAt returns a Lexer for input, which is the part of a larger source
+// starting at pos, so that the tokens have their positions in the source.
+func NewAt(input string, pos token.Position) *Lexer {
+	l := New(input)
+	l.pos = pos
+	return l
+}
+
+// PreserveTrivia makes the lexer attach whitespace and comments to tokens.
+// Trivia up to the end of the line following a token is attached as its
+// Trailing trivia and the rest as Leading trivia of the next token, so that
+// concatenating Leading, Literal and Trailing of all tokens reproduces
+// the input. It must be called before the first call of NextToken.
+func (l *Lexer) PreserveTrivia() {
+	l.trivia = true
+}
+
+// Err returns the first non-EOF error that was encountered while reading
+// the input. Once an error occurs, NextToken returns EOF.
+func (l *Lexer) Err() error {
+	return l.err
+}
+
+// Tokenize scans the whole input and returns its tokens, excluding the final EOF.
+func Tokenize(input string) []token.Token {
+	var tokens []token.Token
+	for tok := range New(input).All() {
+		tokens = append(tokens, tok)
+	}
+	return tokens
+}
+
+// All returns an iterator over the remaining tokens, excluding the final EOF.
+func (l *Lexer) All() iter.Seq[token.Token] {
+	return func(yield func(token.Token) bool) {
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+			if !yield(tok) {
+				return
+			}
+		}
+	}
+}
+
+// Peek returns the next token without consuming it.
+func (l *Lexer) Peek() token.Token {
+	last := l.last
+	tok := l.NextToken()
+	l.Backup()
+	l.last = last
+	return tok
+}
+
+// Backup steps back the token most recently returned by NextToken,
+// so that the next call of NextToken returns it again.
+// It can be called only once per call of NextToken.
+func (l *Lexer) Backup() {
+	l.pending = append(l.pending, l.last)
+}
+
 // NextToken gets the next token if exists, EOF otherwise.
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
+	if n := len(l.pending); n > 0 {
+		l.last = l.pending[n-1]
+		l.pending = l.pending[:n-1]
+		return l.last
+	}
+	l.last = l.scan()
+	return l.last
+}
 
-	l.skipWhitespace()
+func (l *Lexer) scan() token.Token {
+	leading := l.readTrivia(false)
+	pos := l.pos
+	tok := l.scanToken()
+	tok.Pos = pos
+	if l.trivia {
+		tok.Leading = leading
+		if tok.Type != token.EOF {
+			tok.Trailing = l.readTrivia(true)
+		}
+	}
+	return tok
+}
+
+func (l *Lexer) scanToken() token.Token {
+	var tok token.Token
 
 	switch l.ch {
 	case '=':
@@ -34,7 +133,12 @@ func (l *Lexer) NextToken() token.Token {
 	case '+':
 		tok = newToken(token.PLUS, l.ch)
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '>' {
+			l.readChar()
+			tok = token.Token{Type: token.ARROW, Literal: "->"}
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
 	case '!':
 		if l.peekChar() == '=' {
 			l.readChar()
@@ -58,6 +162,8 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.RPAREN, l.ch)
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
+	case ':':
+		tok = newToken(token.COLON, l.ch)
 	case '{':
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
@@ -83,42 +189,82 @@ func (l *Lexer) NextToken() token.Token {
 }
 
 func (l *Lexer) readChar() {
-	if l.readPosition >= len(l.input) {
+	if l.ch == '\n' {
+		l.pos.Line++
+		l.pos.Column = 0
+	}
+	if l.ch != 0 || l.pos.Offset < 0 {
+		l.pos.Offset++
+		l.pos.Column++
+	}
+	if l.err != nil {
 		l.ch = 0
-	} else {
-		l.ch = l.input[l.readPosition]
+		return
 	}
-	l.position = l.readPosition
-	l.readPosition++
+	ch, err := l.r.ReadByte()
+	if err != nil {
+		if err != io.EOF {
+			l.err = err
+		}
+		l.ch = 0
+		return
+	}
+	l.ch = ch
 }
 
 func (l *Lexer) peekChar() byte {
-	if l.readPosition >= len(l.input) {
+	if l.err != nil {
+		return 0
+	}
+	b, err := l.r.Peek(1)
+	if err != nil {
 		return 0
 	}
-	return l.input[l.readPosition]
+	return b[0]
 }
 
-func (l *Lexer) skipWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\r' || l.ch == '\n' {
+// readTrivia skips whitespace and comments and returns them if trivia is
+// preserved. If trailing is true, it stops at the end of the line.
+func (l *Lexer) readTrivia(trailing bool) string {
+	var buf []byte
+	for {
+		switch {
+		case l.ch == ' ' || l.ch == '\t':
+		case (l.ch == '\r' || l.ch == '\n') && !trailing:
+		case l.ch == '/' && l.peekChar() == '/':
+			for l.ch != '\n' && l.ch != '\r' && l.ch != 0 {
+				if l.trivia {
+					buf = append(buf, l.ch)
+				}
+				l.readChar()
+			}
+			continue
+		default:
+			return string(buf)
+		}
+		if l.trivia {
+			buf = append(buf, l.ch)
+		}
 		l.readChar()
 	}
 }
 
 func (l *Lexer) readIdentifier() string {
-	pos := l.position
+	var buf []byte
 	for isLetter(l.ch) {
+		buf = append(buf, l.ch)
 		l.readChar()
 	}
-	return l.input[pos:l.position]
+	return string(buf)
 }
 
 func (l *Lexer) readNumber() string {
-	pos := l.position
+	var buf []byte
 	for isDigit(l.ch) {
+		buf = append(buf, l.ch)
 		l.readChar()
 	}
-	return l.input[pos:l.position]
+	return string(buf)
 }
 
 func isLetter(ch byte) bool {
@@ -1,7 +1,11 @@
 package lexer
 
 import (
+	"errors"
+	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/oohira/monkey/token"
 )
@@ -27,6 +31,7 @@ if (5 < 10) {
 
 10 == 10;
 10 != 9;
+let x: fn(int) -> bool = a->b - c;
 `
 
 	tests := []struct {
@@ -109,6 +114,22 @@ if (5 < 10) {
 		{token.NOTEQ, "!="},
 		{token.INT, "9"},
 		{token.SEMICOLON, ";"},
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.COLON, ":"},
+		{token.FUNCTION, "fn"},
+		{token.LPAREN, "("},
+		{token.IDENT, "int"},
+		{token.RPAREN, ")"},
+		{token.ARROW, "->"},
+		{token.IDENT, "bool"},
+		{token.ASSIGN, "="},
+		{token.IDENT, "a"},
+		{token.ARROW, "->"},
+		{token.IDENT, "b"},
+		{token.MINUS, "-"},
+		{token.IDENT, "c"},
+		{token.SEMICOLON, ";"},
 
 		{token.EOF, ""},
 	}
@@ -125,3 +146,211 @@ if (5 < 10) {
 		}
 	}
 }
+
+func TestNewReader(t *testing.T) {
+	input := `
+let add = fn(x, y) { x + y; };
+let result = add(five, 10);
+!-/*5 < 10 > 5 == 10 != 9 @
+if (five) { return true; } else { return false; }
+`
+	expected := New(input)
+	actual := NewReader(iotest.OneByteReader(strings.NewReader(input)))
+	for i := 0; ; i++ {
+		want := expected.NextToken()
+		got := actual.NextToken()
+		if got != want {
+			t.Fatalf("tokens[%d] - expected=%+v, got=%+v", i, want, got)
+		}
+		if want.Type == token.EOF {
+			break
+		}
+	}
+	if err := actual.Err(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewReaderError(t *testing.T) {
+	readErr := errors.New("connection reset")
+	r := io.MultiReader(strings.NewReader("let x"), iotest.ErrReader(readErr))
+	l := NewReader(r)
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.EOF, ""},
+		{token.EOF, ""},
+	}
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+	if l.Err() != readErr {
+		t.Errorf("l.Err() is not %v. got=%v", readErr, l.Err())
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	input := "let x = 5 + y;"
+	expected := []token.Token{
+		{Type: token.LET, Literal: "let", Pos: token.Position{Offset: 0, Line: 1, Column: 1}},
+		{Type: token.IDENT, Literal: "x", Pos: token.Position{Offset: 4, Line: 1, Column: 5}},
+		{Type: token.ASSIGN, Literal: "=", Pos: token.Position{Offset: 6, Line: 1, Column: 7}},
+		{Type: token.INT, Literal: "5", Pos: token.Position{Offset: 8, Line: 1, Column: 9}},
+		{Type: token.PLUS, Literal: "+", Pos: token.Position{Offset: 10, Line: 1, Column: 11}},
+		{Type: token.IDENT, Literal: "y", Pos: token.Position{Offset: 12, Line: 1, Column: 13}},
+		{Type: token.SEMICOLON, Literal: ";", Pos: token.Position{Offset: 13, Line: 1, Column: 14}},
+	}
+
+	tokens := Tokenize(input)
+	if len(tokens) != len(expected) {
+		t.Fatalf("len(tokens) is not %d. got=%d", len(expected), len(tokens))
+	}
+	for i, tok := range tokens {
+		if tok != expected[i] {
+			t.Errorf("tokens[%d] - expected=%+v, got=%+v", i, expected[i], tok)
+		}
+	}
+
+	if tokens := Tokenize(""); len(tokens) != 0 {
+		t.Errorf("Tokenize(\"\") is not empty. got=%+v", tokens)
+	}
+}
+
+func TestAll(t *testing.T) {
+	l := New("a + b * c")
+
+	var literals []string
+	for tok := range l.All() {
+		literals = append(literals, tok.Literal)
+		if tok.Type == token.ASTERISK {
+			break
+		}
+	}
+	if strings.Join(literals, " ") != "a + b *" {
+		t.Errorf("literals wrong. got=%q", literals)
+	}
+	if tok := l.NextToken(); tok.Literal != "c" {
+		t.Errorf("token after break is not c. got=%+v", tok)
+	}
+}
+
+func TestPeekAndBackup(t *testing.T) {
+	l := New("a + b")
+
+	if tok := l.Peek(); tok.Literal != "a" {
+		t.Fatalf("Peek() is not a. got=%+v", tok)
+	}
+	if tok := l.NextToken(); tok.Literal != "a" {
+		t.Fatalf("NextToken() is not a. got=%+v", tok)
+	}
+	if tok := l.NextToken(); tok.Literal != "+" {
+		t.Fatalf("NextToken() is not +. got=%+v", tok)
+	}
+	if tok := l.Peek(); tok.Literal != "b" {
+		t.Fatalf("Peek() is not b. got=%+v", tok)
+	}
+	l.Backup()
+	if tok := l.NextToken(); tok.Literal != "+" {
+		t.Fatalf("NextToken() after Backup is not +. got=%+v", tok)
+	}
+	if tok := l.NextToken(); tok.Literal != "b" {
+		t.Fatalf("NextToken() is not b. got=%+v", tok)
+	}
+	if tok := l.NextToken(); tok.Type != token.EOF {
+		t.Fatalf("NextToken() is not EOF. got=%+v", tok)
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := "// comment\nx // trailing / comment\n/ y //\n"
+	expected := []token.Token{
+		{Type: token.IDENT, Literal: "x"},
+		{Type: token.SLASH, Literal: "/"},
+		{Type: token.IDENT, Literal: "y"},
+	}
+
+	tokens := Tokenize(input)
+	if len(tokens) != len(expected) {
+		t.Fatalf("len(tokens) is not %d. got=%+v", len(expected), tokens)
+	}
+	for i, tok := range tokens {
+		if tok.Type != expected[i].Type || tok.Literal != expected[i].Literal {
+			t.Errorf("tokens[%d] - expected=%+v, got=%+v", i, expected[i], tok)
+		}
+	}
+}
+
+func TestPreserveTrivia(t *testing.T) {
+	input := "  // head\n\tlet x = 5; // five\n\n  x\n"
+	expected := []token.Token{
+		{Type: token.LET, Literal: "let", Leading: "  // head\n\t", Trailing: " "},
+		{Type: token.IDENT, Literal: "x", Trailing: " "},
+		{Type: token.ASSIGN, Literal: "=", Trailing: " "},
+		{Type: token.INT, Literal: "5"},
+		{Type: token.SEMICOLON, Literal: ";", Trailing: " // five"},
+		{Type: token.IDENT, Literal: "x", Leading: "\n\n  "},
+		{Type: token.EOF, Literal: "", Leading: "\n"},
+	}
+
+	l := New(input)
+	l.PreserveTrivia()
+	var text strings.Builder
+	for i, tt := range expected {
+		tok := l.NextToken()
+		tok.Pos = token.Position{}
+		if tok != tt {
+			t.Fatalf("tokens[%d] - expected=%+v, got=%+v", i, tt, tok)
+		}
+		text.WriteString(tok.Leading + tok.Literal + tok.Trailing)
+	}
+	if text.String() != input {
+		t.Errorf("concatenated tokens wrong. want=%q, got=%q", input, text.String())
+	}
+}
+
+func TestPositions(t *testing.T) {
+	input := "let x\n  = 10;\r\n\n// c\n!y"
+	expected := []struct {
+		literal string
+		pos     token.Position
+	}{
+		{"let", token.Position{Offset: 0, Line: 1, Column: 1}},
+		{"x", token.Position{Offset: 4, Line: 1, Column: 5}},
+		{"=", token.Position{Offset: 8, Line: 2, Column: 3}},
+		{"10", token.Position{Offset: 10, Line: 2, Column: 5}},
+		{";", token.Position{Offset: 12, Line: 2, Column: 7}},
+		{"!", token.Position{Offset: 21, Line: 5, Column: 1}},
+		{"y", token.Position{Offset: 22, Line: 5, Column: 2}},
+		{"", token.Position{Offset: 23, Line: 5, Column: 3}},
+	}
+
+	l := New(input)
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Literal != tt.literal || tok.Pos != tt.pos {
+			t.Errorf("tokens[%d] - expected=%q %+v, got=%q %+v", i, tt.literal, tt.pos, tok.Literal, tok.Pos)
+		}
+	}
+}
+
+func TestNewAt(t *testing.T) {
+	l := NewAt("x\n  y", token.Position{Offset: 20, Line: 3, Column: 7})
+	expected := []token.Position{
+		{Offset: 20, Line: 3, Column: 7},
+		{Offset: 24, Line: 4, Column: 3},
+		{Offset: 25, Line: 4, Column: 4},
+	}
+	for i, pos := range expected {
+		if tok := l.NextToken(); tok.Pos != pos {
+			t.Errorf("tokens[%d] position wrong. want=%+v, got=%+v", i, pos, tok.Pos)
+		}
+	}
+}
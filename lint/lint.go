@@ -0,0 +1,260 @@
+// Package lint reports suspicious constructs in Monkey programs.
+//
+// A linter runs a set of rules over a program. Rules can be disabled by a
+// Config or by directive comments in the source:
+//
+//	// lint:ignore rule,...   ignores the rules on this or the next line
+//	// lint:disable rule,...  disables the rules until the end of the file
+//	// lint:enable rule,...   enables the rules disabled by lint:disable
+//
+// Without rule names, a directive applies to all rules.
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/parser"
+	"github.com/oohira/monkey/resolve"
+	"github.com/oohira/monkey/token"
+)
+
+// Diagnostic represents a problem found by a rule.
+type Diagnostic struct {
+	Pos     token.Position
+	Rule    string
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s (%s)", d.Pos, d.Message, d.Rule)
+}
+
+// SyntaxRule is the rule name of diagnostics reporting parse errors.
+const SyntaxRule = "syntax"
+
+// Rule represents a check of programs.
+type Rule interface {
+	// Name returns the name of the rule used in configs and directives.
+	Name() string
+	// Doc returns a one-line description of the rule.
+	Doc() string
+	// Check reports problems of pass.Program by pass.Reportf.
+	Check(pass *Pass)
+}
+
+// Pass holds a program checked by a rule.
+type Pass struct {
+	Program *ast.Program
+
+	rule        string
+	diagnostics []Diagnostic
+	symbols     func() *resolve.Info
+}
+
+// Symbols returns the symbol table of the program, which is shared by the
+// rules.
+func (p *Pass) Symbols() *resolve.Info {
+	return p.symbols()
+}
+
+// Reportf reports a problem at pos.
+func (p *Pass) Reportf(pos token.Position, format string, args ...interface{}) {
+	p.diagnostics = append(p.diagnostics, Diagnostic{pos, p.rule, fmt.Sprintf(format, args...)})
+}
+
+// DefaultRules returns the rules enabled by default.
+func DefaultRules() []Rule {
+	return []Rule{
+		redeclaredRule{},
+		undefinedRule{},
+		shadowedRule{},
+		unreachableRule{},
+		selfCompareRule{},
+	}
+}
+
+// Config represents the settings of a linter. Rules maps a rule name to
+// whether the rule is enabled, and rules not in the map are enabled.
+type Config struct {
+	Rules map[string]bool `json:"rules"`
+}
+
+// LoadConfig reads a config in JSON such as {"rules": {"undefined": false}}.
+func LoadConfig(r io.Reader) (*Config, error) {
+	config := &Config{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(config); err != nil {
+		return nil, fmt.Errorf("invalid config: %s", err)
+	}
+	return config, nil
+}
+
+// Enabled reports whether the named rule is enabled. A nil Config enables
+// all rules.
+func (c *Config) Enabled(rule string) bool {
+	if c == nil {
+		return true
+	}
+	enabled, ok := c.Rules[rule]
+	return !ok || enabled
+}
+
+// Validate returns an error if the config refers to a rule not in rules.
+func (c *Config) Validate(rules []Rule) error {
+	if c == nil {
+		return nil
+	}
+	known := map[string]bool{}
+	for _, rule := range rules {
+		known[rule.Name()] = true
+	}
+	for name := range c.Rules {
+		if !known[name] {
+			return fmt.Errorf("unknown rule %q", name)
+		}
+	}
+	return nil
+}
+
+// Check runs the rules enabled by config over a program and returns the
+// diagnostics sorted by position.
+func Check(program *ast.Program, rules []Rule, config *Config) []Diagnostic {
+	var info *resolve.Info
+	symbols := func() *resolve.Info {
+		if info == nil {
+			info = resolve.Resolve(program)
+		}
+		return info
+	}
+
+	diagnostics := []Diagnostic{}
+	for _, rule := range rules {
+		if !config.Enabled(rule.Name()) {
+			continue
+		}
+		pass := &Pass{Program: program, rule: rule.Name(), symbols: symbols}
+		rule.Check(pass)
+		diagnostics = append(diagnostics, pass.diagnostics...)
+	}
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Pos.Offset < diagnostics[j].Pos.Offset
+	})
+	return diagnostics
+}
+
+// CheckSource parses src and runs the rules over it like Check, honoring
+// directive comments in src. If src has syntax errors, it returns them as
+// diagnostics of SyntaxRule without running the rules.
+func CheckSource(src string, rules []Rule, config *Config) []Diagnostic {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if errs := p.ErrorList(); len(errs) > 0 {
+		diagnostics := make([]Diagnostic, len(errs))
+		for i, err := range errs {
+			diagnostics[i] = Diagnostic{err.Pos, SyntaxRule, err.Msg}
+		}
+		return diagnostics
+	}
+
+	directives := parseDirectives(src)
+	diagnostics := []Diagnostic{}
+	for _, d := range Check(program, rules, config) {
+		if !directives.suppressed(d) {
+			diagnostics = append(diagnostics, d)
+		}
+	}
+	return diagnostics
+}
+
+// directive represents a lint directive comment.
+type directive struct {
+	line  int    // line the directive applies from
+	kind  string // ignore, disable or enable
+	rules []string
+}
+
+func (d *directive) matches(rule string) bool {
+	if len(d.rules) == 0 {
+		return true
+	}
+	for _, r := range d.rules {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+type directives []*directive
+
+// parseDirectives returns the directives in the comments of src in order.
+// A lint:ignore comment on a line of its own applies to the next line.
+func parseDirectives(src string) directives {
+	var ds directives
+	add := func(text string, line int, alone bool) {
+		fields := strings.Fields(strings.TrimPrefix(text, "//"))
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "lint:") {
+			return
+		}
+		d := &directive{line: line, kind: strings.TrimPrefix(fields[0], "lint:")}
+		if d.kind == "ignore" && alone {
+			d.line++
+		}
+		for _, field := range fields[1:] {
+			for _, rule := range strings.Split(field, ",") {
+				if rule != "" {
+					d.rules = append(d.rules, rule)
+				}
+			}
+		}
+		ds = append(ds, d)
+	}
+
+	l := lexer.New(src)
+	l.PreserveTrivia()
+	for {
+		tok := l.NextToken()
+		// leading trivia starts at the beginning of a line
+		line := tok.Pos.Line - strings.Count(tok.Leading, "\n")
+		for _, text := range strings.SplitAfter(tok.Leading, "\n") {
+			if i := strings.Index(text, "//"); i >= 0 {
+				add(strings.TrimSpace(text[i:]), line, true)
+			}
+			line++
+		}
+		if i := strings.Index(tok.Trailing, "//"); i >= 0 {
+			add(strings.TrimSpace(tok.Trailing[i:]), tok.Pos.Line, false)
+		}
+		if tok.Type == token.EOF {
+			return ds
+		}
+	}
+}
+
+// suppressed reports whether a diagnostic is suppressed by the directives.
+func (ds directives) suppressed(diagnostic Diagnostic) bool {
+	disabled := false
+	for _, d := range ds {
+		if d.line > diagnostic.Pos.Line || !d.matches(diagnostic.Rule) {
+			continue
+		}
+		switch d.kind {
+		case "ignore":
+			if d.line == diagnostic.Pos.Line {
+				return true
+			}
+		case "disable":
+			disabled = true
+		case "enable":
+			disabled = false
+		}
+	}
+	return disabled
+}
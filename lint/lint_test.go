@@ -0,0 +1,157 @@
+package lint
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/parser"
+)
+
+func checkRule(t *testing.T, rule Rule, input string) []string {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	var got []string
+	for _, d := range Check(program, []Rule{rule}, nil) {
+		got = append(got, d.String())
+	}
+	return got
+}
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		rule     Rule
+		input    string
+		expected []string
+	}{
+		{redeclaredRule{}, "let x = 1; let y = 2;", nil},
+		{redeclaredRule{}, "let x = 1; let x = 2;", []string{
+			"1:16: x redeclared in this scope; previous declaration at 1:5 (redeclared)",
+		}},
+		{redeclaredRule{}, "let x = 1; if (x) { let x = 2; }", nil},
+		{redeclaredRule{}, "fn(a, a) { 1 }; fn(b) { let b = 1; }", []string{
+			"1:7: a redeclared in this scope; previous declaration at 1:4 (redeclared)",
+			"1:29: b redeclared in this scope; previous declaration at 1:20 (redeclared)",
+		}},
+		{redeclaredRule{}, "let x = 1; fn(x) { let y = x; }; fn() { let y = 2; }", nil},
+
+		{undefinedRule{}, "let x = 1; x + 2;", nil},
+		{undefinedRule{}, "x; let x = 1;", []string{"1:1: undefined: x (undefined)"}},
+		{undefinedRule{}, "let x = x;", []string{"1:9: undefined: x (undefined)"}},
+		{undefinedRule{}, "let f = fn(n) { f(n - 1) }; f(y);", []string{"1:31: undefined: y (undefined)"}},
+		{undefinedRule{}, "let f = fn() { g() }; let g = fn() { 1 }; f();", nil},
+		{undefinedRule{}, "let f = fn() { let a = b; let b = 1; a }; fn(x) { x }; x;", []string{
+			"1:24: undefined: b (undefined)",
+			"1:56: undefined: x (undefined)",
+		}},
+		{undefinedRule{}, "if (true) { let a = 1; }; a;", []string{"1:27: undefined: a (undefined)"}},
+
+		{shadowedRule{}, "let x = 1; fn(y) { let z = x; }", nil},
+		{shadowedRule{}, "let x = 1; if (x) { let x = 2; }; fn(x) { fn(x) { x } }", []string{
+			"1:25: declaration of x shadows declaration at 1:5 (shadowed)",
+			"1:38: declaration of x shadows declaration at 1:5 (shadowed)",
+			"1:46: declaration of x shadows declaration at 1:38 (shadowed)",
+		}},
+
+		{unreachableRule{}, "let f = fn() { return 1; }; f();", nil},
+		{unreachableRule{}, "return 1; 2; 3;", []string{"1:11: unreachable code (unreachable)"}},
+		{unreachableRule{}, "fn() { if (true) { return 1; 2 } 3 }", []string{
+			"1:30: unreachable code (unreachable)",
+		}},
+		{unreachableRule{}, "fn() { if (true) { return 1; } else { return 2; } 3 }", []string{
+			"1:51: unreachable code (unreachable)",
+		}},
+		{unreachableRule{}, "fn() { if (true) { return 1; } 3 }", nil},
+
+		{selfCompareRule{}, "x == y; x + x; -x < x;", nil},
+		{selfCompareRule{}, "x == x; (a + 1) != (a + 1); f() == f(); 1 < 1", []string{
+			"1:3: comparison of x with itself is always true (self-compare)",
+			"1:17: comparison of (a + 1) with itself is always false (self-compare)",
+			"1:43: comparison of 1 with itself is always false (self-compare)",
+		}},
+	}
+
+	for _, tt := range tests {
+		got := checkRule(t, tt.rule, tt.input)
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("%s: diagnostics for %q wrong.\nwant=%q\ngot =%q", tt.rule.Name(), tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestCheckSource(t *testing.T) {
+	input := `let x = 1;
+let x = 2; // lint:ignore redeclared
+let x = x == x;
+// lint:ignore
+y;
+// lint:disable undefined,self-compare
+z == z;
+// lint:enable undefined
+w == w;
+// lint:disable
+return 1;
+q;
+`
+	tests := []struct {
+		config   *Config
+		expected []string
+	}{
+		{nil, []string{
+			"3:5: x redeclared in this scope; previous declaration at 2:5 (redeclared)",
+			"3:11: comparison of x with itself is always true (self-compare)",
+			"9:1: undefined: w (undefined)",
+			"9:6: undefined: w (undefined)",
+		}},
+		{&Config{Rules: map[string]bool{"redeclared": false, "undefined": true}}, []string{
+			"3:11: comparison of x with itself is always true (self-compare)",
+			"9:1: undefined: w (undefined)",
+			"9:6: undefined: w (undefined)",
+		}},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, d := range CheckSource(input, DefaultRules(), tt.config) {
+			got = append(got, d.String())
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("diagnostics wrong.\nwant=%q\ngot =%q", tt.expected, got)
+		}
+	}
+}
+
+func TestCheckSourceSyntaxError(t *testing.T) {
+	got := CheckSource("let x = 1;\nlet = 2;", DefaultRules(), nil)
+	if len(got) == 0 || got[0].Rule != SyntaxRule || got[0].Pos.Line != 2 {
+		t.Errorf("syntax error diagnostics wrong: %v", got)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	config, err := LoadConfig(strings.NewReader(`{"rules": {"undefined": false, "unreachable": true}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rule, expected := range map[string]bool{"undefined": false, "unreachable": true, "redeclared": true} {
+		if config.Enabled(rule) != expected {
+			t.Errorf("Enabled(%q) wrong. want=%t", rule, expected)
+		}
+	}
+	if err := config.Validate(DefaultRules()); err != nil {
+		t.Errorf("Validate returned error: %s", err)
+	}
+
+	config.Rules["typo"] = false
+	if err := config.Validate(DefaultRules()); err == nil || err.Error() != `unknown rule "typo"` {
+		t.Errorf("Validate error wrong: %v", err)
+	}
+
+	if _, err := LoadConfig(strings.NewReader(`{"rulez": {}}`)); err == nil {
+		t.Errorf("LoadConfig accepted an unknown field")
+	}
+}
@@ -0,0 +1,153 @@
+package lint
+
+import (
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/resolve"
+	"github.com/oohira/monkey/token"
+)
+
+// redeclaredRule reports let bindings and parameters declared twice in the
+// same scope.
+type redeclaredRule struct{}
+
+func (redeclaredRule) Name() string { return "redeclared" }
+
+func (redeclaredRule) Doc() string { return "report names declared twice in the same scope" }
+
+func (redeclaredRule) Check(pass *Pass) {
+	reportProblems(pass, resolve.Redeclared)
+}
+
+// undefinedRule reports uses of names that are not declared.
+type undefinedRule struct{}
+
+func (undefinedRule) Name() string { return "undefined" }
+
+func (undefinedRule) Doc() string { return "report uses of undefined names" }
+
+func (undefinedRule) Check(pass *Pass) {
+	reportProblems(pass, resolve.Undefined)
+}
+
+// shadowedRule reports declarations hiding a name of an outer scope.
+type shadowedRule struct{}
+
+func (shadowedRule) Name() string { return "shadowed" }
+
+func (shadowedRule) Doc() string { return "report names hiding a declaration of an outer scope" }
+
+func (shadowedRule) Check(pass *Pass) {
+	reportProblems(pass, resolve.Shadowed)
+}
+
+func reportProblems(pass *Pass, kind resolve.ProblemKind) {
+	for _, problem := range pass.Symbols().Problems {
+		if problem.Kind == kind {
+			pass.Reportf(problem.Pos(), "%s", problem.Message())
+		}
+	}
+}
+
+// unreachableRule reports statements following a return statement or an if
+// expression returning from both branches.
+type unreachableRule struct{}
+
+func (unreachableRule) Name() string { return "unreachable" }
+
+func (unreachableRule) Doc() string { return "report statements that are never executed" }
+
+func (unreachableRule) Check(pass *Pass) {
+	check := func(stmts []ast.Statement) {
+		for i, stmt := range stmts[:max(len(stmts)-1, 0)] {
+			if returns(stmt) {
+				pass.Reportf(startPos(stmts[i+1]), "unreachable code")
+				return
+			}
+		}
+	}
+	ast.Inspect(pass.Program, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Program:
+			check(n.Statements)
+		case *ast.BlockStatement:
+			check(n.Statements)
+		}
+		return true
+	})
+}
+
+// returns reports whether a statement always returns.
+func returns(stmt ast.Statement) bool {
+	switch stmt := stmt.(type) {
+	case *ast.ReturnStatement:
+		return true
+	case *ast.BlockStatement:
+		return blockReturns(stmt)
+	case *ast.ExpressionStatement:
+		ifExp, ok := stmt.Expression.(*ast.IfExpression)
+		return ok && blockReturns(ifExp.Consequence) && blockReturns(ifExp.Alternative)
+	}
+	return false
+}
+
+func blockReturns(block *ast.BlockStatement) bool {
+	if block == nil {
+		return false
+	}
+	for _, stmt := range block.Statements {
+		if returns(stmt) {
+			return true
+		}
+	}
+	return false
+}
+
+// selfCompareRule reports comparisons of an expression with itself.
+type selfCompareRule struct{}
+
+func (selfCompareRule) Name() string { return "self-compare" }
+
+func (selfCompareRule) Doc() string { return "report comparisons of an expression with itself" }
+
+func (selfCompareRule) Check(pass *Pass) {
+	results := map[string]string{"==": "true", "!=": "false", "<": "false", ">": "false"}
+	ast.Inspect(pass.Program, func(n ast.Node) bool {
+		infix, ok := n.(*ast.InfixExpression)
+		if !ok {
+			return true
+		}
+		result, isComparison := results[infix.Operator]
+		if isComparison && ast.Equal(infix.Left, infix.Right) && !hasCall(infix.Left) {
+			pass.Reportf(infix.Token.Pos, "comparison of %s with itself is always %s", infix.Left, result)
+		}
+		return true
+	})
+}
+
+// hasCall reports whether node contains a call, whose result may differ
+// between evaluations.
+func hasCall(node ast.Node) bool {
+	found := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		if _, ok := n.(*ast.CallExpression); ok {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// startPos returns the position of the first token of a statement.
+func startPos(stmt ast.Statement) token.Position {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		return stmt.Token.Pos
+	case *ast.ReturnStatement:
+		return stmt.Token.Pos
+	case *ast.ExpressionStatement:
+		return stmt.Token.Pos
+	case *ast.BlockStatement:
+		return stmt.Token.Pos
+	}
+	return token.Position{}
+}
@@ -0,0 +1,241 @@
+package lsp
+
+import (
+	"sort"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/cst"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/parser"
+	"github.com/oohira/monkey/resolve"
+)
+
+// document represents an opened text document and its syntax trees.
+type document struct {
+	uri     string
+	text    string
+	lines   []int // offsets of the beginning of lines
+	tree    *cst.Node
+	program *ast.Program
+	errors  []*parser.Error
+	spans   map[ast.Node]span
+	info    *resolve.Info // symbol table
+}
+
+// span represents a range of byte offsets [start, end).
+type span struct {
+	start int
+	end   int
+}
+
+func newDocument(uri, text string) *document {
+	l := lexer.New(text)
+	l.PreserveTrivia()
+	p := parser.New(l)
+	tree := p.ParseConcreteProgram()
+
+	d := &document{
+		uri:     uri,
+		text:    text,
+		lines:   []int{0},
+		tree:    tree,
+		program: tree.AST.(*ast.Program),
+		errors:  p.ErrorList(),
+		spans:   map[ast.Node]span{},
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+	d.collectSpans(tree)
+	d.info = resolve.Resolve(d.program)
+	return d
+}
+
+// collectSpans records the source range of each AST node in the tree.
+// An AST node may appear twice, e.g. with and without enclosing parentheses,
+// and the inner one wins.
+func (d *document) collectSpans(n *cst.Node) {
+	if n.AST != nil {
+		tokens := n.Tokens()
+		if len(tokens) > 0 {
+			first, last := tokens[0], tokens[len(tokens)-1]
+			d.spans[n.AST] = span{first.Pos.Offset, last.Pos.Offset + len(last.Literal)}
+		}
+	}
+	for _, child := range n.Children {
+		d.collectSpans(child)
+	}
+}
+
+// nodeAt returns the innermost node whose range contains offset.
+func (d *document) nodeAt(offset int) ast.Node {
+	var found ast.Node
+	for n := d.tree; n != nil; {
+		var next *cst.Node
+		for _, child := range n.Children {
+			tokens := child.Tokens()
+			if child.Token != nil || len(tokens) == 0 {
+				continue
+			}
+			first, last := tokens[0], tokens[len(tokens)-1]
+			if first.Pos.Offset <= offset && offset < last.Pos.Offset+len(last.Literal) {
+				next = child
+				break
+			}
+		}
+		if next != nil && next.AST != nil {
+			found = next.AST
+		}
+		n = next
+	}
+	return found
+}
+
+// identifierAt returns the identifier at offset, also if offset is just
+// after it as editors put the cursor there.
+func (d *document) identifierAt(offset int) *ast.Identifier {
+	for _, o := range []int{offset, offset - 1} {
+		if ident, ok := d.nodeAt(o).(*ast.Identifier); ok {
+			return ident
+		}
+	}
+	return nil
+}
+
+// offset converts a LSP position into a byte offset.
+func (d *document) offset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(d.lines) {
+		return len(d.text)
+	}
+	offset := d.lines[pos.Line]
+	for units := 0; units < pos.Character && offset < len(d.text) && d.text[offset] != '\n'; {
+		r, size := utf8.DecodeRuneInString(d.text[offset:])
+		units += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+	return offset
+}
+
+// position converts a byte offset into a LSP position.
+func (d *document) position(offset int) Position {
+	line := sort.Search(len(d.lines), func(i int) bool { return d.lines[i] > offset }) - 1
+	units := 0
+	for _, r := range d.text[d.lines[line]:offset] {
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return Position{Line: line, Character: units}
+}
+
+func (d *document) rangeOf(sp span) Range {
+	return Range{Start: d.position(sp.start), End: d.position(sp.end)}
+}
+
+func (d *document) diagnostics() []Diagnostic {
+	lengths := map[int]int{}
+	for _, tok := range d.tree.Tokens() {
+		lengths[tok.Pos.Offset] = len(tok.Literal)
+	}
+
+	diagnostics := []Diagnostic{}
+	for _, err := range d.errors {
+		start := err.Pos.Offset
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    d.rangeOf(span{start, start + lengths[start]}),
+			Severity: SeverityError,
+			Source:   "monkey",
+			Message:  err.Msg,
+		})
+	}
+	if len(d.errors) > 0 {
+		// names are not reliably resolved in incomplete programs
+		return diagnostics
+	}
+	for _, problem := range d.info.Problems {
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    d.rangeOf(d.spans[problem.Ident]),
+			Severity: SeverityWarning,
+			Source:   "monkey",
+			Message:  problem.Message(),
+		})
+	}
+	return diagnostics
+}
+
+func (d *document) symbols() []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+	for _, stmt := range d.program.Statements {
+		symbols = append(symbols, d.symbolsIn(stmt)...)
+	}
+	return symbols
+}
+
+// symbolsIn returns symbols of let bindings in node, nested by function bodies.
+func (d *document) symbolsIn(node ast.Node) []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+	ast.Inspect(node, func(n ast.Node) bool {
+		let, ok := n.(*ast.LetStatement)
+		if !ok {
+			return true
+		}
+		symbol := DocumentSymbol{
+			Name:           let.Name.Value,
+			Kind:           SymbolKindVariable,
+			Range:          d.rangeOf(d.spans[let]),
+			SelectionRange: d.rangeOf(d.spans[let.Name]),
+		}
+		if let.Value != nil {
+			symbol.Detail = let.Value.String()
+			if _, ok := let.Value.(*ast.FunctionLiteral); ok {
+				symbol.Kind = SymbolKindFunction
+			}
+			symbol.Children = d.symbolsIn(let.Value)
+		}
+		symbols = append(symbols, symbol)
+		return false
+	})
+	return symbols
+}
+
+// hover returns the text describing the node at offset.
+func (d *document) hover(offset int) *Hover {
+	node := d.nodeAt(offset)
+	if node == nil {
+		return nil
+	}
+	text := node.String()
+	if ident, ok := node.(*ast.Identifier); ok {
+		if sym := d.info.SymbolOf(ident); sym != nil {
+			switch sym.Kind {
+			case resolve.LetSymbol:
+				text = sym.Node.String()
+			case resolve.ParameterSymbol:
+				text = "parameter " + ident.Value + " of " + sym.Node.String()
+			}
+		}
+	}
+	r := d.rangeOf(d.spans[node])
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: "```monkey\n" + text + "\n```"},
+		Range:    &r,
+	}
+}
+
+// definition returns the location of the declaration of the identifier at offset.
+func (d *document) definition(offset int) *Location {
+	ident := d.identifierAt(offset)
+	if ident == nil {
+		return nil
+	}
+	sym := d.info.SymbolOf(ident)
+	if sym == nil {
+		return nil
+	}
+	return &Location{URI: d.uri, Range: d.rangeOf(d.spans[sym.Decl])}
+}
@@ -0,0 +1,426 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testURI = "file:///test.mk"
+
+// fakeClient is an in-process LSP client connected to a Server.
+type fakeClient struct {
+	t             *testing.T
+	w             io.WriteCloser
+	r             *bufio.Reader
+	nextID        int
+	notifications []message
+	done          chan error
+}
+
+func newFakeClient(t *testing.T) *fakeClient {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	c := &fakeClient{t: t, w: clientOut, r: bufio.NewReader(clientIn), done: make(chan error, 1)}
+	go func() {
+		c.done <- NewServer(serverIn, serverOut).Serve()
+		serverOut.Close()
+	}()
+	t.Cleanup(func() { clientOut.Close() })
+
+	c.request("initialize", map[string]interface{}{}, nil)
+	c.notify("initialized", map[string]interface{}{})
+	return c
+}
+
+func (c *fakeClient) notify(method string, params interface{}) {
+	c.t.Helper()
+	if err := writeMessage(c.w, &notification{JSONRPC: "2.0", Method: method, Params: params}); err != nil {
+		c.t.Fatalf("could not send %s: %v", method, err)
+	}
+}
+
+// request sends a request and decodes its result into result.
+func (c *fakeClient) request(method string, params interface{}, result interface{}) *responseError {
+	c.t.Helper()
+	c.nextID++
+	id := json.RawMessage(itoa(c.nextID))
+	req := struct {
+		JSONRPC string           `json:"jsonrpc"`
+		ID      *json.RawMessage `json:"id"`
+		Method  string           `json:"method"`
+		Params  interface{}      `json:"params"`
+	}{"2.0", &id, method, params}
+	if err := writeMessage(c.w, &req); err != nil {
+		c.t.Fatalf("could not send %s: %v", method, err)
+	}
+
+	for {
+		msg := c.read()
+		if msg.ID == nil {
+			c.notifications = append(c.notifications, msg)
+			continue
+		}
+		if string(*msg.ID) != string(id) {
+			c.t.Fatalf("unexpected response id. want=%s, got=%s", id, *msg.ID)
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result != nil {
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				c.t.Fatalf("could not decode result of %s: %v", method, err)
+			}
+		}
+		return nil
+	}
+}
+
+// diagnostics returns the next diagnostics published by the server.
+func (c *fakeClient) diagnostics() PublishDiagnosticsParams {
+	c.t.Helper()
+	var msg message
+	if len(c.notifications) > 0 {
+		msg, c.notifications = c.notifications[0], c.notifications[1:]
+	} else {
+		msg = c.read()
+	}
+	if msg.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("unexpected message: %+v", msg)
+	}
+	var params PublishDiagnosticsParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		c.t.Fatalf("could not decode diagnostics: %v", err)
+	}
+	return params
+}
+
+func (c *fakeClient) read() message {
+	c.t.Helper()
+	body, err := readMessage(c.r)
+	if err != nil {
+		c.t.Fatalf("could not read message: %v", err)
+	}
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatalf("could not decode message: %v", err)
+	}
+	return msg
+}
+
+func (c *fakeClient) open(text string) {
+	c.t.Helper()
+	c.notify("textDocument/didOpen", &DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: testURI, LanguageID: "monkey", Version: 1, Text: text},
+	})
+}
+
+func itoa(i int) string {
+	b, _ := json.Marshal(i)
+	return string(b)
+}
+
+func position(line, character int) *TextDocumentPositionParams {
+	return &TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: testURI},
+		Position:     Position{Line: line, Character: character},
+	}
+}
+
+func rng(startLine, startChar, endLine, endChar int) Range {
+	return Range{Start: Position{startLine, startChar}, End: Position{endLine, endChar}}
+}
+
+func TestInitialize(t *testing.T) {
+	c := newFakeClient(t)
+	c.nextID = 0
+
+	var result InitializeResult
+	c.request("initialize", map[string]interface{}{}, &result)
+	caps := result.Capabilities
+	if caps.TextDocumentSync != SyncFull || !caps.HoverProvider || !caps.DefinitionProvider ||
+		!caps.DocumentSymbolProvider || !caps.DocumentFormattingProvider {
+		t.Errorf("capabilities wrong. got=%+v", caps)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	c := newFakeClient(t)
+
+	c.open("let x = 5;\nlet = 10;\n")
+	params := c.diagnostics()
+	if params.URI != testURI {
+		t.Errorf("params.URI is not %s. got=%s", testURI, params.URI)
+	}
+	expected := []Diagnostic{
+		{Range: rng(1, 4, 1, 5), Severity: SeverityError, Source: "monkey",
+			Message: "expected next token to be IDENT, got = instead"},
+		{Range: rng(1, 4, 1, 5), Severity: SeverityError, Source: "monkey",
+			Message: "no prefix parse function for = found"},
+	}
+	if !reflect.DeepEqual(params.Diagnostics, expected) {
+		t.Errorf("diagnostics wrong.\nwant=%+v\ngot =%+v", expected, params.Diagnostics)
+	}
+
+	c.notify("textDocument/didChange", &DidChangeTextDocumentParams{
+		TextDocument:   TextDocumentIdentifier{URI: testURI},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "let x = 5;\nlet y = 10;\n"}},
+	})
+	if params := c.diagnostics(); len(params.Diagnostics) != 0 {
+		t.Errorf("diagnostics are not cleared. got=%+v", params.Diagnostics)
+	}
+
+	c.notify("textDocument/didChange", &DidChangeTextDocumentParams{
+		TextDocument:   TextDocumentIdentifier{URI: testURI},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "let x = 5;\nfn(x) { y };\n"}},
+	})
+	expected = []Diagnostic{
+		{Range: rng(1, 3, 1, 4), Severity: SeverityWarning, Source: "monkey",
+			Message: "declaration of x shadows declaration at 1:5"},
+		{Range: rng(1, 8, 1, 9), Severity: SeverityWarning, Source: "monkey",
+			Message: "undefined: y"},
+	}
+	if params := c.diagnostics(); !reflect.DeepEqual(params.Diagnostics, expected) {
+		t.Errorf("warnings wrong.\nwant=%+v\ngot =%+v", expected, params.Diagnostics)
+	}
+
+	c.notify("textDocument/didClose", &DidCloseTextDocumentParams{
+		TextDocument: TextDocumentIdentifier{URI: testURI},
+	})
+	if params := c.diagnostics(); len(params.Diagnostics) != 0 {
+		t.Errorf("diagnostics are not cleared on close. got=%+v", params.Diagnostics)
+	}
+}
+
+const symbolsInput = `let one = 1;
+let add = fn(a, b) {
+  let sum = a + b;
+  sum
+};
+add(one, 2);
+`
+
+func TestDocumentSymbol(t *testing.T) {
+	c := newFakeClient(t)
+	c.open(symbolsInput)
+	c.diagnostics()
+
+	var symbols []DocumentSymbol
+	c.request("textDocument/documentSymbol", &DocumentSymbolParams{
+		TextDocument: TextDocumentIdentifier{URI: testURI},
+	}, &symbols)
+
+	expected := []DocumentSymbol{
+		{Name: "one", Detail: "1", Kind: SymbolKindVariable,
+			Range: rng(0, 0, 0, 12), SelectionRange: rng(0, 4, 0, 7)},
+		{Name: "add", Detail: "fn(a, b) let sum = (a + b);sum", Kind: SymbolKindFunction,
+			Range: rng(1, 0, 4, 2), SelectionRange: rng(1, 4, 1, 7),
+			Children: []DocumentSymbol{
+				{Name: "sum", Detail: "(a + b)", Kind: SymbolKindVariable,
+					Range: rng(2, 2, 2, 18), SelectionRange: rng(2, 6, 2, 9)},
+			}},
+	}
+	if !reflect.DeepEqual(symbols, expected) {
+		t.Errorf("symbols wrong.\nwant=%+v\ngot =%+v", expected, symbols)
+	}
+}
+
+func TestHover(t *testing.T) {
+	c := newFakeClient(t)
+	c.open(symbolsInput)
+	c.diagnostics()
+
+	tests := []struct {
+		line      int
+		character int
+		expected  string
+		r         Range
+	}{
+		{5, 5, "let one = 1;", rng(5, 4, 5, 7)},
+		{3, 3, "let sum = (a + b);", rng(3, 2, 3, 5)},
+		{2, 12, "parameter a of fn(a, b) let sum = (a + b);sum", rng(2, 12, 2, 13)},
+		{2, 14, "(a + b)", rng(2, 12, 2, 17)},
+		{5, 3, "add(one, 2)", rng(5, 0, 5, 11)},
+	}
+	for i, tt := range tests {
+		var hover Hover
+		if err := c.request("textDocument/hover", position(tt.line, tt.character), &hover); err != nil {
+			t.Fatalf("[%d] unexpected error: %v", i, err)
+		}
+		want := "```monkey\n" + tt.expected + "\n```"
+		if hover.Contents.Value != want {
+			t.Errorf("[%d] hover wrong. want=%q, got=%q", i, want, hover.Contents.Value)
+		}
+		if hover.Range == nil || *hover.Range != tt.r {
+			t.Errorf("[%d] hover range wrong. want=%+v, got=%+v", i, tt.r, hover.Range)
+		}
+	}
+
+	var hover *Hover
+	c.request("textDocument/hover", position(6, 0), &hover)
+	if hover != nil {
+		t.Errorf("hover on empty line is not null. got=%+v", hover)
+	}
+}
+
+// TestIncompleteDocument checks that the requests on documents with parse
+// errors, whose ASTs miss some nodes, do not fail.
+func TestIncompleteDocument(t *testing.T) {
+	inputs := []string{
+		"let a = fn(x) { x };\nlet b = -",
+		"- ",
+		"1 +",
+		"let c = if (",
+		"if (x) {",
+		"let f = fn(a, b) { a + ",
+		"f(1, ",
+		"let g = fn() { return -; }",
+		"let x: = 1;",
+	}
+	for _, input := range inputs {
+		d := newDocument(testURI, input)
+		d.diagnostics()
+		d.symbols()
+		for offset := 0; offset <= len(input); offset++ {
+			d.hover(offset)
+			d.definition(offset)
+		}
+	}
+
+	d := newDocument(testURI, "let a = fn(x) { x };\nlet b = -")
+	symbols := d.symbols()
+	if len(symbols) != 2 || symbols[1].Name != "b" || symbols[1].Detail != "(-)" {
+		t.Errorf("symbols wrong. got=%+v", symbols)
+	}
+	hover := newDocument(testURI, "- ").hover(0)
+	if hover == nil || hover.Contents.Value != "```monkey\n(-)\n```" {
+		t.Errorf("hover wrong. got=%+v", hover)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	c := newFakeClient(t)
+	c.open(symbolsInput)
+	c.diagnostics()
+
+	tests := []struct {
+		line      int
+		character int
+		expected  *Range
+	}{
+		{5, 0, &Range{Start: Position{1, 4}, End: Position{1, 7}}},
+		{5, 7, &Range{Start: Position{0, 4}, End: Position{0, 7}}},
+		{2, 16, &Range{Start: Position{1, 16}, End: Position{1, 17}}},
+		{3, 5, &Range{Start: Position{2, 6}, End: Position{2, 9}}},
+		{5, 10, nil},
+	}
+	for i, tt := range tests {
+		var loc *Location
+		if err := c.request("textDocument/definition", position(tt.line, tt.character), &loc); err != nil {
+			t.Fatalf("[%d] unexpected error: %v", i, err)
+		}
+		if tt.expected == nil {
+			if loc != nil {
+				t.Errorf("[%d] definition is not null. got=%+v", i, loc)
+			}
+			continue
+		}
+		if loc == nil || loc.URI != testURI || loc.Range != *tt.expected {
+			t.Errorf("[%d] definition wrong. want=%+v, got=%+v", i, tt.expected, loc)
+		}
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	c := newFakeClient(t)
+	c.open("let x=fn(a){a*2};\nx( 1 )")
+	c.diagnostics()
+
+	params := &DocumentFormattingParams{TextDocument: TextDocumentIdentifier{URI: testURI}}
+	var edits []TextEdit
+	c.request("textDocument/formatting", params, &edits)
+	expected := []TextEdit{{
+		Range:   rng(0, 0, 1, 6),
+		NewText: "let x = fn(a) {\n    a * 2\n};\nx(1)\n",
+	}}
+	if !reflect.DeepEqual(edits, expected) {
+		t.Errorf("edits wrong.\nwant=%+v\ngot =%+v", expected, edits)
+	}
+}
+
+func TestUnknownRequests(t *testing.T) {
+	c := newFakeClient(t)
+
+	if err := c.request("textDocument/hover", position(0, 0), nil); err == nil || err.Code != codeInvalidParams {
+		t.Errorf("hover on unknown document should fail. got=%v", err)
+	}
+	if err := c.request("workspace/symbol", map[string]interface{}{}, nil); err == nil || err.Code != codeMethodNotFound {
+		t.Errorf("unknown method should fail. got=%v", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	c := newFakeClient(t)
+
+	if err := c.request("shutdown", nil, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.request("textDocument/hover", position(0, 0), nil); err == nil || err.Code != codeInvalidRequest {
+		t.Errorf("request after shutdown should fail. got=%v", err)
+	}
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Errorf("Serve returned error: %v", err)
+	}
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	c := newFakeClient(t)
+
+	c.notify("exit", nil)
+	if err := <-c.done; err != ErrNoShutdown {
+		t.Errorf("Serve should return ErrNoShutdown. got=%v", err)
+	}
+}
+
+func TestPositionConversion(t *testing.T) {
+	d := newDocument(testURI, "// héllo 😀\né x\n")
+
+	tests := []struct {
+		offset int
+		pos    Position
+	}{
+		{0, Position{0, 0}},
+		{4, Position{0, 4}},
+		{6, Position{0, 5}},
+		{10, Position{0, 9}},
+		{14, Position{0, 11}},
+		{15, Position{1, 0}},
+		{18, Position{1, 2}},
+		{20, Position{2, 0}},
+	}
+	for i, tt := range tests {
+		if pos := d.position(tt.offset); pos != tt.pos {
+			t.Errorf("[%d] position(%d) wrong. want=%+v, got=%+v", i, tt.offset, tt.pos, pos)
+		}
+		if offset := d.offset(tt.pos); offset != tt.offset {
+			t.Errorf("[%d] offset(%+v) wrong. want=%d, got=%d", i, tt.pos, tt.offset, offset)
+		}
+	}
+}
+
+func TestReadMessageLength(t *testing.T) {
+	tests := []string{
+		"Content-Length: -1\r\n\r\n",
+		"Content-Length: 1099511627776\r\n\r\n",
+		"Content-Length: x\r\n\r\n",
+	}
+	for _, input := range tests {
+		if _, err := readMessage(bufio.NewReader(strings.NewReader(input))); err == nil {
+			t.Errorf("readMessage(%q) should fail", input)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package lsp
+
+// Types of the Language Server Protocol used by the server.
+// See https://microsoft.github.io/language-server-protocol/specification
+
+// Position represents a zero-based position in a text document.
+// Character counts UTF-16 code units.
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// Range represents a range in a text document.
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// Location represents a range in a text document.
+type Location struct {
+	URI   string `json:"uri"`
+	Range Range  `json:"range"`
+}
+
+// Diagnostic represents a problem in a text document.
+type Diagnostic struct {
+	Range    Range  `json:"range"`
+	Severity int    `json:"severity"`
+	Source   string `json:"source"`
+	Message  string `json:"message"`
+}
+
+// DiagnosticSeverity constants
+const (
+	SeverityError   = 1
+	SeverityWarning = 2
+)
+
+// TextEdit represents a replacement of a range with NewText.
+type TextEdit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
+// DocumentSymbol represents a symbol defined in a text document.
+type DocumentSymbol struct {
+	Name           string           `json:"name"`
+	Detail         string           `json:"detail,omitempty"`
+	Kind           int              `json:"kind"`
+	Range          Range            `json:"range"`
+	SelectionRange Range            `json:"selectionRange"`
+	Children       []DocumentSymbol `json:"children,omitempty"`
+}
+
+// SymbolKind constants
+const (
+	SymbolKindFunction = 12
+	SymbolKindVariable = 13
+)
+
+// MarkupContent represents formatted text.
+type MarkupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// Hover represents the result of a hover request.
+type Hover struct {
+	Contents MarkupContent `json:"contents"`
+	Range    *Range        `json:"range,omitempty"`
+}
+
+// TextDocumentIdentifier identifies a text document.
+type TextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+// TextDocumentItem represents an opened text document.
+type TextDocumentItem struct {
+	URI        string `json:"uri"`
+	LanguageID string `json:"languageId"`
+	Version    int    `json:"version"`
+	Text       string `json:"text"`
+}
+
+// DidOpenTextDocumentParams is the parameter of textDocument/didOpen.
+type DidOpenTextDocumentParams struct {
+	TextDocument TextDocumentItem `json:"textDocument"`
+}
+
+// TextDocumentContentChangeEvent represents a change of a text document.
+// Only full document synchronization is supported, so Text is the whole text.
+type TextDocumentContentChangeEvent struct {
+	Text string `json:"text"`
+}
+
+// DidChangeTextDocumentParams is the parameter of textDocument/didChange.
+type DidChangeTextDocumentParams struct {
+	TextDocument   TextDocumentIdentifier           `json:"textDocument"`
+	ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
+}
+
+// DidCloseTextDocumentParams is the parameter of textDocument/didClose.
+type DidCloseTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// TextDocumentPositionParams is the parameter of requests at a position.
+type TextDocumentPositionParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+}
+
+// DocumentSymbolParams is the parameter of textDocument/documentSymbol.
+type DocumentSymbolParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// DocumentFormattingParams is the parameter of textDocument/formatting.
+type DocumentFormattingParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// PublishDiagnosticsParams is the parameter of textDocument/publishDiagnostics.
+type PublishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// InitializeResult is the result of initialize.
+type InitializeResult struct {
+	Capabilities ServerCapabilities `json:"capabilities"`
+	ServerInfo   ServerInfo         `json:"serverInfo"`
+}
+
+// ServerCapabilities represents the features the server provides.
+type ServerCapabilities struct {
+	TextDocumentSync           int  `json:"textDocumentSync"`
+	HoverProvider              bool `json:"hoverProvider"`
+	DefinitionProvider         bool `json:"definitionProvider"`
+	DocumentSymbolProvider     bool `json:"documentSymbolProvider"`
+	DocumentFormattingProvider bool `json:"documentFormattingProvider"`
+}
+
+// ServerInfo represents the name of the server.
+type ServerInfo struct {
+	Name string `json:"name"`
+}
+
+// TextDocumentSyncKind constants
+const (
+	SyncFull = 1
+)
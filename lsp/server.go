@@ -0,0 +1,234 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/oohira/monkey/format"
+)
+
+// Server is a Language Server Protocol server for Monkey programs.
+// It supports diagnostics, document symbols, hover, go to definition and
+// formatting with full text document synchronization.
+type Server struct {
+	in       *bufio.Reader
+	out      io.Writer
+	mu       sync.Mutex // guards out
+	docs     map[string]*document
+	shutdown bool
+}
+
+// NewServer returns a Server that reads messages from in and writes to out.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:   bufio.NewReader(in),
+		out:  out,
+		docs: map[string]*document{},
+	}
+}
+
+// ErrNoShutdown is returned by Serve if the client exits or closes the
+// connection without a shutdown request.
+var ErrNoShutdown = errors.New("exit without shutdown request")
+
+// Serve handles messages until an exit notification or the end of input.
+func (s *Server) Serve() error {
+	for {
+		body, err := readMessage(s.in)
+		if err == io.EOF {
+			return s.exitError()
+		}
+		if err != nil {
+			return err
+		}
+
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			s.replyError(nil, &responseError{Code: codeParseError, Message: err.Error()})
+			continue
+		}
+		if msg.Method == "exit" {
+			return s.exitError()
+		}
+		if msg.Method == "" {
+			// ignore responses since the server sends no request
+			continue
+		}
+		if msg.ID == nil {
+			s.notify(&msg)
+			continue
+		}
+		result, rerr := s.handle(&msg)
+		if rerr != nil {
+			s.replyError(msg.ID, rerr)
+		} else {
+			s.reply(msg.ID, result)
+		}
+	}
+}
+
+func (s *Server) exitError() error {
+	if s.shutdown {
+		return nil
+	}
+	return ErrNoShutdown
+}
+
+// handle handles a request and returns its result.
+func (s *Server) handle(msg *message) (result interface{}, rerr *responseError) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, rerr = nil, &responseError{Code: codeInternalError, Message: fmt.Sprint(r)}
+		}
+	}()
+
+	if s.shutdown {
+		return nil, &responseError{Code: codeInvalidRequest, Message: "server is shut down"}
+	}
+
+	switch msg.Method {
+	case "initialize":
+		return &InitializeResult{
+			Capabilities: ServerCapabilities{
+				TextDocumentSync:           SyncFull,
+				HoverProvider:              true,
+				DefinitionProvider:         true,
+				DocumentSymbolProvider:     true,
+				DocumentFormattingProvider: true,
+			},
+			ServerInfo: ServerInfo{Name: "monkey"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		d, rerr := s.document(msg, &params, &params.TextDocument)
+		if rerr != nil {
+			return nil, rerr
+		}
+		if h := d.hover(d.offset(params.Position)); h != nil {
+			return h, nil
+		}
+		return nil, nil
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		d, rerr := s.document(msg, &params, &params.TextDocument)
+		if rerr != nil {
+			return nil, rerr
+		}
+		if loc := d.definition(d.offset(params.Position)); loc != nil {
+			return loc, nil
+		}
+		return nil, nil
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		d, rerr := s.document(msg, &params, &params.TextDocument)
+		if rerr != nil {
+			return nil, rerr
+		}
+		return d.symbols(), nil
+	case "textDocument/formatting":
+		var params DocumentFormattingParams
+		d, rerr := s.document(msg, &params, &params.TextDocument)
+		if rerr != nil {
+			return nil, rerr
+		}
+		if len(d.errors) > 0 {
+			return nil, nil
+		}
+		formatted := format.Tree(d.tree)
+		if formatted == d.text {
+			return []TextEdit{}, nil
+		}
+		whole := Range{End: d.position(len(d.text))}
+		return []TextEdit{{Range: whole, NewText: formatted}}, nil
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: "method not found: " + msg.Method}
+}
+
+// document decodes the params of msg and returns the document it refers to.
+func (s *Server) document(msg *message, params interface{}, id *TextDocumentIdentifier) (*document, *responseError) {
+	if err := json.Unmarshal(msg.Params, params); err != nil {
+		return nil, &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	d, ok := s.docs[id.URI]
+	if !ok {
+		return nil, &responseError{Code: codeInvalidParams, Message: "unknown document: " + id.URI}
+	}
+	return d, nil
+}
+
+// notify handles a notification. A notification has no response, so a
+// panic while handling it is ignored.
+func (s *Server) notify(msg *message) {
+	defer func() {
+		recover()
+	}()
+
+	switch msg.Method {
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if json.Unmarshal(msg.Params, &params) == nil {
+			s.update(params.TextDocument.URI, params.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if json.Unmarshal(msg.Params, &params) == nil && len(params.ContentChanges) > 0 {
+			last := params.ContentChanges[len(params.ContentChanges)-1]
+			s.update(params.TextDocument.URI, last.Text)
+		}
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if json.Unmarshal(msg.Params, &params) == nil {
+			delete(s.docs, params.TextDocument.URI)
+			s.publish(params.TextDocument.URI, []Diagnostic{})
+		}
+	}
+}
+
+// update parses the text of a document and publishes its diagnostics. If
+// the analysis panics, the document is dropped and the panic is published
+// as its diagnostic.
+func (s *Server) update(uri, text string) {
+	defer func() {
+		if r := recover(); r != nil {
+			delete(s.docs, uri)
+			s.publish(uri, []Diagnostic{{
+				Severity: SeverityError,
+				Source:   "monkey",
+				Message:  "internal error: " + fmt.Sprint(r),
+			}})
+		}
+	}()
+
+	d := newDocument(uri, text)
+	s.docs[uri] = d
+	s.publish(uri, d.diagnostics())
+}
+
+func (s *Server) publish(uri string, diagnostics []Diagnostic) {
+	s.write(&notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  &PublishDiagnosticsParams{URI: uri, Diagnostics: diagnostics},
+	})
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}) {
+	s.write(&response{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func (s *Server) replyError(id *json.RawMessage, err *responseError) {
+	s.write(&errorResponse{JSONRPC: "2.0", ID: id, Error: err})
+}
+
+func (s *Server) write(v interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeMessage(s.out, v)
+}
@@ -0,0 +1,484 @@
+// Package mkc reads and writes compiled Monkey modules (.mkc files).
+//
+// A module consists of a header and a payload:
+//
+//	magic    4 bytes  "\x7fMKC"
+//	version  2 bytes  big-endian, currently 3
+//	length   4 bytes  big-endian length of the payload
+//	checksum 4 bytes  big-endian CRC-32 (IEEE) of the payload
+//	payload
+//
+// The payload holds the names of the globals, the constants and the main
+// instructions. Counts and lengths are encoded as unsigned varints.
+// A constant starts with a tag byte: an integer in int64 range is a signed
+// varint, a big integer is a sign byte and the big-endian bytes of its
+// magnitude, and a function is the number of locals, the number of
+// parameters, its instructions and its source.
+package mkc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/big"
+
+	"github.com/oohira/monkey/code"
+	"github.com/oohira/monkey/compiler"
+	"github.com/oohira/monkey/object"
+)
+
+// Magic is the first bytes of a module.
+const Magic = "\x7fMKC"
+
+// Version is the version of the format written by Write.
+const Version = 3
+
+const headerSize = len(Magic) + 2 + 4 + 4
+
+// tags of constants
+const (
+	tagInteger    = 'i'
+	tagBigInteger = 'I'
+	tagFunction   = 'f'
+)
+
+// Errors returned by Read
+var (
+	ErrNotModule = errors.New("mkc: not a compiled Monkey module")
+	ErrChecksum  = errors.New("mkc: checksum mismatch")
+)
+
+// IsModule reports whether data starts with the magic of a module.
+func IsModule(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(Magic))
+}
+
+// Write writes bytecode as a module.
+func Write(w io.Writer, bytecode *compiler.Bytecode) error {
+	var payload bytes.Buffer
+
+	writeUvarint(&payload, uint64(len(bytecode.Globals)))
+	for _, name := range bytecode.Globals {
+		writeBytes(&payload, []byte(name))
+	}
+
+	writeUvarint(&payload, uint64(len(bytecode.Constants)))
+	for _, c := range bytecode.Constants {
+		switch c := c.(type) {
+		case *object.Integer:
+			if !c.IsBig() {
+				payload.WriteByte(tagInteger)
+				payload.Write(binary.AppendVarint(nil, c.Value))
+				continue
+			}
+			payload.WriteByte(tagBigInteger)
+			payload.WriteByte(byte(c.Big.Sign() + 1))
+			writeBytes(&payload, c.Big.Bytes())
+		case *object.CompiledFunction:
+			payload.WriteByte(tagFunction)
+			writeUvarint(&payload, uint64(c.NumLocals))
+			writeUvarint(&payload, uint64(c.NumParameters))
+			writeBytes(&payload, c.Instructions)
+			writeBytes(&payload, []byte(c.Source))
+		default:
+			return fmt.Errorf("mkc: cannot write constant of type %s", c.Type())
+		}
+	}
+
+	writeBytes(&payload, bytecode.Instructions)
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, Magic...)
+	header = binary.BigEndian.AppendUint16(header, Version)
+	header = binary.BigEndian.AppendUint32(header, uint32(payload.Len()))
+	header = binary.BigEndian.AppendUint32(header, crc32.ChecksumIEEE(payload.Bytes()))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload.Bytes())
+	return err
+}
+
+func writeUvarint(buf *bytes.Buffer, x uint64) {
+	buf.Write(binary.AppendUvarint(nil, x))
+}
+
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	writeUvarint(buf, uint64(len(b)))
+	buf.Write(b)
+}
+
+// Read reads a module and returns its bytecode. It verifies the checksum
+// and that the instructions refer only to existing constants, globals,
+// locals, free variables and positions, and never pop more elements than
+// pushed in the function.
+func Read(r io.Reader) (*compiler.Bytecode, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrNotModule
+		}
+		return nil, err
+	}
+	if !IsModule(header) {
+		return nil, ErrNotModule
+	}
+	if version := binary.BigEndian.Uint16(header[4:]); version != Version {
+		return nil, fmt.Errorf("mkc: unsupported version %d", version)
+	}
+	length := binary.BigEndian.Uint32(header[6:])
+	checksum := binary.BigEndian.Uint32(header[10:])
+
+	payload, err := io.ReadAll(io.LimitReader(r, int64(length)))
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) != int(length) {
+		return nil, errors.New("mkc: truncated module")
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return nil, ErrChecksum
+	}
+
+	d := &decoder{r: bytes.NewReader(payload)}
+	bytecode := d.bytecode()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if err := verify(bytecode); err != nil {
+		return nil, err
+	}
+	return bytecode, nil
+}
+
+// decoder decodes a payload. It records the first error and returns zero
+// values after that.
+type decoder struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (d *decoder) bytecode() *compiler.Bytecode {
+	bytecode := &compiler.Bytecode{}
+
+	n := d.count()
+	for i := 0; i < n && d.err == nil; i++ {
+		bytecode.Globals = append(bytecode.Globals, string(d.bytes()))
+	}
+
+	n = d.count()
+	for i := 0; i < n && d.err == nil; i++ {
+		if c := d.constant(); c != nil {
+			bytecode.Constants = append(bytecode.Constants, c)
+		}
+	}
+
+	bytecode.Instructions = d.bytes()
+	if d.err == nil {
+		if _, err := d.r.ReadByte(); err != io.EOF {
+			d.fail("trailing data")
+		}
+	}
+	return bytecode
+}
+
+func (d *decoder) constant() object.Object {
+	tag := d.byte()
+	switch tag {
+	case tagInteger:
+		v, err := binary.ReadVarint(d.r)
+		if err != nil {
+			d.fail("invalid integer")
+			return nil
+		}
+		return object.NewInteger(v)
+	case tagBigInteger:
+		sign := int(d.byte()) - 1
+		if sign > 1 {
+			d.fail("invalid integer")
+			return nil
+		}
+		v := new(big.Int).SetBytes(d.bytes())
+		if sign < 0 {
+			v.Neg(v)
+		}
+		return object.NewBigInteger(v)
+	case tagFunction:
+		numLocals := d.number(compiler.MaxLocals)
+		numParameters := d.number(compiler.MaxArguments)
+		return &object.CompiledFunction{
+			Instructions:  d.bytes(),
+			NumLocals:     numLocals,
+			NumParameters: numParameters,
+			Source:        string(d.bytes()),
+		}
+	}
+	if d.err == nil {
+		d.fail(fmt.Sprintf("unknown constant tag %d", tag))
+	}
+	return nil
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	b, err := d.r.ReadByte()
+	if err != nil {
+		d.fail("truncated payload")
+	}
+	return b
+}
+
+// count reads a count or a length, which never exceeds the rest of the
+// payload.
+func (d *decoder) count() int {
+	return d.number(d.r.Len())
+}
+
+// number reads a number not greater than max.
+func (d *decoder) number(max int) int {
+	if d.err != nil {
+		return 0
+	}
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil || n > uint64(max) {
+		d.fail("invalid number")
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.count()
+	if d.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		d.fail("truncated payload")
+	}
+	return b
+}
+
+func (d *decoder) fail(msg string) {
+	if d.err == nil {
+		d.err = errors.New("mkc: " + msg)
+	}
+}
+
+// verify checks that the instructions of the bytecode are well-formed.
+func verify(bytecode *compiler.Bytecode) error {
+	functions := map[int]*object.CompiledFunction{}
+	var indexes []int
+	for i, c := range bytecode.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			if fn.NumParameters > fn.NumLocals {
+				return fmt.Errorf("mkc: fn#%d has more parameters than locals", i)
+			}
+			functions[i] = fn
+			indexes = append(indexes, i)
+		}
+	}
+
+	// numFree[i] is the least number of free variables the function i
+	// is closed over
+	numFree := map[int]int{}
+	collect := func(name string, ins code.Instructions) error {
+		return walk(name, ins, func(pos int, op code.Opcode, operands []int) error {
+			if op != code.OpClosure {
+				return nil
+			}
+			if _, ok := functions[operands[0]]; !ok {
+				return invalid(name, pos, "constant %d is not a function", operands[0])
+			}
+			if n, ok := numFree[operands[0]]; !ok || operands[1] < n {
+				numFree[operands[0]] = operands[1]
+			}
+			return nil
+		})
+	}
+	if err := collect("main", bytecode.Instructions); err != nil {
+		return err
+	}
+	for _, i := range indexes {
+		fn := functions[i]
+		if err := collect(fmt.Sprintf("fn#%d", i), fn.Instructions); err != nil {
+			return err
+		}
+	}
+
+	if err := verifyOperands(bytecode, "main", bytecode.Instructions, 0, 0); err != nil {
+		return err
+	}
+	if err := verifyStack("main", bytecode.Instructions); err != nil {
+		return err
+	}
+	for _, i := range indexes {
+		fn := functions[i]
+		name := fmt.Sprintf("fn#%d", i)
+		if err := verifyOperands(bytecode, name, fn.Instructions, fn.NumLocals, numFree[i]); err != nil {
+			return err
+		}
+		if err := verifyStack(name, fn.Instructions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func verifyOperands(bytecode *compiler.Bytecode, name string, ins code.Instructions, numLocals, numFree int) error {
+	return walk(name, ins, func(pos int, op code.Opcode, operands []int) error {
+		switch op {
+		case code.OpConstant, code.OpClosure:
+			if operands[0] >= len(bytecode.Constants) {
+				return invalid(name, pos, "constant %d out of range", operands[0])
+			}
+		case code.OpGetGlobal, code.OpSetGlobal:
+			if operands[0] >= len(bytecode.Globals) {
+				return invalid(name, pos, "global %d out of range", operands[0])
+			}
+		case code.OpGetLocal, code.OpSetLocal, code.OpMakeCell, code.OpGetCell, code.OpSetCell, code.OpTryLocal, code.OpTryCell:
+			if operands[0] >= numLocals {
+				return invalid(name, pos, "local %d out of range", operands[0])
+			}
+		case code.OpGetFree, code.OpGetFreeCell, code.OpTryFree:
+			if operands[0] >= numFree {
+				return invalid(name, pos, "free variable %d out of range", operands[0])
+			}
+		}
+		switch op {
+		case code.OpJump, code.OpJumpNotTruthy:
+			if operands[0] > len(ins) {
+				return invalid(name, pos, "jump to %d out of range", operands[0])
+			}
+		case code.OpTryLocal, code.OpTryCell, code.OpTryFree:
+			if operands[1] > len(ins) {
+				return invalid(name, pos, "jump to %d out of range", operands[1])
+			}
+		}
+		return nil
+	})
+}
+
+// verifyStack checks that the instructions of ins reachable from the
+// first one never pop more elements than pushed in the function, and that
+// the stack has the same depth on all paths to an instruction. Jumps must
+// land on an instruction or the end of ins.
+func verifyStack(name string, ins code.Instructions) error {
+	starts := map[int]bool{len(ins): true}
+	walk(name, ins, func(pos int, op code.Opcode, operands []int) error {
+		starts[pos] = true
+		return nil
+	})
+
+	depths := map[int]int{0: 0} // depths of the stack before the instructions
+	work := []int{0}
+	next := func(from, pos, depth int) error {
+		if !starts[pos] {
+			return invalid(name, from, "jump to %d inside an instruction", pos)
+		}
+		if d, ok := depths[pos]; ok {
+			if d != depth {
+				return invalid(name, from, "stack depth %d differs from %d at %04d", depth, d, pos)
+			}
+			return nil
+		}
+		depths[pos] = depth
+		work = append(work, pos)
+		return nil
+	}
+
+	for len(work) > 0 {
+		pos := work[len(work)-1]
+		work = work[:len(work)-1]
+		if pos == len(ins) {
+			continue
+		}
+		def, _ := code.Lookup(ins[pos])
+		operands, read := code.ReadOperands(def, ins[pos+1:])
+		op := code.Opcode(ins[pos])
+		pops, pushes := stackEffect(op, operands)
+		depth := depths[pos]
+		if depth < pops {
+			return invalid(name, pos, "stack underflow")
+		}
+		depth += pushes - pops
+
+		var err error
+		switch op {
+		case code.OpJump:
+			err = next(pos, operands[0], depth)
+		case code.OpJumpNotTruthy:
+			if err = next(pos, pos+1+read, depth); err == nil {
+				err = next(pos, operands[0], depth)
+			}
+		case code.OpTryLocal, code.OpTryCell, code.OpTryFree:
+			if err = next(pos, pos+1+read, depth); err == nil {
+				err = next(pos, operands[1], depth+1)
+			}
+		case code.OpReturnValue, code.OpReturn:
+		default:
+			err = next(pos, pos+1+read, depth)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stackEffect returns the numbers of elements an instruction pops from and
+// pushes onto the stack. A try instruction pushes an element only when it
+// jumps.
+func stackEffect(op code.Opcode, operands []int) (pops, pushes int) {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull,
+		code.OpGetGlobal, code.OpGetLocal, code.OpGetCell, code.OpGetFree, code.OpGetFreeCell:
+		return 0, 1
+	case code.OpPop, code.OpJumpNotTruthy, code.OpSetGlobal, code.OpSetLocal, code.OpSetCell, code.OpReturnValue:
+		return 1, 0
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+		code.OpEqual, code.OpNotEqual, code.OpLessThan, code.OpGreaterThan:
+		return 2, 1
+	case code.OpMinus, code.OpBang:
+		return 1, 1
+	case code.OpClosure:
+		return operands[1], 1
+	case code.OpCall:
+		return operands[0] + 1, 1
+	}
+	return 0, 0
+}
+
+// walk calls f for each instruction of ins after checking that the opcode
+// is defined and the operands are not truncated.
+func walk(name string, ins code.Instructions, f func(pos int, op code.Opcode, operands []int) error) error {
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			return invalid(name, i, "%s", err)
+		}
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if i+1+width > len(ins) {
+			return invalid(name, i, "truncated %s", def.Name)
+		}
+		operands, read := code.ReadOperands(def, ins[i+1:])
+		if err := f(i, code.Opcode(ins[i]), operands); err != nil {
+			return err
+		}
+		i += 1 + read
+	}
+	return nil
+}
+
+func invalid(name string, pos int, format string, a ...interface{}) error {
+	return fmt.Errorf("mkc: %s: invalid instruction at %04d: %s", name, pos, fmt.Sprintf(format, a...))
+}
@@ -0,0 +1,168 @@
+package mkc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+	"testing"
+
+	"github.com/oohira/monkey/code"
+	"github.com/oohira/monkey/compiler"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/object"
+	"github.com/oohira/monkey/parser"
+	"github.com/oohira/monkey/vm"
+)
+
+func compile(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return c.Bytecode()
+}
+
+func disassemble(t *testing.T, bytecode *compiler.Bytecode) string {
+	t.Helper()
+	var out strings.Builder
+	if err := compiler.Disassemble(&out, bytecode); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return out.String()
+}
+
+func TestRoundTrip(t *testing.T) {
+	bytecode := compile(t, `
+let big = -99999999999999999999;
+let adder = fn(x) { fn(y) { x + y } };
+adder(-3)(big * 2)`)
+
+	var buf bytes.Buffer
+	if err := Write(&buf, bytecode); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	if !IsModule(buf.Bytes()) {
+		t.Fatalf("written module does not start with the magic")
+	}
+	read, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("read error: %s", err)
+	}
+
+	if want, got := disassemble(t, bytecode), disassemble(t, read); want != got {
+		t.Errorf("bytecode changed.\nwant=\n%s\ngot=\n%s", want, got)
+	}
+	machine := vm.New(read)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if got := machine.LastPoppedStackElem().Inspect(); got != "-200000000000000000001" {
+		t.Errorf("result wrong. got=%s", got)
+	}
+}
+
+// module returns a module with the payload and a valid header.
+func module(payload []byte) []byte {
+	header := []byte(Magic)
+	header = binary.BigEndian.AppendUint16(header, Version)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(payload)))
+	header = binary.BigEndian.AppendUint32(header, crc32.ChecksumIEEE(payload))
+	return append(header, payload...)
+}
+
+func TestReadErrors(t *testing.T) {
+	var valid bytes.Buffer
+	if err := Write(&valid, compile(t, "1")); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	corrupted := append([]byte(nil), valid.Bytes()...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	newer := append([]byte(nil), valid.Bytes()...)
+	newer[5] = Version + 1
+
+	encode := func(bytecode *compiler.Bytecode) []byte {
+		var buf bytes.Buffer
+		if err := Write(&buf, bytecode); err != nil {
+			t.Fatalf("write error: %s", err)
+		}
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"empty", nil, "mkc: not a compiled Monkey module"},
+		{"source", []byte("let x = 1; x + 1;"), "mkc: not a compiled Monkey module"},
+		{"version", newer, "mkc: unsupported version 4"},
+		{"checksum", corrupted, "mkc: checksum mismatch"},
+		{"truncated", valid.Bytes()[:valid.Len()-1], "mkc: truncated module"},
+		{"tag", module([]byte{0, 1, 'x'}), "mkc: unknown constant tag 120"},
+		{"length", module([]byte{0, 0, 5, 1}), "mkc: invalid number"},
+		{"trailing", module([]byte{0, 0, 0, 0}), "mkc: trailing data"},
+		{"opcode", encode(&compiler.Bytecode{Instructions: code.Instructions{255}}),
+			"mkc: main: invalid instruction at 0000: opcode 255 undefined"},
+		{"operand", encode(&compiler.Bytecode{Instructions: code.Make(code.OpConstant, 0)[:2]}),
+			"mkc: main: invalid instruction at 0000: truncated OpConstant"},
+		{"constant", encode(&compiler.Bytecode{Instructions: code.Make(code.OpConstant, 1)}),
+			"mkc: main: invalid instruction at 0000: constant 1 out of range"},
+		{"global", encode(&compiler.Bytecode{Instructions: code.Make(code.OpGetGlobal, 0)}),
+			"mkc: main: invalid instruction at 0000: global 0 out of range"},
+		{"local", encode(&compiler.Bytecode{Instructions: code.Make(code.OpGetLocal, 0)}),
+			"mkc: main: invalid instruction at 0000: local 0 out of range"},
+		{"jump", encode(&compiler.Bytecode{Instructions: code.Make(code.OpJump, 4)}),
+			"mkc: main: invalid instruction at 0000: jump to 4 out of range"},
+		{"closure", encode(&compiler.Bytecode{
+			Instructions: code.Make(code.OpClosure, 0, 0),
+			Constants:    []object.Object{object.NewInteger(1)},
+		}), "mkc: main: invalid instruction at 0000: constant 0 is not a function"},
+		{"free", encode(&compiler.Bytecode{
+			Instructions: code.Make(code.OpClosure, 0, 0),
+			Constants: []object.Object{&object.CompiledFunction{
+				Instructions: append(code.Make(code.OpGetFree, 0), code.Make(code.OpReturnValue)...),
+			}},
+		}), "mkc: fn#0: invalid instruction at 0000: free variable 0 out of range"},
+		{"underflow", encode(&compiler.Bytecode{Instructions: code.Make(code.OpCall, 235)}),
+			"mkc: main: invalid instruction at 0000: stack underflow"},
+		{"depth", encode(&compiler.Bytecode{Instructions: concat(
+			code.Make(code.OpTrue),
+			code.Make(code.OpJumpNotTruthy, 5),
+			code.Make(code.OpNull),
+		)}), "mkc: main: invalid instruction at 0004: stack depth 1 differs from 0 at 0005"},
+		{"inside", encode(&compiler.Bytecode{Instructions: concat(
+			code.Make(code.OpJump, 4),
+			code.Make(code.OpConstant, 0),
+		), Constants: []object.Object{object.NewInteger(1)}}),
+			"mkc: main: invalid instruction at 0000: jump to 4 inside an instruction"},
+		{"try", encode(&compiler.Bytecode{
+			Instructions: code.Make(code.OpClosure, 0, 0),
+			Constants: []object.Object{&object.CompiledFunction{
+				Instructions: code.Make(code.OpTryLocal, 0, 9),
+				NumLocals:    1,
+			}},
+		}), "mkc: fn#0: invalid instruction at 0000: jump to 9 out of range"},
+	}
+
+	for _, tt := range tests {
+		_, err := Read(bytes.NewReader(tt.data))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("[%s] error wrong. want=%q, got=%v", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func concat(instructions ...[]byte) code.Instructions {
+	var out code.Instructions
+	for _, ins := range instructions {
+		out = append(out, ins...)
+	}
+	return out
+}
@@ -0,0 +1,35 @@
+package object
+
+// Environment holds the bindings of names to values in a scope.
+type Environment struct {
+	store map[string]Object
+	outer *Environment
+}
+
+// NewEnvironment returns an empty environment.
+func NewEnvironment() *Environment {
+	return &Environment{store: make(map[string]Object)}
+}
+
+// NewEnclosedEnvironment returns an empty environment enclosed by outer.
+func NewEnclosedEnvironment(outer *Environment) *Environment {
+	env := NewEnvironment()
+	env.outer = outer
+	return env
+}
+
+// Get returns the value bound to name in the environment or its outer
+// environments.
+func (e *Environment) Get(name string) (Object, bool) {
+	obj, ok := e.store[name]
+	if !ok && e.outer != nil {
+		obj, ok = e.outer.Get(name)
+	}
+	return obj, ok
+}
+
+// Set binds val to name in the environment and returns val.
+func (e *Environment) Set(name string, val Object) Object {
+	e.store[name] = val
+	return val
+}
@@ -0,0 +1,324 @@
+package object
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/code"
+)
+
+// Type represents the type of an object.
+type Type string
+
+// object Type constants
+const (
+	INTEGER           = "INTEGER"
+	BOOLEAN           = "BOOLEAN"
+	NULL              = "NULL"
+	RETURN_VALUE      = "RETURN_VALUE"
+	ERROR             = "ERROR"
+	FUNCTION          = "FUNCTION"
+	COMPILED_FUNCTION = "COMPILED_FUNCTION"
+	CLOSURE           = "CLOSURE"
+	CELL              = "CELL"
+)
+
+// Object is the interface that represents a value at runtime.
+type Object interface {
+	Type() Type
+	Inspect() string
+}
+
+// ErrDivisionByZero is returned when an integer is divided by zero.
+var ErrDivisionByZero = errors.New("division by zero")
+
+// Integer represents an integer of arbitrary precision.
+// Values that fit in int64 are held in Value and Big is nil;
+// otherwise Big holds the value and Value is meaningless.
+type Integer struct {
+	Value int64
+	Big   *big.Int
+}
+
+// NewInteger returns an Integer of the specified int64 value.
+func NewInteger(v int64) *Integer {
+	return &Integer{Value: v}
+}
+
+// NewBigInteger returns an Integer of the specified value,
+// demoted to int64 representation if it fits.
+func NewBigInteger(v *big.Int) *Integer {
+	if v.IsInt64() {
+		return &Integer{Value: v.Int64()}
+	}
+	return &Integer{Big: v}
+}
+
+// Type returns the type of the integer.
+func (i *Integer) Type() Type {
+	return INTEGER
+}
+
+// Inspect returns a text representation of the integer.
+func (i *Integer) Inspect() string {
+	return i.BigInt().String()
+}
+
+// IsBig reports whether the integer is out of int64 range.
+func (i *Integer) IsBig() bool {
+	return i.Big != nil
+}
+
+// BigInt returns the value of the integer as a newly allocated big.Int.
+func (i *Integer) BigInt() *big.Int {
+	if i.Big != nil {
+		return new(big.Int).Set(i.Big)
+	}
+	return big.NewInt(i.Value)
+}
+
+// Add returns i + j.
+func (i *Integer) Add(j *Integer) *Integer {
+	if !i.IsBig() && !j.IsBig() {
+		sum := i.Value + j.Value
+		// overflow iff both operands have the same sign which differs from the sum
+		if (i.Value >= 0) == (j.Value >= 0) && (sum >= 0) != (i.Value >= 0) {
+			return NewBigInteger(new(big.Int).Add(i.BigInt(), j.BigInt()))
+		}
+		return NewInteger(sum)
+	}
+	return NewBigInteger(new(big.Int).Add(i.BigInt(), j.BigInt()))
+}
+
+// Sub returns i - j.
+func (i *Integer) Sub(j *Integer) *Integer {
+	if !i.IsBig() && !j.IsBig() {
+		diff := i.Value - j.Value
+		// overflow iff operands have different signs and the sign of diff differs from i
+		if (i.Value >= 0) != (j.Value >= 0) && (diff >= 0) != (i.Value >= 0) {
+			return NewBigInteger(new(big.Int).Sub(i.BigInt(), j.BigInt()))
+		}
+		return NewInteger(diff)
+	}
+	return NewBigInteger(new(big.Int).Sub(i.BigInt(), j.BigInt()))
+}
+
+// Mul returns i * j.
+func (i *Integer) Mul(j *Integer) *Integer {
+	if !i.IsBig() && !j.IsBig() {
+		a, b := i.Value, j.Value
+		if a == 0 || b == 0 {
+			return NewInteger(0)
+		}
+		prod := a * b
+		if prod/b == a && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64) {
+			return NewInteger(prod)
+		}
+	}
+	return NewBigInteger(new(big.Int).Mul(i.BigInt(), j.BigInt()))
+}
+
+// Div returns i / j truncated toward zero.
+// It returns ErrDivisionByZero if j is zero.
+func (i *Integer) Div(j *Integer) (*Integer, error) {
+	if !j.IsBig() && j.Value == 0 {
+		return nil, ErrDivisionByZero
+	}
+	if !i.IsBig() && !j.IsBig() && !(i.Value == math.MinInt64 && j.Value == -1) {
+		return NewInteger(i.Value / j.Value), nil
+	}
+	return NewBigInteger(new(big.Int).Quo(i.BigInt(), j.BigInt())), nil
+}
+
+// Neg returns -i.
+func (i *Integer) Neg() *Integer {
+	if !i.IsBig() && i.Value != math.MinInt64 {
+		return NewInteger(-i.Value)
+	}
+	return NewBigInteger(new(big.Int).Neg(i.BigInt()))
+}
+
+// Cmp compares i and j and returns -1, 0 or +1.
+func (i *Integer) Cmp(j *Integer) int {
+	if !i.IsBig() && !j.IsBig() {
+		switch {
+		case i.Value < j.Value:
+			return -1
+		case i.Value > j.Value:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return i.BigInt().Cmp(j.BigInt())
+}
+
+// Boolean represents a boolean value. Only TrueValue and FalseValue exist.
+type Boolean struct {
+	Value bool
+}
+
+// Type returns the type of the boolean.
+func (b *Boolean) Type() Type {
+	return BOOLEAN
+}
+
+// Inspect returns a text representation of the boolean.
+func (b *Boolean) Inspect() string {
+	return fmt.Sprintf("%t", b.Value)
+}
+
+// Null represents the absence of a value. Only NullValue exists.
+type Null struct{}
+
+// Type returns the type of null.
+func (n *Null) Type() Type {
+	return NULL
+}
+
+// Inspect returns a text representation of null.
+func (n *Null) Inspect() string {
+	return "null"
+}
+
+// The only instances of Boolean and Null.
+var (
+	TrueValue  = &Boolean{Value: true}
+	FalseValue = &Boolean{Value: false}
+	NullValue  = &Null{}
+)
+
+// NativeBool returns TrueValue or FalseValue.
+func NativeBool(b bool) *Boolean {
+	if b {
+		return TrueValue
+	}
+	return FalseValue
+}
+
+// IsTruthy reports whether obj is regarded as true in conditions.
+// Everything but false and null is truthy.
+func IsTruthy(obj Object) bool {
+	return obj != FalseValue && obj != NullValue
+}
+
+// ReturnValue wraps the value of a return statement while it leaves
+// the enclosing function.
+type ReturnValue struct {
+	Value Object
+}
+
+// Type returns the type of the return value.
+func (rv *ReturnValue) Type() Type {
+	return RETURN_VALUE
+}
+
+// Inspect returns a text representation of the wrapped value.
+func (rv *ReturnValue) Inspect() string {
+	return rv.Value.Inspect()
+}
+
+// Error represents a runtime error which aborts the evaluation.
+type Error struct {
+	Message string
+}
+
+// Type returns the type of the error.
+func (e *Error) Type() Type {
+	return ERROR
+}
+
+// Inspect returns a text representation of the error.
+func (e *Error) Inspect() string {
+	return "ERROR: " + e.Message
+}
+
+// Function represents a function evaluated from the AST, with the
+// environment it is defined in.
+type Function struct {
+	Parameters []*ast.Identifier
+	Body       *ast.BlockStatement
+	Env        *Environment
+}
+
+// Type returns the type of the function.
+func (f *Function) Type() Type {
+	return FUNCTION
+}
+
+// Inspect returns a text representation of the function.
+func (f *Function) Inspect() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range f.Parameters {
+		params = append(params, p.String())
+	}
+	out.WriteString("fn(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") {\n")
+	out.WriteString(f.Body.String())
+	out.WriteString("\n}")
+
+	return out.String()
+}
+
+// CompiledFunction represents a function compiled to bytecode.
+// NumLocals includes the parameters, and Source is the function literal in
+// the format of Function.Inspect.
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int
+	Source        string
+}
+
+// Type returns the type of the compiled function.
+func (cf *CompiledFunction) Type() Type {
+	return COMPILED_FUNCTION
+}
+
+// Inspect returns a text representation of the compiled function.
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// Closure represents a compiled function with the cells of its free
+// variables.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+// Type returns the type of the closure, which is a function as in the
+// evaluator.
+func (c *Closure) Type() Type {
+	return FUNCTION
+}
+
+// Inspect returns the source of the function in the format of the
+// evaluator.
+func (c *Closure) Inspect() string {
+	return c.Fn.Source
+}
+
+// Cell holds a variable of a compiled function which closures share with
+// the function. Value is nil while the variable is unbound.
+type Cell struct {
+	Value Object
+}
+
+// Type returns the type of the cell.
+func (c *Cell) Type() Type {
+	return CELL
+}
+
+// Inspect returns a text representation of the cell.
+func (c *Cell) Inspect() string {
+	return fmt.Sprintf("Cell[%p]", c)
+}
@@ -0,0 +1,150 @@
+package object
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+func bigInteger(t *testing.T, s string) *Integer {
+	b, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		t.Fatalf("could not parse %q as big.Int", s)
+	}
+	return NewBigInteger(b)
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   *Integer
+		expected string
+		isBig    bool
+	}{
+		{"add", NewInteger(1).Add(NewInteger(2)), "3", false},
+		{"add overflow", NewInteger(math.MaxInt64).Add(NewInteger(1)), "9223372036854775808", true},
+		{"add underflow", NewInteger(math.MinInt64).Add(NewInteger(-1)), "-9223372036854775809", true},
+		{"add demote", bigInteger(t, "9223372036854775808").Add(NewInteger(-1)), "9223372036854775807", false},
+		{"sub", NewInteger(1).Sub(NewInteger(2)), "-1", false},
+		{"sub overflow", NewInteger(math.MinInt64).Sub(NewInteger(1)), "-9223372036854775809", true},
+		{"sub overflow positive", NewInteger(0).Sub(NewInteger(math.MinInt64)), "9223372036854775808", true},
+		{"sub demote", bigInteger(t, "-9223372036854775809").Sub(NewInteger(-1)), "-9223372036854775808", false},
+		{"mul", NewInteger(-6).Mul(NewInteger(7)), "-42", false},
+		{"mul zero", NewInteger(0).Mul(NewInteger(math.MinInt64)), "0", false},
+		{"mul overflow", NewInteger(math.MaxInt64).Mul(NewInteger(2)), "18446744073709551614", true},
+		{"mul min by -1", NewInteger(math.MinInt64).Mul(NewInteger(-1)), "9223372036854775808", true},
+		{"mul big", bigInteger(t, "100000000000000000000").Mul(bigInteger(t, "100000000000000000000")), "10000000000000000000000000000000000000000", true},
+		{"neg", NewInteger(5).Neg(), "-5", false},
+		{"neg min", NewInteger(math.MinInt64).Neg(), "9223372036854775808", true},
+		{"neg demote", bigInteger(t, "9223372036854775808").Neg(), "-9223372036854775808", false},
+	}
+
+	for _, tt := range tests {
+		if tt.result.Inspect() != tt.expected {
+			t.Errorf("[%s] want=%s, got=%s", tt.name, tt.expected, tt.result.Inspect())
+		}
+		if tt.result.IsBig() != tt.isBig {
+			t.Errorf("[%s] IsBig want=%t, got=%t", tt.name, tt.isBig, tt.result.IsBig())
+		}
+	}
+}
+
+func TestIntegerDiv(t *testing.T) {
+	tests := []struct {
+		left     *Integer
+		right    *Integer
+		expected string
+		isBig    bool
+	}{
+		{NewInteger(7), NewInteger(2), "3", false},
+		{NewInteger(-7), NewInteger(2), "-3", false},
+		{NewInteger(math.MinInt64), NewInteger(-1), "9223372036854775808", true},
+		{bigInteger(t, "18446744073709551616"), NewInteger(-4), "-4611686018427387904", false},
+		{bigInteger(t, "-18446744073709551617"), NewInteger(2), "-9223372036854775808", false},
+	}
+
+	for i, tt := range tests {
+		result, err := tt.left.Div(tt.right)
+		if err != nil {
+			t.Fatalf("[%d] unexpected error: %v", i, err)
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("[%d] want=%s, got=%s", i, tt.expected, result.Inspect())
+		}
+		if result.IsBig() != tt.isBig {
+			t.Errorf("[%d] IsBig want=%t, got=%t", i, tt.isBig, result.IsBig())
+		}
+	}
+
+	if _, err := NewInteger(1).Div(NewInteger(0)); err != ErrDivisionByZero {
+		t.Errorf("division by zero should fail. got=%v", err)
+	}
+}
+
+func TestIntegerCmp(t *testing.T) {
+	tests := []struct {
+		left     *Integer
+		right    *Integer
+		expected int
+	}{
+		{NewInteger(1), NewInteger(2), -1},
+		{NewInteger(2), NewInteger(2), 0},
+		{NewInteger(3), NewInteger(2), 1},
+		{bigInteger(t, "9223372036854775808"), NewInteger(math.MaxInt64), 1},
+		{bigInteger(t, "-9223372036854775809"), NewInteger(math.MinInt64), -1},
+	}
+
+	for i, tt := range tests {
+		if got := tt.left.Cmp(tt.right); got != tt.expected {
+			t.Errorf("[%d] want=%d, got=%d", i, tt.expected, got)
+		}
+	}
+}
+
+func TestOperators(t *testing.T) {
+	tests := []struct {
+		result   func() (Object, error)
+		expected string
+	}{
+		{func() (Object, error) { return Prefix("!", NewInteger(0)) }, "false"},
+		{func() (Object, error) { return Prefix("!", NullValue) }, "true"},
+		{func() (Object, error) { return Prefix("-", NewInteger(3)) }, "-3"},
+		{func() (Object, error) { return Prefix("-", TrueValue) }, "unknown operator: -BOOLEAN"},
+		{func() (Object, error) { return Infix("<", NewInteger(1), NewInteger(2)) }, "true"},
+		{func() (Object, error) {
+			return Infix("==", bigInteger(t, "99999999999999999999"), bigInteger(t, "99999999999999999999"))
+		}, "true"},
+		{func() (Object, error) { return Infix("/", NewInteger(1), NewInteger(0)) }, "division by zero"},
+		{func() (Object, error) { return Infix("==", NewInteger(1), TrueValue) }, "false"},
+		{func() (Object, error) { return Infix("!=", NullValue, NullValue) }, "false"},
+		{func() (Object, error) { return Infix("+", NewInteger(1), TrueValue) }, "type mismatch: INTEGER + BOOLEAN"},
+		{func() (Object, error) { return Infix("<", TrueValue, FalseValue) }, "unknown operator: BOOLEAN < BOOLEAN"},
+	}
+
+	for i, tt := range tests {
+		result, err := tt.result()
+		got := ""
+		if err != nil {
+			got = err.Error()
+		} else {
+			got = result.Inspect()
+		}
+		if got != tt.expected {
+			t.Errorf("[%d] want=%s, got=%s", i, tt.expected, got)
+		}
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("x", NewInteger(1))
+	env := NewEnclosedEnvironment(outer)
+	env.Set("y", TrueValue)
+
+	if x, ok := env.Get("x"); !ok || x.Inspect() != "1" {
+		t.Errorf("x wrong. got=%v", x)
+	}
+	if _, ok := outer.Get("y"); ok {
+		t.Errorf("y should not be visible from the outer environment")
+	}
+}
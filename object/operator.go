@@ -0,0 +1,62 @@
+package object
+
+import "fmt"
+
+// Prefix returns the result of the prefix operator applied to right.
+// The evaluator and the virtual machine share it so that they agree on
+// the semantics and the error messages.
+func Prefix(operator string, right Object) (Object, error) {
+	switch operator {
+	case "!":
+		return NativeBool(!IsTruthy(right)), nil
+	case "-":
+		if i, ok := right.(*Integer); ok {
+			return i.Neg(), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operator: %s%s", operator, right.Type())
+}
+
+// Infix returns the result of the infix operator applied to left and right.
+// Integers are compared by value and other objects by identity.
+func Infix(operator string, left, right Object) (Object, error) {
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	switch {
+	case lok && rok:
+		return integerInfix(operator, l, r)
+	case operator == "==":
+		return NativeBool(left == right), nil
+	case operator == "!=":
+		return NativeBool(left != right), nil
+	case left.Type() != right.Type():
+		return nil, fmt.Errorf("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+	}
+	return nil, fmt.Errorf("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+}
+
+func integerInfix(operator string, left, right *Integer) (Object, error) {
+	switch operator {
+	case "+":
+		return left.Add(right), nil
+	case "-":
+		return left.Sub(right), nil
+	case "*":
+		return left.Mul(right), nil
+	case "/":
+		q, err := left.Div(right)
+		if err != nil {
+			return nil, err
+		}
+		return q, nil
+	case "<":
+		return NativeBool(left.Cmp(right) < 0), nil
+	case ">":
+		return NativeBool(left.Cmp(right) > 0), nil
+	case "==":
+		return NativeBool(left.Cmp(right) == 0), nil
+	case "!=":
+		return NativeBool(left.Cmp(right) != 0), nil
+	}
+	return nil, fmt.Errorf("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+}
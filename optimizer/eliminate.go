@@ -0,0 +1,332 @@
+package optimizer
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/resolve"
+	"github.com/oohira/monkey/token"
+)
+
+// Reasons of removals
+const (
+	Unreachable    = "unreachable code"
+	DeadBranch     = "dead branch"
+	UnusedVariable = "unused variable"
+)
+
+// Removal represents code removed by Eliminate.
+type Removal struct {
+	Pos    token.Position
+	Reason string
+	Node   ast.Node
+}
+
+// String returns a description of the removal in the form
+// "line:column: removed reason: code".
+func (r *Removal) String() string {
+	return fmt.Sprintf("%s: removed %s: %s", r.Pos, r.Reason, r.Node)
+}
+
+// Eliminate returns a copy of program without dead code, and the removed
+// code in order of position:
+//
+//	return x; y                     =>  return x;
+//	if (true) { a } else { b }      =>  a
+//	let unused = fn(x) { x }; 1     =>  1
+//
+// A let statement is removed only if its value has no effect, that is, it
+// is a literal, a function literal or an identifier which is always bound,
+// and the name is used nowhere but in the value. Since a block shares the
+// environment of its enclosing scope at runtime, let statements are kept
+// unless every name is used only where the resolver finds it declared.
+func Eliminate(program *ast.Program) (*ast.Program, []*Removal) {
+	program = ast.Clone(program)
+	e := &eliminator{}
+	for {
+		e.info = nil
+		if info := resolve.Resolve(program); analyzable(info) {
+			e.info = info
+		}
+		// removing code may leave more bindings unused or resolved as
+		// at runtime
+		before := program.String()
+		program.Statements = e.statements(program.Statements, true)
+		if program.String() == before {
+			break
+		}
+	}
+	sort.SliceStable(e.removed, func(i, j int) bool {
+		return e.removed[i].Pos.Offset < e.removed[j].Pos.Offset
+	})
+	return program, e.removed
+}
+
+// Optimize returns a copy of program optimized by all the passes, and the
+// code removed by Eliminate.
+func Optimize(program *ast.Program) (*ast.Program, []*Removal) {
+	return Eliminate(Fold(program))
+}
+
+// analyzable reports whether the bindings found by the resolver are the
+// same as at runtime. A redeclaration, a name shadowed in a block and an
+// undefined name are resolved differently from the evaluator.
+func analyzable(info *resolve.Info) bool {
+	for _, p := range info.Problems {
+		if p.Kind != resolve.Shadowed || info.Defs[p.Ident].Scope.Kind == resolve.BlockScope {
+			return false
+		}
+	}
+	return true
+}
+
+type eliminator struct {
+	info    *resolve.Info // nil if unused bindings cannot be found
+	removed []*Removal
+}
+
+func (e *eliminator) remove(pos token.Position, reason string, node ast.Node) {
+	e.removed = append(e.removed, &Removal{Pos: pos, Reason: reason, Node: node})
+}
+
+// statements returns stmts without dead code. valueUsed reports whether the
+// value of the last statement is the value of a program, a function or an
+// if expression whose value is used.
+func (e *eliminator) statements(stmts []ast.Statement, valueUsed bool) []ast.Statement {
+	var out []ast.Statement
+	for i, stmt := range stmts {
+		used := valueUsed && i == len(stmts)-1
+		if branch, ok := e.constantIf(stmt, used); ok {
+			out = append(out, e.statements(branch, used)...)
+			continue
+		}
+		out = append(out, e.statement(stmt, used))
+	}
+
+	for i, stmt := range out {
+		if returns(stmt) {
+			for _, dead := range out[i+1:] {
+				e.remove(statementPos(dead), Unreachable, dead)
+			}
+			out = out[:i+1]
+			break
+		}
+	}
+
+	kept := out[:0]
+	for i, stmt := range out {
+		last := valueUsed && i == len(out)-1
+		if let, ok := stmt.(*ast.LetStatement); ok && !last && e.unused(let) {
+			e.remove(let.Token.Pos, UnusedVariable, let)
+			continue
+		}
+		kept = append(kept, stmt)
+	}
+	return kept
+}
+
+// constantIf returns the statements replacing stmt if it is an if
+// expression with a constant condition. They are the taken branch, unless
+// the value of stmt is used and the branch is empty, in which case stmt is
+// kept for its null value.
+func (e *eliminator) constantIf(stmt ast.Statement, used bool) ([]ast.Statement, bool) {
+	es, ok := stmt.(*ast.ExpressionStatement)
+	if !ok {
+		return nil, false
+	}
+	ie, ok := es.Expression.(*ast.IfExpression)
+	if !ok {
+		return nil, false
+	}
+	truthy, ok := truthiness(ie.Condition)
+	if !ok {
+		return nil, false
+	}
+	taken, dead := ie.Consequence, ie.Alternative
+	if !truthy {
+		taken, dead = ie.Alternative, ie.Consequence
+	}
+	if used && (taken == nil || len(taken.Statements) == 0) {
+		return nil, false
+	}
+	if dead != nil && len(dead.Statements) > 0 {
+		e.remove(dead.Token.Pos, DeadBranch, dead)
+	}
+	if taken == nil {
+		return nil, true
+	}
+	return taken.Statements, true
+}
+
+func (e *eliminator) statement(stmt ast.Statement, used bool) ast.Statement {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		stmt.Value = e.expression(stmt.Value, true)
+	case *ast.ReturnStatement:
+		stmt.ReturnValue = e.expression(stmt.ReturnValue, true)
+	case *ast.ExpressionStatement:
+		stmt.Expression = e.expression(stmt.Expression, used)
+	case *ast.BlockStatement:
+		stmt.Statements = e.statements(stmt.Statements, used)
+	}
+	return stmt
+}
+
+func (e *eliminator) expression(exp ast.Expression, used bool) ast.Expression {
+	switch exp := exp.(type) {
+	case *ast.PrefixExpression:
+		exp.Right = e.expression(exp.Right, true)
+	case *ast.InfixExpression:
+		exp.Left = e.expression(exp.Left, true)
+		exp.Right = e.expression(exp.Right, true)
+	case *ast.IfExpression:
+		exp.Condition = e.expression(exp.Condition, true)
+		if truthy, ok := truthiness(exp.Condition); ok {
+			return e.constantIfExpression(exp, truthy, used)
+		}
+		exp.Consequence.Statements = e.statements(exp.Consequence.Statements, used)
+		if exp.Alternative != nil {
+			exp.Alternative.Statements = e.statements(exp.Alternative.Statements, used)
+		}
+	case *ast.FunctionLiteral:
+		exp.Body.Statements = e.statements(exp.Body.Statements, true)
+	case *ast.CallExpression:
+		exp.Function = e.expression(exp.Function, true)
+		for i, arg := range exp.Arguments {
+			exp.Arguments[i] = e.expression(arg, true)
+		}
+	}
+	return exp
+}
+
+// constantIfExpression returns the expression of the taken branch if it is
+// the only statement of the branch, or otherwise exp with the dead branch
+// emptied.
+func (e *eliminator) constantIfExpression(exp *ast.IfExpression, truthy, used bool) ast.Expression {
+	taken, dead := exp.Consequence, exp.Alternative
+	if !truthy {
+		taken, dead = exp.Alternative, exp.Consequence
+	}
+	if dead != nil && len(dead.Statements) > 0 {
+		e.remove(dead.Token.Pos, DeadBranch, dead)
+	}
+	if truthy {
+		exp.Alternative = nil
+	} else {
+		exp.Consequence = &ast.BlockStatement{Token: exp.Consequence.Token}
+	}
+	if taken == nil {
+		return exp
+	}
+
+	taken.Statements = e.statements(taken.Statements, used)
+	if len(taken.Statements) == 1 {
+		if es, ok := taken.Statements[0].(*ast.ExpressionStatement); ok {
+			return es.Expression
+		}
+	}
+	return exp
+}
+
+// unused reports whether let can be removed.
+func (e *eliminator) unused(let *ast.LetStatement) bool {
+	if e.info == nil {
+		return false
+	}
+	sym := e.info.Defs[let.Name]
+	if sym == nil {
+		return false
+	}
+	inValue := contained(let.Value)
+	for _, use := range sym.Uses {
+		if !inValue[use] {
+			return false
+		}
+	}
+	return e.pure(let)
+}
+
+// pure reports whether evaluating the value of let has no effect.
+func (e *eliminator) pure(let *ast.LetStatement) bool {
+	switch value := let.Value.(type) {
+	case *ast.IntegerLiteral, *ast.Boolean, *ast.FunctionLiteral:
+		return true
+	case *ast.Identifier:
+		sym := e.info.Uses[value]
+		if sym == nil {
+			return false
+		}
+		if sym.Kind == resolve.ParameterSymbol {
+			return true
+		}
+		// the declaration has been evaluated unless it is later or
+		// being evaluated
+		decl, ok := sym.Node.(*ast.LetStatement)
+		return ok && decl != let && sym.Decl.Token.Pos.Offset < value.Token.Pos.Offset &&
+			!contained(decl.Value)[value]
+	}
+	return false
+}
+
+// contained returns the identifiers in node.
+func contained(node ast.Node) map[*ast.Identifier]bool {
+	idents := map[*ast.Identifier]bool{}
+	ast.Inspect(node, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Identifier); ok {
+			idents[ident] = true
+		}
+		return true
+	})
+	return idents
+}
+
+// truthiness returns the truthiness of a constant condition.
+func truthiness(exp ast.Expression) (truthy, ok bool) {
+	switch exp := exp.(type) {
+	case *ast.Boolean:
+		return exp.Value, true
+	case *ast.IntegerLiteral:
+		return true, true
+	}
+	return false, false
+}
+
+// returns reports whether stmt always ends its function, or the program,
+// unless it fails.
+func returns(stmt ast.Statement) bool {
+	switch stmt := stmt.(type) {
+	case *ast.ReturnStatement:
+		return true
+	case *ast.BlockStatement:
+		return blockReturns(stmt)
+	case *ast.ExpressionStatement:
+		ie, ok := stmt.Expression.(*ast.IfExpression)
+		return ok && ie.Alternative != nil && blockReturns(ie.Consequence) && blockReturns(ie.Alternative)
+	}
+	return false
+}
+
+func blockReturns(block *ast.BlockStatement) bool {
+	for _, stmt := range block.Statements {
+		if returns(stmt) {
+			return true
+		}
+	}
+	return false
+}
+
+// statementPos returns the position of the first token of stmt.
+func statementPos(stmt ast.Statement) token.Position {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		return stmt.Token.Pos
+	case *ast.ReturnStatement:
+		return stmt.Token.Pos
+	case *ast.ExpressionStatement:
+		return stmt.Token.Pos
+	case *ast.BlockStatement:
+		return stmt.Token.Pos
+	}
+	return token.Position{}
+}
@@ -0,0 +1,246 @@
+// Package optimizer rewrites Monkey programs into equivalent programs which
+// evaluate faster.
+//
+// Every pass returns a rewritten copy of the program and leaves the original
+// untouched. A pass never changes the result of a program, including the
+// runtime errors it reports.
+package optimizer
+
+import (
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/object"
+	"github.com/oohira/monkey/resolve"
+	"github.com/oohira/monkey/token"
+	"github.com/oohira/monkey/types"
+)
+
+// Fold returns a copy of program with constant subexpressions folded into
+// literals and algebraic identities simplified:
+//
+//	60 * 60 * 24   =>  86400
+//	x + 0, x * 1   =>  x
+//	-(-x)          =>  x
+//	!!b            =>  b
+//
+// Operations which fail at runtime, such as 1 / 0, are kept as they are.
+// An identity is simplified only if the operand is known to be an integer,
+// or a boolean for !!b, by its syntax or by type inference, since otherwise
+// the operation may fail at runtime. !!b is simplified regardless of the type
+// of b where only the truthiness of its value matters, that is, in the
+// condition of an if expression and the operand of !.
+func Fold(program *ast.Program) *ast.Program {
+	program = ast.Clone(program)
+	f := &folder{}
+	// the checker models lexical scoping, which is the scoping at runtime
+	// only if the resolver finds it so
+	if analyzable(resolve.Resolve(program)) {
+		if info := types.Check(program); len(info.Errors) == 0 {
+			f.types = info
+		}
+	}
+	f.statements(program.Statements)
+	return program
+}
+
+type folder struct {
+	types *types.Info // nil if the program is not well-typed or not analyzable
+}
+
+func (f *folder) statements(stmts []ast.Statement) {
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *ast.LetStatement:
+			stmt.Value = f.expression(stmt.Value)
+		case *ast.ReturnStatement:
+			stmt.ReturnValue = f.expression(stmt.ReturnValue)
+		case *ast.ExpressionStatement:
+			stmt.Expression = f.expression(stmt.Expression)
+		case *ast.BlockStatement:
+			f.statements(stmt.Statements)
+		}
+	}
+}
+
+func (f *folder) expression(exp ast.Expression) ast.Expression {
+	switch exp := exp.(type) {
+	case *ast.PrefixExpression:
+		exp.Right = f.expression(exp.Right)
+		if exp.Operator == "!" {
+			exp.Right = condition(exp.Right)
+		}
+		return f.prefix(exp)
+	case *ast.InfixExpression:
+		exp.Left = f.expression(exp.Left)
+		exp.Right = f.expression(exp.Right)
+		return f.infix(exp)
+	case *ast.IfExpression:
+		exp.Condition = condition(f.expression(exp.Condition))
+		f.statements(exp.Consequence.Statements)
+		if exp.Alternative != nil {
+			f.statements(exp.Alternative.Statements)
+		}
+	case *ast.FunctionLiteral:
+		f.statements(exp.Body.Statements)
+	case *ast.CallExpression:
+		exp.Function = f.expression(exp.Function)
+		for i, arg := range exp.Arguments {
+			exp.Arguments[i] = f.expression(arg)
+		}
+	}
+	return exp
+}
+
+func (f *folder) prefix(exp *ast.PrefixExpression) ast.Expression {
+	if right, ok := constant(exp.Right); ok {
+		if result, err := object.Prefix(exp.Operator, right); err == nil {
+			return literal(result, exp.Token.Pos)
+		}
+		return exp
+	}
+
+	// -(-x) => x, !!b => b
+	inner, ok := exp.Right.(*ast.PrefixExpression)
+	if !ok || inner.Operator != exp.Operator {
+		return exp
+	}
+	if exp.Operator == "-" && f.isInt(inner.Right) || exp.Operator == "!" && f.isBool(inner.Right) {
+		return inner.Right
+	}
+	return exp
+}
+
+func (f *folder) infix(exp *ast.InfixExpression) ast.Expression {
+	left, leftOK := constant(exp.Left)
+	right, rightOK := constant(exp.Right)
+	if leftOK && rightOK {
+		if result, err := object.Infix(exp.Operator, left, right); err == nil {
+			return literal(result, literalPos(exp.Left))
+		}
+		return exp
+	}
+
+	switch exp.Operator {
+	case "+":
+		if isInteger(right, 0) && f.isInt(exp.Left) {
+			return exp.Left
+		}
+		if isInteger(left, 0) && f.isInt(exp.Right) {
+			return exp.Right
+		}
+	case "-":
+		if isInteger(right, 0) && f.isInt(exp.Left) {
+			return exp.Left
+		}
+	case "*":
+		if isInteger(right, 1) && f.isInt(exp.Left) {
+			return exp.Left
+		}
+		if isInteger(left, 1) && f.isInt(exp.Right) {
+			return exp.Right
+		}
+	case "/":
+		if isInteger(right, 1) && f.isInt(exp.Left) {
+			return exp.Left
+		}
+	}
+	return exp
+}
+
+// isInt reports whether exp evaluates to an integer unless it fails.
+func (f *folder) isInt(exp ast.Expression) bool {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return true
+	case *ast.PrefixExpression:
+		return exp.Operator == "-"
+	case *ast.InfixExpression:
+		switch exp.Operator {
+		case "+", "-", "*", "/":
+			return true
+		}
+	}
+	return f.types != nil && f.types.TypeOf(exp) == types.Int
+}
+
+// isBool reports whether exp evaluates to a boolean unless it fails.
+func (f *folder) isBool(exp ast.Expression) bool {
+	switch exp := exp.(type) {
+	case *ast.Boolean:
+		return true
+	case *ast.PrefixExpression:
+		return exp.Operator == "!"
+	case *ast.InfixExpression:
+		switch exp.Operator {
+		case "==", "!=", "<", ">":
+			return true
+		}
+	}
+	return f.types != nil && f.types.TypeOf(exp) == types.Bool
+}
+
+// condition simplifies exp whose value matters only by its truthiness.
+func condition(exp ast.Expression) ast.Expression {
+	for {
+		outer, ok := exp.(*ast.PrefixExpression)
+		if !ok || outer.Operator != "!" {
+			return exp
+		}
+		inner, ok := outer.Right.(*ast.PrefixExpression)
+		if !ok || inner.Operator != "!" {
+			return exp
+		}
+		exp = inner.Right
+	}
+}
+
+// constant returns the value of exp if it is a literal.
+func constant(exp ast.Expression) (object.Object, bool) {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		if exp.Big != nil {
+			return object.NewBigInteger(exp.Big), true
+		}
+		return object.NewInteger(exp.Value), true
+	case *ast.Boolean:
+		return object.NativeBool(exp.Value), true
+	}
+	return nil, false
+}
+
+// literalPos returns the position of a literal.
+func literalPos(exp ast.Expression) token.Position {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return exp.Token.Pos
+	case *ast.Boolean:
+		return exp.Token.Pos
+	}
+	return token.Position{}
+}
+
+func isInteger(obj object.Object, v int64) bool {
+	i, ok := obj.(*object.Integer)
+	return ok && !i.IsBig() && i.Value == v
+}
+
+// literal returns the literal of a constant at pos.
+func literal(obj object.Object, pos token.Position) ast.Expression {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		lit := &ast.IntegerLiteral{
+			Token: token.Token{Type: token.INT, Literal: obj.Inspect(), Pos: pos},
+			Value: obj.Value,
+		}
+		if obj.IsBig() {
+			lit.Big = obj.BigInt()
+		}
+		return lit
+	case *object.Boolean:
+		tok := token.Token{Type: token.FALSE, Literal: "false", Pos: pos}
+		if obj.Value {
+			tok = token.Token{Type: token.TRUE, Literal: "true", Pos: pos}
+		}
+		return &ast.Boolean{Token: tok, Value: obj.Value}
+	}
+	panic("optimizer: no literal of " + obj.Type())
+}
@@ -0,0 +1,218 @@
+package optimizer
+
+import (
+	goast "go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/evaluator"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/object"
+	"github.com/oohira/monkey/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func eval(program *ast.Program) string {
+	return evaluator.Eval(program, object.NewEnvironment()).Inspect()
+}
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"60 * 60 * 24", "86400"},
+		{"1 + 2 * 3 - 4 / 2", "5"},
+		{"-(-5)", "5"},
+		{"-(3 - 5)", "2"},
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"1 < 2 == !false", "true"},
+		{"!5", "false"},
+		{"1 == true", "false"},
+		{"let a = 2 * 3; a * (4 + 5)", "let a = 6;(a * 9)"},
+		{"fn(x) { x * (1 + 1) }(3)", "fn(x) (x * 2)(3)"},
+		{"if (1 > 2) { 3 * 3 } else { 4 * 4 }", "iffalse 9else 16"},
+		{"f(1 + 1, 2 * 2)", "f(2, 4)"},
+
+		// runtime errors are kept
+		{"1 / 0", "(1 / 0)"},
+		{"1 / (1 - 1)", "(1 / 0)"},
+		{"-true", "(-true)"},
+		{"1 + true", "(1 + true)"},
+		{"true + false", "(true + false)"},
+
+		// identities on integers
+		{"let f = fn(x) { x + 0 };", "let f = fn(x) x;"},
+		{"let f = fn(x) { 0 + x * 1 };", "let f = fn(x) x;"},
+		{"let f = fn(x) { 1 * x - 0 };", "let f = fn(x) x;"},
+		{"let f = fn(x) { x / 1 };", "let f = fn(x) x;"},
+		{"let f = fn(x) { -(-x) };", "let f = fn(x) x;"},
+		{"let f = fn(x) { -(-x) - 0 + x };", "let f = fn(x) (x + x);"},
+		{"let f = fn(g) { g() * 1 + 1 };", "let f = fn(g) (g() + 1);"},
+		{"let f = fn(x, y) { (x + y) * 1 }; f(true, 1)", "let f = fn(x, y) (x + y);f(true, 1)"},
+
+		// operands which are not known to be integers
+		{"let f = fn(x) { x };  f(true) * 1", "let f = fn(x) x;(f(true) * 1)"},
+		{"let f = fn(x) { -(-x) }; f(true)", "let f = fn(x) (-(-x));f(true)"},
+		{"let f = fn(x) { x + 0 }; x * 1", "let f = fn(x) (x + 0);(x * 1)"},
+		{"fn(x) { x - 0 }(true)", "fn(x) (x - 0)(true)"},
+		{"let x = 1 * 1; 0 - x", "let x = 1;(0 - x)"},
+		{"let f = fn(c) { let x = 1; if (c) { let x = true; }; x + 0 }; f(true)", "let f = fn(c) let x = 1;ifc let x = true;(x + 0);f(true)"},
+
+		// !!b
+		{"let f = fn(x) { !!(x < 1) };", "let f = fn(x) (x < 1);"},
+		{"let f = fn(x) { if (x == true) { !!x } else { false } };", "let f = fn(x) if(x == true) xelse false;"},
+		{"let f = fn(x) { !!x == true }; f(5)", "let f = fn(x) ((!(!x)) == true);f(5)"},
+		{"let f = fn(x) { !!x };", "let f = fn(x) (!(!x));"},
+		{"let f = fn(x) { if (!!x) { 1 } };", "let f = fn(x) ifx 1;"},
+		{"let f = fn(x) { !!!x };", "let f = fn(x) (!x);"},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		original := program.String()
+		folded := Fold(program)
+		if got := folded.String(); got != tt.expected {
+			t.Errorf("folded %q wrong. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+		if program.String() != original {
+			t.Errorf("Fold modified the original program %q. got=%q", tt.input, program.String())
+		}
+		if want, got := eval(program), eval(folded); want != got {
+			t.Errorf("result of %q changed. want=%s, got=%s", tt.input, want, got)
+		}
+	}
+}
+
+func TestEliminate(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		removed  []string
+	}{
+		// unreachable code
+		{"return 1; 2; 3", "return 1;", []string{
+			"1:11: removed unreachable code: 2",
+			"1:14: removed unreachable code: 3",
+		}},
+		{"let f = fn(x) { return x; x + 1 }; f(1)", "let f = fn(x) return x;;f(1)", []string{
+			"1:27: removed unreachable code: (x + 1)",
+		}},
+		{"let f = fn(x) { if (x) { return 1 } else { return 2 }; 3 }; f(true)",
+			"let f = fn(x) ifx return 1;else return 2;;f(true)", []string{
+				"1:56: removed unreachable code: 3",
+			}},
+		{"let f = fn(x) { if (x) { return 1 }; 3 }; f(false)",
+			"let f = fn(x) ifx return 1;3;f(false)", nil},
+
+		// constant conditions
+		{"if (true) { 1 } else { 2 }", "1", []string{"1:22: removed dead branch: 2"}},
+		{"if (false) { 1 } else { 2 }", "2", []string{"1:12: removed dead branch: 1"}},
+		{"if (1) { 2 }", "2", nil},
+		{"1; if (false) { 2 }", "1iffalse ", []string{"1:15: removed dead branch: 2"}},
+		{"if (false) { 2 }; 1", "1", []string{"1:12: removed dead branch: 2"}},
+		{"let x = if (true) { 1 } else { 2 }; x", "let x = 1;x", []string{"1:30: removed dead branch: 2"}},
+		{"let x = if (false) { 1 }; x", "let x = iffalse ;x", []string{"1:20: removed dead branch: 1"}},
+		{"let f = fn() { if (true) { return 1; } 2 }; f()", "let f = fn() return 1;;f()", []string{
+			"1:40: removed unreachable code: 2",
+		}},
+		{"let f = fn(x) { if (true) { x } else { -x } }; f(3)", "let f = fn(x) x;f(3)", []string{
+			"1:38: removed dead branch: (-x)",
+		}},
+
+		// unused let statements
+		{"let x = 1; 2", "2", []string{"1:1: removed unused variable: let x = 1;"}},
+		{"let x = 1; let y = x; 2", "2", []string{
+			"1:1: removed unused variable: let x = 1;",
+			"1:12: removed unused variable: let y = x;",
+		}},
+		{"let f = fn(n) { f(n) }; 1", "1", []string{"1:1: removed unused variable: let f = fn(n) f(n);"}},
+		{"let f = fn(x) { let y = x; let z = 1; y }; f(2)", "let f = fn(x) let y = x;y;f(2)", []string{
+			"1:28: removed unused variable: let z = 1;",
+		}},
+		{"let x = 1; return 2; x", "return 2;", []string{
+			"1:1: removed unused variable: let x = 1;",
+			"1:22: removed unreachable code: x",
+		}},
+		{"let x = 1;", "let x = 1;", nil},
+		{"let x = 1 + 1; 2", "let x = (1 + 1);2", nil},
+		{"let f = fn() { 1 }; let x = f(); 2", "let f = fn() 1;let x = f();2", nil},
+		{"let x = y; 2", "let x = y;2", nil},
+		{"let g = fn() { let x = y; 1 }; let y = 2; g()", "let g = fn() let x = y;1;let y = 2;g()", nil},
+		{"let x = 1; let x = 2; 3", "let x = 1;let x = 2;3", nil},
+		{"let x = 1; if (x) { let x = 2 }; x", "let x = 1;ifx let x = 2;x", nil},
+		{"let f = fn(x) { let x = 1; 2 }; f(3)", "let f = fn(x) let x = 1;2;f(3)", nil},
+		{"let x = 1; let f = fn() { let x = 2; 3 }; f() + x", "let x = 1;let f = fn() 3;(f() + x)", []string{
+			"1:27: removed unused variable: let x = 2;",
+		}},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		original := program.String()
+		eliminated, removed := Eliminate(program)
+		if got := eliminated.String(); got != tt.expected {
+			t.Errorf("eliminated %q wrong. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+		if program.String() != original {
+			t.Errorf("Eliminate modified the original program %q. got=%q", tt.input, program.String())
+		}
+		var got []string
+		for _, r := range removed {
+			got = append(got, r.String())
+		}
+		if !reflect.DeepEqual(got, tt.removed) {
+			t.Errorf("removals of %q wrong.\nwant=%q\ngot= %q", tt.input, tt.removed, got)
+		}
+		if want, got := eval(program), eval(eliminated); want != got {
+			t.Errorf("result of %q changed. want=%s, got=%s", tt.input, want, got)
+		}
+	}
+}
+
+// TestOptimizePreservesResults optimizes every program in the parser tests
+// and compares the results with the unoptimized programs.
+func TestOptimizePreservesResults(t *testing.T) {
+	file, err := goparser.ParseFile(gotoken.NewFileSet(), "../parser/parser_test.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	goast.Inspect(file, func(node goast.Node) bool {
+		lit, ok := node.(*goast.BasicLit)
+		if !ok || lit.Kind != gotoken.STRING {
+			return true
+		}
+		input, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := parser.New(lexer.New(input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			return true
+		}
+		n++
+		optimized, _ := Optimize(program)
+		if want, got := eval(program), eval(optimized); want != got {
+			t.Errorf("result of %q changed. want=%s, got=%s", input, want, got)
+		}
+		return true
+	})
+	if n == 0 {
+		t.Fatal("no programs found in the parser tests")
+	}
+}
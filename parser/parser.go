@@ -1,10 +1,14 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
+	"reflect"
 	"strconv"
 
 	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/cst"
 	"github.com/oohira/monkey/lexer"
 	"github.com/oohira/monkey/token"
 )
@@ -21,6 +25,7 @@ const (
 )
 
 var precedences = map[token.Type]int{
+	token.LPAREN:   CALL,
 	token.EQ:       EQUALS,
 	token.NOTEQ:    EQUALS,
 	token.LT:       LESSGREATER,
@@ -36,19 +41,36 @@ type (
 	infixParseFn  func(ast.Expression) ast.Expression
 )
 
+// Error represents a parse error at a position.
+type Error struct {
+	Pos token.Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
 // Parser represents a parser of Monkey programming language.
 type Parser struct {
 	l              *lexer.Lexer
 	curToken       token.Token
 	peekToken      token.Token
-	errors         []string
+	errors         []*Error
 	prefixParseFns map[token.Type]prefixParseFn
 	infixParseFns  map[token.Type]infixParseFn
+
+	// fields to build a concrete syntax tree
+	concrete bool
+	tokens   []token.Token // all tokens read so far
+	pos      int           // index of curToken in tokens
+	spans    []cst.Span
+	depth    int // nesting level of block statements
 }
 
 // New returns a Parser that wraps the specified Lexer l.
 func New(l *lexer.Lexer) *Parser {
-	p := &Parser{l: l, errors: []string{}}
+	p := &Parser{l: l, errors: []*Error{}}
 
 	// Read two tokens to set both curToken and peekToken
 	p.nextToken()
@@ -59,6 +81,11 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefix(token.TRUE, p.parseBoolean)
+	p.registerPrefix(token.FALSE, p.parseBoolean)
+	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
+	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 
 	p.infixParseFns = make(map[token.Type]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
@@ -69,6 +96,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOTEQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LPAREN, p.parseCallExpression)
 
 	return p
 }
@@ -83,18 +111,65 @@ func (p *Parser) registerInfix(tokenType token.Type, fn infixParseFn) {
 
 // Errors returns a list of parse error.
 func (p *Parser) Errors() []string {
+	return messages(p.errors)
+}
+
+// ErrorList returns a list of parse error with its position.
+func (p *Parser) ErrorList() []*Error {
 	return p.errors
 }
 
+func (p *Parser) errorf(pos token.Position, format string, a ...interface{}) {
+	p.errors = append(p.errors, &Error{Pos: pos, Msg: fmt.Sprintf(format, a...)})
+}
+
 func (p *Parser) peekError(t token.Type) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
+	p.errorf(p.peekToken.Pos, "expected next token to be %s, got %s instead",
 		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+}
+
+func messages(errs []*Error) []string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Msg
+	}
+	return msgs
 }
 
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+	p.pos++
+	if p.concrete {
+		p.tokens = append(p.tokens, p.peekToken)
+	}
+}
+
+// record records that node spans tokens from start to the current token.
+func (p *Parser) record(node ast.Node, start int) {
+	if !p.concrete || node == nil || reflect.ValueOf(node).IsNil() {
+		return
+	}
+	p.spans = append(p.spans, cst.Span{Start: start, End: p.pos, Node: node})
+}
+
+// ParseConcreteProgram parses a program and returns a concrete syntax tree
+// whose root holds the AST. It must be called instead of ParseProgram
+// right after New. The tree is lossless only if the lexer preserves trivia.
+func (p *Parser) ParseConcreteProgram() *cst.Node {
+	p.concrete = true
+	p.tokens = []token.Token{p.curToken, p.peekToken}
+	p.pos = 0
+
+	program := p.ParseProgram()
+	root := cst.Build(p.tokens[:p.pos+1], p.spans, program)
+
+	attributed := 0
+	for _, span := range p.spans {
+		attributed += len(span.Errors)
+	}
+	root.Errors = messages(p.errors[attributed:])
+	return root
 }
 
 // ParseProgram parses a program and returns an AST.
@@ -109,10 +184,29 @@ func (p *Parser) ParseProgram() *ast.Program {
 		}
 		p.nextToken()
 	}
+	if err := p.l.Err(); err != nil {
+		p.errorf(p.curToken.Pos, "could not read input: %s", err)
+	}
 	return program
 }
 
 func (p *Parser) parseStatement() ast.Statement {
+	start, numErrors := p.pos, len(p.errors)
+	stmt := p.parseStatementKind()
+	if stmt == nil || reflect.ValueOf(stmt).IsNil() {
+		stmt = nil
+	}
+	if p.concrete {
+		span := cst.Span{Start: start, End: p.pos, Node: stmt}
+		if p.depth == 0 {
+			span.Errors = messages(p.errors[numErrors:])
+		}
+		p.spans = append(p.spans, span)
+	}
+	return stmt
+}
+
+func (p *Parser) parseStatementKind() ast.Statement {
 	switch p.curToken.Type {
 	case token.LET:
 		return p.parseLetStatement()
@@ -133,15 +227,25 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	p.nextToken()
 
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	p.record(stmt.Name, p.pos)
+
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		p.nextToken()
+		if stmt.Type = p.parseType(); stmt.Type == nil {
+			return nil
+		}
+	}
 
 	if !p.peekTokenIs(token.ASSIGN) {
 		p.peekError(token.ASSIGN)
 		return nil
 	}
 	p.nextToken()
+	p.nextToken()
 
-	// TODO: Skip parsing expression for now
-	for !p.curTokenIs(token.SEMICOLON) {
+	stmt.Value = p.parseExpression(LOWEST)
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -153,8 +257,8 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 
 	p.nextToken()
 
-	// TODO: Skip parsing expression for now
-	for !p.curTokenIs(token.SEMICOLON) {
+	stmt.ReturnValue = p.parseExpression(LOWEST)
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -175,11 +279,12 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 func (p *Parser) parseExpression(precedence int) ast.Expression {
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
-		msg := fmt.Sprintf("no prefix parse function for %s found", p.curToken.Type)
-		p.errors = append(p.errors, msg)
+		p.errorf(p.curToken.Pos, "no prefix parse function for %s found", p.curToken.Type)
 		return nil
 	}
+	start := p.pos
 	leftExp := prefix()
+	p.record(leftExp, start)
 
 	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
 		infix := p.infixParseFns[p.peekToken.Type]
@@ -188,6 +293,7 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 		}
 		p.nextToken()
 		leftExp = infix(leftExp)
+		p.record(leftExp, start)
 	}
 	return leftExp
 }
@@ -223,15 +329,226 @@ func (p *Parser) parseIdentifier() ast.Expression {
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	literal := &ast.IntegerLiteral{Token: p.curToken}
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		if b, ok := new(big.Int).SetString(p.curToken.Literal, 0); ok {
+			literal.Big = b
+			return literal
+		}
+	}
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorf(p.curToken.Pos, "could not parse %q as integer", p.curToken.Literal)
 		return nil
 	}
 	literal.Value = value
 	return literal
 }
 
+func (p *Parser) parseBoolean() ast.Expression {
+	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
+}
+
+func (p *Parser) parseGroupedExpression() ast.Expression {
+	p.nextToken()
+
+	exp := p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	return exp
+}
+
+func (p *Parser) parseIfExpression() ast.Expression {
+	exp := &ast.IfExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	exp.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	exp.Consequence = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.ELSE) {
+		p.nextToken()
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		exp.Alternative = p.parseBlockStatement()
+	}
+
+	return exp
+}
+
+func (p *Parser) parseBlockStatement() *ast.BlockStatement {
+	block := &ast.BlockStatement{Token: p.curToken}
+	block.Statements = []ast.Statement{}
+	start := p.pos
+
+	p.depth++
+	p.nextToken()
+	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+		stmt := p.parseStatement()
+		if stmt != nil {
+			block.Statements = append(block.Statements, stmt)
+		}
+		p.nextToken()
+	}
+	p.depth--
+	if p.curTokenIs(token.EOF) {
+		p.errorf(p.curToken.Pos, "expected next token to be %s, got %s instead",
+			token.RBRACE, token.EOF)
+	}
+
+	p.record(block, start)
+	return block
+}
+
+func (p *Parser) parseFunctionLiteral() ast.Expression {
+	literal := &ast.FunctionLiteral{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	literal.Parameters = p.parseFunctionParameters()
+	if literal.Parameters == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.ARROW) {
+		p.nextToken()
+		p.nextToken()
+		if literal.ReturnType = p.parseType(); literal.ReturnType == nil {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	literal.Body = p.parseBlockStatement()
+
+	return literal
+}
+
+func (p *Parser) parseFunctionParameters() []*ast.Identifier {
+	identifiers := []*ast.Identifier{}
+
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		return identifiers
+	}
+
+	for {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		p.record(ident, p.pos)
+		identifiers = append(identifiers, ident)
+
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+			p.nextToken()
+			if ident.Type = p.parseType(); ident.Type == nil {
+				return nil
+			}
+		}
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	return identifiers
+}
+
+// parseType parses a type annotation starting at the current token.
+func (p *Parser) parseType() ast.TypeExpression {
+	start := p.pos
+	switch p.curToken.Type {
+	case token.IDENT:
+		t := &ast.NamedType{Token: p.curToken, Name: p.curToken.Literal}
+		p.record(t, start)
+		return t
+	case token.FUNCTION:
+		t := &ast.FunctionType{Token: p.curToken, Parameters: []ast.TypeExpression{}}
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+		if p.peekTokenIs(token.RPAREN) {
+			p.nextToken()
+		} else {
+			for {
+				p.nextToken()
+				param := p.parseType()
+				if param == nil {
+					return nil
+				}
+				t.Parameters = append(t.Parameters, param)
+				if !p.peekTokenIs(token.COMMA) {
+					break
+				}
+				p.nextToken()
+			}
+			if !p.expectPeek(token.RPAREN) {
+				return nil
+			}
+		}
+		if !p.expectPeek(token.ARROW) {
+			return nil
+		}
+		p.nextToken()
+		if t.Result = p.parseType(); t.Result == nil {
+			return nil
+		}
+		p.record(t, start)
+		return t
+	}
+	p.errorf(p.curToken.Pos, "expected a type, got %s instead", p.curToken.Type)
+	return nil
+}
+
+func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
+	exp := &ast.CallExpression{Token: p.curToken, Function: function}
+	exp.Arguments = p.parseCallArguments()
+	if exp.Arguments == nil {
+		return nil
+	}
+	return exp
+}
+
+func (p *Parser) parseCallArguments() []ast.Expression {
+	args := []ast.Expression{}
+
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		return args
+	}
+
+	p.nextToken()
+	args = append(args, p.parseExpression(LOWEST))
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		p.nextToken()
+		args = append(args, p.parseExpression(LOWEST))
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	return args
+}
+
 func (p *Parser) curTokenIs(t token.Type) bool {
 	return p.curToken.Type == t
 }
@@ -240,6 +557,15 @@ func (p *Parser) peekTokenIs(t token.Type) bool {
 	return p.peekToken.Type == t
 }
 
+func (p *Parser) expectPeek(t token.Type) bool {
+	if p.peekTokenIs(t) {
+		p.nextToken()
+		return true
+	}
+	p.peekError(t)
+	return false
+}
+
 func (p *Parser) curPrecedence() int {
 	if p, ok := precedences[p.curToken.Type]; ok {
 		return p
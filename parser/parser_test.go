@@ -1,8 +1,12 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/oohira/monkey/ast"
 	"github.com/oohira/monkey/lexer"
@@ -27,10 +31,11 @@ let foobar = 838383;
 
 	tests := []struct {
 		expectedIdentifier string
+		expectedValue      interface{}
 	}{
-		{"x"},
-		{"y"},
-		{"foobar"},
+		{"x", 5},
+		{"y", 10},
+		{"foobar", 838383},
 	}
 
 	for i, test := range tests {
@@ -43,6 +48,7 @@ let foobar = 838383;
 			t.Errorf("stmt[%d] is not *LetStatement. got=%T", i, stmt)
 		}
 		testLiteralExpression(t, letStmt.Name, test.expectedIdentifier)
+		testLiteralExpression(t, letStmt.Value, test.expectedValue)
 	}
 }
 
@@ -63,14 +69,47 @@ return 993322;
 		t.Fatalf("program.Statements does not contain 3 statements. got=%d", len(program.Statements))
 	}
 
+	expectedValues := []interface{}{5, 10, 993322}
 	for i, stmt := range program.Statements {
 		returnStmt, ok := stmt.(*ast.ReturnStatement)
 		if !ok {
-			t.Errorf("stmt[%d] is not *ReturnStatement. got=%T", i, stmt)
+			t.Fatalf("stmt[%d] is not *ReturnStatement. got=%T", i, stmt)
 		}
 		if returnStmt.TokenLiteral() != "return" {
 			t.Errorf("stmt[%d].TokenLiteral is not 'return'. got=%q", i, stmt.TokenLiteral())
 		}
+		testLiteralExpression(t, returnStmt.ReturnValue, expectedValues[i])
+	}
+}
+
+func TestLetAndReturnValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let y = true;", true},
+		{"let foobar = y;", "y"},
+		{"return true;", true},
+		{"return foobar;", "foobar"},
+	}
+
+	for i, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("[%d] program has not enough statements. got=%d", i, len(program.Statements))
+		}
+		switch stmt := program.Statements[0].(type) {
+		case *ast.LetStatement:
+			testLiteralExpression(t, stmt.Value, test.expected)
+		case *ast.ReturnStatement:
+			testLiteralExpression(t, stmt.ReturnValue, test.expected)
+		default:
+			t.Fatalf("[%d] program.Statements[0] is not *LetStatement or *ReturnStatement. got=%T", i, stmt)
+		}
 	}
 }
 
@@ -175,6 +214,16 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		{"5 > 4 == 3 < 4", "((5 > 4) == (3 < 4))"},
 		{"5 > 4 != 3 < 4", "((5 > 4) != (3 < 4))"},
 		{"3 + 4 * 5 == 3 * 1 + 4 * 5", "((3 + (4 * 5)) == ((3 * 1) + (4 * 5)))"},
+		{"true", "true"},
+		{"3 > 5 == false", "((3 > 5) == false)"},
+		{"!true", "(!true)"},
+		{"1 + (2 + 3) + 4", "((1 + (2 + 3)) + 4)"},
+		{"(5 + 5) * 2", "((5 + 5) * 2)"},
+		{"-(5 + 5)", "(-(5 + 5))"},
+		{"!(true == true)", "(!(true == true))"},
+		{"a + add(b * c) + d", "((a + add((b * c))) + d)"},
+		{"add(a, b, 1, 2 * 3, 4 + 5, add(6, 7 * 8))", "add(a, b, 1, (2 * 3), (4 + 5), add(6, (7 * 8)))"},
+		{"add(a + b + c * d / f + g)", "add((((a + b) + ((c * d) / f)) + g))"},
 	}
 
 	for i, test := range tests {
@@ -228,6 +277,328 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	testLiteralExpression(t, stmt.Expression, 5)
 }
 
+func TestBooleanExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"true;", true},
+		{"false;", false},
+	}
+
+	for i, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("[%d] program has not enough statements. got=%d", i, len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("[%d] program.Statements[0] is not *ExpressionStatement. got=%T",
+				i, program.Statements[0])
+		}
+		testLiteralExpression(t, stmt.Expression, test.expected)
+	}
+}
+
+func TestIfExpression(t *testing.T) {
+	tests := []struct {
+		input          string
+		hasAlternative bool
+	}{
+		{"if (x < y) { x }", false},
+		{"if (x < y) { x } else { y }", true},
+	}
+
+	for i, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("[%d] program has not enough statements. got=%d", i, len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("[%d] program.Statements[0] is not *ExpressionStatement. got=%T",
+				i, program.Statements[0])
+		}
+		exp, ok := stmt.Expression.(*ast.IfExpression)
+		if !ok {
+			t.Fatalf("[%d] exp is not *IfExpression. got=%T", i, stmt.Expression)
+		}
+		if !testInfixExpression(t, exp.Condition, "x", "<", "y") {
+			return
+		}
+		if len(exp.Consequence.Statements) != 1 {
+			t.Fatalf("[%d] consequence is not 1 statement. got=%d", i, len(exp.Consequence.Statements))
+		}
+		consequence, ok := exp.Consequence.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("[%d] consequence is not *ExpressionStatement. got=%T",
+				i, exp.Consequence.Statements[0])
+		}
+		testLiteralExpression(t, consequence.Expression, "x")
+
+		if !test.hasAlternative {
+			if exp.Alternative != nil {
+				t.Errorf("[%d] exp.Alternative is not nil. got=%+v", i, exp.Alternative)
+			}
+			continue
+		}
+		if exp.Alternative == nil || len(exp.Alternative.Statements) != 1 {
+			t.Fatalf("[%d] alternative is not 1 statement. got=%+v", i, exp.Alternative)
+		}
+		alternative, ok := exp.Alternative.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("[%d] alternative is not *ExpressionStatement. got=%T",
+				i, exp.Alternative.Statements[0])
+		}
+		testLiteralExpression(t, alternative.Expression, "y")
+	}
+}
+
+func TestFunctionLiteralParsing(t *testing.T) {
+	input := "fn(x, y) { x + y; }"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program has not enough statements. got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+	function, ok := stmt.Expression.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("exp is not *FunctionLiteral. got=%T", stmt.Expression)
+	}
+	if len(function.Parameters) != 2 {
+		t.Fatalf("function has wrong number of parameters. got=%d", len(function.Parameters))
+	}
+	testLiteralExpression(t, function.Parameters[0], "x")
+	testLiteralExpression(t, function.Parameters[1], "y")
+
+	if len(function.Body.Statements) != 1 {
+		t.Fatalf("function.Body is not 1 statement. got=%d", len(function.Body.Statements))
+	}
+	body, ok := function.Body.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("function body is not *ExpressionStatement. got=%T", function.Body.Statements[0])
+	}
+	testInfixExpression(t, body.Expression, "x", "+", "y")
+}
+
+func TestFunctionParameterParsing(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedParams []string
+	}{
+		{"fn() {};", []string{}},
+		{"fn(x) {};", []string{"x"}},
+		{"fn(x, y, z) {};", []string{"x", "y", "z"}},
+	}
+
+	for i, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		function := stmt.Expression.(*ast.FunctionLiteral)
+		if len(function.Parameters) != len(test.expectedParams) {
+			t.Fatalf("[%d] length of parameters wrong. want=%d, got=%d",
+				i, len(test.expectedParams), len(function.Parameters))
+		}
+		for j, ident := range test.expectedParams {
+			testLiteralExpression(t, function.Parameters[j], ident)
+		}
+	}
+}
+
+func TestTypeAnnotations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: int = 5;", "let x: int = 5;"},
+		{"let f: fn(int, bool) -> int = g;", "let f: fn(int, bool) -> int = g;"},
+		{"let f: fn() -> fn(int) -> int = g;", "let f: fn() -> fn(int) -> int = g;"},
+		{"fn(a: int, b) -> bool { a }", "fn(a: int, b) -> bool a"},
+		{"fn(f: fn(int) -> int) -> fn(int) -> int { f }", "fn(f: fn(int) -> int) -> fn(int) -> int f"},
+		{"fn(a, b) { a }", "fn(a, b) a"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if program.String() != tt.expected {
+			t.Errorf("String() wrong. want=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	program := New(lexer.New("let f = fn(a: int, b) -> bool { a };")).ParseProgram()
+	let := program.Statements[0].(*ast.LetStatement)
+	if let.Type != nil {
+		t.Errorf("let.Type is not nil. got=%s", let.Type)
+	}
+	fn := let.Value.(*ast.FunctionLiteral)
+	if named, ok := fn.Parameters[0].Type.(*ast.NamedType); !ok || named.Name != "int" {
+		t.Errorf("type of parameter a wrong. got=%v", fn.Parameters[0].Type)
+	}
+	if fn.Parameters[1].Type != nil {
+		t.Errorf("type of parameter b is not nil. got=%s", fn.Parameters[1].Type)
+	}
+	if named, ok := fn.ReturnType.(*ast.NamedType); !ok || named.Name != "bool" {
+		t.Errorf("return type wrong. got=%v", fn.ReturnType)
+	}
+}
+
+func TestTypeAnnotationErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: = 5;", "1:8: expected a type, got = instead"},
+		{"let x: int 5;", "1:12: expected next token to be =, got INT instead"},
+		{"fn(a: 1) { a }", "1:7: expected a type, got INT instead"},
+		{"fn() -> { 1 }", "1:9: expected a type, got { instead"},
+		{"let f: fn(int) = g;", "1:16: expected next token to be ->, got = instead"},
+		{"let f: fn(int bool) -> int = g;", "1:15: expected next token to be ), got IDENT instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors := p.ErrorList()
+		if len(errors) == 0 {
+			t.Errorf("no errors for %q", tt.input)
+			continue
+		}
+		if errors[0].Error() != tt.expected {
+			t.Errorf("first error for %q wrong. want=%q, got=%q", tt.input, tt.expected, errors[0].Error())
+		}
+	}
+}
+
+func TestCallExpressionParsing(t *testing.T) {
+	input := "add(1, 2 * 3, 4 + 5);"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program has not enough statements. got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+	exp, ok := stmt.Expression.(*ast.CallExpression)
+	if !ok {
+		t.Fatalf("exp is not *CallExpression. got=%T", stmt.Expression)
+	}
+	if !testIdentifier(t, exp.Function, "add") {
+		return
+	}
+	if len(exp.Arguments) != 3 {
+		t.Fatalf("wrong length of arguments. got=%d", len(exp.Arguments))
+	}
+	testLiteralExpression(t, exp.Arguments[0], 1)
+	testInfixExpression(t, exp.Arguments[1], 2, "*", 3)
+	testInfixExpression(t, exp.Arguments[2], 4, "+", 5)
+}
+
+func TestErrorList(t *testing.T) {
+	input := "let x 5;\nlet = 10;\nif (x { 1 }"
+
+	p := New(lexer.New(input))
+	p.ParseProgram()
+
+	expected := []string{
+		"1:7: expected next token to be =, got INT instead",
+		"2:5: expected next token to be IDENT, got = instead",
+		"2:5: no prefix parse function for = found",
+		"3:7: expected next token to be ), got { instead",
+		"3:7: no prefix parse function for { found",
+		"3:11: no prefix parse function for } found",
+	}
+	errors := p.ErrorList()
+	if len(errors) != len(expected) {
+		t.Fatalf("wrong number of errors. want=%d, got=%d: %v", len(expected), len(errors), p.Errors())
+	}
+	for i, err := range errors {
+		if err.Error() != expected[i] {
+			t.Errorf("errors[%d] wrong. want=%q, got=%q", i, expected[i], err.Error())
+		}
+	}
+}
+
+func TestBigIntegerLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775808;", "9223372036854775808"},
+		{"123456789012345678901234567890;", "123456789012345678901234567890"},
+	}
+
+	for i, test := range tests {
+		l := lexer.New(test.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("[%d] program has not enough statements. got=%d", i, len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("[%d] program.Statements[0] is not *ExpressionStatement. got=%T",
+				i, program.Statements[0])
+		}
+		literal, ok := stmt.Expression.(*ast.IntegerLiteral)
+		if !ok {
+			t.Fatalf("[%d] exp is not *IntegerLiteral. got=%T", i, stmt.Expression)
+		}
+		if literal.Big == nil {
+			t.Fatalf("[%d] literal.Big is nil", i)
+		}
+		if literal.Big.String() != test.expected {
+			t.Errorf("[%d] literal.Big is not %s. got=%s", i, test.expected, literal.Big.String())
+		}
+	}
+}
+
+func TestReadError(t *testing.T) {
+	r := io.MultiReader(strings.NewReader("5 + "), iotest.ErrReader(errors.New("broken pipe")))
+	p := New(lexer.NewReader(r))
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("parser has no errors")
+	}
+	want := "could not read input: broken pipe"
+	if errors[len(errors)-1] != want {
+		t.Errorf("last error is not %q. got=%q", want, errors[len(errors)-1])
+	}
+}
+
 func checkParserErrors(t *testing.T, p *Parser) {
 	errors := p.Errors()
 	if len(errors) == 0 {
@@ -249,6 +620,8 @@ func testLiteralExpression(t *testing.T, exp ast.Expression, want interface{}) b
 		return testIntegerLiteral(t, exp, v)
 	case string:
 		return testIdentifier(t, exp, v)
+	case bool:
+		return testBooleanLiteral(t, exp, v)
 	}
 	t.Errorf("type of exp not handled. got=%T", exp)
 	return false
@@ -271,6 +644,39 @@ func testIntegerLiteral(t *testing.T, exp ast.Expression, want int64) bool {
 	return true
 }
 
+func testBooleanLiteral(t *testing.T, exp ast.Expression, want bool) bool {
+	boolean, ok := exp.(*ast.Boolean)
+	if !ok {
+		t.Fatalf("exp is not *Boolean. got=%T", exp)
+		return false
+	}
+	if boolean.Value != want {
+		t.Errorf("boolean.Value is not %t. got=%t", want, boolean.Value)
+		return false
+	}
+	if boolean.TokenLiteral() != fmt.Sprintf("%t", want) {
+		t.Errorf("boolean.TokenLiteral is not %t. got=%s", want, boolean.TokenLiteral())
+		return false
+	}
+	return true
+}
+
+func testInfixExpression(t *testing.T, exp ast.Expression, left interface{}, operator string, right interface{}) bool {
+	infix, ok := exp.(*ast.InfixExpression)
+	if !ok {
+		t.Fatalf("exp is not *InfixExpression. got=%T", exp)
+		return false
+	}
+	if !testLiteralExpression(t, infix.Left, left) {
+		return false
+	}
+	if infix.Operator != operator {
+		t.Errorf("infix.Operator is not %s. got=%s", operator, infix.Operator)
+		return false
+	}
+	return testLiteralExpression(t, infix.Right, right)
+}
+
 func testIdentifier(t *testing.T, exp ast.Expression, want string) bool {
 	ident, ok := exp.(*ast.Identifier)
 	if !ok {
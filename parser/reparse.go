@@ -0,0 +1,239 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/cst"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/token"
+)
+
+// Edit represents a replacement of the source text between byte offsets
+// Start and End with Text.
+type Edit struct {
+	Start int
+	End   int
+	Text  string
+}
+
+// ParseConcrete parses input into a lossless concrete syntax tree.
+func ParseConcrete(input string) *cst.Node {
+	return parseConcreteAt(input, token.Position{Line: 1, Column: 1})
+}
+
+// parseConcreteAt parses input, which is the part of a larger source
+// starting at pos, into a concrete syntax tree with the positions in the
+// source.
+func parseConcreteAt(input string, pos token.Position) *cst.Node {
+	l := lexer.NewAt(input, pos)
+	l.PreserveTrivia()
+	return New(l).ParseConcreteProgram()
+}
+
+// Reparse applies edit to the source of tree, which must be a tree returned by
+// ParseConcrete or Reparse, and returns the tree of the edited source.
+// Only the statements around the edit are lexed and parsed again; the other
+// statements of tree are reused, or copied with their positions shifted if
+// the edit moves them, and tree is left unchanged. The result is the same as
+// ParseConcrete of the edited source. Errors are collected by CollectErrors.
+func Reparse(tree *cst.Node, edit Edit) (*cst.Node, error) {
+	children := tree.Children
+	starts := make([]int, len(children)+1)
+	for i, child := range children {
+		starts[i+1] = starts[i] + child.Len()
+	}
+	length := starts[len(children)]
+	if edit.Start < 0 || edit.Start > edit.End || edit.End > length {
+		return nil, fmt.Errorf("edit range [%d, %d) is out of source range [0, %d)",
+			edit.Start, edit.End, length)
+	}
+	delta := len(edit.Text) - (edit.End - edit.Start)
+
+	// find children touching the edit, and the one before them as well since
+	// its end depends on the first token of the next statement.
+	lo, hi := -1, -1
+	for i := range children {
+		if starts[i] <= edit.End && edit.Start <= starts[i+1] {
+			if lo < 0 {
+				lo = i
+			}
+			hi = i
+		}
+	}
+	if lo > 0 {
+		lo--
+	}
+
+	// text of children from lo, with the edit applied
+	var text []byte
+	for _, child := range children[lo:] {
+		text = append(text, child.Text()...)
+	}
+	source := string(text)
+	offset := starts[lo]
+	text = append(text[:edit.Start-offset], append([]byte(edit.Text), text[edit.End-offset:]...)...)
+
+	// position of the start of the child lo, which is not moved by the edit
+	base := token.Position{Line: 1, Column: 1}
+	if lo > 0 {
+		last := lastToken(children[lo-1])
+		base = advance(last.Pos, last.Literal+last.Trailing)
+	}
+
+	// parse statements from lo until a statement starts at the same place as
+	// an unchanged old one, from which the old statements are reused.
+	for next := hi + 1; ; next++ {
+		if next >= len(children)-1 {
+			sub := parseConcreteAt(string(text), base)
+			return splice(tree, children[:lo], sub.Children, nil, sub.Errors), nil
+		}
+		end := starts[next+1] + delta - offset
+		sub := parseConcreteAt(string(text[:end]), base)
+
+		restart := starts[next] + delta - offset
+		pos := 0
+		for i, child := range sub.Children {
+			if pos == restart {
+				from := advance(base, source[:starts[next]-offset])
+				after := shift(children[next:], from, advance(base, string(text[:restart])))
+				return splice(tree, children[:lo], sub.Children[:i], after, tree.Errors), nil
+			}
+			pos += child.Len()
+		}
+	}
+}
+
+// lastToken returns the last token under n.
+func lastToken(n *cst.Node) *token.Token {
+	for n.Token == nil {
+		n = n.Children[len(n.Children)-1]
+	}
+	return n.Token
+}
+
+// advance returns the position after text starting at pos.
+func advance(pos token.Position, text string) token.Position {
+	for i := 0; i < len(text); i++ {
+		pos.Offset++
+		if text[i] == '\n' {
+			pos.Line++
+			pos.Column = 1
+		} else {
+			pos.Column++
+		}
+	}
+	return pos
+}
+
+// shift returns nodes, which start at from, moved to start at to. The nodes
+// are copied along with their AST nodes, so that the nodes given are left
+// unchanged.
+func shift(nodes []*cst.Node, from, to token.Position) []*cst.Node {
+	if from == to {
+		return nodes
+	}
+	move := func(pos *token.Position) {
+		if pos.Line == from.Line {
+			pos.Column += to.Column - from.Column
+		}
+		pos.Line += to.Line - from.Line
+		pos.Offset += to.Offset - from.Offset
+	}
+
+	moved := make([]*cst.Node, len(nodes))
+	for i, n := range nodes {
+		// the copies of the AST nodes by the original ones, which are
+		// visited in the same order in both trees
+		copies := map[ast.Node]ast.Node{}
+		if n.AST != nil {
+			var originals []ast.Node
+			ast.Inspect(n.AST, func(node ast.Node) bool {
+				originals = append(originals, node)
+				return true
+			})
+			k := 0
+			ast.Inspect(ast.Clone(n.AST), func(node ast.Node) bool {
+				copies[originals[k]] = node
+				k++
+				move(&tokenOf(node).Pos)
+				return true
+			})
+		}
+		moved[i] = copyNode(n, copies, move)
+	}
+	return moved
+}
+
+// copyNode returns a copy of n with its AST nodes replaced by their copies
+// and the positions of its tokens moved.
+func copyNode(n *cst.Node, copies map[ast.Node]ast.Node, move func(*token.Position)) *cst.Node {
+	c := &cst.Node{Kind: n.Kind, Errors: n.Errors}
+	if n.AST != nil {
+		c.AST = copies[n.AST]
+	}
+	if n.Token != nil {
+		tok := *n.Token
+		move(&tok.Pos)
+		c.Token = &tok
+	}
+	if n.Children != nil {
+		c.Children = make([]*cst.Node, len(n.Children))
+		for i, child := range n.Children {
+			c.Children[i] = copyNode(child, copies, move)
+		}
+	}
+	return c
+}
+
+// tokenOf returns the first token of an AST node.
+func tokenOf(node ast.Node) *token.Token {
+	switch n := node.(type) {
+	case *ast.LetStatement:
+		return &n.Token
+	case *ast.ReturnStatement:
+		return &n.Token
+	case *ast.ExpressionStatement:
+		return &n.Token
+	case *ast.BlockStatement:
+		return &n.Token
+	case *ast.PrefixExpression:
+		return &n.Token
+	case *ast.InfixExpression:
+		return &n.Token
+	case *ast.Identifier:
+		return &n.Token
+	case *ast.IntegerLiteral:
+		return &n.Token
+	case *ast.Boolean:
+		return &n.Token
+	case *ast.IfExpression:
+		return &n.Token
+	case *ast.FunctionLiteral:
+		return &n.Token
+	case *ast.CallExpression:
+		return &n.Token
+	case *ast.NamedType:
+		return &n.Token
+	case *ast.FunctionType:
+		return &n.Token
+	}
+	panic(fmt.Sprintf("parser: no token of %T", node))
+}
+
+// splice returns a new tree whose children are the concatenation of
+// before, middle and after.
+func splice(tree *cst.Node, before, middle, after []*cst.Node, errors []string) *cst.Node {
+	children := make([]*cst.Node, 0, len(before)+len(middle)+len(after))
+	children = append(children, before...)
+	children = append(children, middle...)
+	children = append(children, after...)
+
+	program := &ast.Program{Statements: []ast.Statement{}}
+	for _, child := range children {
+		if stmt, ok := child.AST.(ast.Statement); ok {
+			program.Statements = append(program.Statements, stmt)
+		}
+	}
+	return &cst.Node{Kind: tree.Kind, AST: program, Children: children, Errors: errors}
+}
@@ -0,0 +1,180 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/cst"
+	"github.com/oohira/monkey/sexpr"
+	"github.com/oohira/monkey/token"
+)
+
+const reparseInput = `let x = 5;
+let y = 10;
+// comment
+x + y * 2;
+-a
+b == c; return 1;
+foo
+`
+
+func TestReparse(t *testing.T) {
+	tests := []struct {
+		old  string
+		new  string
+		text string
+	}{
+		{"5", "5", "500"},
+		{"10;", "10", ""},
+		{"// comment\n", "// comment", " changed"},
+		{"x + y", "x", "z"},
+		{"x + y", "x ", "1 - "},
+		{"-a\nb", "\n", " * "},
+		{"-a\nb", "a", "a;"},
+		{"b == c", "==", "!="},
+		{"return", "return", "@"},
+		{"foo", "foo", "+"},
+		{"foo\n", "\n", "\nlet z = x;"},
+		{"let x = 5;\n", "\n", "\n\n"},
+		{"let y", "let", "\nlet"},
+		{"10;", ";", "; 1;"},
+		{"let x", "let x", "let xx"},
+		{"let x", "", "3 "},
+		{"5;", ";", " "},
+		{"let x = 5;", "let x = 5;", ""},
+		{reparseInput, reparseInput, ""},
+		{reparseInput, reparseInput, "1 + 2"},
+	}
+
+	for i, tt := range tests {
+		base := strings.Index(reparseInput, tt.old)
+		start := base + strings.Index(tt.old, tt.new)
+		edit := Edit{Start: start, End: start + len(tt.new), Text: tt.text}
+		source := reparseInput[:edit.Start] + edit.Text + reparseInput[edit.End:]
+
+		tree, err := Reparse(ParseConcrete(reparseInput), edit)
+		if err != nil {
+			t.Fatalf("[%d] unexpected error: %v", i, err)
+		}
+		checkSameTree(t, i, tree, ParseConcrete(source))
+		if tree.Text() != source {
+			t.Errorf("[%d] Text() wrong. want=%q, got=%q", i, source, tree.Text())
+		}
+	}
+}
+
+func TestReparseSequence(t *testing.T) {
+	source := ""
+	tree := ParseConcrete(source)
+	for i, ch := range "let add = a + b;\nadd * 2 // done\n" {
+		edit := Edit{Start: len(source), End: len(source), Text: string(ch)}
+		source += string(ch)
+
+		var err error
+		tree, err = Reparse(tree, edit)
+		if err != nil {
+			t.Fatalf("[%d] unexpected error: %v", i, err)
+		}
+		checkSameTree(t, i, tree, ParseConcrete(source))
+	}
+}
+
+func TestReparseReusesUnchangedStatements(t *testing.T) {
+	var b strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&b, "let v%d = %d;\n", i, i)
+	}
+	source := b.String()
+	old := ParseConcrete(source)
+
+	start := strings.Index(source, "50;")
+	tree, err := Reparse(old, Edit{Start: start, End: start + 2, Text: "51"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tree.Children) != len(old.Children) {
+		t.Fatalf("number of statements changed. want=%d, got=%d", len(old.Children), len(tree.Children))
+	}
+	reused := 0
+	for i := range tree.Children {
+		if tree.Children[i] == old.Children[i] {
+			reused++
+		}
+	}
+	if reused < len(old.Children)-3 {
+		t.Errorf("too few statements reused. got=%d of %d", reused, len(old.Children))
+	}
+}
+
+func TestReparseLeavesTreeUnchanged(t *testing.T) {
+	old := ParseConcrete(reparseInput)
+	want := dump(old)
+	pos := positions(old.AST)
+
+	start := strings.Index(reparseInput, "5;")
+	if _, err := Reparse(old, Edit{Start: start, End: start + 1, Text: "1 +\n 2"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := dump(old); got != want {
+		t.Errorf("old tree changed.\nwant=%s\ngot =%s", want, got)
+	}
+	if got := positions(old.AST); !reflect.DeepEqual(got, pos) {
+		t.Errorf("old AST positions changed.\nwant=%v\ngot =%v", pos, got)
+	}
+}
+
+func TestReparseOutOfRange(t *testing.T) {
+	tree := ParseConcrete("x;")
+	edits := []Edit{{-1, 0, ""}, {1, 0, ""}, {0, 3, ""}}
+	for _, edit := range edits {
+		if _, err := Reparse(tree, edit); err == nil {
+			t.Errorf("Reparse(%+v) should fail", edit)
+		}
+	}
+}
+
+func checkSameTree(t *testing.T, i int, got, want *cst.Node) {
+	t.Helper()
+	if dump(got) != dump(want) {
+		t.Errorf("[%d] tree wrong.\nwant=%s\ngot =%s", i, dump(want), dump(got))
+	}
+	if !reflect.DeepEqual(got.CollectErrors(), want.CollectErrors()) {
+		t.Errorf("[%d] errors wrong. want=%q, got=%q", i, want.CollectErrors(), got.CollectErrors())
+	}
+	if !ast.Equal(got.AST, want.AST) {
+		t.Errorf("[%d] AST wrong. want=%s, got=%s", i, sexpr.Encode(want.AST), sexpr.Encode(got.AST))
+	}
+	// ast.Equal ignores positions
+	if !reflect.DeepEqual(positions(got.AST), positions(want.AST)) {
+		t.Errorf("[%d] AST positions wrong.\nwant=%v\ngot =%v", i, positions(want.AST), positions(got.AST))
+	}
+}
+
+// positions returns the positions of the nodes of the AST in the form
+// "Kind@offset:line:column".
+func positions(node ast.Node) []string {
+	var positions []string
+	ast.Inspect(node, func(n ast.Node) bool {
+		tok := reflect.ValueOf(n).Elem().FieldByName("Token")
+		if tok.IsValid() {
+			pos := tok.Interface().(token.Token).Pos
+			positions = append(positions, fmt.Sprintf("%T@%d:%s", n, pos.Offset, pos))
+		}
+		return true
+	})
+	return positions
+}
+
+func dump(n *cst.Node) string {
+	if n.Token != nil {
+		return fmt.Sprintf("%q@%d:%s", n.Token.Leading+n.Token.Literal+n.Token.Trailing, n.Token.Pos.Offset, n.Token.Pos)
+	}
+	children := make([]string, len(n.Children))
+	for i, child := range n.Children {
+		children[i] = dump(child)
+	}
+	return n.Kind + "(" + strings.Join(children, " ") + ")"
+}
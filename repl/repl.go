@@ -6,7 +6,6 @@ import (
 	"io"
 
 	"github.com/oohira/monkey/lexer"
-	"github.com/oohira/monkey/token"
 )
 
 // PROMPT is characters to prompt users input.
@@ -24,8 +23,7 @@ func Start(in io.Reader, out io.Writer) {
 		}
 		line := scanner.Text()
 
-		l := lexer.New(line)
-		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		for _, tok := range lexer.Tokenize(line) {
 			fmt.Fprintf(out, "%+v\n", tok)
 		}
 	}
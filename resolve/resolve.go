@@ -0,0 +1,269 @@
+// Package resolve binds identifiers of a Monkey program to their declarations.
+//
+// A program, each function literal and each block is a scope. A name declared
+// by a let statement is visible from the next statement to the end of its
+// scope, and a parameter in the whole function. Since a function body is
+// evaluated only when the function is called, names declared later in the
+// enclosing scopes are also visible from it, which allows mutually recursive
+// functions.
+package resolve
+
+import (
+	"fmt"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/token"
+)
+
+// ScopeKind represents a kind of scope.
+type ScopeKind int
+
+// Kinds of scopes
+const (
+	ProgramScope ScopeKind = iota
+	FunctionScope
+	BlockScope
+)
+
+var scopeKindNames = [...]string{"program", "function", "block"}
+
+func (k ScopeKind) String() string {
+	return scopeKindNames[k]
+}
+
+// Scope represents a region of a program in which names are declared.
+// Node is the *ast.Program, *ast.FunctionLiteral or *ast.BlockStatement of
+// the scope. The body of a function shares the scope of its parameters.
+type Scope struct {
+	Kind     ScopeKind
+	Node     ast.Node
+	Outer    *Scope
+	Children []*Scope
+	Symbols  []*Symbol // in order of declaration
+
+	names map[string]*Symbol // latest declarations so far
+	all   map[string]*Symbol // first declarations anywhere in the scope
+}
+
+// Lookup returns the latest declaration of name in s or its outer scopes,
+// or nil if name is not declared.
+func (s *Scope) Lookup(name string) *Symbol {
+	for ; s != nil; s = s.Outer {
+		if sym, ok := s.names[name]; ok {
+			return sym
+		}
+	}
+	return nil
+}
+
+// resolve returns the declaration of name visible at the current point of
+// the walk in s.
+func (s *Scope) resolve(name string) *Symbol {
+	deferred := false
+	for ; s != nil; s = s.Outer {
+		if sym, ok := s.names[name]; ok {
+			return sym
+		}
+		if sym, ok := s.all[name]; ok && deferred {
+			return sym
+		}
+		if s.Kind == FunctionScope {
+			deferred = true
+		}
+	}
+	return nil
+}
+
+// SymbolKind represents a kind of declaration.
+type SymbolKind int
+
+// Kinds of symbols
+const (
+	LetSymbol SymbolKind = iota
+	ParameterSymbol
+)
+
+func (k SymbolKind) String() string {
+	if k == ParameterSymbol {
+		return "parameter"
+	}
+	return "let"
+}
+
+// Symbol represents a declared name. Node is the *ast.LetStatement or the
+// *ast.FunctionLiteral declaring it, and Uses are the identifiers referring
+// to it in order of appearance.
+type Symbol struct {
+	Name  string
+	Kind  SymbolKind
+	Decl  *ast.Identifier
+	Node  ast.Node
+	Scope *Scope
+	Uses  []*ast.Identifier
+}
+
+// ProblemKind represents a kind of problem found by the resolver.
+type ProblemKind int
+
+// Kinds of problems
+const (
+	Undefined  ProblemKind = iota // a name used without declaration
+	Redeclared                    // a name declared twice in the same scope
+	Shadowed                      // a name hiding a declaration of an outer scope
+)
+
+// Problem represents a problem of name binding. Ident is the identifier at
+// the problem, and Previous is the declaration redeclared or shadowed.
+type Problem struct {
+	Kind     ProblemKind
+	Ident    *ast.Identifier
+	Previous *Symbol
+}
+
+// Pos returns the position of the problem.
+func (p *Problem) Pos() token.Position {
+	return p.Ident.Token.Pos
+}
+
+// Message returns a description of the problem.
+func (p *Problem) Message() string {
+	switch p.Kind {
+	case Redeclared:
+		return fmt.Sprintf("%s redeclared in this scope; previous declaration at %s",
+			p.Ident.Value, p.Previous.Decl.Token.Pos)
+	case Shadowed:
+		return fmt.Sprintf("declaration of %s shadows declaration at %s",
+			p.Ident.Value, p.Previous.Decl.Token.Pos)
+	}
+	return "undefined: " + p.Ident.Value
+}
+
+func (p *Problem) String() string {
+	return p.Pos().String() + ": " + p.Message()
+}
+
+// Info represents the symbol table of a program.
+type Info struct {
+	Root     *Scope
+	Defs     map[*ast.Identifier]*Symbol // declaring identifiers
+	Uses     map[*ast.Identifier]*Symbol // identifiers referring to a declaration
+	Scopes   map[ast.Node]*Scope         // scopes by their nodes
+	Problems []*Problem                  // in order of appearance
+}
+
+// SymbolOf returns the symbol an identifier declares or refers to, or nil if
+// it is undefined.
+func (info *Info) SymbolOf(ident *ast.Identifier) *Symbol {
+	if sym, ok := info.Defs[ident]; ok {
+		return sym
+	}
+	return info.Uses[ident]
+}
+
+// Resolve resolves the identifiers of a program. It accepts programs with
+// syntax errors and skips missing nodes.
+func Resolve(program *ast.Program) *Info {
+	r := &resolver{info: &Info{
+		Defs:   map[*ast.Identifier]*Symbol{},
+		Uses:   map[*ast.Identifier]*Symbol{},
+		Scopes: map[ast.Node]*Scope{},
+	}}
+	r.info.Root = r.openScope(ProgramScope, program, nil, program.Statements)
+	r.statements(program.Statements, r.info.Root)
+	return r.info
+}
+
+type resolver struct {
+	info *Info
+}
+
+// openScope returns a new scope of node with the let statements of stmts
+// predeclared for functions referring to them.
+func (r *resolver) openScope(kind ScopeKind, node ast.Node, outer *Scope, stmts []ast.Statement) *Scope {
+	s := &Scope{
+		Kind:  kind,
+		Node:  node,
+		Outer: outer,
+		names: map[string]*Symbol{},
+		all:   map[string]*Symbol{},
+	}
+	if outer != nil {
+		outer.Children = append(outer.Children, s)
+	}
+	r.info.Scopes[node] = s
+	for _, stmt := range stmts {
+		if let, ok := stmt.(*ast.LetStatement); ok && let.Name != nil {
+			if _, ok := s.all[let.Name.Value]; !ok {
+				s.all[let.Name.Value] = &Symbol{Name: let.Name.Value, Kind: LetSymbol, Decl: let.Name, Node: let, Scope: s}
+			}
+		}
+	}
+	return s
+}
+
+func (r *resolver) statements(stmts []ast.Statement, s *Scope) {
+	for _, stmt := range stmts {
+		if let, ok := stmt.(*ast.LetStatement); ok {
+			r.node(let.Value, s)
+			r.declare(let.Name, LetSymbol, let, s)
+			continue
+		}
+		r.node(stmt, s)
+	}
+}
+
+func (r *resolver) declare(ident *ast.Identifier, kind SymbolKind, node ast.Node, s *Scope) {
+	if ident == nil {
+		return
+	}
+	sym := s.all[ident.Value]
+	if sym == nil || sym.Decl != ident {
+		sym = &Symbol{Name: ident.Value, Kind: kind, Decl: ident, Node: node, Scope: s}
+	}
+
+	if previous, ok := s.names[ident.Value]; ok {
+		r.problem(Redeclared, ident, previous)
+	} else if previous := s.resolve(ident.Value); previous != nil {
+		r.problem(Shadowed, ident, previous)
+	}
+	s.names[ident.Value] = sym
+	s.Symbols = append(s.Symbols, sym)
+	r.info.Defs[ident] = sym
+}
+
+func (r *resolver) problem(kind ProblemKind, ident *ast.Identifier, previous *Symbol) {
+	r.info.Problems = append(r.info.Problems, &Problem{Kind: kind, Ident: ident, Previous: previous})
+}
+
+func (r *resolver) node(node ast.Node, s *Scope) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Identifier:
+			if sym := s.resolve(n.Value); sym != nil {
+				sym.Uses = append(sym.Uses, n)
+				r.info.Uses[n] = sym
+			} else {
+				r.problem(Undefined, n, nil)
+			}
+		case *ast.BlockStatement:
+			inner := r.openScope(BlockScope, n, s, n.Statements)
+			r.statements(n.Statements, inner)
+			return false
+		case *ast.FunctionLiteral:
+			var body []ast.Statement
+			if n.Body != nil {
+				body = n.Body.Statements
+			}
+			inner := r.openScope(FunctionScope, n, s, body)
+			for _, param := range n.Parameters {
+				r.declare(param, ParameterSymbol, n, inner)
+			}
+			if n.Body != nil {
+				r.info.Scopes[n.Body] = inner
+			}
+			r.statements(body, inner)
+			return false
+		}
+		return true
+	})
+}
@@ -0,0 +1,157 @@
+package resolve
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+// bindings returns the uses of identifiers as "name@use->decl" in order.
+func bindings(program *ast.Program, info *Info) string {
+	var out []string
+	ast.Inspect(program, func(n ast.Node) bool {
+		ident, ok := n.(*ast.Identifier)
+		if !ok || info.Defs[ident] != nil {
+			return true
+		}
+		if sym := info.Uses[ident]; sym != nil {
+			out = append(out, ident.Value+"@"+ident.Token.Pos.String()+"->"+sym.Decl.Token.Pos.String())
+		} else {
+			out = append(out, ident.Value+"@"+ident.Token.Pos.String()+"->?")
+		}
+		return true
+	})
+	return strings.Join(out, " ")
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		input    string
+		bindings string
+		problems []string
+	}{
+		{
+			"let x = 1; x;",
+			"x@1:12->1:5",
+			nil,
+		},
+		{
+			"x; let x = 1; let x = x;",
+			"x@1:1->? x@1:23->1:8",
+			[]string{"1:1: undefined: x", "1:19: x redeclared in this scope; previous declaration at 1:8"},
+		},
+		{
+			"let f = fn(n) { f(n) }; let g = fn() { h() }; let h = fn() { g() };",
+			"f@1:17->1:5 n@1:19->1:12 h@1:40->1:51 g@1:62->1:29",
+			nil,
+		},
+		{
+			"let x = 1; if (x) { let x = 2; x }; x",
+			"x@1:16->1:5 x@1:32->1:25 x@1:37->1:5",
+			[]string{"1:25: declaration of x shadows declaration at 1:5"},
+		},
+		{
+			"if (true) { let a = 1; } else { a }; a",
+			"a@1:33->? a@1:38->?",
+			[]string{"1:33: undefined: a", "1:38: undefined: a"},
+		},
+		{
+			"let a = 1; fn(a, b) { let b = a; fn() { a + b } }",
+			"a@1:31->1:15 a@1:41->1:15 b@1:45->1:27",
+			[]string{
+				"1:15: declaration of a shadows declaration at 1:5",
+				"1:27: b redeclared in this scope; previous declaration at 1:18",
+			},
+		},
+		{
+			"fn() { let y = z; let z = 1; }",
+			"z@1:16->?",
+			[]string{"1:16: undefined: z"},
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		info := Resolve(program)
+		if got := bindings(program, info); got != tt.bindings {
+			t.Errorf("bindings of %q wrong.\nwant=%s\ngot =%s", tt.input, tt.bindings, got)
+		}
+		var problems []string
+		for _, p := range info.Problems {
+			problems = append(problems, p.String())
+		}
+		if strings.Join(problems, "\n") != strings.Join(tt.problems, "\n") {
+			t.Errorf("problems of %q wrong.\nwant=%q\ngot =%q", tt.input, tt.problems, problems)
+		}
+	}
+}
+
+func TestSymbolTable(t *testing.T) {
+	program := parse(t, "let add = fn(a, b) { if (a) { let c = b; c } }; add(1, add);")
+	info := Resolve(program)
+
+	root := info.Root
+	if root.Kind != ProgramScope || root.Node != program || len(root.Symbols) != 1 {
+		t.Fatalf("root scope wrong: %+v", root)
+	}
+	add := root.Symbols[0]
+	if add.Name != "add" || add.Kind != LetSymbol || add.Node != program.Statements[0] || len(add.Uses) != 2 {
+		t.Errorf("symbol add wrong: %+v", add)
+	}
+	if root.Lookup("add") != add || root.Lookup("a") != nil {
+		t.Errorf("Lookup in root scope wrong")
+	}
+
+	fn := program.Statements[0].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	fnScope := info.Scopes[fn]
+	if fnScope == nil || fnScope.Kind != FunctionScope || fnScope.Outer != root || info.Scopes[fn.Body] != fnScope {
+		t.Fatalf("function scope wrong: %+v", fnScope)
+	}
+	var names []string
+	for _, sym := range fnScope.Symbols {
+		names = append(names, sym.Kind.String()+" "+sym.Name)
+	}
+	if strings.Join(names, ", ") != "parameter a, parameter b" {
+		t.Errorf("symbols of function scope wrong: %v", names)
+	}
+	if fnScope.Lookup("add") != add {
+		t.Errorf("Lookup of outer name in function scope wrong")
+	}
+
+	if len(fnScope.Children) != 1 {
+		t.Fatalf("function scope has wrong number of children: %d", len(fnScope.Children))
+	}
+	block := fnScope.Children[0]
+	if block.Kind != BlockScope || block.Outer != fnScope || len(block.Symbols) != 1 {
+		t.Fatalf("block scope wrong: %+v", block)
+	}
+	c := block.Symbols[0]
+	if c.Name != "c" || len(c.Uses) != 1 || info.SymbolOf(c.Uses[0]) != c || info.SymbolOf(c.Decl) != c {
+		t.Errorf("symbol c wrong: %+v", c)
+	}
+	if b := block.Lookup("b"); b == nil || b.Kind != ParameterSymbol || b.Node != fn {
+		t.Errorf("Lookup of parameter from block wrong: %+v", b)
+	}
+}
+
+func TestResolveIncompleteProgram(t *testing.T) {
+	p := parser.New(lexer.New("let = 1; let x = fn(a) { a + ; x"))
+	program := p.ParseProgram()
+	info := Resolve(program)
+	if info.Root == nil {
+		t.Fatalf("Resolve returned no root scope")
+	}
+}
@@ -0,0 +1,365 @@
+// Package rvm executes Monkey programs on a register-based virtual machine.
+//
+// The programs are compiled from the SSA form of package ssa, whose values
+// are assigned to registers by ssa.Allocate. Each call has a window of
+// registers on a register stack, and the i-th argument is passed in the
+// i-th register of the window. The phis are lowered into moves at the
+// ends of the predecessors of their blocks.
+package rvm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/oohira/monkey/object"
+	"github.com/oohira/monkey/ssa"
+)
+
+// Opcode represents an operation of an instruction. R(x) is the register
+// x of the window, K(x) the constant x, G(x) the global x and F(x) the cell
+// of the free variable x of the running closure.
+type Opcode byte
+
+// Opcodes
+const (
+	OpLoadConst     Opcode = iota // R(A) = K(B)
+	OpLoadNil                     // R(A) = undefined
+	OpMove                        // R(A) = R(B)
+	OpGetGlobal                   // R(A) = the first defined of R(Regs...), or G(B), failing if it is not set
+	OpSetGlobal                   // G(A) = R(B)
+	OpGetFree                     // R(A) = F(B)
+	OpNewCell                     // R(A) = a new cell
+	OpLoad                        // R(A) = the value in the cell R(B)
+	OpStore                       // the value in the cell R(A) = R(B)
+	OpCheck                       // R(A) = the first defined of R(Regs...), failing with the name C if none is
+	OpNeg                         // R(A) = -R(B)
+	OpNot                         // R(A) = !R(B)
+	OpAdd                         // R(A) = R(B) + R(C)
+	OpSub                         // R(A) = R(B) - R(C)
+	OpMul                         // R(A) = R(B) * R(C)
+	OpDiv                         // R(A) = R(B) / R(C)
+	OpEqual                       // R(A) = R(B) == R(C)
+	OpNotEqual                    // R(A) = R(B) != R(C)
+	OpLessThan                    // R(A) = R(B) < R(C)
+	OpGreaterThan                 // R(A) = R(B) > R(C)
+	OpClosure                     // R(A) = closure of the function B with R(Regs...)
+	OpCall                        // R(A) = R(B)(R(Regs...))
+	OpJump                        // jump to A
+	OpJumpNotTruthy               // jump to B unless R(A) is truthy
+	OpReturn                      // return R(A)
+)
+
+var opcodeNames = [...]string{
+	OpLoadConst:     "loadconst",
+	OpLoadNil:       "loadnil",
+	OpMove:          "move",
+	OpGetGlobal:     "getglobal",
+	OpSetGlobal:     "setglobal",
+	OpGetFree:       "getfree",
+	OpNewCell:       "newcell",
+	OpLoad:          "load",
+	OpStore:         "store",
+	OpCheck:         "check",
+	OpNeg:           "neg",
+	OpNot:           "not",
+	OpAdd:           "add",
+	OpSub:           "sub",
+	OpMul:           "mul",
+	OpDiv:           "div",
+	OpEqual:         "eq",
+	OpNotEqual:      "ne",
+	OpLessThan:      "lt",
+	OpGreaterThan:   "gt",
+	OpClosure:       "closure",
+	OpCall:          "call",
+	OpJump:          "jump",
+	OpJumpNotTruthy: "jumpnot",
+	OpReturn:        "return",
+}
+
+func (op Opcode) String() string {
+	if int(op) < len(opcodeNames) {
+		return opcodeNames[op]
+	}
+	return fmt.Sprintf("opcode(%d)", op)
+}
+
+// Instruction represents an instruction. Regs are the registers of the cells
+// of OpClosure, the arguments of OpCall and the variables of OpGetGlobal
+// and OpCheck.
+type Instruction struct {
+	Op      Opcode
+	A, B, C int
+	Regs    []int
+}
+
+func (ins Instruction) String() string {
+	var operands []string
+	r := func(x int) string { return fmt.Sprintf("r%d", x) }
+	switch ins.Op {
+	case OpLoadConst:
+		operands = []string{r(ins.A), fmt.Sprintf("k%d", ins.B)}
+	case OpLoadNil, OpNewCell, OpReturn:
+		operands = []string{r(ins.A)}
+	case OpMove, OpNeg, OpNot, OpLoad, OpStore:
+		operands = []string{r(ins.A), r(ins.B)}
+	case OpGetGlobal:
+		operands = []string{r(ins.A), fmt.Sprintf("g%d", ins.B)}
+	case OpSetGlobal:
+		operands = []string{fmt.Sprintf("g%d", ins.A), r(ins.B)}
+	case OpGetFree:
+		operands = []string{r(ins.A), fmt.Sprintf("f%d", ins.B)}
+	case OpCheck:
+		operands = []string{r(ins.A), fmt.Sprintf("n%d", ins.C)}
+	case OpClosure:
+		operands = []string{r(ins.A), fmt.Sprintf("fn#%d", ins.B)}
+	case OpCall:
+		operands = []string{r(ins.A), r(ins.B)}
+	case OpJump:
+		operands = []string{fmt.Sprintf("%04d", ins.A)}
+	case OpJumpNotTruthy:
+		operands = []string{r(ins.A), fmt.Sprintf("%04d", ins.B)}
+	default:
+		operands = []string{r(ins.A), r(ins.B), r(ins.C)}
+	}
+	for _, x := range ins.Regs {
+		operands = append(operands, r(x))
+	}
+	return ins.Op.String() + " " + strings.Join(operands, " ")
+}
+
+// Function represents a compiled function.
+type Function struct {
+	Name      string
+	Source    string
+	NumParams int
+	NumRegs   int
+	Code      []Instruction
+}
+
+// Program represents a compiled program. Functions[0] is the top-level
+// statements, and Names are the names checked by OpCheck.
+type Program struct {
+	Functions []*Function
+	Constants []object.Object
+	Globals   []string
+	Names     []string
+}
+
+// Compile allocates the registers of p and compiles it.
+func Compile(p *ssa.Program) *Program {
+	c := &compiler{
+		prog:      &Program{Globals: p.Globals},
+		constants: map[string]int{},
+	}
+	for _, f := range p.Funcs {
+		ssa.Allocate(f)
+		c.prog.Functions = append(c.prog.Functions, c.compile(f))
+	}
+	return c.prog
+}
+
+type compiler struct {
+	prog      *Program
+	constants map[string]int // indexes of the constants by type and value
+}
+
+func (c *compiler) compile(f *ssa.Func) *Function {
+	fn := &Function{Name: f.Name, Source: f.Source, NumParams: len(f.Params), NumRegs: f.NumRegs}
+	if f.ID == 0 {
+		fn.Name = "main"
+	}
+	emit := func(ins Instruction) {
+		fn.Code = append(fn.Code, ins)
+	}
+
+	starts := map[*ssa.Block]int{}
+	type fixup struct {
+		pc     int
+		target *ssa.Block
+	}
+	var fixups []fixup
+	jump := func(target *ssa.Block, next int) {
+		if next < len(f.Blocks) && f.Blocks[next] == target {
+			return
+		}
+		fixups = append(fixups, fixup{len(fn.Code), target})
+		emit(Instruction{Op: OpJump})
+	}
+
+	for i, b := range f.Blocks {
+		starts[b] = len(fn.Code)
+		for _, v := range b.Values {
+			c.compileValue(v, emit)
+		}
+		switch b.Kind {
+		case ssa.BlockPlain:
+			phiMoves(b, f.NumRegs, fn, emit)
+			jump(b.Succs[0], i+1)
+		case ssa.BlockIf:
+			fixups = append(fixups, fixup{len(fn.Code), b.Succs[1]})
+			emit(Instruction{Op: OpJumpNotTruthy, A: b.Control.Reg})
+			jump(b.Succs[0], i+1)
+		case ssa.BlockReturn:
+			emit(Instruction{Op: OpReturn, A: b.Control.Reg})
+		}
+	}
+	for _, fix := range fixups {
+		ins := &fn.Code[fix.pc]
+		if ins.Op == OpJump {
+			ins.A = starts[fix.target]
+		} else {
+			ins.B = starts[fix.target]
+		}
+	}
+	return fn
+}
+
+var binaryOpcodes = map[ssa.Op]Opcode{
+	ssa.OpAdd: OpAdd,
+	ssa.OpSub: OpSub,
+	ssa.OpMul: OpMul,
+	ssa.OpDiv: OpDiv,
+	ssa.OpEq:  OpEqual,
+	ssa.OpNe:  OpNotEqual,
+	ssa.OpLt:  OpLessThan,
+	ssa.OpGt:  OpGreaterThan,
+}
+
+func (c *compiler) compileValue(v *ssa.Value, emit func(Instruction)) {
+	p := c.prog
+	regs := func(values []*ssa.Value) []int {
+		rs := make([]int, len(values))
+		for i, arg := range values {
+			rs[i] = arg.Reg
+		}
+		return rs
+	}
+
+	switch v.Op {
+	case ssa.OpConst:
+		key := string(v.Const.Type()) + " " + v.Const.Inspect()
+		index, ok := c.constants[key]
+		if !ok {
+			index = len(p.Constants)
+			p.Constants = append(p.Constants, v.Const)
+			c.constants[key] = index
+		}
+		emit(Instruction{Op: OpLoadConst, A: v.Reg, B: index})
+	case ssa.OpUndef:
+		emit(Instruction{Op: OpLoadNil, A: v.Reg})
+	case ssa.OpParam, ssa.OpPhi:
+		// in the register already
+	case ssa.OpFree:
+		emit(Instruction{Op: OpGetFree, A: v.Reg, B: v.Index})
+	case ssa.OpCell:
+		emit(Instruction{Op: OpNewCell, A: v.Reg})
+	case ssa.OpLoad:
+		emit(Instruction{Op: OpLoad, A: v.Reg, B: v.Args[0].Reg})
+	case ssa.OpStore:
+		emit(Instruction{Op: OpStore, A: v.Args[0].Reg, B: v.Args[1].Reg})
+	case ssa.OpGlobal:
+		emit(Instruction{Op: OpGetGlobal, A: v.Reg, B: v.Index, Regs: regs(v.Args)})
+	case ssa.OpSetGlobal:
+		emit(Instruction{Op: OpSetGlobal, A: v.Index, B: v.Args[0].Reg})
+	case ssa.OpCheck:
+		p.Names = append(p.Names, v.Name)
+		emit(Instruction{Op: OpCheck, A: v.Reg, C: len(p.Names) - 1, Regs: regs(v.Args)})
+	case ssa.OpCopy:
+		if v.Reg != v.Args[0].Reg {
+			emit(Instruction{Op: OpMove, A: v.Reg, B: v.Args[0].Reg})
+		}
+	case ssa.OpNeg:
+		emit(Instruction{Op: OpNeg, A: v.Reg, B: v.Args[0].Reg})
+	case ssa.OpNot:
+		emit(Instruction{Op: OpNot, A: v.Reg, B: v.Args[0].Reg})
+	case ssa.OpClosure:
+		emit(Instruction{Op: OpClosure, A: v.Reg, B: v.Func.ID, Regs: regs(v.Args)})
+	case ssa.OpCall:
+		emit(Instruction{Op: OpCall, A: v.Reg, B: v.Args[0].Reg, Regs: regs(v.Args[1:])})
+	default:
+		emit(Instruction{Op: binaryOpcodes[v.Op], A: v.Reg, B: v.Args[0].Reg, C: v.Args[1].Reg})
+	}
+}
+
+// phiMoves emits the moves setting the phis of the successor of b to their
+// values from b. The moves are parallel, so that a register is read before
+// it is overwritten, and a cycle of moves goes through the register
+// scratch, which is added to the registers of fn if used.
+func phiMoves(b *ssa.Block, scratch int, fn *Function, emit func(Instruction)) {
+	succ := b.Succs[0]
+	var dsts, srcs []int
+	for _, v := range succ.Values {
+		if v.Op != ssa.OpPhi {
+			break
+		}
+		for j, pred := range succ.Preds {
+			if pred == b && v.Reg != v.Args[j].Reg {
+				dsts = append(dsts, v.Reg)
+				srcs = append(srcs, v.Args[j].Reg)
+			}
+		}
+	}
+
+	for len(dsts) > 0 {
+		// a move whose destination is not read by the others goes first
+		i := 0
+		for ; i < len(dsts); i++ {
+			read := false
+			for j, src := range srcs {
+				read = read || j != i && src == dsts[i]
+			}
+			if !read {
+				break
+			}
+		}
+		if i == len(dsts) {
+			if fn.NumRegs <= scratch {
+				fn.NumRegs = scratch + 1
+			}
+			emit(Instruction{Op: OpMove, A: scratch, B: dsts[0]})
+			for j := range srcs {
+				if srcs[j] == dsts[0] {
+					srcs[j] = scratch
+				}
+			}
+			i = 0
+		}
+		emit(Instruction{Op: OpMove, A: dsts[i], B: srcs[i]})
+		dsts = append(dsts[:i], dsts[i+1:]...)
+		srcs = append(srcs[:i], srcs[i+1:]...)
+	}
+}
+
+// Fprint writes the instructions of the program in a human readable form.
+func Fprint(w io.Writer, p *Program) error {
+	var out strings.Builder
+	out.WriteString("constants:\n")
+	for i, c := range p.Constants {
+		fmt.Fprintf(&out, "  k%d %s %s\n", i, c.Type(), c.Inspect())
+	}
+	for i, fn := range p.Functions {
+		name := ""
+		if fn.Name != "" {
+			name = " " + fn.Name
+		}
+		fmt.Fprintf(&out, "fn#%d%s (params=%d, regs=%d):\n", i, name, fn.NumParams, fn.NumRegs)
+		for pc, ins := range fn.Code {
+			line := fmt.Sprintf("  %04d %s", pc, ins)
+			switch ins.Op {
+			case OpGetGlobal:
+				line = fmt.Sprintf("%-32s ; %s", line, p.Globals[ins.B])
+			case OpSetGlobal:
+				line = fmt.Sprintf("%-32s ; %s", line, p.Globals[ins.A])
+			case OpLoadConst:
+				line = fmt.Sprintf("%-32s ; %s", line, p.Constants[ins.B].Inspect())
+			case OpCheck:
+				line = fmt.Sprintf("%-32s ; %s", line, p.Names[ins.C])
+			}
+			out.WriteString(line + "\n")
+		}
+	}
+	_, err := io.WriteString(w, out.String())
+	return err
+}
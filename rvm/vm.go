@@ -0,0 +1,251 @@
+package rvm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/oohira/monkey/object"
+)
+
+// Limits of the virtual machine
+const (
+	StackSize = 1 << 20 // registers of all the calls
+	MaxFrames = 1 << 14
+
+	initialStackSize = 1 << 10
+)
+
+// ErrStackOverflow is returned when the registers or the call frames are
+// exhausted.
+var ErrStackOverflow = errors.New("stack overflow")
+
+// Closure represents a function with the cells of its free variables.
+type Closure struct {
+	Fn   *Function
+	Free []*object.Cell
+}
+
+// Type returns the type of the closure, which is a function in Monkey.
+func (c *Closure) Type() object.Type {
+	return object.FUNCTION
+}
+
+// Inspect returns the source of the function in the format of the
+// evaluator.
+func (c *Closure) Inspect() string {
+	return c.Fn.Source
+}
+
+// VM represents a virtual machine running a program.
+type VM struct {
+	program *Program
+	globals []object.Object
+	stack   []object.Object
+	depth   int
+}
+
+// New returns a virtual machine ready to run program.
+func New(program *Program) *VM {
+	return &VM{
+		program: program,
+		globals: make([]object.Object, len(program.Globals)),
+		stack:   make([]object.Object, initialStackSize),
+	}
+}
+
+// Run runs the program and returns its value.
+func (vm *VM) Run() (object.Object, error) {
+	main := vm.program.Functions[0]
+	if !vm.reserve(main.NumRegs) {
+		return nil, ErrStackOverflow
+	}
+	return vm.run(&Closure{Fn: main}, 0)
+}
+
+// reserve grows the stack to hold n registers, and reports whether they are
+// within StackSize. The windows of the running calls stay in the old stack,
+// which is fine since a call reads only its own registers.
+func (vm *VM) reserve(n int) bool {
+	if n <= len(vm.stack) {
+		return true
+	}
+	if n > StackSize {
+		return false
+	}
+	size := 2 * len(vm.stack)
+	for size < n {
+		size *= 2
+	}
+	if size > StackSize {
+		size = StackSize
+	}
+	stack := make([]object.Object, size)
+	copy(stack, vm.stack)
+	vm.stack = stack
+	return true
+}
+
+// run runs cl with the window of registers starting at base.
+func (vm *VM) run(cl *Closure, base int) (object.Object, error) {
+	code := cl.Fn.Code
+	regs := vm.stack[base : base+cl.Fn.NumRegs]
+	for pc := 0; ; pc++ {
+		ins := &code[pc]
+		switch ins.Op {
+		case OpLoadConst:
+			regs[ins.A] = vm.program.Constants[ins.B]
+
+		case OpLoadNil:
+			regs[ins.A] = nil
+
+		case OpMove:
+			regs[ins.A] = regs[ins.B]
+
+		case OpGetGlobal:
+			value := first(regs, ins.Regs)
+			if value == nil {
+				value = vm.globals[ins.B]
+			}
+			if value == nil {
+				return nil, fmt.Errorf("identifier not found: %s", vm.program.Globals[ins.B])
+			}
+			regs[ins.A] = value
+
+		case OpSetGlobal:
+			vm.globals[ins.A] = regs[ins.B]
+
+		case OpGetFree:
+			regs[ins.A] = cl.Free[ins.B]
+
+		case OpNewCell:
+			regs[ins.A] = &object.Cell{}
+
+		case OpLoad:
+			regs[ins.A] = regs[ins.B].(*object.Cell).Value
+
+		case OpStore:
+			regs[ins.A].(*object.Cell).Value = regs[ins.B]
+
+		case OpCheck:
+			value := first(regs, ins.Regs)
+			if value == nil {
+				return nil, fmt.Errorf("identifier not found: %s", vm.program.Names[ins.C])
+			}
+			regs[ins.A] = value
+
+		case OpNeg, OpNot:
+			operator := "-"
+			if ins.Op == OpNot {
+				operator = "!"
+			}
+			result, err := object.Prefix(operator, regs[ins.B])
+			if err != nil {
+				return nil, err
+			}
+			regs[ins.A] = result
+
+		case OpAdd, OpSub, OpMul, OpDiv, OpEqual, OpNotEqual, OpLessThan, OpGreaterThan:
+			result, err := infix(ins.Op, regs[ins.B], regs[ins.C])
+			if err != nil {
+				return nil, err
+			}
+			regs[ins.A] = result
+
+		case OpClosure:
+			free := make([]*object.Cell, len(ins.Regs))
+			for i, r := range ins.Regs {
+				free[i] = regs[r].(*object.Cell)
+			}
+			regs[ins.A] = &Closure{Fn: vm.program.Functions[ins.B], Free: free}
+
+		case OpCall:
+			result, err := vm.call(regs[ins.B], ins.Regs, regs, base+len(regs))
+			if err != nil {
+				return nil, err
+			}
+			regs[ins.A] = result
+
+		case OpJump:
+			pc = ins.A - 1
+
+		case OpJumpNotTruthy:
+			if !object.IsTruthy(regs[ins.A]) {
+				pc = ins.B - 1
+			}
+
+		case OpReturn:
+			return regs[ins.A], nil
+
+		default:
+			return nil, fmt.Errorf("unknown opcode %d", ins.Op)
+		}
+	}
+}
+
+// first returns the value of the first of the registers rs of regs which
+// is defined, or nil.
+func first(regs []object.Object, rs []int) object.Object {
+	for _, r := range rs {
+		if regs[r] != nil {
+			return regs[r]
+		}
+	}
+	return nil
+}
+
+// call calls callee with the arguments in the registers args of regs, with
+// the window of registers of the callee starting at base.
+func (vm *VM) call(callee object.Object, args []int, regs []object.Object, base int) (object.Object, error) {
+	cl, ok := callee.(*Closure)
+	if !ok {
+		return nil, fmt.Errorf("not a function: %s", callee.Type())
+	}
+	if len(args) != cl.Fn.NumParams {
+		return nil, fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParams, len(args))
+	}
+	if vm.depth >= MaxFrames || !vm.reserve(base+cl.Fn.NumRegs) {
+		return nil, ErrStackOverflow
+	}
+	for i, r := range args {
+		vm.stack[base+i] = regs[r]
+	}
+	vm.depth++
+	result, err := vm.run(cl, base)
+	vm.depth--
+	return result, err
+}
+
+var infixOperators = map[Opcode]string{
+	OpAdd:         "+",
+	OpSub:         "-",
+	OpMul:         "*",
+	OpDiv:         "/",
+	OpEqual:       "==",
+	OpNotEqual:    "!=",
+	OpLessThan:    "<",
+	OpGreaterThan: ">",
+}
+
+// infix applies the operator of op to left and right, without looking up
+// the operator for small integers.
+func infix(op Opcode, left, right object.Object) (object.Object, error) {
+	if l, ok := left.(*object.Integer); ok && !l.IsBig() {
+		if r, ok := right.(*object.Integer); ok && !r.IsBig() {
+			switch op {
+			case OpAdd:
+				return l.Add(r), nil
+			case OpSub:
+				return l.Sub(r), nil
+			case OpLessThan:
+				return object.NativeBool(l.Value < r.Value), nil
+			case OpGreaterThan:
+				return object.NativeBool(l.Value > r.Value), nil
+			case OpEqual:
+				return object.NativeBool(l.Value == r.Value), nil
+			case OpNotEqual:
+				return object.NativeBool(l.Value != r.Value), nil
+			}
+		}
+	}
+	return object.Infix(infixOperators[op], left, right)
+}
@@ -0,0 +1,190 @@
+package rvm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/evaluator"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/object"
+	"github.com/oohira/monkey/parser"
+	"github.com/oohira/monkey/ssa"
+)
+
+func parse(t testing.TB, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+// run builds program, optimizes it by passes and runs it, and returns the
+// result in the form of the evaluator, that is, a runtime error is returned
+// as an *object.Error.
+func run(t testing.TB, program *ast.Program, passes []ssa.Pass) object.Object {
+	t.Helper()
+	p, err := ssa.Build(program)
+	if err != nil {
+		t.Fatalf("build error: %s", err)
+	}
+	ssa.Optimize(p, passes)
+	result, err := New(Compile(p)).Run()
+	if err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	return result
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5", "5"},
+		{"-5 + 10 * 2", "15"},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", "50"},
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"!true; !!5", "true"},
+		{"1 < 2 == true", "true"},
+		{"1 == true", "false"},
+		{"if (0) { 10 }", "10"},
+		{"if (1 > 2) { 10 }", "null"},
+		{"if (1 > 2) { 10 } else { 20 }", "20"},
+		{"if (true) {}", "null"},
+		{"if (if (false) { 10 }) { 10 } else { 20 }", "20"},
+		{"let a = 5; let b = a * 2; b", "10"},
+		{"let a = 5;", "null"},
+		{"", "null"},
+		{"9; return 2 * 5; 9;", "10"},
+		{"if (true) { if (true) { return 10; } return 1; }", "10"},
+		{"let f = fn(x) { if (x) { return 1; } 2 }; f(true) + f(false)", "3"},
+		{"let f = fn() { let x = 1; }; f()", "null"},
+		{"let f = fn() { }; f()", "null"},
+		{"let add = fn(a, b) { a + b }; add(add(1, 2), 3)", "6"},
+		{"fn(x) { x }(5)", "5"},
+		{"let one = fn() { 1 }; let g = fn() { one }; g()()", "1"},
+		{"let adder = fn(x) { fn(y) { x + y } }; let addTwo = adder(2); addTwo(3)", "5"},
+		{"let f = fn(a) { fn(b) { fn(c) { a + b + c } } }; f(1)(2)(3)", "6"},
+		{"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(25)", "15511210043330985984000000"},
+		{"let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } }; let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; odd(7)", "true"},
+		{"let f = fn() { let count = fn(n) { if (n == 0) { 0 } else { count(n - 1) + 1 } }; count(10) }; f()", "10"},
+		{"let f = fn(x) { 1 + if (x) { return 10; } else { 2 } }; f(true) + f(false)", "13"},
+		{"let x = 1; let f = fn() { x }; let x = 2; f()", "2"},
+		{"let x = 10; let f = fn(x) { let x = x + 1; x }; f(1) + x", "12"},
+		{"let f = fn(a, b) { let x = a * b; let y = a * b; x + y }; f(3, 4)", "24"},
+		{"let f = fn(a, b) { let t = if (a) { b } else { a }; let u = if (a) { b } else { a }; t + u }; f(1, 2)", "4"},
+		{"let swap = fn(a, b, n) { if (n == 0) { a * 10 + b } else { swap(b, a, n - 1) } }; swap(1, 2, 3)", "21"},
+		{"5 + true;", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+		{"-true", "ERROR: unknown operator: -BOOLEAN"},
+		{"true + false; 5", "ERROR: unknown operator: BOOLEAN + BOOLEAN"},
+		{"if (10 > 1) { true + false; 5 }", "ERROR: unknown operator: BOOLEAN + BOOLEAN"},
+		{"true < false", "ERROR: unknown operator: BOOLEAN < BOOLEAN"},
+		{"1 / 0", "ERROR: division by zero"},
+		{"let f = fn(x) { let y = 1 / x; 5 }; f(0)", "ERROR: division by zero"},
+		{"5()", "ERROR: not a function: INTEGER"},
+		{"fn(x) { x }(1, 2)", "ERROR: wrong number of arguments: want=1, got=2"},
+		{"let f = fn() { g }; f(); let g = 1;", "ERROR: identifier not found: g"},
+		{"fn() { if (false) { let y = 1; } y }()", "ERROR: identifier not found: y"},
+		{"x; let x = 1", "ERROR: identifier not found: x"},
+		{"let f = fn(a) { a + b }; f(1)", "ERROR: identifier not found: b"},
+		{"let f = fn() { return 1; g() }; f()", "1"},
+		{"fn(x) { x } + 1", "ERROR: type mismatch: FUNCTION + INTEGER"},
+		{"let g = fn() { let a = 1; let h = fn() { a }; let a = 2; h() }; g()", "2"},
+		{"let f = fn() { let loop = fn(n) { loop }; let r = loop(1); let loop = 5; r(1) }; f()", "5"},
+		{"let x = 1; let f = fn() { if (true) { let x = 2; } x }; f() + x", "3"},
+		{"let f = fn(x, x) { x }; f(1, 2)", "2"},
+		{"let f = fn(x, y, x) { fn() { x + y } }; f(1, 2, 3)()", "5"},
+		{"let f = fn() { let h = fn() { y }; if (false) { let y = 1; } h() }; let y = 2; f()", "2"},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		for _, passes := range [][]ssa.Pass{nil, ssa.Passes} {
+			got := run(t, program, passes)
+			if got.Inspect() != tt.expected {
+				t.Errorf("result of %q with %d passes wrong. want=%s, got=%s", tt.input, len(passes), tt.expected, got.Inspect())
+			}
+		}
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	got := run(t, parse(t, "let f = fn(n) { f(n + 1) + 1 }; f(0)"), nil)
+	if got.Inspect() != "ERROR: stack overflow" {
+		t.Errorf("result wrong. got=%s", got.Inspect())
+	}
+}
+
+func TestPhiMoves(t *testing.T) {
+	tests := []struct {
+		phis     [][2]int // registers of the phis and of their values
+		expected []string
+		numRegs  int
+	}{
+		{[][2]int{{0, 0}}, nil, 3},
+		{[][2]int{{1, 0}, {2, 1}}, []string{"move r2 r1", "move r1 r0"}, 3},
+		{[][2]int{{0, 1}, {1, 0}}, []string{"move r3 r0", "move r0 r1", "move r1 r3"}, 4},
+	}
+
+	for _, tt := range tests {
+		pred, succ := &ssa.Block{}, &ssa.Block{}
+		pred.Succs = []*ssa.Block{succ}
+		succ.Preds = []*ssa.Block{pred}
+		for _, phi := range tt.phis {
+			arg := &ssa.Value{Reg: phi[1]}
+			succ.Values = append(succ.Values, &ssa.Value{Op: ssa.OpPhi, Reg: phi[0], Args: []*ssa.Value{arg}})
+		}
+		fn := &Function{NumRegs: 3}
+		var got []string
+		phiMoves(pred, 3, fn, func(ins Instruction) {
+			got = append(got, ins.String())
+		})
+		if strings.Join(got, "; ") != strings.Join(tt.expected, "; ") {
+			t.Errorf("moves of %v wrong. want=%q, got=%q", tt.phis, tt.expected, got)
+		}
+		if fn.NumRegs != tt.numRegs {
+			t.Errorf("NumRegs of %v wrong. want=%d, got=%d", tt.phis, tt.numRegs, fn.NumRegs)
+		}
+	}
+}
+
+// BenchmarkRun runs the programs ../testdata/*.mk shared by the
+// implementations, whose results must be those of the evaluator.
+func BenchmarkRun(b *testing.B) {
+	files, err := filepath.Glob("../testdata/*.mk")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			b.Fatal(err)
+		}
+		program := parse(b, string(src))
+		want := evaluator.Eval(program, object.NewEnvironment()).Inspect()
+		b.Run(strings.TrimSuffix(filepath.Base(file), ".mk"), func(b *testing.B) {
+			p, err := ssa.Build(program)
+			if err != nil {
+				b.Fatalf("build error: %s", err)
+			}
+			ssa.Optimize(p, ssa.Passes)
+			compiled := Compile(p)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				result, err := New(compiled).Run()
+				if err != nil {
+					result = &object.Error{Message: err.Error()}
+				}
+				if got := result.Inspect(); got != want {
+					b.Fatalf("result wrong. want=%s, got=%s", want, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,573 @@
+package sexpr
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/token"
+)
+
+// Encode returns the canonical S-expression of an AST node, e.g.
+// (let x (infix + 1 (infix * 2 3))) for "let x = 1 + 2 * 3;".
+//
+// Identifiers, integers and booleans are atoms, and the other nodes are lists
+// headed by program, let, return, block, prefix, infix, if, fn, params or call.
+// An expression statement is encoded as its expression, and a nil node as nil.
+//
+// Type annotations follow the annotated names: (let x int 5),
+// (params (a int) b) and (fn (params a) int (block a)) for the result type.
+// A type name is an atom and a function type is (-> int int bool), where the
+// last item is the result type.
+func Encode(node ast.Node) string {
+	var out strings.Builder
+	encode(&out, node)
+	return out.String()
+}
+
+func encode(out *strings.Builder, node ast.Node) {
+	list := func(head string, items ...func()) {
+		out.WriteString("(" + head)
+		for _, item := range items {
+			out.WriteString(" ")
+			item()
+		}
+		out.WriteString(")")
+	}
+	sub := func(n ast.Node) func() {
+		return func() { encode(out, n) }
+	}
+	atom := func(s string) func() {
+		return func() { out.WriteString(s) }
+	}
+	stmts := func(ss []ast.Statement) []func() {
+		items := make([]func(), len(ss))
+		for i, s := range ss {
+			items[i] = sub(s)
+		}
+		return items
+	}
+
+	switch n := node.(type) {
+	case *ast.Program:
+		if n != nil {
+			list("program", stmts(n.Statements)...)
+			return
+		}
+	case *ast.LetStatement:
+		if n != nil {
+			if n.Type != nil {
+				list("let", sub(n.Name), sub(n.Type), sub(n.Value))
+			} else {
+				list("let", sub(n.Name), sub(n.Value))
+			}
+			return
+		}
+	case *ast.ReturnStatement:
+		if n != nil {
+			list("return", sub(n.ReturnValue))
+			return
+		}
+	case *ast.ExpressionStatement:
+		if n != nil {
+			encode(out, n.Expression)
+			return
+		}
+	case *ast.BlockStatement:
+		if n != nil {
+			list("block", stmts(n.Statements)...)
+			return
+		}
+	case *ast.PrefixExpression:
+		if n != nil {
+			list("prefix", atom(n.Operator), sub(n.Right))
+			return
+		}
+	case *ast.InfixExpression:
+		if n != nil {
+			list("infix", atom(n.Operator), sub(n.Left), sub(n.Right))
+			return
+		}
+	case *ast.IfExpression:
+		if n != nil {
+			items := []func(){sub(n.Condition), sub(n.Consequence)}
+			if n.Alternative != nil {
+				items = append(items, sub(n.Alternative))
+			}
+			list("if", items...)
+			return
+		}
+	case *ast.FunctionLiteral:
+		if n != nil {
+			params := make([]func(), len(n.Parameters))
+			for i, p := range n.Parameters {
+				params[i] = sub(p)
+			}
+			items := []func(){func() { list("params", params...) }}
+			if n.ReturnType != nil {
+				items = append(items, sub(n.ReturnType))
+			}
+			list("fn", append(items, sub(n.Body))...)
+			return
+		}
+	case *ast.CallExpression:
+		if n != nil {
+			items := []func(){sub(n.Function)}
+			for _, arg := range n.Arguments {
+				items = append(items, sub(arg))
+			}
+			list("call", items...)
+			return
+		}
+	case *ast.Identifier:
+		if n != nil && n.Type != nil {
+			list(n.Value, sub(n.Type))
+			return
+		}
+		if n != nil {
+			out.WriteString(n.Value)
+			return
+		}
+	case *ast.IntegerLiteral:
+		if n != nil {
+			if n.Big != nil {
+				out.WriteString(n.Big.String())
+			} else {
+				out.WriteString(strconv.FormatInt(n.Value, 10))
+			}
+			return
+		}
+	case *ast.Boolean:
+		if n != nil {
+			out.WriteString(strconv.FormatBool(n.Value))
+			return
+		}
+	case *ast.NamedType:
+		if n != nil {
+			out.WriteString(n.Name)
+			return
+		}
+	case *ast.FunctionType:
+		if n != nil {
+			items := make([]func(), 0, len(n.Parameters)+1)
+			for _, p := range n.Parameters {
+				items = append(items, sub(p))
+			}
+			list("->", append(items, sub(n.Result))...)
+			return
+		}
+	}
+	out.WriteString("nil")
+}
+
+// sexp represents a parsed S-expression: an atom or a list.
+type sexp struct {
+	atom   string
+	list   []*sexp
+	isList bool
+	offset int
+}
+
+func (s *sexp) String() string {
+	if !s.isList {
+		return s.atom
+	}
+	items := make([]string, len(s.list))
+	for i, item := range s.list {
+		items[i] = item.String()
+	}
+	return "(" + strings.Join(items, " ") + ")"
+}
+
+// Error represents an error in an S-expression at a byte offset.
+type Error struct {
+	Offset int
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("sexpr: offset %d: %s", e.Offset, e.Msg)
+}
+
+// Read parses an S-expression produced by Encode back into an AST node.
+// An atom or a list other than a statement in statement position becomes an
+// ExpressionStatement. Tokens of the nodes are synthesized without positions.
+func Read(src string) (ast.Node, error) {
+	r := &reader{src: src}
+	s, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+	r.skipSpace()
+	if r.pos < len(r.src) {
+		return nil, &Error{r.pos, "unexpected text after S-expression"}
+	}
+	if s.isList && len(s.list) > 0 && !s.list[0].isList {
+		switch s.list[0].atom {
+		case "program":
+			return program(s)
+		case "let", "return", "block":
+			return statement(s)
+		}
+	}
+	return expression(s)
+}
+
+type reader struct {
+	src string
+	pos int
+}
+
+func (r *reader) skipSpace() {
+	for r.pos < len(r.src) && strings.ContainsRune(" \t\r\n", rune(r.src[r.pos])) {
+		r.pos++
+	}
+}
+
+func (r *reader) read() (*sexp, error) {
+	r.skipSpace()
+	if r.pos >= len(r.src) {
+		return nil, &Error{r.pos, "unexpected end of input"}
+	}
+	start := r.pos
+	switch r.src[r.pos] {
+	case '(':
+		r.pos++
+		s := &sexp{isList: true, offset: start}
+		for {
+			r.skipSpace()
+			if r.pos >= len(r.src) {
+				return nil, &Error{start, "unclosed list"}
+			}
+			if r.src[r.pos] == ')' {
+				r.pos++
+				return s, nil
+			}
+			item, err := r.read()
+			if err != nil {
+				return nil, err
+			}
+			s.list = append(s.list, item)
+		}
+	case ')':
+		return nil, &Error{start, "unexpected )"}
+	}
+	for r.pos < len(r.src) && !strings.ContainsRune(" \t\r\n()", rune(r.src[r.pos])) {
+		r.pos++
+	}
+	return &sexp{atom: r.src[start:r.pos], offset: start}, nil
+}
+
+// form checks that s is a list headed by head with at least min and at most
+// max items after the head. A negative max means no upper limit.
+func form(s *sexp, head string, min, max int) error {
+	if !s.isList || len(s.list) == 0 || s.list[0].isList || s.list[0].atom != head {
+		return &Error{s.offset, fmt.Sprintf("expected (%s ...), got %s", head, s)}
+	}
+	if n := len(s.list) - 1; n < min || max >= 0 && n > max {
+		return &Error{s.offset, fmt.Sprintf("wrong number of items in %s", s)}
+	}
+	return nil
+}
+
+func program(s *sexp) (*ast.Program, error) {
+	if err := form(s, "program", 0, -1); err != nil {
+		return nil, err
+	}
+	stmts, err := statements(s.list[1:])
+	if err != nil {
+		return nil, err
+	}
+	return &ast.Program{Statements: stmts}, nil
+}
+
+func statements(items []*sexp) ([]ast.Statement, error) {
+	stmts := []ast.Statement{}
+	for _, item := range items {
+		stmt, err := statement(item)
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+	return stmts, nil
+}
+
+func statement(s *sexp) (ast.Statement, error) {
+	if s.isList && len(s.list) > 0 && !s.list[0].isList {
+		switch s.list[0].atom {
+		case "let":
+			if err := form(s, "let", 2, 3); err != nil {
+				return nil, err
+			}
+			name, err := identifier(s.list[1])
+			if err != nil {
+				return nil, err
+			}
+			let := &ast.LetStatement{Token: tok(token.LET, "let"), Name: name}
+			if len(s.list) == 4 {
+				if let.Type, err = typeExpression(s.list[2]); err != nil {
+					return nil, err
+				}
+			}
+			if let.Value, err = expression(s.list[len(s.list)-1]); err != nil {
+				return nil, err
+			}
+			return let, nil
+		case "return":
+			if err := form(s, "return", 1, 1); err != nil {
+				return nil, err
+			}
+			value, err := expression(s.list[1])
+			if err != nil {
+				return nil, err
+			}
+			return &ast.ReturnStatement{Token: tok(token.RETURN, "return"), ReturnValue: value}, nil
+		case "block":
+			return block(s)
+		}
+	}
+	exp, err := expression(s)
+	if err != nil {
+		return nil, err
+	}
+	return &ast.ExpressionStatement{Token: firstToken(exp), Expression: exp}, nil
+}
+
+func block(s *sexp) (*ast.BlockStatement, error) {
+	if err := form(s, "block", 0, -1); err != nil {
+		return nil, err
+	}
+	stmts, err := statements(s.list[1:])
+	if err != nil {
+		return nil, err
+	}
+	return &ast.BlockStatement{Token: tok(token.LBRACE, "{"), Statements: stmts}, nil
+}
+
+var (
+	prefixOperators = map[string]token.Type{"!": token.BANG, "-": token.MINUS}
+	infixOperators  = map[string]token.Type{
+		"+": token.PLUS, "-": token.MINUS, "*": token.ASTERISK, "/": token.SLASH,
+		"<": token.LT, ">": token.GT, "==": token.EQ, "!=": token.NOTEQ,
+	}
+)
+
+func expression(s *sexp) (ast.Expression, error) {
+	if !s.isList {
+		return atom(s)
+	}
+	if len(s.list) == 0 || s.list[0].isList {
+		return nil, &Error{s.offset, fmt.Sprintf("expected an expression, got %s", s)}
+	}
+
+	switch s.list[0].atom {
+	case "prefix":
+		if err := form(s, "prefix", 2, 2); err != nil {
+			return nil, err
+		}
+		op := s.list[1].atom
+		t, ok := prefixOperators[op]
+		if !ok || s.list[1].isList {
+			return nil, &Error{s.list[1].offset, fmt.Sprintf("unknown prefix operator %s", s.list[1])}
+		}
+		right, err := expression(s.list[2])
+		if err != nil {
+			return nil, err
+		}
+		return &ast.PrefixExpression{Token: tok(t, op), Operator: op, Right: right}, nil
+	case "infix":
+		if err := form(s, "infix", 3, 3); err != nil {
+			return nil, err
+		}
+		op := s.list[1].atom
+		t, ok := infixOperators[op]
+		if !ok || s.list[1].isList {
+			return nil, &Error{s.list[1].offset, fmt.Sprintf("unknown infix operator %s", s.list[1])}
+		}
+		left, err := expression(s.list[2])
+		if err != nil {
+			return nil, err
+		}
+		right, err := expression(s.list[3])
+		if err != nil {
+			return nil, err
+		}
+		return &ast.InfixExpression{Token: tok(t, op), Operator: op, Left: left, Right: right}, nil
+	case "if":
+		if err := form(s, "if", 2, 3); err != nil {
+			return nil, err
+		}
+		cond, err := expression(s.list[1])
+		if err != nil {
+			return nil, err
+		}
+		exp := &ast.IfExpression{Token: tok(token.IF, "if"), Condition: cond}
+		if exp.Consequence, err = block(s.list[2]); err != nil {
+			return nil, err
+		}
+		if len(s.list) == 4 {
+			if exp.Alternative, err = block(s.list[3]); err != nil {
+				return nil, err
+			}
+		}
+		return exp, nil
+	case "fn":
+		if err := form(s, "fn", 2, 3); err != nil {
+			return nil, err
+		}
+		if err := form(s.list[1], "params", 0, -1); err != nil {
+			return nil, err
+		}
+		fn := &ast.FunctionLiteral{Token: tok(token.FUNCTION, "fn"), Parameters: []*ast.Identifier{}}
+		for _, p := range s.list[1].list[1:] {
+			param, err := parameter(p)
+			if err != nil {
+				return nil, err
+			}
+			fn.Parameters = append(fn.Parameters, param)
+		}
+		if len(s.list) == 4 {
+			var err error
+			if fn.ReturnType, err = typeExpression(s.list[2]); err != nil {
+				return nil, err
+			}
+		}
+		body, err := block(s.list[len(s.list)-1])
+		if err != nil {
+			return nil, err
+		}
+		fn.Body = body
+		return fn, nil
+	case "call":
+		if err := form(s, "call", 1, -1); err != nil {
+			return nil, err
+		}
+		function, err := expression(s.list[1])
+		if err != nil {
+			return nil, err
+		}
+		call := &ast.CallExpression{Token: tok(token.LPAREN, "("), Function: function, Arguments: []ast.Expression{}}
+		for _, a := range s.list[2:] {
+			arg, err := expression(a)
+			if err != nil {
+				return nil, err
+			}
+			call.Arguments = append(call.Arguments, arg)
+		}
+		return call, nil
+	}
+	return nil, &Error{s.offset, fmt.Sprintf("unknown form %s", s.list[0])}
+}
+
+func atom(s *sexp) (ast.Expression, error) {
+	switch {
+	case s.atom == "true" || s.atom == "false":
+		return &ast.Boolean{Token: tok(token.LookupIdent(s.atom), s.atom), Value: s.atom == "true"}, nil
+	case s.atom[0] >= '0' && s.atom[0] <= '9':
+		v, ok := new(big.Int).SetString(s.atom, 10)
+		if !ok {
+			return nil, &Error{s.offset, fmt.Sprintf("invalid integer %s", s.atom)}
+		}
+		literal := &ast.IntegerLiteral{Token: tok(token.INT, s.atom)}
+		if v.IsInt64() {
+			literal.Value = v.Int64()
+		} else {
+			literal.Big = v
+		}
+		return literal, nil
+	}
+	return identifier(s)
+}
+
+func identifier(s *sexp) (*ast.Identifier, error) {
+	if s.isList || token.LookupIdent(s.atom) != token.IDENT || !isIdentifier(s.atom) {
+		return nil, &Error{s.offset, fmt.Sprintf("expected an identifier, got %s", s)}
+	}
+	return &ast.Identifier{Token: tok(token.IDENT, s.atom), Value: s.atom}, nil
+}
+
+// parameter reads a parameter, which is an identifier or a list of an
+// identifier and its type.
+func parameter(s *sexp) (*ast.Identifier, error) {
+	if !s.isList {
+		return identifier(s)
+	}
+	if len(s.list) != 2 {
+		return nil, &Error{s.offset, fmt.Sprintf("expected a parameter, got %s", s)}
+	}
+	ident, err := identifier(s.list[0])
+	if err != nil {
+		return nil, err
+	}
+	if ident.Type, err = typeExpression(s.list[1]); err != nil {
+		return nil, err
+	}
+	return ident, nil
+}
+
+func typeExpression(s *sexp) (ast.TypeExpression, error) {
+	if !s.isList {
+		if token.LookupIdent(s.atom) != token.IDENT || !isIdentifier(s.atom) {
+			return nil, &Error{s.offset, fmt.Sprintf("expected a type, got %s", s)}
+		}
+		return &ast.NamedType{Token: tok(token.IDENT, s.atom), Name: s.atom}, nil
+	}
+	if err := form(s, "->", 1, -1); err != nil {
+		return nil, err
+	}
+	t := &ast.FunctionType{Token: tok(token.FUNCTION, "fn"), Parameters: []ast.TypeExpression{}}
+	items := s.list[1:]
+	for _, item := range items[:len(items)-1] {
+		param, err := typeExpression(item)
+		if err != nil {
+			return nil, err
+		}
+		t.Parameters = append(t.Parameters, param)
+	}
+	result, err := typeExpression(items[len(items)-1])
+	if err != nil {
+		return nil, err
+	}
+	t.Result = result
+	return t, nil
+}
+
+func isIdentifier(s string) bool {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if !('a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func tok(t token.Type, literal string) token.Token {
+	return token.Token{Type: t, Literal: literal}
+}
+
+// firstToken returns the token an expression statement starts with.
+func firstToken(exp ast.Expression) token.Token {
+	switch e := exp.(type) {
+	case *ast.InfixExpression:
+		return firstToken(e.Left)
+	case *ast.CallExpression:
+		return firstToken(e.Function)
+	case *ast.Identifier:
+		return e.Token
+	case *ast.IntegerLiteral:
+		return e.Token
+	case *ast.Boolean:
+		return e.Token
+	case *ast.PrefixExpression:
+		return e.Token
+	case *ast.IfExpression:
+		return e.Token
+	case *ast.FunctionLiteral:
+		return e.Token
+	}
+	return token.Token{}
+}
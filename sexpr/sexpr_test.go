@@ -0,0 +1,151 @@
+package sexpr
+
+import (
+	"testing"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 1 + 2 * 3;", "(program (let x (infix + 1 (infix * 2 3))))"},
+		{"return -a;", "(program (return (prefix - a)))"},
+		{"!true == false", "(program (infix == (prefix ! true) false))"},
+		{"(1 + 2) * 3", "(program (infix * (infix + 1 2) 3))"},
+		{"if (x < y) { x } else { y }", "(program (if (infix < x y) (block x) (block y)))"},
+		{"if (x) { }", "(program (if x (block)))"},
+		{"fn(a, b) { return a; }", "(program (fn (params a b) (block (return a))))"},
+		{"fn() { 1 }()", "(program (call (fn (params) (block 1))))"},
+		{"add(1, f(2))", "(program (call add 1 (call f 2)))"},
+		{"9223372036854775808", "(program 9223372036854775808)"},
+		{"", "(program)"},
+		{"let x: int = 1;", "(program (let x int 1))"},
+		{"fn(a: int, b) -> fn(int) -> bool { a }",
+			"(program (fn (params (a int) b) (-> int bool) (block a)))"},
+	}
+
+	for _, tt := range tests {
+		got := Encode(parse(t, tt.input))
+		if got != tt.expected {
+			t.Errorf("Encode(%q) wrong.\nwant=%s\ngot =%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestEncodeNode(t *testing.T) {
+	program := parse(t, "let x = 1 + 2 * 3;")
+	if got := Encode(program.Statements[0]); got != "(let x (infix + 1 (infix * 2 3)))" {
+		t.Errorf("Encode(statement) wrong. got=%s", got)
+	}
+	if got := Encode(nil); got != "nil" {
+		t.Errorf("Encode(nil) wrong. got=%s", got)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	inputs := []string{
+		"let x = 5; let y = true; let foobar = y;",
+		"return 5; return 10; return add(15);",
+		"-a * b; !-a; a + b + c; a * b / c; 3 + 4 * 5 == 3 * 1 + 4 * 5",
+		"3 > 5 == false; 1 + (2 + 3) + 4; -(5 + 5); !(true == true)",
+		"a + add(b * c) + d; add(a, b, 1, 2 * 3, 4 + 5, add(6, 7 * 8))",
+		"if (x < y) { x }; if (x != y) { x } else { let z = y; return z; }",
+		"let f = fn(x, y) { x + y; }; fn() {}; f(1)(2);",
+		"let big = 18446744073709551616 * 2;",
+		"let f: fn(int, fn() -> bool) -> int = fn(a: int, g: fn() -> bool) -> int { a };",
+	}
+
+	for _, input := range inputs {
+		program := parse(t, input)
+		encoded := Encode(program)
+		node, err := Read(encoded)
+		if err != nil {
+			t.Errorf("Read(%s) returned error: %s", encoded, err)
+			continue
+		}
+		if node.String() != program.String() {
+			t.Errorf("String() of read program wrong.\nwant=%s\ngot =%s", program.String(), node.String())
+		}
+		if again := Encode(node); again != encoded {
+			t.Errorf("Encode of read program wrong.\nwant=%s\ngot =%s", encoded, again)
+		}
+	}
+}
+
+func TestReadNode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(let x (infix + 1 (infix * 2 3)))", "let x = (1 + (2 * 3));"},
+		{"(return x)", "return x;"},
+		{"(block (call f) 1)", "f()1"},
+		{"( infix\n- a  (prefix - b) )", "(a - (-b))"},
+		{"call", "call"},
+		{"(call call infix)", "call(infix)"},
+	}
+
+	for _, tt := range tests {
+		node, err := Read(tt.input)
+		if err != nil {
+			t.Errorf("Read(%q) returned error: %s", tt.input, err)
+			continue
+		}
+		if node.String() != tt.expected {
+			t.Errorf("Read(%q) wrong. want=%q, got=%q", tt.input, tt.expected, node.String())
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", "sexpr: offset 0: unexpected end of input"},
+		{"(program", "sexpr: offset 0: unclosed list"},
+		{")", "sexpr: offset 0: unexpected )"},
+		{"x y", "sexpr: offset 2: unexpected text after S-expression"},
+		{"(infix % 1 2)", "sexpr: offset 7: unknown infix operator %"},
+		{"(prefix + 1)", "sexpr: offset 8: unknown prefix operator +"},
+		{"(let 1 2)", "sexpr: offset 5: expected an identifier, got 1"},
+		{"(let x)", "sexpr: offset 0: wrong number of items in (let x)"},
+		{"(program (let if 1))", "sexpr: offset 14: expected an identifier, got if"},
+		{"(if x y)", "sexpr: offset 6: expected (block ...), got y"},
+		{"(fn (a) (block))", "sexpr: offset 4: expected (params ...), got (a)"},
+		{"(program (let x (while x)))", "sexpr: offset 16: unknown form while"},
+		{"(1 2)", "sexpr: offset 0: unknown form 1"},
+		{"()", "sexpr: offset 0: expected an expression, got ()"},
+		{"12a", "sexpr: offset 0: invalid integer 12a"},
+		{"x-y", "sexpr: offset 0: expected an identifier, got x-y"},
+		{"(let x 1 2)", "sexpr: offset 7: expected a type, got 1"},
+		{"(fn (params (a)) (block))", "sexpr: offset 12: expected a parameter, got (a)"},
+		{"(let f (->) g)", "sexpr: offset 7: wrong number of items in (->)"},
+	}
+
+	for _, tt := range tests {
+		_, err := Read(tt.input)
+		if err == nil {
+			t.Errorf("Read(%q) returned no error", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("Read(%q) error wrong. want=%q, got=%q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
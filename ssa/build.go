@@ -0,0 +1,561 @@
+package ssa
+
+import (
+	"fmt"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/env"
+	"github.com/oohira/monkey/object"
+	"github.com/oohira/monkey/token"
+)
+
+// Build translates program into SSA form. The let statements of the
+// variables which are not captured become copies named after their
+// variables, which copy propagation removes.
+func Build(program *ast.Program) (*Program, error) {
+	p := &Program{}
+	info := env.Analyze(program)
+	b := &builder{
+		prog:    p,
+		info:    info,
+		fn:      &Func{},
+		env:     info.Functions[program],
+		defs:    map[*Block]map[string]*Value{},
+		globals: map[string]int{},
+	}
+	p.Funcs = append(p.Funcs, b.fn)
+
+	b.block = b.fn.newBlock()
+	b.fn.Blocks = []*Block{b.block}
+	value, err := b.statements(program.Statements)
+	if err != nil {
+		return nil, err
+	}
+	b.ret(value)
+	b.finish()
+	return p, nil
+}
+
+// builder builds a function. The builder of the top-level statements has
+// no outer builder, and its variables are globals.
+type builder struct {
+	prog  *Program
+	info  *env.Info
+	fn    *Func
+	env   *env.Function
+	outer *builder
+	block *Block // block being built
+
+	defs     map[*Block]map[string]*Value // values of the locals at the end of blocks
+	undef    *Value
+	cells    map[*env.Variable]*Value // cells of the captured locals
+	free     map[*env.Variable]*Value // cells of the free variables
+	freeVars []*env.Variable          // free variables by index
+
+	globals map[string]int // of the top-level builder, indexes of the globals
+}
+
+func (b *builder) top() *builder {
+	for b.outer != nil {
+		b = b.outer
+	}
+	return b
+}
+
+// emit appends a value to the current block.
+func (b *builder) emit(op Op, args ...*Value) *Value {
+	return b.fn.newValue(b.block, op, args...)
+}
+
+// entryValue inserts a value into the entry block after the parameters.
+func (b *builder) entryValue(op Op) *Value {
+	entry := b.fn.Blocks[0]
+	v := &Value{ID: b.fn.nextValue, Op: op, Block: entry, Reg: -1}
+	b.fn.nextValue++
+	i := 0
+	for i < len(entry.Values) && entry.Values[i].Op == OpParam {
+		i++
+	}
+	entry.Values = append(entry.Values[:i], append([]*Value{v}, entry.Values[i:]...)...)
+	return v
+}
+
+func (b *builder) constant(obj object.Object) *Value {
+	v := b.emit(OpConst)
+	v.Const = obj
+	return v
+}
+
+// reachable reports whether control may reach the current block.
+func (b *builder) reachable() bool {
+	return b.block == b.fn.Blocks[0] || len(b.block.Preds) > 0
+}
+
+// jump ends the current block with a jump to target.
+func (b *builder) jump(target *Block) {
+	b.block.Kind = BlockPlain
+	b.block.Succs = []*Block{target}
+	if b.reachable() {
+		target.Preds = append(target.Preds, b.block)
+	}
+}
+
+// ret ends the current block with a return of value and continues in an
+// unreachable block.
+func (b *builder) ret(value *Value) {
+	b.block.Kind = BlockReturn
+	b.block.Control = value
+	b.block = b.fn.newBlock()
+}
+
+// finish orders the reachable blocks of the function in reverse postorder
+// and numbers the blocks and the values.
+func (b *builder) finish() {
+	f := b.fn
+	var postorder []*Block
+	visited := map[*Block]bool{}
+	var visit func(*Block)
+	visit = func(block *Block) {
+		visited[block] = true
+		// the first successor comes first in reverse postorder
+		for i := len(block.Succs) - 1; i >= 0; i-- {
+			if s := block.Succs[i]; !visited[s] {
+				visit(s)
+			}
+		}
+		postorder = append(postorder, block)
+	}
+	visit(f.Blocks[0])
+
+	f.Blocks = f.Blocks[:0]
+	for i := len(postorder) - 1; i >= 0; i-- {
+		f.Blocks = append(f.Blocks, postorder[i])
+	}
+	renumber(f)
+}
+
+// renumber numbers the blocks and the values of f in order.
+func renumber(f *Func) {
+	f.nextBlock, f.nextValue = 0, 0
+	for _, block := range f.Blocks {
+		block.ID = f.nextBlock
+		f.nextBlock++
+		for _, v := range block.Values {
+			v.ID = f.nextValue
+			f.nextValue++
+		}
+	}
+}
+
+// statements builds stmts and returns the value of the last statement,
+// which is null unless it is an expression statement.
+func (b *builder) statements(stmts []ast.Statement) (*Value, error) {
+	var value *Value
+	for _, stmt := range stmts {
+		value = nil
+		switch stmt := stmt.(type) {
+		case *ast.ExpressionStatement:
+			v, err := b.expression(stmt.Expression)
+			if err != nil {
+				return nil, err
+			}
+			value = v
+		case *ast.LetStatement:
+			if err := b.let(stmt); err != nil {
+				return nil, err
+			}
+		case *ast.ReturnStatement:
+			v, err := b.expression(stmt.ReturnValue)
+			if err != nil {
+				return nil, err
+			}
+			b.ret(v)
+		case *ast.BlockStatement:
+			v, err := b.statements(stmt.Statements)
+			if err != nil {
+				return nil, err
+			}
+			value = v
+		default:
+			return nil, fmt.Errorf("cannot build %T", stmt)
+		}
+	}
+	if value == nil {
+		value = b.constant(object.NullValue)
+	}
+	return value, nil
+}
+
+func (b *builder) let(let *ast.LetStatement) error {
+	name := let.Name.Value
+	var value *Value
+	var err error
+	if fl, ok := let.Value.(*ast.FunctionLiteral); ok {
+		value, err = b.function(fl, name)
+	} else {
+		value, err = b.expression(let.Value)
+	}
+	if err != nil {
+		return err
+	}
+
+	if b.outer == nil {
+		set := b.emit(OpSetGlobal, value)
+		set.Index, set.Name = b.global(name), name
+		return nil
+	}
+	b.assign(b.env.Lookup(name), value)
+	return nil
+}
+
+// assign sets the local variable v to value. A captured variable is also
+// set in its cell, which only its function sets, so that the function reads
+// the value without loading it from the cell.
+func (b *builder) assign(v *env.Variable, value *Value) {
+	if v.Captured {
+		store := b.emit(OpStore, b.cells[v], value)
+		store.Name = v.Name
+	} else {
+		value = b.emit(OpCopy, value)
+		value.Name = v.Name
+	}
+	b.write(b.block, v.Name, value)
+}
+
+// global returns the index of the global name, defining it if needed.
+func (b *builder) global(name string) int {
+	top := b.top()
+	if index, ok := top.globals[name]; ok {
+		return index
+	}
+	index := len(top.prog.Globals)
+	top.prog.Globals = append(top.prog.Globals, name)
+	top.globals[name] = index
+	return index
+}
+
+func (b *builder) write(block *Block, name string, v *Value) {
+	if b.defs[block] == nil {
+		b.defs[block] = map[string]*Value{}
+	}
+	b.defs[block][name] = v
+}
+
+// read returns the value of a local variable at the end of block, or nil
+// if it is defined on no path to block.
+func (b *builder) read(block *Block, name string) *Value {
+	if v, ok := b.defs[block][name]; ok {
+		return v
+	}
+	var v *Value
+	switch len(block.Preds) {
+	case 0:
+	case 1:
+		v = b.read(block.Preds[0], name)
+	default:
+		args := make([]*Value, len(block.Preds))
+		same := true
+		for i, pred := range block.Preds {
+			args[i] = b.read(pred, name)
+			same = same && args[i] == args[0]
+		}
+		if same {
+			v = args[0]
+			break
+		}
+		for i, arg := range args {
+			if arg == nil {
+				args[i] = b.undefined()
+			}
+		}
+		v = b.fn.newValue(block, OpPhi, args...)
+	}
+	b.write(block, name, v)
+	return v
+}
+
+func (b *builder) undefined() *Value {
+	if b.undef == nil {
+		b.undef = b.entryValue(OpUndef)
+	}
+	return b.undef
+}
+
+func maybeUndefined(v *Value, visited map[*Value]bool) bool {
+	switch {
+	case v.Op == OpUndef:
+		return true
+	case v.Op != OpPhi || visited[v]:
+		return false
+	}
+	visited[v] = true
+	for _, arg := range v.Args {
+		if maybeUndefined(arg, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// cell returns the cell of the captured variable v of the function or an
+// outer function, which is a free variable of the closure in the latter
+// case.
+func (b *builder) cell(v *env.Variable) *Value {
+	if v.Fn == b.env {
+		return b.cells[v]
+	}
+	if c, ok := b.free[v]; ok {
+		return c
+	}
+	c := b.entryValue(OpFree)
+	c.Index, c.Name = len(b.fn.Free), v.Name
+	b.fn.Free = append(b.fn.Free, v.Name)
+	b.freeVars = append(b.freeVars, v)
+	b.free[v] = c
+	return c
+}
+
+// variable returns the value of the variable v of the function or an outer
+// function at the current point, which may be undefined, and reports
+// whether it is defined. It returns nil if v is defined on no path to the
+// current block.
+func (b *builder) variable(v *env.Variable) (*Value, bool) {
+	if v.Fn != b.env {
+		load := b.emit(OpLoad, b.cell(v))
+		load.Name = v.Name
+		return load, false
+	}
+	value := b.read(b.block, v.Name)
+	if value == nil {
+		return nil, false
+	}
+	return value, !maybeUndefined(value, map[*Value]bool{})
+}
+
+// identifier returns the value of the first variable bound of those ident
+// may refer to, as the evaluator looks up the environments. The global of
+// the program is the last of them, and a name which no function declares
+// refers to a global which is never set, so that it fails at runtime.
+func (b *builder) identifier(ident *ast.Identifier) *Value {
+	name := ident.Value
+	ref := b.info.Refs[ident]
+	var values []*Value
+	for i, v := range ref.Vars {
+		if v.Fn.Outer == nil {
+			break
+		}
+		value, defined := b.variable(v)
+		if value == nil {
+			continue
+		}
+		values = append(values, value)
+		if defined || i == len(ref.Vars)-1 && ref.Bound {
+			if len(values) == 1 {
+				return value
+			}
+			// the check never fails
+			check := b.emit(OpCheck, values...)
+			check.Name = name
+			return check
+		}
+	}
+
+	if n := len(ref.Vars); n == 0 || ref.Vars[n-1].Fn.Outer == nil || len(values) == 0 {
+		global := b.emit(OpGlobal, values...)
+		global.Index, global.Name = b.global(name), name
+		return global
+	}
+	check := b.emit(OpCheck, values...)
+	check.Name = name
+	if len(values) == 1 && values[0].Op != OpLoad {
+		// the local is defined after the check
+		b.write(b.block, name, check)
+	}
+	return check
+}
+
+var infixOps = map[string]Op{
+	"+":  OpAdd,
+	"-":  OpSub,
+	"*":  OpMul,
+	"/":  OpDiv,
+	"==": OpEq,
+	"!=": OpNe,
+	"<":  OpLt,
+	">":  OpGt,
+}
+
+func (b *builder) expression(exp ast.Expression) (*Value, error) {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		if exp.Big != nil {
+			return b.constant(object.NewBigInteger(exp.Big)), nil
+		}
+		return b.constant(object.NewInteger(exp.Value)), nil
+
+	case *ast.Boolean:
+		return b.constant(object.NativeBool(exp.Value)), nil
+
+	case *ast.PrefixExpression:
+		right, err := b.expression(exp.Right)
+		if err != nil {
+			return nil, err
+		}
+		switch exp.Operator {
+		case "!":
+			return b.emit(OpNot, right), nil
+		case "-":
+			return b.emit(OpNeg, right), nil
+		}
+		return nil, errorf(exp.Token, "unknown operator %s", exp.Operator)
+
+	case *ast.InfixExpression:
+		op, ok := infixOps[exp.Operator]
+		if !ok {
+			return nil, errorf(exp.Token, "unknown operator %s", exp.Operator)
+		}
+		left, err := b.expression(exp.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := b.expression(exp.Right)
+		if err != nil {
+			return nil, err
+		}
+		return b.emit(op, left, right), nil
+
+	case *ast.IfExpression:
+		return b.ifExpression(exp)
+
+	case *ast.Identifier:
+		return b.identifier(exp), nil
+
+	case *ast.FunctionLiteral:
+		return b.function(exp, "")
+
+	case *ast.CallExpression:
+		callee, err := b.expression(exp.Function)
+		if err != nil {
+			return nil, err
+		}
+		args := []*Value{callee}
+		for _, a := range exp.Arguments {
+			arg, err := b.expression(a)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		return b.emit(OpCall, args...), nil
+	}
+	return nil, fmt.Errorf("cannot build %T", exp)
+}
+
+// ifExpression builds the branches into separate blocks joined by a block
+// selecting the value with a phi. A missing alternative has a block of its
+// own, so that no edge leads from a block with two successors to a block
+// with two predecessors.
+func (b *builder) ifExpression(ie *ast.IfExpression) (*Value, error) {
+	cond, err := b.expression(ie.Condition)
+	if err != nil {
+		return nil, err
+	}
+	then, els, merge := b.fn.newBlock(), b.fn.newBlock(), b.fn.newBlock()
+	b.block.Kind = BlockIf
+	b.block.Control = cond
+	b.block.Succs = []*Block{then, els}
+	if b.reachable() {
+		then.Preds = []*Block{b.block}
+		els.Preds = []*Block{b.block}
+	}
+
+	var values []*Value
+	for _, branch := range []struct {
+		block *Block
+		stmts *ast.BlockStatement
+	}{{then, ie.Consequence}, {els, ie.Alternative}} {
+		b.block = branch.block
+		var value *Value
+		if branch.stmts != nil {
+			if value, err = b.statements(branch.stmts.Statements); err != nil {
+				return nil, err
+			}
+		} else {
+			value = b.constant(object.NullValue)
+		}
+		if b.reachable() {
+			values = append(values, value)
+		}
+		b.jump(merge)
+	}
+
+	b.block = merge
+	switch len(values) {
+	case 0:
+		return b.constant(object.NullValue), nil
+	case 1:
+		return values[0], nil
+	}
+	return b.fn.newValue(merge, OpPhi, values...), nil
+}
+
+// function builds fl into a new function and returns the closure of it,
+// which holds the cells of the variables of the outer functions it refers
+// to. If name is not empty, it is the name of the let statement declaring
+// the function.
+func (b *builder) function(fl *ast.FunctionLiteral, name string) (*Value, error) {
+	inner := &builder{
+		prog:  b.prog,
+		info:  b.info,
+		fn:    &Func{ID: len(b.prog.Funcs), Name: name, Source: (&object.Function{Parameters: fl.Parameters, Body: fl.Body}).Inspect()},
+		env:   b.info.Functions[fl],
+		outer: b,
+		defs:  map[*Block]map[string]*Value{},
+		cells: map[*env.Variable]*Value{},
+		free:  map[*env.Variable]*Value{},
+	}
+	b.prog.Funcs = append(b.prog.Funcs, inner.fn)
+	inner.block = inner.fn.newBlock()
+	inner.fn.Blocks = []*Block{inner.block}
+	params := make([]*Value, len(fl.Parameters))
+	for i, param := range fl.Parameters {
+		params[i] = inner.emit(OpParam)
+		params[i].Index, params[i].Name = i, param.Value
+		inner.fn.Params = append(inner.fn.Params, param.Value)
+	}
+	for _, v := range inner.env.Vars {
+		if v.Captured {
+			inner.cells[v] = inner.emit(OpCell)
+			inner.cells[v].Name = v.Name
+		}
+	}
+	for i, v := range inner.env.Params {
+		if v == nil {
+			continue // the last argument is bound to the name
+		}
+		if v.Captured {
+			store := inner.emit(OpStore, inner.cells[v], params[i])
+			store.Name = v.Name
+		}
+		inner.write(inner.block, v.Name, params[i])
+	}
+
+	value, err := inner.statements(fl.Body.Statements)
+	if err != nil {
+		return nil, err
+	}
+	inner.ret(value)
+	inner.finish()
+
+	cells := make([]*Value, len(inner.freeVars))
+	for i, v := range inner.freeVars {
+		cells[i] = b.cell(v)
+	}
+	v := b.emit(OpClosure, cells...)
+	v.Func = inner.fn
+	return v, nil
+}
+
+func errorf(tok token.Token, format string, a ...interface{}) *Error {
+	return &Error{Pos: tok.Pos, Msg: fmt.Sprintf(format, a...)}
+}
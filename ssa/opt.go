@@ -0,0 +1,211 @@
+package ssa
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pass represents an optimization of a function.
+type Pass struct {
+	Name string
+	Run  func(f *Func)
+}
+
+// Passes are the optimizations in the order they are meant to run.
+var Passes = []Pass{
+	{"copyprop", PropagateCopies},
+	{"cse", EliminateCommonSubexpressions},
+	{"dce", EliminateDeadCode},
+}
+
+// LookupPasses returns the passes of a comma-separated list of names.
+func LookupPasses(names string) ([]Pass, error) {
+	var passes []Pass
+	for _, name := range strings.Split(names, ",") {
+		found := false
+		for _, pass := range Passes {
+			if pass.Name == name {
+				passes = append(passes, pass)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown pass %q", name)
+		}
+	}
+	return passes, nil
+}
+
+// Optimize runs passes on every function of p in order.
+func Optimize(p *Program, passes []Pass) {
+	for _, pass := range passes {
+		for _, f := range p.Funcs {
+			pass.Run(f)
+		}
+	}
+}
+
+// PropagateCopies replaces the uses of copies with the copied values, and
+// the uses of phis selecting the same value from every predecessor with the
+// value, and removes them.
+func PropagateCopies(f *Func) {
+	replace := map[*Value]*Value{}
+	for _, b := range f.Blocks {
+		for _, v := range b.Values {
+			rewrite(v.Args, replace)
+			switch {
+			case v.Op == OpCopy:
+				replace[v] = v.Args[0]
+			case v.Op == OpPhi && same(v.Args):
+				replace[v] = v.Args[0]
+			}
+		}
+	}
+	apply(f, replace)
+}
+
+// EliminateCommonSubexpressions replaces a value with an equal value
+// computed in a block dominating it, and a phi with an equal phi of its
+// block. An operation which may fail is replaced too, since it has
+// succeeded if the equal one has.
+func EliminateCommonSubexpressions(f *Func) {
+	idom := dominators(f)
+	dominates := func(a, b *Block) bool {
+		for ; b != nil; b = idom[b] {
+			if b == a {
+				return true
+			}
+		}
+		return false
+	}
+
+	type key struct {
+		op   Op
+		args [2]*Value
+		aux  string
+	}
+	available := map[key][]*Value{}
+	replace := map[*Value]*Value{}
+	for _, b := range f.Blocks {
+		for _, v := range b.Values {
+			rewrite(v.Args, replace)
+			if v.Op == OpPhi && same(v.Args) {
+				replace[v] = v.Args[0]
+				continue
+			}
+			k := key{op: v.Op}
+			switch v.Op {
+			case OpConst:
+				k.aux = string(v.Const.Type()) + " " + v.Const.Inspect()
+			case OpParam, OpFree:
+				k.aux = fmt.Sprint(v.Index)
+			case OpPhi:
+				// a phi selects by the predecessors of its block
+				k.aux = b.String()
+			case OpNeg, OpNot, OpAdd, OpSub, OpMul, OpDiv, OpEq, OpNe, OpLt, OpGt, OpCheck:
+			default:
+				continue
+			}
+			if len(v.Args) > len(k.args) {
+				continue
+			}
+			copy(k.args[:], v.Args)
+			for _, w := range available[k] {
+				if dominates(w.Block, b) {
+					replace[v] = w
+					break
+				}
+			}
+			if replace[v] == nil {
+				available[k] = append(available[k], v)
+			}
+		}
+	}
+	apply(f, replace)
+}
+
+// EliminateDeadCode removes the values which are not used and cannot fail.
+func EliminateDeadCode(f *Func) {
+	for {
+		uses := f.Uses()
+		removed := false
+		for _, b := range f.Blocks {
+			values := b.Values[:0]
+			for _, v := range b.Values {
+				if uses[v] == 0 && v.Op.HasValue() && !v.Op.canFail() {
+					removed = true
+					continue
+				}
+				values = append(values, v)
+			}
+			b.Values = values
+		}
+		if !removed {
+			return
+		}
+	}
+}
+
+// dominators returns the immediate dominators of the blocks of f, computed
+// in a single pass since the graph is acyclic.
+func dominators(f *Func) map[*Block]*Block {
+	order := map[*Block]int{}
+	for i, b := range f.Blocks {
+		order[b] = i
+	}
+	idom := map[*Block]*Block{}
+	for _, b := range f.Blocks[1:] {
+		d := b.Preds[0]
+		for _, p := range b.Preds[1:] {
+			for d != p {
+				for order[p] > order[d] {
+					p = idom[p]
+				}
+				for order[d] > order[p] {
+					d = idom[d]
+				}
+			}
+		}
+		idom[b] = d
+	}
+	return idom
+}
+
+func same(values []*Value) bool {
+	for _, v := range values {
+		if v != values[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// rewrite replaces the values in args by replace.
+func rewrite(args []*Value, replace map[*Value]*Value) {
+	for i, arg := range args {
+		if r, ok := replace[arg]; ok {
+			args[i] = r
+		}
+	}
+}
+
+// apply replaces the uses of the values in replace and removes them.
+func apply(f *Func, replace map[*Value]*Value) {
+	if len(replace) == 0 {
+		return
+	}
+	for _, b := range f.Blocks {
+		values := b.Values[:0]
+		for _, v := range b.Values {
+			if _, ok := replace[v]; ok {
+				continue
+			}
+			rewrite(v.Args, replace)
+			values = append(values, v)
+		}
+		b.Values = values
+		if r, ok := replace[b.Control]; ok {
+			b.Control = r
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package ssa
+
+// Allocate assigns registers to the values of f by linear scan over the
+// blocks in order, and sets f.NumRegs. The i-th parameter is in the i-th
+// register. A phi is assigned its own register, which the predecessors of
+// its block set by moves at their ends, so the live ranges of its
+// arguments may share the register.
+//
+// Since the blocks are in topological order, a value is live from its
+// definition to its last use or the end of the last block it is live out
+// of. The ranges are conservative across the branches of an if, which is
+// safe because the branches run exclusively.
+func Allocate(f *Func) {
+	start, end := liveRanges(f)
+
+	f.NumRegs = len(f.Params)
+	var active []*Value
+	var busy []bool
+	for _, b := range f.Blocks {
+		for _, v := range b.Values {
+			v.Reg = -1
+			if !v.Op.HasValue() {
+				continue
+			}
+
+			// free the registers of the values which are no longer live
+			live := active[:0]
+			for _, a := range active {
+				if end[a] < start[v] {
+					busy[a.Reg] = false
+				} else {
+					live = append(live, a)
+				}
+			}
+			active = live
+
+			reg := 0
+			if v.Op == OpParam {
+				reg = v.Index
+			} else {
+				for reg < len(busy) && busy[reg] {
+					reg++
+				}
+			}
+			for len(busy) <= reg {
+				busy = append(busy, false)
+			}
+			busy[reg] = true
+			v.Reg = reg
+			active = append(active, v)
+			if reg >= f.NumRegs {
+				f.NumRegs = reg + 1
+			}
+		}
+	}
+}
+
+// liveRanges returns the positions of the definitions of the values of f
+// and of the ends of their live ranges. A value live out of a block, or
+// used by a phi of its successor, is live to the end of the block.
+func liveRanges(f *Func) (start, end map[*Value]int) {
+	blockEnd := map[*Block]int{}
+	start = map[*Value]int{}
+	pos := 0
+	for _, b := range f.Blocks {
+		// the phis are set at once by the moves in the predecessors
+		for _, v := range b.Values {
+			if v.Op == OpPhi {
+				start[v] = pos
+			}
+		}
+		pos++
+		for _, v := range b.Values {
+			if v.Op != OpPhi {
+				start[v] = pos
+				pos++
+			}
+		}
+		blockEnd[b] = pos
+		pos++
+	}
+
+	end = map[*Value]int{}
+	use := func(v *Value, at int) {
+		if at > end[v] {
+			end[v] = at
+		}
+	}
+	liveIn := map[*Block]map[*Value]bool{}
+	for i := len(f.Blocks) - 1; i >= 0; i-- {
+		b := f.Blocks[i]
+		live := map[*Value]bool{}
+		for _, s := range b.Succs {
+			for v := range liveIn[s] {
+				live[v] = true
+			}
+			for _, phi := range s.Values {
+				if phi.Op != OpPhi {
+					break
+				}
+				for j, pred := range s.Preds {
+					if pred == b {
+						live[phi.Args[j]] = true
+					}
+				}
+			}
+		}
+		for v := range live {
+			use(v, blockEnd[b])
+		}
+		if b.Control != nil {
+			live[b.Control] = true
+			use(b.Control, blockEnd[b])
+		}
+		for j := len(b.Values) - 1; j >= 0; j-- {
+			v := b.Values[j]
+			delete(live, v)
+			if v.Op == OpPhi {
+				continue
+			}
+			for _, arg := range v.Args {
+				live[arg] = true
+				use(arg, start[v])
+			}
+		}
+		liveIn[b] = live
+	}
+
+	for v, s := range start {
+		if end[v] < s {
+			end[v] = s
+		}
+	}
+	return start, end
+}
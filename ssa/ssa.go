@@ -0,0 +1,321 @@
+// Package ssa translates Monkey programs into an intermediate
+// representation in static single assignment form.
+//
+// A program is a list of functions, the first of which is the top-level
+// statements. A function is a graph of basic blocks, each holding a list
+// of values computed by three-address operations and ending with a jump,
+// a branch or a return. Each value is assigned once; a phi value at the
+// start of a block selects the value coming from each predecessor.
+//
+// The names are bound as in the compiler for the stack VM: top-level let
+// statements define globals, let statements and parameters in functions
+// define locals, and a local which nested functions refer to is held in a
+// cell shared by the closures, which hold the cells of the variables of the
+// enclosing functions they refer to. An identifier which may be evaluated
+// before its variable is bound evaluates to the first variable bound of
+// those of the enclosing functions declaring the name, as the evaluator
+// looks up the environments. Since Monkey has no loops, the graphs are
+// acyclic.
+package ssa
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/oohira/monkey/object"
+	"github.com/oohira/monkey/token"
+)
+
+// Op represents the operation of a value.
+type Op int
+
+// Operations of values
+const (
+	OpConst     Op = iota // Const
+	OpUndef               // the value of a variable not defined on a path
+	OpParam               // the Index-th parameter
+	OpFree                // the cell of the Index-th free variable of the closure
+	OpCell                // a new cell, which is undefined
+	OpLoad                // the value in the cell Args[0]
+	OpStore               // sets the cell Args[0] to Args[1]; no value
+	OpGlobal              // the first of Args which is defined, or the global Index, failing if it is not set
+	OpSetGlobal           // sets the global Index to Args[0]; no value
+	OpCheck               // the first of Args which is defined, failing if none is
+	OpCopy                // Args[0]
+	OpPhi                 // Args[i] if control comes from Block.Preds[i]
+	OpNeg                 // -Args[0]
+	OpNot                 // !Args[0]
+	OpAdd                 // Args[0] + Args[1]
+	OpSub                 // Args[0] - Args[1]
+	OpMul                 // Args[0] * Args[1]
+	OpDiv                 // Args[0] / Args[1]
+	OpEq                  // Args[0] == Args[1]
+	OpNe                  // Args[0] != Args[1]
+	OpLt                  // Args[0] < Args[1]
+	OpGt                  // Args[0] > Args[1]
+	OpClosure             // a closure of Func with Args as its free variables
+	OpCall                // Args[0] called with Args[1:]
+)
+
+var opNames = [...]string{
+	OpConst:     "const",
+	OpUndef:     "undef",
+	OpParam:     "param",
+	OpFree:      "free",
+	OpCell:      "cell",
+	OpLoad:      "load",
+	OpStore:     "store",
+	OpGlobal:    "global",
+	OpSetGlobal: "setglobal",
+	OpCheck:     "check",
+	OpCopy:      "copy",
+	OpPhi:       "phi",
+	OpNeg:       "neg",
+	OpNot:       "not",
+	OpAdd:       "add",
+	OpSub:       "sub",
+	OpMul:       "mul",
+	OpDiv:       "div",
+	OpEq:        "eq",
+	OpNe:        "ne",
+	OpLt:        "lt",
+	OpGt:        "gt",
+	OpClosure:   "closure",
+	OpCall:      "call",
+}
+
+func (op Op) String() string {
+	if int(op) < len(opNames) {
+		return opNames[op]
+	}
+	return fmt.Sprintf("op(%d)", int(op))
+}
+
+// Operator returns the Monkey operator of a prefix or an infix operation,
+// or "" for other operations.
+func (op Op) Operator() string {
+	return operators[op]
+}
+
+var operators = map[Op]string{
+	OpNeg: "-", OpNot: "!",
+	OpAdd: "+", OpSub: "-", OpMul: "*", OpDiv: "/",
+	OpEq: "==", OpNe: "!=", OpLt: "<", OpGt: ">",
+}
+
+// HasValue reports whether the operation produces a value.
+func (op Op) HasValue() bool {
+	return op != OpSetGlobal && op != OpStore
+}
+
+// canFail reports whether the operation may end the program with a
+// runtime error.
+func (op Op) canFail() bool {
+	switch op {
+	case OpGlobal, OpCheck, OpNeg, OpAdd, OpSub, OpMul, OpDiv, OpLt, OpGt, OpCall:
+		return true
+	}
+	return false
+}
+
+// Value represents a value computed by an operation. Name is the variable
+// holding the value, or the variable checked by OpCheck, and Reg is the
+// register assigned by Allocate, or -1.
+type Value struct {
+	ID    int
+	Op    Op
+	Args  []*Value
+	Block *Block
+	Const object.Object
+	Index int
+	Func  *Func
+	Name  string
+	Reg   int
+}
+
+func (v *Value) String() string {
+	if v.Reg >= 0 {
+		return fmt.Sprintf("v%d:r%d", v.ID, v.Reg)
+	}
+	return fmt.Sprintf("v%d", v.ID)
+}
+
+// LongString returns the operation computing the value.
+func (v *Value) LongString() string {
+	var out strings.Builder
+	if v.Op.HasValue() {
+		out.WriteString(v.String() + " = ")
+	}
+	out.WriteString(v.Op.String())
+	switch v.Op {
+	case OpConst:
+		out.WriteString(" " + v.Const.Inspect())
+	case OpParam, OpFree, OpGlobal, OpSetGlobal:
+		fmt.Fprintf(&out, " %d", v.Index)
+	case OpClosure:
+		out.WriteString(" " + v.Func.String())
+	}
+	for _, arg := range v.Args {
+		out.WriteString(" " + arg.String())
+	}
+	if v.Name != "" {
+		out.WriteString(" ; " + v.Name)
+	}
+	return out.String()
+}
+
+// BlockKind represents how a block ends.
+type BlockKind int
+
+// Kinds of blocks
+const (
+	BlockPlain  BlockKind = iota // jumps to Succs[0]
+	BlockIf                      // jumps to Succs[0] if Control is truthy, or Succs[1]
+	BlockReturn                  // returns Control
+)
+
+// Block represents a basic block. The phi values come first in Values.
+type Block struct {
+	ID      int
+	Kind    BlockKind
+	Values  []*Value
+	Control *Value
+	Preds   []*Block
+	Succs   []*Block
+	Func    *Func
+}
+
+func (b *Block) String() string {
+	return fmt.Sprintf("b%d", b.ID)
+}
+
+// Func represents a function, or the top-level statements of a program.
+// Blocks[0] is the entry block, and the blocks are in reverse postorder,
+// so that a block comes after its predecessors. NumRegs is the number of
+// registers assigned by Allocate.
+type Func struct {
+	ID      int
+	Name    string   // name of the let statement declaring the function
+	Source  string   // the literal in the format of object.Function.Inspect
+	Params  []string // names of the parameters
+	Free    []string // names of the free variables
+	Blocks  []*Block
+	NumRegs int
+
+	nextValue int
+	nextBlock int
+}
+
+func (f *Func) String() string {
+	if f.ID == 0 {
+		return "main"
+	}
+	return fmt.Sprintf("fn#%d", f.ID)
+}
+
+// newValue returns a new value in b, appended unless it is a phi.
+func (f *Func) newValue(b *Block, op Op, args ...*Value) *Value {
+	v := &Value{ID: f.nextValue, Op: op, Args: args, Block: b, Reg: -1}
+	f.nextValue++
+	if op == OpPhi {
+		b.Values = append([]*Value{v}, b.Values...)
+	} else {
+		b.Values = append(b.Values, v)
+	}
+	return v
+}
+
+func (f *Func) newBlock() *Block {
+	b := &Block{ID: f.nextBlock, Func: f}
+	f.nextBlock++
+	return b
+}
+
+// Uses returns the number of uses of each value in f.
+func (f *Func) Uses() map[*Value]int {
+	uses := map[*Value]int{}
+	for _, b := range f.Blocks {
+		for _, v := range b.Values {
+			for _, arg := range v.Args {
+				uses[arg]++
+			}
+		}
+		if b.Control != nil {
+			uses[b.Control]++
+		}
+	}
+	return uses
+}
+
+// Program represents a program. Funcs[0] is the top-level statements, and
+// Globals holds the names of the globals by index.
+type Program struct {
+	Funcs   []*Func
+	Globals []string
+}
+
+// Error represents an error found while building a program.
+type Error struct {
+	Pos token.Position
+	Msg string
+}
+
+// Error returns the message prefixed with the position, e.g. "1:5: ...".
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
+// Fprint writes the functions of the program in a human readable form.
+func Fprint(w io.Writer, p *Program) error {
+	var out strings.Builder
+	if len(p.Globals) > 0 {
+		out.WriteString("globals:\n")
+		for i, name := range p.Globals {
+			fmt.Fprintf(&out, "  %d %s\n", i, name)
+		}
+	}
+	for _, f := range p.Funcs {
+		writeFunc(&out, f)
+	}
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+func writeFunc(out *strings.Builder, f *Func) {
+	fmt.Fprintf(out, "func %s", f)
+	if f.Name != "" {
+		out.WriteString(" " + f.Name)
+	}
+	if f.ID != 0 {
+		fmt.Fprintf(out, "(%s)", strings.Join(f.Params, ", "))
+	}
+	if len(f.Free) > 0 {
+		fmt.Fprintf(out, " free(%s)", strings.Join(f.Free, ", "))
+	}
+	if f.NumRegs > 0 {
+		fmt.Fprintf(out, " regs=%d", f.NumRegs)
+	}
+	out.WriteString(":\n")
+	for _, b := range f.Blocks {
+		out.WriteString(b.String() + ":")
+		if len(b.Preds) > 0 {
+			out.WriteString(" <-")
+			for _, p := range b.Preds {
+				out.WriteString(" " + p.String())
+			}
+		}
+		out.WriteString("\n")
+		for _, v := range b.Values {
+			out.WriteString("  " + v.LongString() + "\n")
+		}
+		switch b.Kind {
+		case BlockPlain:
+			fmt.Fprintf(out, "  jump %s\n", b.Succs[0])
+		case BlockIf:
+			fmt.Fprintf(out, "  if %s %s %s\n", b.Control, b.Succs[0], b.Succs[1])
+		case BlockReturn:
+			fmt.Fprintf(out, "  return %s\n", b.Control)
+		}
+	}
+}
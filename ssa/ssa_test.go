@@ -0,0 +1,243 @@
+package ssa
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func build(t *testing.T, input string) *Program {
+	t.Helper()
+	p, err := Build(parse(t, input))
+	if err != nil {
+		t.Fatalf("build error for %q: %s", input, err)
+	}
+	return p
+}
+
+func dump(t *testing.T, p *Program) string {
+	t.Helper()
+	var out strings.Builder
+	if err := Fprint(&out, p); err != nil {
+		t.Fatalf("Fprint error: %s", err)
+	}
+	return out.String()
+}
+
+func TestBuild(t *testing.T) {
+	input := `
+let x = 1;
+let f = fn(a) { if (a) { let y = a }; y + x };
+f(x)
+`
+	expected := `globals:
+  0 x
+  1 f
+func main:
+b0:
+  v0 = const 1
+  setglobal 0 v0 ; x
+  v2 = closure fn#1
+  setglobal 1 v2 ; f
+  v4 = global 1 ; f
+  v5 = global 0 ; x
+  v6 = call v4 v5
+  return v6
+func fn#1 f(a):
+b0:
+  v0 = param 0 ; a
+  v1 = undef
+  if v0 b1 b2
+b1: <- b0
+  v2 = copy v0 ; y
+  v3 = const null
+  jump b3
+b2: <- b0
+  v4 = const null
+  jump b3
+b3: <- b1 b2
+  v5 = phi v2 v1
+  v6 = phi v3 v4
+  v7 = check v5 ; y
+  v8 = global 0 ; x
+  v9 = add v7 v8
+  return v9
+`
+	if got := dump(t, build(t, input)); got != expected {
+		t.Errorf("IR wrong.\nwant=\n%s\ngot=\n%s", expected, got)
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input    string
+		passes   string
+		expected string // the body of the first function literal
+	}{
+		{
+			"fn(a, b) { let x = a * b; let y = x; let z = a * b; if (a) { y } else { z } + 1 }",
+			"copyprop",
+			`  v0 = param 0 ; a
+  v1 = param 1 ; b
+  v2 = mul v0 v1
+  v5 = mul v0 v1
+  if v0 b1 b2
+b1: <- b0
+  jump b3
+b2: <- b0
+  jump b3
+b3: <- b1 b2
+  v7 = phi v2 v5
+  v8 = const 1
+  v9 = add v7 v8
+  return v9
+`,
+		},
+		{
+			"fn(a, b) { let x = a * b; let y = x; let z = a * b; if (a) { y } else { z } + 1 }",
+			"cse",
+			`  v0 = param 0 ; a
+  v1 = param 1 ; b
+  v2 = mul v0 v1
+  v3 = copy v2 ; x
+  v4 = copy v3 ; y
+  v6 = copy v2 ; z
+  if v0 b1 b2
+b1: <- b0
+  jump b3
+b2: <- b0
+  jump b3
+b3: <- b1 b2
+  v7 = phi v4 v6
+  v8 = const 1
+  v9 = add v7 v8
+  return v9
+`,
+		},
+		{
+			"fn(a, b) { let x = a * b; let y = x; let z = a * b; if (a) { y } else { z } + 1 }",
+			"copyprop,cse,dce",
+			`  v0 = param 0 ; a
+  v1 = param 1 ; b
+  v2 = mul v0 v1
+  if v0 b1 b2
+b1: <- b0
+  jump b3
+b2: <- b0
+  jump b3
+b3: <- b1 b2
+  v8 = const 1
+  v9 = add v2 v8
+  return v9
+`,
+		},
+		{
+			// a negation may fail, so it stays, and so does the store
+			// into the cell of a captured variable
+			"fn(a) { let k = 5; let g = fn() { a }; -a; a }",
+			"dce",
+			`  v0 = param 0 ; a
+  v1 = cell ; a
+  store v1 v0 ; a
+  v7 = neg v0
+  return v0
+`,
+		},
+		{
+			// the checks of equal phis are merged
+			"fn(a) { if (a) { let y = a; let z = a }; y + z }",
+			"copyprop,cse",
+			`  v0 = param 0 ; a
+  v1 = undef
+  if v0 b1 b2
+b1: <- b0
+  v4 = const null
+  jump b3
+b2: <- b0
+  v5 = const null
+  jump b3
+b3: <- b1 b2
+  v6 = phi v0 v1
+  v8 = phi v4 v5
+  v9 = check v6 ; y
+  v11 = add v9 v9
+  return v11
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		p := build(t, tt.input)
+		passes, err := LookupPasses(tt.passes)
+		if err != nil {
+			t.Fatalf("LookupPasses error: %s", err)
+		}
+		Optimize(p, passes)
+		got := dump(t, &Program{Funcs: p.Funcs[1:2]})
+		got = got[strings.Index(got, "b0:\n")+len("b0:\n"):]
+		if got != tt.expected {
+			t.Errorf("IR of %q after %s wrong.\nwant=\n%s\ngot=\n%s", tt.input, tt.passes, tt.expected, got)
+		}
+	}
+}
+
+func TestLookupPasses(t *testing.T) {
+	if _, err := LookupPasses("cse,foo"); err == nil || err.Error() != `unknown pass "foo"` {
+		t.Errorf("error wrong. got=%v", err)
+	}
+}
+
+// TestAllocate checks that the values live at the same time are in
+// different registers.
+func TestAllocate(t *testing.T) {
+	inputs := []string{
+		"fn(a, b) { let x = a * b; let y = x; let z = a * b; if (a) { y } else { z } + 1 }",
+		"fn(a, b, n) { if (n) { fn(a, b) { a }(b, a) } else { a + b } }",
+		"fn(a) { let x = if (a) { let y = 1; 2 } else { 3 }; let z = if (x) { a } else { x }; x + z }",
+	}
+
+	for _, input := range inputs {
+		p := build(t, input)
+		for _, passes := range [][]Pass{nil, Passes} {
+			Optimize(p, passes)
+			f := p.Funcs[1]
+			Allocate(f)
+			start, end := liveRanges(f)
+			var values []*Value
+			for _, b := range f.Blocks {
+				for _, v := range b.Values {
+					if v.Op.HasValue() {
+						values = append(values, v)
+					}
+				}
+			}
+			for i, v := range values {
+				if v.Op == OpParam && v.Reg != v.Index {
+					t.Errorf("register of %s in %q wrong. want=%d", v, input, v.Index)
+				}
+				if v.Reg < 0 || v.Reg >= f.NumRegs {
+					t.Errorf("register of %s in %q out of range", v, input)
+				}
+				for _, w := range values[i+1:] {
+					overlap := start[v] <= end[w] && start[w] <= end[v]
+					if overlap && v.Reg == w.Reg {
+						t.Errorf("%s and %s in %q share the register", v, w, input)
+					}
+				}
+			}
+		}
+	}
+}
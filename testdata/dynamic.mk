@@ -0,0 +1,9 @@
+let twice = fn(f, x) { f(f(x)) };
+let pick = fn(flag) { if (flag) { 1 } else { true } };
+let half = fn(x) { x / 2 };
+let sign = fn(x) {
+  if (x < 0) { return -1; }
+  if (x == 0) { return 0; }
+  1
+};
+twice(half, 100) + sign(-5) * 10 + pick(0 == 1) + 1
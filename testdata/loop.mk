@@ -0,0 +1,10 @@
+// a loop written as a tail-recursive function, since Monkey has no loop
+// statements
+let loop = fn(i, sum) {
+  if (i > 1000) {
+    return sum;
+  }
+  let next = i + 1;
+  loop(next, sum + i * i)
+};
+loop(1, 0)
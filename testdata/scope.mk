@@ -0,0 +1,11 @@
+let x = 10;
+let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+let counter = fn(start) {
+  let x = start * 2;
+  fn(step) { x + step }
+};
+if (isEven(4)) { let y = 1 };
+let value = if (true) { let z = y + 1; z * 2 } else { return 0 };
+let new = counter(value)(x);
+new + 9223372036854775807
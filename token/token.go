@@ -1,12 +1,32 @@
 package token
 
+import "fmt"
+
 // Type represents the type of a token.
 type Type string
 
+// Position represents a position in a source text.
+// Line and Column are 1-based, and Column counts bytes.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+// String returns a text representation of the position in the form "line:column".
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
 // Token represents a token.
+// Leading and Trailing hold the whitespace and comments around the token
+// only when the lexer preserves trivia.
 type Token struct {
-	Type    Type
-	Literal string
+	Type     Type
+	Literal  string
+	Pos      Position // position of the first character of Literal
+	Leading  string
+	Trailing string
 }
 
 // token Type constants
@@ -33,6 +53,8 @@ const (
 	// Delimiters
 	COMMA     = ","
 	SEMICOLON = ";"
+	COLON     = ":"
+	ARROW     = "->"
 
 	LPAREN = "("
 	RPAREN = ")"
@@ -0,0 +1,587 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/env"
+	"github.com/oohira/monkey/resolve"
+	"github.com/oohira/monkey/token"
+)
+
+// Error represents a type error.
+type Error struct {
+	Pos token.Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
+// Info represents the result of type inference. Types holds the types of
+// expressions and Defs the types of declared names.
+type Info struct {
+	Types  map[ast.Expression]Type
+	Defs   map[*ast.Identifier]*Scheme
+	Errors []*Error // sorted by position
+}
+
+// TypeOf returns the type of an expression with bound variables resolved,
+// or nil if the expression was not checked.
+func (info *Info) TypeOf(exp ast.Expression) Type {
+	t, ok := info.Types[exp]
+	if !ok {
+		return nil
+	}
+	return resolved(t)
+}
+
+// resolved returns a type with all bound variables replaced by their types.
+func resolved(t Type) Type {
+	switch t := prune(t).(type) {
+	case *Func:
+		params := make([]Type, len(t.Params))
+		for i, p := range t.Params {
+			params[i] = resolved(p)
+		}
+		return &Func{Params: params, Result: resolved(t.Result)}
+	default:
+		return t
+	}
+}
+
+// Check infers the types of a program and reports type errors and undefined
+// names.
+func Check(program *ast.Program) *Info {
+	return checkProgram(program, false)
+}
+
+// CheckGradual checks a program like Check, but gives the type any to
+// parameters and function results without type annotations. A let binding
+// without annotation has the type of its value.
+func CheckGradual(program *ast.Program) *Info {
+	return checkProgram(program, true)
+}
+
+func checkProgram(program *ast.Program, gradual bool) *Info {
+	c := &checker{
+		gradual: gradual,
+		info: &Info{
+			Types: map[ast.Expression]Type{},
+			Defs:  map[*ast.Identifier]*Scheme{},
+		},
+		symbols:    resolve.Resolve(program),
+		envs:       env.Analyze(program),
+		vars:       map[*env.Variable]*Scheme{},
+		mono:       map[*env.Variable]bool{},
+		signatures: map[*ast.FunctionLiteral]*Func{},
+	}
+	c.fn = c.envs.Functions[program]
+	c.monomorphic(c.fn, program)
+	for _, ref := range c.envs.Refs {
+		if len(ref.Vars) > 1 {
+			for _, v := range ref.Vars {
+				c.mono[v] = true
+			}
+		}
+	}
+	c.statements(program.Statements)
+
+	sort.SliceStable(c.info.Errors, func(i, j int) bool {
+		return c.info.Errors[i].Pos.Offset < c.info.Errors[j].Pos.Offset
+	})
+	return c.info
+}
+
+// checker infers types of the variables of the environments at runtime,
+// where a let statement rebinds the variable of its function and an
+// identifier may refer to the variable of an outer function until the let
+// statement of the name in its function has run. The resolver only reports
+// undefined names.
+type checker struct {
+	gradual    bool
+	info       *Info
+	symbols    *resolve.Info
+	envs       *env.Info
+	fn         *env.Function // the function being checked
+	vars       map[*env.Variable]*Scheme
+	mono       map[*env.Variable]bool // variables never generalized
+	signatures map[*ast.FunctionLiteral]*Func
+	returns    []Type // result types of the enclosing functions
+	level      int
+	nextVar    int
+}
+
+// monomorphic finds the variables of f and its nested functions declared
+// by more than one let statement. Such a variable holds the values of all
+// of them, which the uses of any of them may see.
+func (c *checker) monomorphic(f *env.Function, node ast.Node) {
+	declared := map[*env.Variable]bool{}
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.LetStatement:
+			if n.Name != nil {
+				v := f.Lookup(n.Name.Value)
+				if declared[v] {
+					c.mono[v] = true
+				}
+				declared[v] = true
+			}
+		case *ast.FunctionLiteral:
+			if n != node {
+				c.monomorphic(c.envs.Functions[n], n)
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func (c *checker) errorf(pos token.Position, format string, args ...interface{}) {
+	c.info.Errors = append(c.info.Errors, &Error{pos, fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) newVar() *Var {
+	c.nextVar++
+	return &Var{id: c.nextVar, level: c.level}
+}
+
+// statements returns the type of the value of a statement list, which is
+// the type of the last statement.
+func (c *checker) statements(stmts []ast.Statement) Type {
+	var t Type = Null
+	for _, stmt := range stmts {
+		t = c.statement(stmt)
+	}
+	return t
+}
+
+func (c *checker) statement(stmt ast.Statement) Type {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		c.let(stmt)
+		return Null
+	case *ast.ReturnStatement:
+		t := c.expression(stmt.ReturnValue)
+		if len(c.returns) > 0 {
+			c.unify(c.returns[len(c.returns)-1], t, stmt.ReturnValue)
+		}
+		// the statement never completes, so it may have any type
+		return c.newVar()
+	case *ast.ExpressionStatement:
+		return c.expression(stmt.Expression)
+	case *ast.BlockStatement:
+		return c.block(stmt)
+	}
+	return c.newVar()
+}
+
+func (c *checker) block(block *ast.BlockStatement) Type {
+	if block == nil {
+		return c.newVar()
+	}
+	return c.statements(block.Statements)
+}
+
+// let infers the type of a let binding and generalizes it. The name is
+// bound to a monomorphic type while its value is inferred, so that
+// recursive functions refer to themselves. The type of a function literal
+// has its declared shape from the start, so that recursive calls do not
+// narrow parameters of the type any in gradual mode.
+func (c *checker) let(let *ast.LetStatement) {
+	if let.Name == nil {
+		c.expression(let.Value)
+		return
+	}
+	variable := c.fn.Lookup(let.Name.Value)
+	if scheme, ok := c.vars[variable]; ok {
+		// a parameter, redeclared or referred to before its declaration;
+		// kept monomorphic
+		c.unify(scheme.Type, c.expression(let.Value), let.Value)
+		c.info.Defs[let.Name] = scheme
+		return
+	}
+
+	c.level++
+	v := c.newVar()
+	if let.Type != nil {
+		unify(v, c.annotation(let.Type))
+	}
+	if fn, ok := let.Value.(*ast.FunctionLiteral); ok {
+		c.unify(v, c.signature(fn), let.Value)
+	}
+	c.vars[variable] = &Scheme{Type: v}
+	c.unify(v, c.expression(let.Value), let.Value)
+	c.level--
+
+	scheme := &Scheme{Type: v}
+	if !c.mono[variable] {
+		scheme = c.generalize(v)
+	}
+	c.vars[variable] = scheme
+	c.info.Defs[let.Name] = scheme
+}
+
+// generalize returns a scheme quantifying the unbound variables of t
+// introduced in a deeper let than the current one.
+func (c *checker) generalize(t Type) *Scheme {
+	scheme := &Scheme{Type: t}
+	seen := map[*Var]bool{}
+	var collect func(t Type)
+	collect = func(t Type) {
+		switch t := prune(t).(type) {
+		case *Func:
+			for _, p := range t.Params {
+				collect(p)
+			}
+			collect(t.Result)
+		case *Var:
+			if t.level > c.level && !seen[t] {
+				seen[t] = true
+				scheme.Vars = append(scheme.Vars, t)
+			}
+		}
+	}
+	collect(t)
+	return scheme
+}
+
+// instantiate returns the type of a scheme with fresh variables.
+func (c *checker) instantiate(s *Scheme) Type {
+	if len(s.Vars) == 0 {
+		return s.Type
+	}
+	fresh := map[*Var]Type{}
+	for _, v := range s.Vars {
+		fresh[v] = c.newVar()
+	}
+	var copyType func(t Type) Type
+	copyType = func(t Type) Type {
+		switch t := prune(t).(type) {
+		case *Func:
+			params := make([]Type, len(t.Params))
+			for i, p := range t.Params {
+				params[i] = copyType(p)
+			}
+			return &Func{Params: params, Result: copyType(t.Result)}
+		case *Var:
+			if f, ok := fresh[t]; ok {
+				return f
+			}
+			return t
+		default:
+			return t
+		}
+	}
+	return copyType(s.Type)
+}
+
+func (c *checker) expression(exp ast.Expression) Type {
+	if exp == nil {
+		return c.newVar()
+	}
+	t := c.infer(exp)
+	c.info.Types[exp] = t
+	return t
+}
+
+func (c *checker) infer(exp ast.Expression) Type {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return Int
+	case *ast.Boolean:
+		return Bool
+	case *ast.Identifier:
+		return c.identifier(exp)
+	case *ast.PrefixExpression:
+		t := c.expression(exp.Right)
+		if exp.Operator == "-" {
+			c.unify(Int, t, exp.Right)
+			return Int
+		}
+		// ! accepts any value by its truthiness
+		return Bool
+	case *ast.InfixExpression:
+		left, right := c.expression(exp.Left), c.expression(exp.Right)
+		switch exp.Operator {
+		case "==", "!=":
+			c.unify(left, right, exp.Right)
+			return Bool
+		case "<", ">":
+			c.unify(Int, left, exp.Left)
+			c.unify(Int, right, exp.Right)
+			return Bool
+		}
+		c.unify(Int, left, exp.Left)
+		c.unify(Int, right, exp.Right)
+		return Int
+	case *ast.IfExpression:
+		// the condition may be any value by its truthiness
+		c.expression(exp.Condition)
+		t := c.block(exp.Consequence)
+		if exp.Alternative == nil {
+			return Null
+		}
+		alt := c.block(exp.Alternative)
+		c.unifyAt(t, alt, blockPos(exp.Alternative))
+		return t
+	case *ast.FunctionLiteral:
+		return c.function(exp)
+	case *ast.CallExpression:
+		return c.call(exp)
+	}
+	return c.newVar()
+}
+
+// identifier returns the type of the variables an identifier may refer to,
+// which must all have the same type.
+func (c *checker) identifier(ident *ast.Identifier) Type {
+	ref := c.envs.Refs[ident]
+	if c.symbols.SymbolOf(ident) == nil || ref == nil || len(ref.Vars) == 0 {
+		c.errorf(ident.Token.Pos, "undefined: %s", ident.Value)
+		return c.newVar()
+	}
+	t := c.instantiate(c.scheme(ref.Vars[0]))
+	for _, v := range ref.Vars[1:] {
+		c.unify(t, c.scheme(v).Type, ident)
+	}
+	return t
+}
+
+// scheme returns the type of a variable.
+func (c *checker) scheme(v *env.Variable) *Scheme {
+	scheme, ok := c.vars[v]
+	if !ok {
+		// referred to before its declaration from a function body; it must
+		// not be generalized by the enclosing lets
+		tv := c.newVar()
+		tv.level = 0
+		scheme = &Scheme{Type: tv}
+		c.vars[v] = scheme
+	}
+	return scheme
+}
+
+// signature returns the type of a function literal by the annotations of
+// its parameters and result.
+func (c *checker) signature(fn *ast.FunctionLiteral) *Func {
+	if sig, ok := c.signatures[fn]; ok {
+		return sig
+	}
+	params := make([]Type, len(fn.Parameters))
+	for i, param := range fn.Parameters {
+		params[i] = c.declared(param.Type)
+	}
+	sig := &Func{Params: params, Result: c.declared(fn.ReturnType)}
+	c.signatures[fn] = sig
+	return sig
+}
+
+func (c *checker) function(fn *ast.FunctionLiteral) Type {
+	sig := c.signature(fn)
+	outer := c.fn
+	c.fn = c.envs.Functions[fn]
+	for i, param := range fn.Parameters {
+		// a duplicate parameter is bound to the last argument
+		if v := c.fn.Params[i]; v != nil {
+			c.vars[v] = &Scheme{Type: sig.Params[i]}
+		}
+		c.info.Defs[param] = &Scheme{Type: sig.Params[i]}
+	}
+
+	c.returns = append(c.returns, sig.Result)
+	body := c.block(fn.Body)
+	c.returns = c.returns[:len(c.returns)-1]
+	c.unifyAt(sig.Result, body, blockPos(fn.Body))
+	c.fn = outer
+	return sig
+}
+
+// declared returns the type of a declaration with an optional annotation.
+func (c *checker) declared(annotation ast.TypeExpression) Type {
+	switch {
+	case annotation != nil:
+		return c.annotation(annotation)
+	case c.gradual:
+		return Any
+	}
+	return c.newVar()
+}
+
+// annotation returns the type denoted by a type annotation.
+func (c *checker) annotation(t ast.TypeExpression) Type {
+	switch t := t.(type) {
+	case *ast.NamedType:
+		if named, ok := named[t.Name]; ok {
+			return named
+		}
+		c.errorf(t.Token.Pos, "unknown type %s", t.Name)
+	case *ast.FunctionType:
+		params := make([]Type, len(t.Parameters))
+		for i, p := range t.Parameters {
+			params[i] = c.annotation(p)
+		}
+		return &Func{Params: params, Result: c.annotation(t.Result)}
+	}
+	return c.newVar()
+}
+
+func (c *checker) call(call *ast.CallExpression) Type {
+	callee := c.expression(call.Function)
+	args := make([]Type, len(call.Arguments))
+	for i, arg := range call.Arguments {
+		args[i] = c.expression(arg)
+	}
+
+	if fn, ok := prune(callee).(*Func); ok {
+		if len(fn.Params) != len(args) {
+			c.errorf(call.Token.Pos, "wrong number of arguments: %s takes %d, got %d",
+				call.Function, len(fn.Params), len(args))
+			return c.newVar()
+		}
+		for i, arg := range call.Arguments {
+			c.unify(fn.Params[i], args[i], arg)
+		}
+		return fn.Result
+	}
+
+	result := c.newVar()
+	if prune(callee) == Any {
+		unify(result, Any)
+		return result
+	}
+	if _, ok := prune(callee).(*Var); !ok {
+		c.errorf(startPos(call.Function), "cannot call %s of type %s", call.Function, callee)
+		return result
+	}
+	c.unify(callee, &Func{Params: args, Result: result}, call.Function)
+	return result
+}
+
+// unify unifies the expected type with the actual type of node and reports
+// a mismatch at node.
+func (c *checker) unify(expected, actual Type, node ast.Expression) {
+	c.unifyAt(expected, actual, startPos(node))
+}
+
+func (c *checker) unifyAt(expected, actual Type, pos token.Position) {
+	if err := unify(expected, actual); err != nil {
+		names := map[*Var]string{}
+		e, a := typeString(expected, names), typeString(actual, names)
+		if err == errRecursive {
+			c.errorf(pos, "recursive type: %s = %s", e, a)
+		} else {
+			c.errorf(pos, "type mismatch: expected %s, got %s", e, a)
+		}
+	}
+}
+
+var (
+	errMismatch  = fmt.Errorf("type mismatch")
+	errRecursive = fmt.Errorf("recursive type")
+)
+
+func unify(a, b Type) error {
+	a, b = prune(a), prune(b)
+	if v, ok := a.(*Var); ok {
+		return bind(v, b)
+	}
+	if v, ok := b.(*Var); ok {
+		return bind(v, a)
+	}
+	if a == Any || b == Any {
+		return nil
+	}
+
+	switch a := a.(type) {
+	case *Basic:
+		if a != b {
+			return errMismatch
+		}
+		return nil
+	case *Func:
+		b, ok := b.(*Func)
+		if !ok || len(a.Params) != len(b.Params) {
+			return errMismatch
+		}
+		for i := range a.Params {
+			if err := unify(a.Params[i], b.Params[i]); err != nil {
+				return err
+			}
+		}
+		return unify(a.Result, b.Result)
+	}
+	return errMismatch
+}
+
+// bind binds a variable to a type, lowering the levels of the variables in
+// the type so that they are generalized only where v is.
+func bind(v *Var, t Type) error {
+	if t == Type(v) {
+		return nil
+	}
+	if occurs(v, t) {
+		return errRecursive
+	}
+	v.instance = t
+	return nil
+}
+
+func occurs(v *Var, t Type) bool {
+	switch t := prune(t).(type) {
+	case *Func:
+		for _, p := range t.Params {
+			if occurs(v, p) {
+				return true
+			}
+		}
+		return occurs(v, t.Result)
+	case *Var:
+		if t == v {
+			return true
+		}
+		if t.level > v.level {
+			t.level = v.level
+		}
+	}
+	return false
+}
+
+// startPos returns the position of the first token of an expression.
+func startPos(exp ast.Expression) token.Position {
+	switch exp := exp.(type) {
+	case *ast.InfixExpression:
+		return startPos(exp.Left)
+	case *ast.CallExpression:
+		return startPos(exp.Function)
+	case *ast.Identifier:
+		return exp.Token.Pos
+	case *ast.IntegerLiteral:
+		return exp.Token.Pos
+	case *ast.Boolean:
+		return exp.Token.Pos
+	case *ast.PrefixExpression:
+		return exp.Token.Pos
+	case *ast.IfExpression:
+		return exp.Token.Pos
+	case *ast.FunctionLiteral:
+		return exp.Token.Pos
+	}
+	return token.Position{}
+}
+
+// blockPos returns the position of the last statement of a block, which
+// gives the value of the block, or of the block itself if it is empty.
+func blockPos(block *ast.BlockStatement) token.Position {
+	if block == nil {
+		return token.Position{}
+	}
+	if n := len(block.Statements); n > 0 {
+		if es, ok := block.Statements[n-1].(*ast.ExpressionStatement); ok && es.Expression != nil {
+			return startPos(es.Expression)
+		}
+	}
+	return block.Token.Pos
+}
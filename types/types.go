@@ -0,0 +1,127 @@
+// Package types infers static types of Monkey programs.
+//
+// The inference is Hindley-Milner with let-polymorphism: a let binding is
+// generalized, so that a function like fn(x) { x } can be applied to values
+// of different types. Names are bound to the variables of the environments
+// at runtime found by the env package, and a variable declared by more than
+// one let statement or referred to along with another is not generalized.
+// The checker only reports programs that may fail at runtime with type
+// mismatches and does not change their meaning.
+//
+// Type annotations such as let x: int = 5 and fn(a: int) -> bool { ... } are
+// enforced where present. In the gradual mode, parameters and results without
+// annotations have the type any, which is compatible with all types, so that
+// unannotated functions are left dynamic.
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Type represents a type of values.
+type Type interface {
+	String() string
+	typ()
+}
+
+// Basic represents a type without type parameters.
+type Basic struct {
+	Name string
+}
+
+// Basic types
+var (
+	Int    = &Basic{"int"}
+	Bool   = &Basic{"bool"}
+	String = &Basic{"string"} // reserved for string values
+	Null   = &Basic{"null"}   // the value of an if expression without else
+	Any    = &Basic{"any"}    // dynamically typed values, compatible with all types
+)
+
+// named maps the names of types in annotations to the types.
+var named = map[string]Type{
+	"int":    Int,
+	"bool":   Bool,
+	"string": String,
+	"null":   Null,
+	"any":    Any,
+}
+
+// Func represents a function type.
+type Func struct {
+	Params []Type
+	Result Type
+}
+
+// Var represents a type variable. A bound variable stands for the type it is
+// unified with.
+type Var struct {
+	id       int
+	level    int  // let nesting depth where the variable is introduced
+	instance Type // bound type, or nil
+}
+
+func (t *Basic) typ() {}
+func (t *Func) typ()  {}
+func (t *Var) typ()   {}
+
+func (t *Basic) String() string { return t.Name }
+func (t *Func) String() string  { return typeString(t, map[*Var]string{}) }
+func (t *Var) String() string   { return typeString(t, map[*Var]string{}) }
+
+// prune returns the type a variable is bound to, following chains of bound
+// variables, or the type itself if it is not a bound variable.
+func prune(t Type) Type {
+	for {
+		v, ok := t.(*Var)
+		if !ok || v.instance == nil {
+			return t
+		}
+		t = v.instance
+	}
+}
+
+// typeString returns the text of a type, naming unbound variables a, b, c...
+// in order of appearance. names holds the names given so far, so that types
+// printed together share the names.
+func typeString(t Type, names map[*Var]string) string {
+	switch t := prune(t).(type) {
+	case *Basic:
+		return t.Name
+	case *Func:
+		params := make([]string, len(t.Params))
+		for i, p := range t.Params {
+			params[i] = typeString(p, names)
+		}
+		return "fn(" + strings.Join(params, ", ") + ") -> " + typeString(t.Result, names)
+	case *Var:
+		name, ok := names[t]
+		if !ok {
+			name = varName(len(names))
+			names[t] = name
+		}
+		return name
+	}
+	return "?"
+}
+
+// varName returns the i-th variable name: a, ..., z, a1, ..., z1, a2...
+func varName(i int) string {
+	name := string(rune('a' + i%26))
+	if i >= 26 {
+		name += fmt.Sprint(i / 26)
+	}
+	return name
+}
+
+// Scheme represents a possibly polymorphic type of a name. The type is
+// instantiated with fresh variables for Vars at each use of the name.
+type Scheme struct {
+	Vars []*Var
+	Type Type
+}
+
+func (s *Scheme) String() string {
+	return typeString(s.Type, map[*Var]string{})
+}
@@ -0,0 +1,221 @@
+package types
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/parser"
+)
+
+func check(t *testing.T, input string) (*ast.Program, *Info) {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program, Check(program)
+}
+
+func TestSignatures(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 5;", "int"},
+		{"let x = !5;", "bool"},
+		{"let x = 1 < 2 == true;", "bool"},
+		{"let x = if (1) { 2 };", "null"},
+		{"let x = if (1) { 2 } else { 3 };", "int"},
+		{"let id = fn(x) { x };", "fn(a) -> a"},
+		{"let k = fn(x, y) { x };", "fn(a, b) -> a"},
+		{"let add = fn(x, y) { x + y };", "fn(int, int) -> int"},
+		{"let neg = fn(x) { -x };", "fn(int) -> int"},
+		{"let eq = fn(x, y) { x == y };", "fn(a, a) -> bool"},
+		{"let f = fn() { return 1; };", "fn() -> int"},
+		{"let f = fn(x) { if (x) { return 1; } 2 };", "fn(a) -> int"},
+		{"let f = fn(x) { let y = x; };", "fn(a) -> null"},
+		{"let apply = fn(f, x) { f(x) };", "fn(fn(a) -> b, a) -> b"},
+		{"let compose = fn(f, g) { fn(x) { f(g(x)) } };", "fn(fn(a) -> b, fn(c) -> a) -> fn(c) -> b"},
+		{"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } };", "fn(int) -> int"},
+		{"let loop = fn(n) { loop(n) };", "fn(a) -> b"},
+		{"let id = fn(x) { x }; let pair = fn(a, b) { id(a) == a; id(b) == b; id };", "fn(a, b) -> fn(c) -> c"},
+		{"let f = fn(x) { let g = fn(y) { x }; g(1); g(true) };", "fn(a) -> a"},
+	}
+
+	for _, tt := range tests {
+		program, info := check(t, tt.input)
+		if len(info.Errors) > 0 {
+			t.Errorf("type errors for %q: %v", tt.input, info.Errors)
+			continue
+		}
+		lets := program.Statements
+		let := lets[len(lets)-1].(*ast.LetStatement)
+		if got := info.Defs[let.Name].String(); got != tt.expected {
+			t.Errorf("type of %q wrong. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestLetPolymorphism(t *testing.T) {
+	program, info := check(t, "let id = fn(x) { x }; let a = id(1); let b = id(true); let c = id(id);")
+	if len(info.Errors) > 0 {
+		t.Fatalf("type errors: %v", info.Errors)
+	}
+	for i, expected := range []string{"fn(a) -> a", "int", "bool", "fn(a) -> a"} {
+		let := program.Statements[i].(*ast.LetStatement)
+		if got := info.Defs[let.Name].String(); got != expected {
+			t.Errorf("type of %s wrong. want=%s, got=%s", let.Name, expected, got)
+		}
+	}
+
+	// parameters are monomorphic
+	_, info = check(t, "fn(id) { id(1); id(true) }")
+	if len(info.Errors) != 1 || info.Errors[0].Msg != "type mismatch: expected int, got bool" {
+		t.Errorf("errors wrong: %v", info.Errors)
+	}
+}
+
+func TestMutualRecursion(t *testing.T) {
+	program, info := check(t, `
+let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };`)
+	if len(info.Errors) > 0 {
+		t.Fatalf("type errors: %v", info.Errors)
+	}
+	for _, stmt := range program.Statements {
+		let := stmt.(*ast.LetStatement)
+		if got := info.Defs[let.Name].String(); got != "fn(int) -> bool" {
+			t.Errorf("type of %s wrong. got=%s", let.Name, got)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"1 + true", []string{"1:5: type mismatch: expected int, got bool"}},
+		{"-true; !1", []string{"1:2: type mismatch: expected int, got bool"}},
+		{"1 == false", []string{"1:6: type mismatch: expected int, got bool"}},
+		{"if (true) { 1 } else { false }", []string{"1:24: type mismatch: expected int, got bool"}},
+		{"let f = fn(x) { x + 1 }; f(true)", []string{"1:28: type mismatch: expected int, got bool"}},
+		{"let f = fn(x) { x }; f(1, 2)", []string{"1:23: wrong number of arguments: f takes 1, got 2"}},
+		{"1(2)", []string{"1:1: cannot call 1 of type int"}},
+		{"fn(x) { x(x) }", []string{"1:9: recursive type: a = fn(a) -> b"}},
+		{"fn(x) { if (x) { return 1; } true }", []string{"1:30: type mismatch: expected int, got bool"}},
+		{"let f = fn(g) { g(1) }; f(fn(x) { x == true })",
+			[]string{"1:27: type mismatch: expected fn(int) -> a, got fn(bool) -> bool"}},
+		{"x + 1; let y = z;", []string{"1:1: undefined: x", "1:16: undefined: z"}},
+		{"let f = fn(c) { let x = 1; if (c) { let x = true; }; x + 1 }; f(true)",
+			[]string{"1:45: type mismatch: expected int, got bool"}},
+		{"let x = 1; let f = fn() { x + 1 }; let x = true; f()",
+			[]string{"1:44: type mismatch: expected int, got bool"}},
+		{"let id = fn(x) { x }; let f = fn() { id(true) }; let id = fn(y) { y + 1 }; f()",
+			[]string{"1:59: type mismatch: expected fn(bool) -> bool, got fn(int) -> int"}},
+		{"let x = 1; let f = fn(c) { if (c) { let x = true; }; x + 1 }; f(true)", []string{
+			"1:54: type mismatch: expected bool, got int",
+			"1:54: type mismatch: expected int, got bool",
+		}},
+		{"let x = 1; let f = fn() { let y = x; let x = 2; y + x }; f()", nil},
+		{"(1 + true) + (false - 2)", []string{
+			"1:6: type mismatch: expected int, got bool",
+			"1:15: type mismatch: expected int, got bool",
+		}},
+	}
+
+	for _, tt := range tests {
+		_, info := check(t, tt.input)
+		var got []string
+		for _, err := range info.Errors {
+			got = append(got, err.Error())
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("errors for %q wrong.\nwant=%q\ngot =%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestTypeOf(t *testing.T) {
+	program, info := check(t, "let f = fn(x) { x < 1 }; f(2)")
+	call := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	if got := info.TypeOf(call); got == nil || got.String() != "bool" {
+		t.Errorf("TypeOf(call) wrong. got=%v", got)
+	}
+	if got := info.TypeOf(call.Function); got == nil || got.String() != "fn(int) -> bool" {
+		t.Errorf("TypeOf(f) wrong. got=%v", got)
+	}
+	if got := info.TypeOf(&ast.Identifier{Value: "unknown"}); got != nil {
+		t.Errorf("TypeOf(unchecked) wrong. got=%v", got)
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		errors   []string
+	}{
+		{"let x: int = 5;", "int", nil},
+		{"let id = fn(x: int) { x };", "fn(int) -> int", nil},
+		{"let f = fn(x) -> bool { x };", "fn(bool) -> bool", nil},
+		{"let f: fn(int) -> int = fn(x) { x };", "fn(int) -> int", nil},
+		{"let f = fn(x: int, x: bool) { x };", "fn(int, bool) -> bool", nil},
+		{"let x: bool = 5;", "bool", []string{"1:15: type mismatch: expected bool, got int"}},
+		{"let x: foo = 5;", "int", []string{"1:8: unknown type foo"}},
+	}
+
+	for _, tt := range tests {
+		program, info := check(t, tt.input)
+		var got []string
+		for _, err := range info.Errors {
+			got = append(got, err.Error())
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.errors, "\n") {
+			t.Errorf("errors for %q wrong.\nwant=%q\ngot =%q", tt.input, tt.errors, got)
+		}
+		let := program.Statements[0].(*ast.LetStatement)
+		if got := info.Defs[let.Name].String(); got != tt.expected {
+			t.Errorf("type of %q wrong. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestCheckGradual(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn(x) { x };", "fn(any) -> any"},
+		{"let f = fn(x: int) -> int { x + 1 };", "fn(int) -> int"},
+		{"let f = fn(x, y: bool) { if (y) { x } else { 1 } };", "fn(any, bool) -> any"},
+		{"let x = 1;", "int"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		info := CheckGradual(program)
+		if len(info.Errors) > 0 {
+			t.Errorf("type errors for %q: %v", tt.input, info.Errors)
+			continue
+		}
+		let := program.Statements[0].(*ast.LetStatement)
+		if got := info.Defs[let.Name].String(); got != tt.expected {
+			t.Errorf("type of %q wrong. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+
+	info := CheckGradual(parser.New(lexer.New("let f = fn(n) { if (n < 2) { return n; } f(n - 1) }; f(true)")).ParseProgram())
+	if len(info.Errors) > 0 {
+		t.Errorf("type errors for recursive function: %v", info.Errors)
+	}
+
+	info = CheckGradual(parser.New(lexer.New("fn(x: int) { x }(true)")).ParseProgram())
+	if len(info.Errors) != 1 || info.Errors[0].Msg != "type mismatch: expected int, got bool" {
+		t.Errorf("errors wrong: %v", info.Errors)
+	}
+}
@@ -0,0 +1,362 @@
+// Package vm executes compiled Monkey programs on a stack-based virtual
+// machine.
+package vm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/oohira/monkey/code"
+	"github.com/oohira/monkey/compiler"
+	"github.com/oohira/monkey/object"
+)
+
+// Limits of the virtual machine
+const (
+	StackSize = 1 << 16
+	MaxFrames = 1 << 14
+)
+
+// ErrStackOverflow is returned when the operand stack or the call frames
+// are exhausted.
+var ErrStackOverflow = errors.New("stack overflow")
+
+// Frame represents a call of a closure. ip is the position of the
+// instruction being executed and basePointer is the position of the first
+// local on the stack.
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int
+}
+
+func (f *Frame) instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
+
+// VM represents a virtual machine running a program.
+type VM struct {
+	constants []object.Object
+	globals   []object.Object
+	names     []string // names of the globals by index
+
+	stack []object.Object
+	sp    int // the top of the stack is stack[sp-1]
+
+	frames      []Frame
+	framesIndex int
+}
+
+// New returns a virtual machine ready to run bytecode, which must be
+// well-formed as the output of the compiler and the bytecode verified by
+// mkc.Read are.
+func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainClosure := &object.Closure{Fn: mainFn}
+
+	frames := make([]Frame, 1, 64)
+	frames[0] = Frame{cl: mainClosure, ip: -1}
+
+	return &VM{
+		constants:   bytecode.Constants,
+		globals:     make([]object.Object, len(bytecode.Globals)),
+		names:       bytecode.Globals,
+		stack:       make([]object.Object, StackSize),
+		sp:          0,
+		frames:      frames,
+		framesIndex: 1,
+	}
+}
+
+// LastPoppedStackElem returns the element popped last from the stack,
+// which is the value of the program after Run.
+func (vm *VM) LastPoppedStackElem() object.Object {
+	return vm.stack[vm.sp]
+}
+
+// Run runs the program until it ends or a runtime error occurs.
+func (vm *VM) Run() error {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for {
+		frame := &vm.frames[vm.framesIndex-1]
+		frame.ip++
+		ins = frame.instructions()
+		if frame.ip >= len(ins) {
+			return nil
+		}
+		ip = frame.ip
+		op = code.Opcode(ins[ip])
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			if err := vm.push(vm.constants[constIndex]); err != nil {
+				return err
+			}
+
+		case code.OpPop:
+			vm.pop()
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+			code.OpEqual, code.OpNotEqual, code.OpLessThan, code.OpGreaterThan:
+			right := vm.pop()
+			left := vm.pop()
+			result, err := object.Infix(infixOperators[op], left, right)
+			if err != nil {
+				return err
+			}
+			vm.push(result)
+
+		case code.OpMinus, code.OpBang:
+			operator := "-"
+			if op == code.OpBang {
+				operator = "!"
+			}
+			result, err := object.Prefix(operator, vm.pop())
+			if err != nil {
+				return err
+			}
+			vm.push(result)
+
+		case code.OpTrue:
+			if err := vm.push(object.TrueValue); err != nil {
+				return err
+			}
+
+		case code.OpFalse:
+			if err := vm.push(object.FalseValue); err != nil {
+				return err
+			}
+
+		case code.OpNull:
+			if err := vm.push(object.NullValue); err != nil {
+				return err
+			}
+
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip = pos - 1
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			if !object.IsTruthy(vm.pop()) {
+				frame.ip = pos - 1
+			}
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			vm.globals[globalIndex] = vm.pop()
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			global := vm.globals[globalIndex]
+			if global == nil {
+				return fmt.Errorf("identifier not found: %s", vm.names[globalIndex])
+			}
+			if err := vm.push(global); err != nil {
+				return err
+			}
+
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			local := vm.stack[frame.basePointer+int(localIndex)]
+			if local == nil {
+				return fmt.Errorf("unbound local %d", localIndex)
+			}
+			if err := vm.push(local); err != nil {
+				return err
+			}
+
+		case code.OpMakeCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			local := &vm.stack[frame.basePointer+int(localIndex)]
+			*local = &object.Cell{Value: *local}
+
+		case code.OpGetCell, code.OpGetFree:
+			index := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			cell, err := vm.cell(frame, op, int(index))
+			if err != nil {
+				return err
+			}
+			if cell.Value == nil {
+				return fmt.Errorf("unbound cell %d", index)
+			}
+			if err := vm.push(cell.Value); err != nil {
+				return err
+			}
+
+		case code.OpSetCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			cell, err := vm.cell(frame, op, int(localIndex))
+			if err != nil {
+				return err
+			}
+			cell.Value = vm.pop()
+
+		case code.OpGetFreeCell:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			if err := vm.push(frame.cl.Free[freeIndex]); err != nil {
+				return err
+			}
+
+		case code.OpTryLocal, code.OpTryCell, code.OpTryFree:
+			index := int(code.ReadUint8(ins[ip+1:]))
+			pos := int(code.ReadUint16(ins[ip+2:]))
+			frame.ip += 3
+			var value object.Object
+			if op == code.OpTryLocal {
+				value = vm.stack[frame.basePointer+index]
+			} else {
+				cell, err := vm.cell(frame, op, index)
+				if err != nil {
+					return err
+				}
+				value = cell.Value
+			}
+			if value != nil {
+				if err := vm.push(value); err != nil {
+					return err
+				}
+				frame.ip = pos - 1
+			}
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			frame.ip += 3
+			if err := vm.pushClosure(int(constIndex), int(numFree)); err != nil {
+				return err
+			}
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			if err := vm.callClosure(int(numArgs)); err != nil {
+				return err
+			}
+
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+			if vm.framesIndex == 1 {
+				// a return statement at the top level ends the program
+				return nil
+			}
+			vm.framesIndex--
+			vm.sp = frame.basePointer - 1
+			vm.push(returnValue)
+
+		case code.OpReturn:
+			vm.framesIndex--
+			vm.sp = frame.basePointer - 1
+			vm.push(object.NullValue)
+
+		default:
+			return fmt.Errorf("unknown opcode %d", op)
+		}
+	}
+}
+
+var infixOperators = map[code.Opcode]string{
+	code.OpAdd:         "+",
+	code.OpSub:         "-",
+	code.OpMul:         "*",
+	code.OpDiv:         "/",
+	code.OpEqual:       "==",
+	code.OpNotEqual:    "!=",
+	code.OpLessThan:    "<",
+	code.OpGreaterThan: ">",
+}
+
+func (vm *VM) callClosure(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	cl, ok := callee.(*object.Closure)
+	if !ok {
+		return fmt.Errorf("not a function: %s", callee.Type())
+	}
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+	}
+	if vm.framesIndex >= MaxFrames {
+		return ErrStackOverflow
+	}
+
+	basePointer := vm.sp - numArgs
+	if basePointer+cl.Fn.NumLocals >= StackSize {
+		return ErrStackOverflow
+	}
+	frame := Frame{cl: cl, ip: -1, basePointer: basePointer}
+	if vm.framesIndex < len(vm.frames) {
+		vm.frames[vm.framesIndex] = frame
+	} else {
+		vm.frames = append(vm.frames, frame)
+	}
+	vm.framesIndex++
+
+	// clear the locals left by the previous calls
+	for i := vm.sp; i < basePointer+cl.Fn.NumLocals; i++ {
+		vm.stack[i] = nil
+	}
+	vm.sp = basePointer + cl.Fn.NumLocals
+	return nil
+}
+
+// cell returns the cell of the local or, for OpGetFree and OpTryFree, the
+// free variable at index.
+func (vm *VM) cell(frame *Frame, op code.Opcode, index int) (*object.Cell, error) {
+	var obj object.Object
+	if op == code.OpGetFree || op == code.OpTryFree {
+		obj = frame.cl.Free[index]
+	} else {
+		obj = vm.stack[frame.basePointer+index]
+	}
+	cell, ok := obj.(*object.Cell)
+	if !ok {
+		return nil, fmt.Errorf("not a cell: %d", index)
+	}
+	return cell, nil
+}
+
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	function, ok := vm.constants[constIndex].(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", vm.constants[constIndex])
+	}
+
+	free := make([]object.Object, numFree)
+	copy(free, vm.stack[vm.sp-numFree:vm.sp])
+	vm.sp = vm.sp - numFree
+
+	return vm.push(&object.Closure{Fn: function, Free: free})
+}
+
+func (vm *VM) push(o object.Object) error {
+	if vm.sp >= StackSize {
+		return ErrStackOverflow
+	}
+	vm.stack[vm.sp] = o
+	vm.sp++
+	return nil
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
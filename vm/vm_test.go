@@ -0,0 +1,161 @@
+package vm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/compiler"
+	"github.com/oohira/monkey/evaluator"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/object"
+	"github.com/oohira/monkey/parser"
+)
+
+func parse(t testing.TB, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+// run compiles and runs program, and returns the result in the form of
+// the evaluator, that is, a runtime error is returned as an *object.Error.
+func run(t testing.TB, program *ast.Program) object.Object {
+	t.Helper()
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	return vm.LastPoppedStackElem()
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5", "5"},
+		{"-5 + 10 * 2", "15"},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", "50"},
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"!true; !!5", "true"},
+		{"1 < 2 == true", "true"},
+		{"1 == true", "false"},
+		{"if (0) { 10 }", "10"},
+		{"if (1 > 2) { 10 }", "null"},
+		{"if (1 > 2) { 10 } else { 20 }", "20"},
+		{"if (true) {}", "null"},
+		{"if (if (false) { 10 }) { 10 } else { 20 }", "20"},
+		{"let a = 5; let b = a * 2; b", "10"},
+		{"let a = 5;", "null"},
+		{"", "null"},
+		{"9; return 2 * 5; 9;", "10"},
+		{"if (true) { if (true) { return 10; } return 1; }", "10"},
+		{"let f = fn(x) { if (x) { return 1; } 2 }; f(true) + f(false)", "3"},
+		{"let f = fn() { let x = 1; }; f()", "null"},
+		{"let f = fn() { }; f()", "null"},
+		{"let add = fn(a, b) { a + b }; add(add(1, 2), 3)", "6"},
+		{"fn(x) { x }(5)", "5"},
+		{"let one = fn() { 1 }; let g = fn() { one }; g()()", "1"},
+		{"let adder = fn(x) { fn(y) { x + y } }; let addTwo = adder(2); addTwo(3)", "5"},
+		{"let f = fn(a) { fn(b) { fn(c) { a + b + c } } }; f(1)(2)(3)", "6"},
+		{"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(25)", "15511210043330985984000000"},
+		{"let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } }; let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; odd(7)", "true"},
+		{"let f = fn() { let count = fn(n) { if (n == 0) { 0 } else { count(n - 1) + 1 } }; count(10) }; f()", "10"},
+		{"let f = fn(x) { 1 + if (x) { return 10; } else { 2 } }; f(true) + f(false)", "13"},
+		{"let x = 1; let f = fn() { x }; let x = 2; f()", "2"},
+		{"let x = 10; let f = fn(x) { let x = x + 1; x }; f(1) + x", "12"},
+		{"let g = fn() { let a = 1; let h = fn() { a }; let a = 2; h() }; g()", "2"},
+		{"let f = fn() { let g = fn() { x }; let x = 4; g() }; f()", "4"},
+		{"let f = fn() { return 1; g() }; f()", "1"},
+		{"let x = 1; let f = fn() { if (true) { let x = 2; } x }; f() + x", "3"},
+		{"let x = 1; let f = fn() { if (false) { let x = 2; } x }; f()", "1"},
+		{"let f = fn(x, x) { x }; f(1, 2)", "2"},
+		{"let counter = fn() { let n = 0; let inc = fn() { let n = n + 1; n }; inc() + inc() }; counter()", "2"},
+		{"5 + true;", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+		{"-true", "ERROR: unknown operator: -BOOLEAN"},
+		{"true + false; 5", "ERROR: unknown operator: BOOLEAN + BOOLEAN"},
+		{"if (10 > 1) { true + false; 5 }", "ERROR: unknown operator: BOOLEAN + BOOLEAN"},
+		{"true < false", "ERROR: unknown operator: BOOLEAN < BOOLEAN"},
+		{"1 / 0", "ERROR: division by zero"},
+		{"5()", "ERROR: not a function: INTEGER"},
+		{"fn(x) { x }(1, 2)", "ERROR: wrong number of arguments: want=1, got=2"},
+		{"let f = fn() { g }; f(); let g = 1;", "ERROR: identifier not found: g"},
+		{"x; let x = 1;", "ERROR: identifier not found: x"},
+		{"fn(a) { fn() { b } }(1)()", "ERROR: identifier not found: b"},
+		{"fn() {} + 1", "ERROR: type mismatch: FUNCTION + INTEGER"},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		got := run(t, program)
+		if got.Inspect() != tt.expected {
+			t.Errorf("result of %q wrong. want=%s, got=%s", tt.input, tt.expected, got.Inspect())
+		}
+		// the VM must agree with the evaluator
+		if evaluated := evaluator.Eval(program, object.NewEnvironment()); evaluated.Inspect() != got.Inspect() {
+			t.Errorf("result of %q differs from the evaluator. vm=%s, evaluator=%s", tt.input, got.Inspect(), evaluated.Inspect())
+		}
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	got := run(t, parse(t, "let f = fn(n) { f(n + 1) + 1 }; f(0)"))
+	if got.Inspect() != "ERROR: stack overflow" {
+		t.Errorf("result wrong. got=%s", got.Inspect())
+	}
+}
+
+func TestUndefinedLocal(t *testing.T) {
+	got := run(t, parse(t, "fn() { if (false) { let y = 1; } y }()"))
+	if got.Inspect() != "ERROR: identifier not found: y" {
+		t.Errorf("result wrong. got=%s", got.Inspect())
+	}
+}
+
+// BenchmarkRun runs the programs ../testdata/*.mk shared by the
+// implementations, whose results must be those of the evaluator.
+func BenchmarkRun(b *testing.B) {
+	files, err := filepath.Glob("../testdata/*.mk")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			b.Fatal(err)
+		}
+		program := parse(b, string(src))
+		want := evaluator.Eval(program, object.NewEnvironment()).Inspect()
+		b.Run(strings.TrimSuffix(filepath.Base(file), ".mk"), func(b *testing.B) {
+			comp := compiler.New()
+			if err := comp.Compile(program); err != nil {
+				b.Fatalf("compiler error: %s", err)
+			}
+			bytecode := comp.Bytecode()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				vm := New(bytecode)
+				var result object.Object
+				if err := vm.Run(); err != nil {
+					result = &object.Error{Message: err.Error()}
+				} else {
+					result = vm.LastPoppedStackElem()
+				}
+				if got := result.Inspect(); got != want {
+					b.Fatalf("result wrong. want=%s, got=%s", want, got)
+				}
+			}
+		})
+	}
+}
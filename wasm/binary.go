@@ -0,0 +1,509 @@
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var magic = []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+
+// Section ids
+const (
+	customSection   = 0
+	typeSection     = 1
+	functionSection = 3
+	exportSection   = 7
+	codeSection     = 10
+)
+
+const (
+	funcTypeTag  = 0x60
+	funcExport   = 0x00
+	funcNames    = 1
+	localNames   = 2
+	nameSection  = "name"
+	maxBodyDepth = 1024
+	maxLocals    = 50000
+)
+
+// Encode returns the binary encoding of m. The names of the functions and
+// the locals are encoded in the name section.
+func Encode(m *Module) []byte {
+	out := append([]byte(nil), magic...)
+
+	var sec []byte
+	sec = appendU32(sec, len(m.Types))
+	for _, ft := range m.Types {
+		sec = append(sec, funcTypeTag)
+		sec = appendValTypes(sec, ft.Params)
+		sec = appendValTypes(sec, ft.Results)
+	}
+	out = appendSection(out, typeSection, sec)
+
+	sec = appendU32(nil, len(m.Funcs))
+	for _, f := range m.Funcs {
+		sec = appendU32(sec, f.Type)
+	}
+	out = appendSection(out, functionSection, sec)
+
+	sec = appendU32(nil, len(m.Exports))
+	for _, e := range m.Exports {
+		sec = appendName(sec, e.Name)
+		sec = append(sec, funcExport)
+		sec = appendU32(sec, e.Func)
+	}
+	out = appendSection(out, exportSection, sec)
+
+	sec = appendU32(nil, len(m.Funcs))
+	for _, f := range m.Funcs {
+		code := appendLocals(nil, f.Locals)
+		code = appendInstrs(code, f.Body)
+		code = append(code, byte(OpEnd))
+		sec = appendU32(sec, len(code))
+		sec = append(sec, code...)
+	}
+	out = appendSection(out, codeSection, sec)
+
+	if names := encodeNames(m); names != nil {
+		out = appendSection(out, customSection, names)
+	}
+	return out
+}
+
+func encodeNames(m *Module) []byte {
+	var fnames, lnames []byte
+	nf, nl := 0, 0
+	for i, f := range m.Funcs {
+		if f.Name != "" {
+			nf++
+			fnames = appendU32(fnames, i)
+			fnames = appendName(fnames, f.Name)
+		}
+		if len(f.LocalNames) > 0 {
+			nl++
+			lnames = appendU32(lnames, i)
+			lnames = appendU32(lnames, len(f.LocalNames))
+			for j, name := range f.LocalNames {
+				lnames = appendU32(lnames, j)
+				lnames = appendName(lnames, name)
+			}
+		}
+	}
+	if nf == 0 && nl == 0 {
+		return nil
+	}
+	sec := appendName(nil, nameSection)
+	if nf > 0 {
+		sec = appendSection(sec, funcNames, append(appendU32(nil, nf), fnames...))
+	}
+	if nl > 0 {
+		sec = appendSection(sec, localNames, append(appendU32(nil, nl), lnames...))
+	}
+	return sec
+}
+
+func appendSection(out []byte, id byte, contents []byte) []byte {
+	out = append(out, id)
+	out = appendU32(out, len(contents))
+	return append(out, contents...)
+}
+
+func appendValTypes(out []byte, types []ValType) []byte {
+	out = appendU32(out, len(types))
+	for _, t := range types {
+		out = append(out, byte(t))
+	}
+	return out
+}
+
+// appendLocals appends the locals as runs of the same type.
+func appendLocals(out []byte, locals []ValType) []byte {
+	var runs []int
+	for i := range locals {
+		if i == 0 || locals[i] != locals[i-1] {
+			runs = append(runs, i)
+		}
+	}
+	out = appendU32(out, len(runs))
+	for i, start := range runs {
+		end := len(locals)
+		if i+1 < len(runs) {
+			end = runs[i+1]
+		}
+		out = appendU32(out, end-start)
+		out = append(out, byte(locals[start]))
+	}
+	return out
+}
+
+func appendInstrs(out []byte, instrs []Instr) []byte {
+	for _, in := range instrs {
+		out = append(out, byte(in.Op))
+		switch in.Op {
+		case OpI32Const:
+			out = appendS64(out, int64(int32(in.Imm)))
+		case OpI64Const:
+			out = appendS64(out, in.Imm)
+		case OpLocalGet, OpLocalSet, OpLocalTee, OpCall:
+			out = appendU32(out, int(in.Imm))
+		case OpIf:
+			out = append(out, byte(in.Type))
+			out = appendInstrs(out, in.Then)
+			if in.Else != nil {
+				out = append(out, byte(OpElse))
+				out = appendInstrs(out, in.Else)
+			}
+			out = append(out, byte(OpEnd))
+		}
+	}
+	return out
+}
+
+func appendName(out []byte, name string) []byte {
+	out = appendU32(out, len(name))
+	return append(out, name...)
+}
+
+// appendU32 appends n in unsigned LEB128.
+func appendU32(out []byte, n int) []byte {
+	v := uint32(n)
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+// appendS64 appends n in signed LEB128.
+func appendS64(out []byte, n int64) []byte {
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if (n == 0 && b&0x40 == 0) || (n == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+// Decode returns the module of a binary encoding. Custom sections other
+// than the name section are skipped.
+func Decode(data []byte) (*Module, error) {
+	if !bytes.HasPrefix(data, magic) {
+		return nil, errors.New("wasm: not a module of version 1")
+	}
+	d := &decoder{data: data, pos: len(magic)}
+	m := &Module{}
+	var types []int
+	var names *nameMap
+	last := 0
+	for !d.eof() {
+		id := d.byte()
+		size := d.u32()
+		if d.err != nil {
+			break
+		}
+		end := d.pos + size
+		if end > len(d.data) {
+			return nil, d.errorf("section %d exceeds the module", id)
+		}
+		if id != customSection {
+			if int(id) <= last {
+				return nil, d.errorf("section %d out of order", id)
+			}
+			last = int(id)
+		}
+		sec := &decoder{data: d.data[:end], pos: d.pos}
+		switch id {
+		case customSection:
+			if name := sec.name(); name == nameSection && sec.err == nil {
+				names = sec.names()
+			}
+			sec.pos = end
+		case typeSection:
+			for n := sec.u32(); n > 0 && sec.err == nil; n-- {
+				if sec.byte() != funcTypeTag {
+					sec.fail("function type expected")
+					break
+				}
+				params := sec.valTypes()
+				results := sec.valTypes()
+				m.Types = append(m.Types, FuncType{Params: params, Results: results})
+			}
+		case functionSection:
+			for n := sec.u32(); n > 0 && sec.err == nil; n-- {
+				types = append(types, sec.u32())
+			}
+		case exportSection:
+			for n := sec.u32(); n > 0 && sec.err == nil; n-- {
+				name := sec.name()
+				if sec.byte() != funcExport {
+					sec.fail("export of %q is not a function", name)
+					break
+				}
+				m.Exports = append(m.Exports, Export{Name: name, Func: sec.u32()})
+			}
+		case codeSection:
+			n := sec.u32()
+			if n != len(types) {
+				sec.fail("%d function bodies for %d functions", n, len(types))
+			}
+			for i := 0; i < n && sec.err == nil; i++ {
+				size := sec.u32()
+				if sec.pos+size > len(sec.data) {
+					sec.fail("function body exceeds the section")
+					break
+				}
+				body := &decoder{data: sec.data[:sec.pos+size], pos: sec.pos}
+				f := &Func{Type: types[i]}
+				for runs := body.u32(); runs > 0 && body.err == nil; runs-- {
+					count, t := body.u32(), ValType(body.byte())
+					if t != I32 && t != I64 {
+						body.fail("unsupported value type 0x%02x", byte(t))
+					}
+					if len(f.Locals)+count > maxLocals {
+						body.fail("too many locals")
+						break
+					}
+					for ; count > 0; count-- {
+						f.Locals = append(f.Locals, t)
+					}
+				}
+				var end Opcode
+				f.Body, end = body.instrs(0)
+				if end != OpEnd && body.err == nil {
+					body.fail("else outside of if")
+				}
+				if body.err == nil && !body.eof() {
+					body.fail("garbage after function body")
+				}
+				if body.err != nil {
+					return nil, body.err
+				}
+				m.Funcs = append(m.Funcs, f)
+				sec.pos = body.pos
+			}
+		default:
+			return nil, d.errorf("unsupported section %d", id)
+		}
+		if sec.err != nil {
+			return nil, sec.err
+		}
+		if sec.pos != end {
+			return nil, d.errorf("section %d has a wrong size", id)
+		}
+		d.pos = end
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(m.Funcs) != len(types) {
+		return nil, fmt.Errorf("wasm: %d functions without bodies", len(types)-len(m.Funcs))
+	}
+	if names != nil {
+		for i, name := range names.funcs {
+			if i < len(m.Funcs) {
+				m.Funcs[i].Name = name
+			}
+		}
+		for i, locals := range names.locals {
+			if i < len(m.Funcs) {
+				m.Funcs[i].LocalNames = locals
+			}
+		}
+	}
+	return m, nil
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (d *decoder) eof() bool {
+	return d.pos >= len(d.data)
+}
+
+func (d *decoder) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("wasm: offset %d: %s", d.pos, fmt.Sprintf(format, a...))
+}
+
+func (d *decoder) fail(format string, a ...interface{}) {
+	if d.err == nil {
+		d.err = d.errorf(format, a...)
+	}
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if d.eof() {
+		d.fail("unexpected end")
+		return 0
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b
+}
+
+func (d *decoder) u32() int {
+	var v uint64
+	for shift := 0; shift < 35; shift += 7 {
+		b := d.byte()
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			if v > 1<<32-1 {
+				d.fail("integer too large")
+			}
+			return int(v)
+		}
+	}
+	d.fail("integer too long")
+	return 0
+}
+
+func (d *decoder) s64() int64 {
+	var v int64
+	shift := 0
+	for shift < 70 {
+		b := d.byte()
+		v |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				v |= -1 << shift
+			}
+			return v
+		}
+	}
+	d.fail("integer too long")
+	return 0
+}
+
+func (d *decoder) name() string {
+	n := d.u32()
+	if d.err != nil {
+		return ""
+	}
+	if d.pos+n > len(d.data) {
+		d.fail("name exceeds the section")
+		return ""
+	}
+	s := string(d.data[d.pos : d.pos+n])
+	d.pos += n
+	return s
+}
+
+func (d *decoder) valTypes() []ValType {
+	n := d.u32()
+	var types []ValType
+	for ; n > 0 && d.err == nil; n-- {
+		t := ValType(d.byte())
+		if t != I32 && t != I64 {
+			d.fail("unsupported value type 0x%02x", byte(t))
+		}
+		types = append(types, t)
+	}
+	return types
+}
+
+// instrs decodes instructions up to an end or else, which it returns.
+func (d *decoder) instrs(depth int) ([]Instr, Opcode) {
+	if depth > maxBodyDepth {
+		d.fail("blocks nested too deeply")
+		return nil, 0
+	}
+	instrs := []Instr{}
+	for d.err == nil {
+		op := Opcode(d.byte())
+		if d.err != nil {
+			break
+		}
+		in := Instr{Op: op}
+		switch op {
+		case OpEnd, OpElse:
+			return instrs, op
+		case OpI32Const:
+			v := d.s64()
+			if v != int64(int32(v)) {
+				d.fail("i32 constant out of range")
+			}
+			in.Imm = v
+		case OpI64Const:
+			in.Imm = d.s64()
+		case OpLocalGet, OpLocalSet, OpLocalTee, OpCall:
+			in.Imm = int64(d.u32())
+		case OpIf:
+			in.Type = BlockType(d.byte())
+			if in.Type != NoResult && ValType(in.Type) != I32 && ValType(in.Type) != I64 {
+				d.fail("unsupported block type 0x%02x", byte(in.Type))
+			}
+			var end Opcode
+			in.Then, end = d.instrs(depth + 1)
+			if end == OpElse {
+				in.Else, end = d.instrs(depth + 1)
+				if end == OpElse {
+					d.fail("else after else")
+				}
+			}
+		default:
+			if _, ok := opcodeNames[op]; !ok {
+				d.fail("unsupported opcode 0x%02x", byte(op))
+			}
+		}
+		instrs = append(instrs, in)
+	}
+	return instrs, 0
+}
+
+type nameMap struct {
+	funcs  map[int]string
+	locals map[int][]string
+}
+
+// names decodes the function and local names of a name section. Malformed
+// names are ignored as the specification requires.
+func (d *decoder) names() *nameMap {
+	names := &nameMap{funcs: map[int]string{}, locals: map[int][]string{}}
+	for !d.eof() && d.err == nil {
+		id := d.byte()
+		size := d.u32()
+		end := d.pos + size
+		if d.err != nil || end > len(d.data) {
+			return nil
+		}
+		sub := &decoder{data: d.data[:end], pos: d.pos}
+		switch id {
+		case funcNames:
+			for n := sub.u32(); n > 0 && sub.err == nil; n-- {
+				i := sub.u32()
+				names.funcs[i] = sub.name()
+			}
+		case localNames:
+			for n := sub.u32(); n > 0 && sub.err == nil; n-- {
+				i := sub.u32()
+				var locals []string
+				for k := sub.u32(); k > 0 && sub.err == nil; k-- {
+					j := sub.u32()
+					name := sub.name()
+					if j != len(locals) {
+						sub.fail("sparse local names")
+					}
+					locals = append(locals, name)
+				}
+				names.locals[i] = locals
+			}
+		}
+		if sub.err != nil {
+			return nil
+		}
+		d.pos = end
+	}
+	return names
+}
@@ -0,0 +1,183 @@
+package wasm
+
+import (
+	"fmt"
+	"math"
+)
+
+// MaxCallDepth is the depth of calls at which the interpreter traps.
+const MaxCallDepth = 10000
+
+// Trap represents a runtime error of a module.
+type Trap struct {
+	Message string
+}
+
+func (t *Trap) Error() string {
+	return "wasm trap: " + t.Message
+}
+
+// Instance represents a validated module ready to run.
+type Instance struct {
+	m     *Module
+	depth int
+}
+
+// Instantiate validates m and returns its instance.
+func Instantiate(m *Module) (*Instance, error) {
+	if err := Validate(m); err != nil {
+		return nil, err
+	}
+	return &Instance{m: m}, nil
+}
+
+// Invoke calls the exported function name with args and returns its
+// results. Values of i32 are held in the lower 32 bits.
+func (in *Instance) Invoke(name string, args ...uint64) (results []uint64, err error) {
+	index := -1
+	for _, e := range in.m.Exports {
+		if e.Name == name {
+			index = e.Func
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("wasm: no exported function %q", name)
+	}
+	ft := in.m.Types[in.m.Funcs[index].Type]
+	if len(args) != len(ft.Params) {
+		return nil, fmt.Errorf("wasm: %s takes %d arguments, got %d", name, len(ft.Params), len(args))
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			trap, ok := r.(*Trap)
+			if !ok {
+				panic(r)
+			}
+			results, err = nil, trap
+		}
+		in.depth = 0
+	}()
+	return in.call(index, args), nil
+}
+
+func trap(message string) {
+	panic(&Trap{Message: message})
+}
+
+// frame represents an activation of a function.
+type frame struct {
+	locals []uint64
+	stack  []uint64
+}
+
+func (f *frame) push(v uint64) {
+	f.stack = append(f.stack, v)
+}
+
+func (f *frame) pop() uint64 {
+	v := f.stack[len(f.stack)-1]
+	f.stack = f.stack[:len(f.stack)-1]
+	return v
+}
+
+func (in *Instance) call(index int, args []uint64) []uint64 {
+	in.depth++
+	if in.depth > MaxCallDepth {
+		trap("call stack exhausted")
+	}
+	f := in.m.Funcs[index]
+	fr := &frame{locals: make([]uint64, len(args)+len(f.Locals))}
+	copy(fr.locals, args)
+	in.exec(fr, f.Body)
+	n := len(in.m.Types[f.Type].Results)
+	results := append([]uint64(nil), fr.stack[len(fr.stack)-n:]...)
+	in.depth--
+	return results
+}
+
+// exec executes instructions and reports whether a return was executed.
+// The module is validated, so the stack never underflows.
+func (in *Instance) exec(fr *frame, body []Instr) bool {
+	for _, i := range body {
+		switch i.Op {
+		case OpNop:
+		case OpUnreachable:
+			trap("unreachable")
+		case OpReturn:
+			return true
+		case OpDrop:
+			fr.pop()
+		case OpI32Const:
+			fr.push(uint64(uint32(i.Imm)))
+		case OpI64Const:
+			fr.push(uint64(i.Imm))
+		case OpLocalGet:
+			fr.push(fr.locals[i.Imm])
+		case OpLocalSet:
+			fr.locals[i.Imm] = fr.pop()
+		case OpLocalTee:
+			fr.locals[i.Imm] = fr.stack[len(fr.stack)-1]
+		case OpCall:
+			ft := in.m.Types[in.m.Funcs[i.Imm].Type]
+			n := len(fr.stack) - len(ft.Params)
+			args := append([]uint64(nil), fr.stack[n:]...)
+			fr.stack = append(fr.stack[:n], in.call(int(i.Imm), args)...)
+		case OpIf:
+			body := i.Then
+			if uint32(fr.pop()) == 0 {
+				body = i.Else
+			}
+			// The values of a block are on top of the stack when it ends.
+			if in.exec(fr, body) {
+				return true
+			}
+		case OpI32Eqz:
+			fr.push(boolean(uint32(fr.pop()) == 0))
+		case OpI64Eqz:
+			fr.push(boolean(fr.pop() == 0))
+		default:
+			b, a := fr.pop(), fr.pop()
+			fr.push(binaryOp(i.Op, a, b))
+		}
+	}
+	return false
+}
+
+func binaryOp(op Opcode, a, b uint64) uint64 {
+	switch op {
+	case OpI32Eq:
+		return boolean(uint32(a) == uint32(b))
+	case OpI32Ne:
+		return boolean(uint32(a) != uint32(b))
+	case OpI64Eq:
+		return boolean(a == b)
+	case OpI64Ne:
+		return boolean(a != b)
+	case OpI64LtS:
+		return boolean(int64(a) < int64(b))
+	case OpI64GtS:
+		return boolean(int64(a) > int64(b))
+	case OpI64Add:
+		return a + b
+	case OpI64Sub:
+		return a - b
+	case OpI64Mul:
+		return a * b
+	case OpI64DivS:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		if int64(a) == math.MinInt64 && int64(b) == -1 {
+			trap("integer overflow")
+		}
+		return uint64(int64(a) / int64(b))
+	}
+	panic("wasm: unexpected instruction " + op.String())
+}
+
+func boolean(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,166 @@
+// Package wasm represents WebAssembly modules of a small subset of the
+// instruction set: 32 and 64-bit integer constants, comparisons and
+// arithmetic, locals, calls and structured if blocks.
+//
+// A module can be printed in the text format (WAT), encoded into and
+// decoded from the binary format, validated and run by an interpreter.
+package wasm
+
+import "fmt"
+
+// ValType represents a type of values.
+type ValType byte
+
+// Value types in their binary encoding
+const (
+	I32 ValType = 0x7f
+	I64 ValType = 0x7e
+)
+
+func (t ValType) String() string {
+	switch t {
+	case I32:
+		return "i32"
+	case I64:
+		return "i64"
+	}
+	return fmt.Sprintf("valtype(0x%02x)", byte(t))
+}
+
+// FuncType represents the signature of a function.
+type FuncType struct {
+	Params  []ValType
+	Results []ValType
+}
+
+func (ft FuncType) equal(other FuncType) bool {
+	return equalTypes(ft.Params, other.Params) && equalTypes(ft.Results, other.Results)
+}
+
+func equalTypes(a, b []ValType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Module represents a module.
+type Module struct {
+	Types   []FuncType
+	Funcs   []*Func
+	Exports []Export
+}
+
+// AddType returns the index of ft in the types of m, adding it if absent.
+func (m *Module) AddType(ft FuncType) int {
+	for i, t := range m.Types {
+		if t.equal(ft) {
+			return i
+		}
+	}
+	m.Types = append(m.Types, ft)
+	return len(m.Types) - 1
+}
+
+// Func represents a function. Locals are the types of the locals following
+// the parameters. Name and LocalNames, which includes the parameters, are
+// optional.
+type Func struct {
+	Type       int
+	Locals     []ValType
+	Body       []Instr
+	Name       string
+	LocalNames []string
+}
+
+// Export represents an exported function.
+type Export struct {
+	Name string
+	Func int
+}
+
+// Opcode represents an instruction in its binary encoding.
+type Opcode byte
+
+// Opcodes
+const (
+	OpUnreachable Opcode = 0x00
+	OpNop         Opcode = 0x01
+	OpIf          Opcode = 0x04
+	OpElse        Opcode = 0x05
+	OpEnd         Opcode = 0x0b
+	OpReturn      Opcode = 0x0f
+	OpCall        Opcode = 0x10
+	OpDrop        Opcode = 0x1a
+	OpLocalGet    Opcode = 0x20
+	OpLocalSet    Opcode = 0x21
+	OpLocalTee    Opcode = 0x22
+	OpI32Const    Opcode = 0x41
+	OpI64Const    Opcode = 0x42
+	OpI32Eqz      Opcode = 0x45
+	OpI32Eq       Opcode = 0x46
+	OpI32Ne       Opcode = 0x47
+	OpI64Eqz      Opcode = 0x50
+	OpI64Eq       Opcode = 0x51
+	OpI64Ne       Opcode = 0x52
+	OpI64LtS      Opcode = 0x53
+	OpI64GtS      Opcode = 0x55
+	OpI64Add      Opcode = 0x7c
+	OpI64Sub      Opcode = 0x7d
+	OpI64Mul      Opcode = 0x7e
+	OpI64DivS     Opcode = 0x7f
+)
+
+var opcodeNames = map[Opcode]string{
+	OpUnreachable: "unreachable",
+	OpNop:         "nop",
+	OpIf:          "if",
+	OpReturn:      "return",
+	OpCall:        "call",
+	OpDrop:        "drop",
+	OpLocalGet:    "local.get",
+	OpLocalSet:    "local.set",
+	OpLocalTee:    "local.tee",
+	OpI32Const:    "i32.const",
+	OpI64Const:    "i64.const",
+	OpI32Eqz:      "i32.eqz",
+	OpI32Eq:       "i32.eq",
+	OpI32Ne:       "i32.ne",
+	OpI64Eqz:      "i64.eqz",
+	OpI64Eq:       "i64.eq",
+	OpI64Ne:       "i64.ne",
+	OpI64LtS:      "i64.lt_s",
+	OpI64GtS:      "i64.gt_s",
+	OpI64Add:      "i64.add",
+	OpI64Sub:      "i64.sub",
+	OpI64Mul:      "i64.mul",
+	OpI64DivS:     "i64.div_s",
+}
+
+func (op Opcode) String() string {
+	if name, ok := opcodeNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("opcode(0x%02x)", byte(op))
+}
+
+// BlockType represents the type of the result of an if block: a value
+// type, or NoResult.
+type BlockType byte
+
+// NoResult is the block type of a block without a result.
+const NoResult BlockType = 0x40
+
+// Instr represents an instruction. Imm is the constant, local index or
+// function index. An if instruction holds its block type and bodies.
+type Instr struct {
+	Op         Opcode
+	Imm        int64
+	Type       BlockType
+	Then, Else []Instr
+}
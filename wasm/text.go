@@ -0,0 +1,96 @@
+package wasm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteText writes m in the text format. Functions and locals are referred
+// to by their names if they have ones.
+func WriteText(w io.Writer, m *Module) error {
+	var out strings.Builder
+	out.WriteString("(module")
+	for i, ft := range m.Types {
+		fmt.Fprintf(&out, "\n  (type (;%d;) (func%s))", i, signature(ft, nil))
+	}
+	for i, f := range m.Funcs {
+		ft := m.Types[f.Type]
+		fmt.Fprintf(&out, "\n  (func %s (type %d)%s", funcRef(m, i), f.Type, signature(ft, f.LocalNames))
+		for j, t := range f.Locals {
+			name := ""
+			if k := len(ft.Params) + j; k < len(f.LocalNames) {
+				name = " $" + f.LocalNames[k]
+			}
+			fmt.Fprintf(&out, " (local%s %s)", name, t)
+		}
+		p := &printer{out: &out, m: m, f: f}
+		p.instrs(f.Body, 2)
+		out.WriteString(")")
+	}
+	for _, e := range m.Exports {
+		fmt.Fprintf(&out, "\n  (export %q (func %s))", e.Name, funcRef(m, e.Func))
+	}
+	out.WriteString(")\n")
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// signature returns the parameters and results of ft, with the names of
+// the parameters if any.
+func signature(ft FuncType, names []string) string {
+	var out strings.Builder
+	for i, t := range ft.Params {
+		if i < len(names) {
+			fmt.Fprintf(&out, " (param $%s %s)", names[i], t)
+		} else {
+			fmt.Fprintf(&out, " (param %s)", t)
+		}
+	}
+	for _, t := range ft.Results {
+		fmt.Fprintf(&out, " (result %s)", t)
+	}
+	return out.String()
+}
+
+func funcRef(m *Module, index int) string {
+	if index < len(m.Funcs) && m.Funcs[index].Name != "" {
+		return "$" + m.Funcs[index].Name
+	}
+	return fmt.Sprint(index)
+}
+
+type printer struct {
+	out *strings.Builder
+	m   *Module
+	f   *Func
+}
+
+func (p *printer) instrs(instrs []Instr, depth int) {
+	indent := strings.Repeat("  ", depth)
+	for _, in := range instrs {
+		p.out.WriteString("\n" + indent + in.Op.String())
+		switch in.Op {
+		case OpI32Const, OpI64Const:
+			fmt.Fprintf(p.out, " %d", in.Imm)
+		case OpLocalGet, OpLocalSet, OpLocalTee:
+			if int(in.Imm) < len(p.f.LocalNames) {
+				p.out.WriteString(" $" + p.f.LocalNames[in.Imm])
+			} else {
+				fmt.Fprintf(p.out, " %d", in.Imm)
+			}
+		case OpCall:
+			p.out.WriteString(" " + funcRef(p.m, int(in.Imm)))
+		case OpIf:
+			if in.Type != NoResult {
+				fmt.Fprintf(p.out, " (result %s)", ValType(in.Type))
+			}
+			p.instrs(in.Then, depth+1)
+			if in.Else != nil {
+				p.out.WriteString("\n" + indent + "else")
+				p.instrs(in.Else, depth+1)
+			}
+			p.out.WriteString("\n" + indent + "end")
+		}
+	}
+}
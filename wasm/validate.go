@@ -0,0 +1,201 @@
+package wasm
+
+import "fmt"
+
+// Validate checks that the types and indices of m are valid, and that every
+// function body leaves operands of the right types on the stack.
+func Validate(m *Module) error {
+	for i, f := range m.Funcs {
+		if f.Type < 0 || f.Type >= len(m.Types) {
+			return fmt.Errorf("wasm: function %d: unknown type %d", i, f.Type)
+		}
+		ft := m.Types[f.Type]
+		v := &validator{m: m, results: ft.Results}
+		v.locals = append(append(v.locals, ft.Params...), f.Locals...)
+		if err := v.block(f.Body, ft.Results); err != nil {
+			return fmt.Errorf("wasm: function %s: %s", funcRef(m, i), err)
+		}
+	}
+	names := map[string]bool{}
+	for _, e := range m.Exports {
+		if names[e.Name] {
+			return fmt.Errorf("wasm: duplicate export %q", e.Name)
+		}
+		names[e.Name] = true
+		if e.Func < 0 || e.Func >= len(m.Funcs) {
+			return fmt.Errorf("wasm: export %q: unknown function %d", e.Name, e.Func)
+		}
+	}
+	return nil
+}
+
+type validator struct {
+	m       *Module
+	locals  []ValType
+	results []ValType
+}
+
+// unknown is the type of an operand popped from the stack after an
+// unconditional branch, which matches any type.
+const unknown ValType = 0
+
+// operands is the stack of operands of a block.
+type operands struct {
+	stack       []ValType
+	unreachable bool
+}
+
+func (o *operands) push(types ...ValType) {
+	o.stack = append(o.stack, types...)
+}
+
+func (o *operands) pop(want ValType) error {
+	if len(o.stack) == 0 {
+		if o.unreachable {
+			return nil
+		}
+		return fmt.Errorf("operand stack underflow; %s expected", want)
+	}
+	got := o.stack[len(o.stack)-1]
+	o.stack = o.stack[:len(o.stack)-1]
+	if want != unknown && got != want {
+		return fmt.Errorf("type mismatch: %s expected, got %s", want, got)
+	}
+	return nil
+}
+
+func (o *operands) popAll(types []ValType) error {
+	for i := len(types) - 1; i >= 0; i-- {
+		if err := o.pop(types[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// block validates instructions which end with operands of the types of
+// results.
+func (v *validator) block(body []Instr, results []ValType) error {
+	o := &operands{}
+	for _, in := range body {
+		if err := v.instr(o, in); err != nil {
+			return fmt.Errorf("%s: %s", in.Op, err)
+		}
+	}
+	if err := o.popAll(results); err != nil {
+		return fmt.Errorf("end: %s", err)
+	}
+	if len(o.stack) > 0 {
+		return fmt.Errorf("end: %d values left on the stack", len(o.stack))
+	}
+	return nil
+}
+
+func (v *validator) local(in Instr) (ValType, error) {
+	if in.Imm < 0 || in.Imm >= int64(len(v.locals)) {
+		return 0, fmt.Errorf("unknown local %d", in.Imm)
+	}
+	return v.locals[in.Imm], nil
+}
+
+func (v *validator) instr(o *operands, in Instr) error {
+	switch in.Op {
+	case OpNop:
+	case OpUnreachable:
+		o.stack, o.unreachable = o.stack[:0], true
+	case OpReturn:
+		if err := o.popAll(v.results); err != nil {
+			return err
+		}
+		o.stack, o.unreachable = o.stack[:0], true
+	case OpDrop:
+		return o.pop(unknown)
+	case OpI32Const:
+		if in.Imm != int64(int32(in.Imm)) {
+			return fmt.Errorf("constant %d out of range", in.Imm)
+		}
+		o.push(I32)
+	case OpI64Const:
+		o.push(I64)
+	case OpLocalGet:
+		t, err := v.local(in)
+		if err != nil {
+			return err
+		}
+		o.push(t)
+	case OpLocalSet, OpLocalTee:
+		t, err := v.local(in)
+		if err != nil {
+			return err
+		}
+		if err := o.pop(t); err != nil {
+			return err
+		}
+		if in.Op == OpLocalTee {
+			o.push(t)
+		}
+	case OpCall:
+		if in.Imm < 0 || in.Imm >= int64(len(v.m.Funcs)) {
+			return fmt.Errorf("unknown function %d", in.Imm)
+		}
+		ft := v.m.Types[v.m.Funcs[in.Imm].Type]
+		if err := o.popAll(ft.Params); err != nil {
+			return err
+		}
+		o.push(ft.Results...)
+	case OpI32Eqz:
+		return unary(o, I32, I32)
+	case OpI64Eqz:
+		return unary(o, I64, I32)
+	case OpI32Eq, OpI32Ne:
+		return binary(o, I32, I32)
+	case OpI64Eq, OpI64Ne, OpI64LtS, OpI64GtS:
+		return binary(o, I64, I32)
+	case OpI64Add, OpI64Sub, OpI64Mul, OpI64DivS:
+		return binary(o, I64, I64)
+	case OpIf:
+		if err := o.pop(I32); err != nil {
+			return err
+		}
+		var results []ValType
+		switch in.Type {
+		case NoResult:
+		case BlockType(I32), BlockType(I64):
+			results = []ValType{ValType(in.Type)}
+			if in.Else == nil {
+				return fmt.Errorf("if with a result must have else")
+			}
+		default:
+			return fmt.Errorf("unsupported block type 0x%02x", byte(in.Type))
+		}
+		if err := v.block(in.Then, results); err != nil {
+			return err
+		}
+		if err := v.block(in.Else, results); err != nil {
+			return fmt.Errorf("else: %s", err)
+		}
+		o.push(results...)
+	default:
+		return fmt.Errorf("unsupported instruction")
+	}
+	return nil
+}
+
+func unary(o *operands, operand, result ValType) error {
+	if err := o.pop(operand); err != nil {
+		return err
+	}
+	o.push(result)
+	return nil
+}
+
+func binary(o *operands, operand, result ValType) error {
+	if err := o.pop(operand); err != nil {
+		return err
+	}
+	if err := o.pop(operand); err != nil {
+		return err
+	}
+	o.push(result)
+	return nil
+}
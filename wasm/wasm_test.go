@@ -0,0 +1,272 @@
+package wasm
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+// factorial returns a module exporting fact, a recursive factorial, and
+// div, which divides its arguments.
+func factorial() *Module {
+	m := &Module{}
+	unary := m.AddType(FuncType{Params: []ValType{I64}, Results: []ValType{I64}})
+	binary := m.AddType(FuncType{Params: []ValType{I64, I64}, Results: []ValType{I64}})
+	m.Funcs = []*Func{
+		{
+			Type: unary,
+			Body: []Instr{
+				{Op: OpLocalGet, Imm: 0},
+				{Op: OpI64Eqz},
+				{Op: OpIf, Type: BlockType(I64),
+					Then: []Instr{{Op: OpI64Const, Imm: 1}},
+					Else: []Instr{
+						{Op: OpLocalGet, Imm: 0},
+						{Op: OpLocalGet, Imm: 0},
+						{Op: OpI64Const, Imm: 1},
+						{Op: OpI64Sub},
+						{Op: OpCall, Imm: 0},
+						{Op: OpI64Mul},
+					},
+				},
+			},
+			Name:       "fact",
+			LocalNames: []string{"n"},
+		},
+		{
+			Type:   binary,
+			Locals: []ValType{I64, I32, I32},
+			Body: []Instr{
+				{Op: OpLocalGet, Imm: 0},
+				{Op: OpLocalGet, Imm: 1},
+				{Op: OpI64DivS},
+				{Op: OpLocalTee, Imm: 2},
+			},
+		},
+	}
+	m.Exports = []Export{{Name: "fact", Func: 0}, {Name: "div", Func: 1}}
+	return m
+}
+
+func TestAddType(t *testing.T) {
+	m := factorial()
+	if i := m.AddType(FuncType{Params: []ValType{I64}, Results: []ValType{I64}}); i != 0 {
+		t.Errorf("existing type not found. got=%d", i)
+	}
+	if i := m.AddType(FuncType{Results: []ValType{I64}}); i != 2 {
+		t.Errorf("new type not added. got=%d", i)
+	}
+}
+
+func TestText(t *testing.T) {
+	expected := `(module
+  (type (;0;) (func (param i64) (result i64)))
+  (type (;1;) (func (param i64) (param i64) (result i64)))
+  (func $fact (type 0) (param $n i64) (result i64)
+    local.get $n
+    i64.eqz
+    if (result i64)
+      i64.const 1
+    else
+      local.get $n
+      local.get $n
+      i64.const 1
+      i64.sub
+      call $fact
+      i64.mul
+    end)
+  (func 1 (type 1) (param i64) (param i64) (result i64) (local i64) (local i32) (local i32)
+    local.get 0
+    local.get 1
+    i64.div_s
+    local.tee 2)
+  (export "fact" (func $fact))
+  (export "div" (func 1)))
+`
+	var out strings.Builder
+	if err := WriteText(&out, factorial()); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != expected {
+		t.Errorf("text wrong. want=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func TestLEB128(t *testing.T) {
+	unsigned := []struct {
+		n        int
+		expected []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x01}},
+		{624485, []byte{0xe5, 0x8e, 0x26}},
+	}
+	for _, tt := range unsigned {
+		got := appendU32(nil, tt.n)
+		if !bytes.Equal(got, tt.expected) {
+			t.Errorf("unsigned LEB128 of %d wrong. want=%x, got=%x", tt.n, tt.expected, got)
+		}
+		d := &decoder{data: got}
+		if n := d.u32(); n != tt.n || d.err != nil {
+			t.Errorf("decoded %x wrong. want=%d, got=%d (%v)", got, tt.n, n, d.err)
+		}
+	}
+
+	signed := []struct {
+		n        int64
+		expected []byte
+	}{
+		{0, []byte{0x00}},
+		{-1, []byte{0x7f}},
+		{63, []byte{0x3f}},
+		{64, []byte{0xc0, 0x00}},
+		{-64, []byte{0x40}},
+		{-123456, []byte{0xc0, 0xbb, 0x78}},
+		{math.MaxInt64, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00}},
+		{math.MinInt64, []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x7f}},
+	}
+	for _, tt := range signed {
+		got := appendS64(nil, tt.n)
+		if !bytes.Equal(got, tt.expected) {
+			t.Errorf("signed LEB128 of %d wrong. want=%x, got=%x", tt.n, tt.expected, got)
+		}
+		d := &decoder{data: got}
+		if n := d.s64(); n != tt.n || d.err != nil {
+			t.Errorf("decoded %x wrong. want=%d, got=%d (%v)", got, tt.n, n, d.err)
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	m := factorial()
+	data := Encode(m)
+	if !bytes.HasPrefix(data, magic) {
+		t.Fatalf("no header: %x", data[:8])
+	}
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want, got strings.Builder
+	WriteText(&want, m)
+	WriteText(&got, decoded)
+	if got.String() != want.String() {
+		t.Errorf("decoded module differs. want=\n%s\ngot=\n%s", want.String(), got.String())
+	}
+	if !bytes.Equal(Encode(decoded), data) {
+		t.Errorf("encoding of the decoded module differs")
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid := Encode(factorial())
+	tests := []struct {
+		data     []byte
+		expected string
+	}{
+		{[]byte("\x00asm\x02\x00\x00\x00"), "wasm: not a module of version 1"},
+		{valid[:len(valid)-3], "exceeds the module"},
+		{append(append([]byte(nil), magic...), 2, 0), "unsupported section 2"},
+		{append(append([]byte(nil), magic...), 3, 1, 0, 1, 1, 0), "section 1 out of order"},
+		{append(append([]byte(nil), magic...), 1, 4, 1, 0x60, 1, 0x7d), "unsupported value type 0x7d"},
+		{append(append([]byte(nil), magic...), 3, 2, 1, 0), "1 functions without bodies"},
+		{append(append([]byte(nil), magic...), 3, 2, 1, 0, 10, 5, 1, 3, 0, 0xfc, 0x0b), "unsupported opcode 0xfc"},
+		{append(append([]byte(nil), magic...), 3, 2, 1, 0, 10, 3, 1, 1, 0), "unexpected end"},
+		{append(append([]byte(nil), magic...), 3, 2, 1, 0, 10, 5, 1, 3, 0, 0x05, 0x0b), "else outside of if"},
+	}
+	for _, tt := range tests {
+		_, err := Decode(tt.data)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("error for %x wrong. want=%q, got=%v", tt.data, tt.expected, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		body     []Instr
+		expected string
+	}{
+		{[]Instr{{Op: OpI64Const, Imm: 1}}, ""},
+		{[]Instr{}, "end: operand stack underflow; i64 expected"},
+		{[]Instr{{Op: OpI32Const, Imm: 1}}, "end: type mismatch: i64 expected, got i32"},
+		{[]Instr{{Op: OpI64Const}, {Op: OpI64Const}}, "end: 1 values left on the stack"},
+		{[]Instr{{Op: OpI64Const}, {Op: OpI64Add}}, "i64.add: operand stack underflow; i64 expected"},
+		{[]Instr{{Op: OpLocalGet, Imm: 1}}, "local.get: unknown local 1"},
+		{[]Instr{{Op: OpCall, Imm: 5}}, "call: unknown function 5"},
+		{[]Instr{{Op: OpI32Const, Imm: 1 << 40}}, "i32.const: constant 1099511627776 out of range"},
+		{[]Instr{{Op: OpLocalGet}, {Op: OpCall, Imm: 0}}, ""},
+		{[]Instr{{Op: OpI64Const}, {Op: OpReturn}, {Op: OpI64Add}}, ""},
+		{[]Instr{{Op: OpUnreachable}}, ""},
+		{[]Instr{{Op: OpI32Const}, {Op: OpIf, Type: BlockType(I64), Then: []Instr{{Op: OpI64Const}}}},
+			"if: if with a result must have else"},
+		{[]Instr{{Op: OpI32Const}, {Op: OpIf, Type: BlockType(I64),
+			Then: []Instr{{Op: OpI64Const}}, Else: []Instr{{Op: OpI32Const}}}},
+			"if: else: end: type mismatch: i64 expected, got i32"},
+		{[]Instr{{Op: OpI64Const}, {Op: OpIf, Type: NoResult}, {Op: OpI64Const}},
+			"if: type mismatch: i32 expected, got i64"},
+		{[]Instr{{Op: OpI32Const}, {Op: OpIf, Type: NoResult, Then: []Instr{{Op: OpI64Const}}}, {Op: OpI64Const}},
+			"if: end: 1 values left on the stack"},
+	}
+	for _, tt := range tests {
+		m := &Module{}
+		m.Funcs = []*Func{{
+			Type: m.AddType(FuncType{Params: []ValType{I64}, Results: []ValType{I64}}),
+			Body: tt.body,
+		}}
+		err := Validate(m)
+		got := ""
+		if err != nil {
+			got = strings.TrimPrefix(err.Error(), "wasm: function 0: ")
+		}
+		if got != tt.expected {
+			t.Errorf("validation error wrong. want=%q, got=%q", tt.expected, got)
+		}
+	}
+
+	m := factorial()
+	m.Exports = append(m.Exports, Export{Name: "fact", Func: 1})
+	if err := Validate(m); err == nil || err.Error() != `wasm: duplicate export "fact"` {
+		t.Errorf("duplicate export not reported. got=%v", err)
+	}
+}
+
+func TestInvoke(t *testing.T) {
+	in, err := Instantiate(factorial())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		args     []uint64
+		expected uint64
+		err      string
+	}{
+		{"fact", []uint64{0}, 1, ""},
+		{"fact", []uint64{20}, 2432902008176640000, ""},
+		{"div", []uint64{7, uint64(-2 & math.MaxUint64)}, uint64(-3 & math.MaxUint64), ""},
+		{"div", []uint64{7, 0}, 0, "wasm trap: integer divide by zero"},
+		{"div", []uint64{1 << 63, math.MaxUint64}, 0, "wasm trap: integer overflow"},
+		{"fact", []uint64{math.MaxUint64}, 0, "wasm trap: call stack exhausted"},
+		{"fact", nil, 0, "wasm: fact takes 1 arguments, got 0"},
+		{"main", nil, 0, `wasm: no exported function "main"`},
+	}
+	for _, tt := range tests {
+		results, err := in.Invoke(tt.name, tt.args...)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s%v: error wrong. want=%q, got=%v", tt.name, tt.args, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s%v: %s", tt.name, tt.args, err)
+			continue
+		}
+		if len(results) != 1 || results[0] != tt.expected {
+			t.Errorf("%s%v wrong. want=%d, got=%v", tt.name, tt.args, tt.expected, results)
+		}
+	}
+}
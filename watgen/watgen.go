@@ -0,0 +1,578 @@
+// Package watgen compiles the integer, boolean and function subset of
+// Monkey programs into WebAssembly modules.
+//
+// Integers are i64 and booleans are i32, so a program must type check and
+// every value must be an integer or a boolean. Unlike the interpreter,
+// integers are 64-bit and wrap around on overflow, and a division by zero
+// traps. Functions must be declared by top-level let statements and may
+// use only their parameters, their local variables and the top-level
+// functions, which they can call but not pass as values.
+//
+// Every top-level function is exported by its name, and the top-level
+// statements become the exported function "main", which returns the value
+// of the program unless it is null. Unsupported constructs are reported
+// with their positions.
+package watgen
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/resolve"
+	"github.com/oohira/monkey/token"
+	"github.com/oohira/monkey/types"
+	"github.com/oohira/monkey/wasm"
+)
+
+// Main is the name of the exported function evaluating the top-level
+// statements of a program.
+const Main = "main"
+
+// Error represents a construct which cannot be compiled.
+type Error struct {
+	Pos token.Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
+// function represents a function of the module. lit is nil for main.
+type function struct {
+	index  int
+	name   string
+	lit    *ast.FunctionLiteral
+	decl   token.Position
+	node   ast.Node // the node of the scope of the function
+	sig    wasm.FuncType
+	locals map[string]int
+	wasm   *wasm.Func
+	calls  []*function
+}
+
+// local returns the index of the local variable name, adding it with type
+// t if absent.
+func (c *compiler) local(name string, t wasm.ValType, pos token.Position) int {
+	f := c.fn
+	if i, ok := f.locals[name]; ok {
+		if got := c.localType(i); got != t {
+			c.errorf(pos, "%s is %s and %s in the same function", name, typeName(got), typeName(t))
+		}
+		return i
+	}
+	i := len(f.sig.Params) + len(f.wasm.Locals)
+	f.locals[name] = i
+	f.wasm.Locals = append(f.wasm.Locals, t)
+	f.wasm.LocalNames = append(f.wasm.LocalNames, name)
+	return i
+}
+
+func (c *compiler) localType(i int) wasm.ValType {
+	if params := c.fn.sig.Params; i < len(params) {
+		return params[i]
+	}
+	return c.fn.wasm.Locals[i-len(c.fn.sig.Params)]
+}
+
+// mainCall represents a call from the top-level statements.
+type mainCall struct {
+	pos    token.Position
+	callee *function
+}
+
+type compiler struct {
+	types     *types.Info
+	resolve   *resolve.Info
+	module    *wasm.Module
+	funcs     map[*resolve.Symbol]*function
+	rejected  map[*resolve.Symbol]bool // top-level functions not supported
+	fn        *function
+	mainCalls []mainCall
+	errors    []*Error
+}
+
+func (c *compiler) errorf(pos token.Position, format string, args ...interface{}) {
+	c.errors = append(c.errors, &Error{pos, fmt.Sprintf(format, args...)})
+}
+
+// Compile compiles program into a module, or returns the type errors and
+// the unsupported constructs of program sorted by position.
+func Compile(program *ast.Program) (*wasm.Module, []*Error) {
+	info := types.Check(program)
+	if len(info.Errors) > 0 {
+		errs := make([]*Error, len(info.Errors))
+		for i, err := range info.Errors {
+			errs[i] = &Error{err.Pos, err.Msg}
+		}
+		return nil, errs
+	}
+	c := &compiler{
+		types:    info,
+		resolve:  resolve.Resolve(program),
+		module:   &wasm.Module{},
+		funcs:    map[*resolve.Symbol]*function{},
+		rejected: map[*resolve.Symbol]bool{},
+	}
+
+	var fns []*function
+	for _, stmt := range program.Statements {
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok {
+			continue
+		}
+		lit, ok := let.Value.(*ast.FunctionLiteral)
+		if !ok {
+			continue
+		}
+		if f := c.declare(let, lit, len(fns)); f != nil {
+			fns = append(fns, f)
+		} else {
+			c.rejected[c.resolve.Defs[let.Name]] = true
+		}
+	}
+	main := &function{
+		index:  len(fns),
+		name:   Main,
+		node:   program,
+		sig:    wasm.FuncType{Results: c.mainResults(program)},
+		locals: map[string]int{},
+		wasm:   &wasm.Func{Name: Main},
+	}
+
+	for _, f := range fns {
+		c.fn = f
+		t := f.sig.Results[0]
+		c.statements(f.lit.Body.Statements, &t, f.lit.Body.Token.Pos)
+	}
+	c.fn = main
+	var result *wasm.ValType
+	if len(main.sig.Results) > 0 {
+		result = &main.sig.Results[0]
+	}
+	c.statements(program.Statements, result, token.Position{Line: 1, Column: 1})
+	c.checkOrder()
+
+	if len(c.errors) > 0 {
+		sort.SliceStable(c.errors, func(i, j int) bool {
+			return c.errors[i].Pos.Offset < c.errors[j].Pos.Offset
+		})
+		return nil, c.errors
+	}
+	for _, f := range append(fns, main) {
+		f.wasm.Type = c.module.AddType(f.sig)
+		c.module.Funcs = append(c.module.Funcs, f.wasm)
+		c.module.Exports = append(c.module.Exports, wasm.Export{Name: f.name, Func: f.index})
+	}
+	return c.module, nil
+}
+
+// declare returns the function declared by a top-level let statement, or
+// nil if its name or its signature is not supported.
+func (c *compiler) declare(let *ast.LetStatement, lit *ast.FunctionLiteral, index int) *function {
+	sym := c.resolve.Defs[let.Name]
+	pos := let.Name.Token.Pos
+	if let.Name.Value == Main {
+		c.errorf(pos, "%s is reserved for the top-level statements", Main)
+		return nil
+	}
+	for _, other := range c.resolve.Root.Symbols {
+		if other != sym && other.Name == sym.Name {
+			if other.Decl.Token.Pos.Offset < pos.Offset {
+				c.errorf(pos, "%s redeclared; functions must have unique names", sym.Name)
+			}
+			return nil
+		}
+	}
+
+	ft, ok := c.types.TypeOf(lit).(*types.Func)
+	if !ok {
+		c.errorf(pos, "cannot determine the type of %s", sym.Name)
+		return nil
+	}
+	f := &function{
+		index:  index,
+		name:   sym.Name,
+		lit:    lit,
+		decl:   let.Token.Pos,
+		node:   lit,
+		locals: map[string]int{},
+		wasm:   &wasm.Func{Name: sym.Name},
+	}
+	for i, param := range lit.Parameters {
+		t, ok := c.valType(ft.Params[i], param.Token.Pos, "parameter "+param.Value)
+		if !ok {
+			return nil
+		}
+		// a duplicate parameter is bound to the last argument
+		f.locals[param.Value] = i
+		f.sig.Params = append(f.sig.Params, t)
+		f.wasm.LocalNames = append(f.wasm.LocalNames, param.Value)
+	}
+	t, ok := c.valType(ft.Result, pos, "the result of "+sym.Name)
+	if !ok {
+		return nil
+	}
+	f.sig.Results = []wasm.ValType{t}
+	c.funcs[sym] = f
+	return f
+}
+
+// valType returns the value type of t, or reports that what, which has
+// the type t, is not supported.
+func (c *compiler) valType(t types.Type, pos token.Position, what string) (wasm.ValType, bool) {
+	switch t {
+	case types.Int:
+		return wasm.I64, true
+	case types.Bool:
+		return wasm.I32, true
+	}
+	switch t.(type) {
+	case *types.Var:
+		c.errorf(pos, "cannot determine the type of %s; annotate it as int or bool", what)
+	case *types.Func:
+		c.errorf(pos, "%s is a function, which is not supported as a value", what)
+	default:
+		c.errorf(pos, "%s is %s, which is not supported", what, t)
+	}
+	return 0, false
+}
+
+// typeOf returns the value type of exp, which is used to continue
+// compiling after an error. It defaults to i64.
+func (c *compiler) typeOf(exp ast.Expression) wasm.ValType {
+	if c.types.TypeOf(exp) == types.Bool {
+		return wasm.I32
+	}
+	return wasm.I64
+}
+
+func typeName(t wasm.ValType) string {
+	if t == wasm.I64 {
+		return "int"
+	}
+	return "bool"
+}
+
+// mainResults returns the results of main, which are the type of the last
+// statement if it is an expression, or else the type of the values of
+// the top-level return statements.
+func (c *compiler) mainResults(program *ast.Program) []wasm.ValType {
+	stmts := program.Statements
+	if len(stmts) == 0 {
+		return nil
+	}
+	if es, ok := stmts[len(stmts)-1].(*ast.ExpressionStatement); ok {
+		t := c.types.TypeOf(es.Expression)
+		if t != types.Null {
+			if vt, ok := c.valType(t, startPos(es.Expression), "the value of the program"); ok {
+				return []wasm.ValType{vt}
+			}
+			return nil
+		}
+	}
+	var results []wasm.ValType
+	for _, stmt := range stmts {
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FunctionLiteral:
+				return false
+			case *ast.ReturnStatement:
+				if results == nil {
+					if vt, ok := c.valType(c.types.TypeOf(n.ReturnValue), n.Token.Pos, "the value of the program"); ok {
+						results = []wasm.ValType{vt}
+					}
+				}
+			}
+			return true
+		})
+	}
+	return results
+}
+
+// checkOrder reports the calls from the top-level statements which may
+// reach a function before its declaration is evaluated.
+func (c *compiler) checkOrder() {
+	for _, call := range c.mainCalls {
+		seen := map[*function]bool{}
+		var visit func(f *function) *function
+		visit = func(f *function) *function {
+			if seen[f] {
+				return nil
+			}
+			seen[f] = true
+			if f.decl.Offset > call.pos.Offset {
+				return f
+			}
+			for _, g := range f.calls {
+				if late := visit(g); late != nil {
+					return late
+				}
+			}
+			return nil
+		}
+		if late := visit(call.callee); late != nil {
+			c.errorf(call.pos, "call of %s before the declaration of %s", call.callee.name, late.name)
+		}
+	}
+}
+
+func (c *compiler) emit(body *[]wasm.Instr, op wasm.Opcode, imm int64) {
+	*body = append(*body, wasm.Instr{Op: op, Imm: imm})
+}
+
+// statements compiles stmts into c.fn, leaving the value of the last
+// statement of type *result on the stack, or no value if result is nil.
+// pos is the position of the statements used in errors.
+func (c *compiler) statements(stmts []ast.Statement, result *wasm.ValType, pos token.Position) {
+	c.fn.wasm.Body = c.block(stmts, result, pos)
+}
+
+// block returns the instructions of stmts like statements.
+func (c *compiler) block(stmts []ast.Statement, result *wasm.ValType, pos token.Position) []wasm.Instr {
+	body := []wasm.Instr{}
+	if len(stmts) == 0 && result != nil {
+		c.errorf(pos, "an empty block has no value")
+	}
+	for i, stmt := range stmts {
+		last := i == len(stmts)-1
+		switch stmt := stmt.(type) {
+		case *ast.ReturnStatement:
+			t := c.expression(&body, stmt.ReturnValue)
+			if len(c.fn.sig.Results) == 0 {
+				c.errorf(stmt.Token.Pos, "return of a value from a program without value")
+			} else if t != c.fn.sig.Results[0] {
+				c.errorf(stmt.Token.Pos, "return of %s from a program of %s", typeName(t), typeName(c.fn.sig.Results[0]))
+			}
+			c.emit(&body, wasm.OpReturn, 0)
+			// the rest of the statements is never evaluated
+			return body
+		case *ast.LetStatement:
+			if last && result != nil {
+				c.errorf(stmt.Token.Pos, "a let statement has no value; end the block with an expression")
+			}
+			c.let(&body, stmt)
+		case *ast.ExpressionStatement:
+			if last && result != nil {
+				if t := c.expression(&body, stmt.Expression); t != *result {
+					c.errorf(startPos(stmt.Expression), "%s expected, got %s", typeName(*result), typeName(t))
+				}
+				continue
+			}
+			if ie, ok := stmt.Expression.(*ast.IfExpression); ok {
+				c.ifExpression(&body, ie, nil)
+				continue
+			}
+			c.expression(&body, stmt.Expression)
+			c.emit(&body, wasm.OpDrop, 0)
+		case *ast.BlockStatement:
+			var r *wasm.ValType
+			if last {
+				r = result
+			}
+			body = append(body, c.block(stmt.Statements, r, stmt.Token.Pos)...)
+		}
+	}
+	return body
+}
+
+func (c *compiler) let(body *[]wasm.Instr, let *ast.LetStatement) {
+	if lit, ok := let.Value.(*ast.FunctionLiteral); ok {
+		// top-level functions are declared by declare
+		if c.fn.lit != nil || !c.isTopLevel(let) {
+			c.errorf(lit.Token.Pos, "unsupported function literal; functions must be declared by top-level let statements")
+		}
+		return
+	}
+	t := c.expression(body, let.Value)
+	c.emit(body, wasm.OpLocalSet, int64(c.local(let.Name.Value, t, let.Name.Token.Pos)))
+}
+
+// isTopLevel reports whether let is a statement of the program.
+func (c *compiler) isTopLevel(let *ast.LetStatement) bool {
+	sym := c.resolve.Defs[let.Name]
+	return sym != nil && sym.Scope == c.resolve.Root
+}
+
+// expression compiles exp leaving its value on the stack and returns its
+// type.
+func (c *compiler) expression(body *[]wasm.Instr, exp ast.Expression) wasm.ValType {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		if exp.Big != nil {
+			c.errorf(exp.Token.Pos, "integer %s overflows int64", exp.Big)
+		}
+		c.emit(body, wasm.OpI64Const, exp.Value)
+		return wasm.I64
+	case *ast.Boolean:
+		v := int64(0)
+		if exp.Value {
+			v = 1
+		}
+		c.emit(body, wasm.OpI32Const, v)
+		return wasm.I32
+	case *ast.Identifier:
+		return c.identifier(body, exp)
+	case *ast.PrefixExpression:
+		if exp.Operator == "-" {
+			c.emit(body, wasm.OpI64Const, 0)
+			c.expression(body, exp.Right)
+			c.emit(body, wasm.OpI64Sub, 0)
+			return wasm.I64
+		}
+		// an integer is always truthy
+		if c.expression(body, exp.Right) == wasm.I64 {
+			c.emit(body, wasm.OpDrop, 0)
+			c.emit(body, wasm.OpI32Const, 0)
+		} else {
+			c.emit(body, wasm.OpI32Eqz, 0)
+		}
+		return wasm.I32
+	case *ast.InfixExpression:
+		t := c.expression(body, exp.Left)
+		c.expression(body, exp.Right)
+		op, result := infixOp(exp.Operator, t)
+		c.emit(body, op, 0)
+		return result
+	case *ast.IfExpression:
+		t, ok := c.valType(c.types.TypeOf(exp), exp.Token.Pos, "the value of the if expression")
+		if !ok {
+			return wasm.I64
+		}
+		c.ifExpression(body, exp, &t)
+		return t
+	case *ast.CallExpression:
+		return c.call(body, exp)
+	case *ast.FunctionLiteral:
+		c.errorf(exp.Token.Pos, "unsupported function literal; functions must be declared by top-level let statements")
+	default:
+		c.errorf(startPos(exp), "unsupported expression %s", exp)
+	}
+	return wasm.I64
+}
+
+// infixOp returns the instruction of an operator on operands of type t and
+// the type of its result.
+func infixOp(operator string, t wasm.ValType) (wasm.Opcode, wasm.ValType) {
+	switch operator {
+	case "+":
+		return wasm.OpI64Add, wasm.I64
+	case "-":
+		return wasm.OpI64Sub, wasm.I64
+	case "*":
+		return wasm.OpI64Mul, wasm.I64
+	case "/":
+		return wasm.OpI64DivS, wasm.I64
+	case "<":
+		return wasm.OpI64LtS, wasm.I32
+	case ">":
+		return wasm.OpI64GtS, wasm.I32
+	case "==":
+		if t == wasm.I32 {
+			return wasm.OpI32Eq, wasm.I32
+		}
+		return wasm.OpI64Eq, wasm.I32
+	}
+	if t == wasm.I32 {
+		return wasm.OpI32Ne, wasm.I32
+	}
+	return wasm.OpI64Ne, wasm.I32
+}
+
+// ifExpression compiles an if expression with a value of type *result, or
+// without value if result is nil.
+func (c *compiler) ifExpression(body *[]wasm.Instr, ie *ast.IfExpression, result *wasm.ValType) {
+	// an integer condition is always truthy
+	if c.expression(body, ie.Condition) == wasm.I64 {
+		c.emit(body, wasm.OpDrop, 0)
+		c.emit(body, wasm.OpI32Const, 1)
+	}
+	in := wasm.Instr{Op: wasm.OpIf, Type: wasm.NoResult}
+	if result != nil {
+		in.Type = wasm.BlockType(*result)
+		if ie.Alternative == nil {
+			c.errorf(ie.Token.Pos, "an if expression without else has no value")
+		}
+	}
+	in.Then = c.block(ie.Consequence.Statements, result, ie.Consequence.Token.Pos)
+	if ie.Alternative != nil {
+		in.Else = c.block(ie.Alternative.Statements, result, ie.Alternative.Token.Pos)
+	}
+	*body = append(*body, in)
+}
+
+func (c *compiler) identifier(body *[]wasm.Instr, ident *ast.Identifier) wasm.ValType {
+	sym := c.resolve.Uses[ident]
+	if sym == nil {
+		c.errorf(ident.Token.Pos, "undefined: %s", ident.Value)
+		return wasm.I64
+	}
+	if c.rejected[sym] {
+		return c.typeOf(ident)
+	}
+	if c.funcs[sym] != nil {
+		c.errorf(ident.Token.Pos, "%s is a function, which is not supported as a value", ident.Value)
+		return wasm.I64
+	}
+	s := sym.Scope
+	for s.Kind == resolve.BlockScope {
+		s = s.Outer
+	}
+	i, ok := c.fn.locals[ident.Value]
+	if s.Node != c.fn.node || !ok {
+		c.errorf(ident.Token.Pos, "%s is declared outside the function; functions can use only their parameters, local variables and top-level functions", ident.Value)
+		return wasm.I64
+	}
+	c.emit(body, wasm.OpLocalGet, int64(i))
+	return c.localType(i)
+}
+
+func (c *compiler) call(body *[]wasm.Instr, call *ast.CallExpression) wasm.ValType {
+	ident, ok := call.Function.(*ast.Identifier)
+	var callee *function
+	if ok {
+		sym := c.resolve.Uses[ident]
+		if c.rejected[sym] {
+			return c.typeOf(call)
+		}
+		callee = c.funcs[sym]
+	}
+	if callee == nil {
+		c.errorf(startPos(call.Function), "unsupported call of %s; only top-level functions can be called", call.Function)
+		return c.typeOf(call)
+	}
+	for _, arg := range call.Arguments {
+		c.expression(body, arg)
+	}
+	c.emit(body, wasm.OpCall, int64(callee.index))
+	if c.fn.lit == nil {
+		c.mainCalls = append(c.mainCalls, mainCall{ident.Token.Pos, callee})
+	} else {
+		c.fn.calls = append(c.fn.calls, callee)
+	}
+	return callee.sig.Results[0]
+}
+
+// startPos returns the position of the first token of an expression.
+func startPos(exp ast.Expression) token.Position {
+	switch exp := exp.(type) {
+	case *ast.InfixExpression:
+		return startPos(exp.Left)
+	case *ast.CallExpression:
+		return startPos(exp.Function)
+	case *ast.Identifier:
+		return exp.Token.Pos
+	case *ast.IntegerLiteral:
+		return exp.Token.Pos
+	case *ast.Boolean:
+		return exp.Token.Pos
+	case *ast.PrefixExpression:
+		return exp.Token.Pos
+	case *ast.IfExpression:
+		return exp.Token.Pos
+	case *ast.FunctionLiteral:
+		return exp.Token.Pos
+	}
+	return token.Position{}
+}
@@ -0,0 +1,247 @@
+package watgen
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/oohira/monkey/ast"
+	"github.com/oohira/monkey/evaluator"
+	"github.com/oohira/monkey/lexer"
+	"github.com/oohira/monkey/object"
+	"github.com/oohira/monkey/parser"
+	"github.com/oohira/monkey/wasm"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func compile(t *testing.T, input string) *wasm.Module {
+	t.Helper()
+	m, errs := Compile(parse(t, input))
+	if len(errs) > 0 {
+		t.Fatalf("compile errors for %q: %v", input, errs)
+	}
+	return m
+}
+
+var programs = []string{
+	"5",
+	"-7 + 2 * 3",
+	"7 / -2",
+	"1 < 2 == true",
+	"true != false",
+	"!5",
+	"!!true",
+	"let a = 5; let a = a * 2; a",
+	"let x = 1; if (true) { let x = 2 }; x",
+	"if (0) { 1 } else { 2 }",
+	"if (1 > 2) { true } else { false }",
+	"if (false) { 1 }",
+	"let x = 3;",
+	"return 4; 5",
+	"if (true) { return 1 }; 2",
+	"let max = fn(a, b) { if (a > b) { a } else { b } }; max(3, max(8, 5))",
+	"let fib = fn(n) { if (n < 2) { return n } fib(n - 1) + fib(n - 2) }; fib(20)",
+	"let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };" +
+		"let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; even(10)",
+	"let f = fn(x: bool) { let y = if (x) { return 10 } else { 20 }; y + 1 }; f(true) * f(false)",
+	"let f = fn(n) { let m = n * 2; if (m > 10) { let m = m - 10; m } else { m } }; f(3) + f(8)",
+	"let f = fn(x: int) -> bool { x > 0 }; if (f(2)) { 1 } else { 0 }",
+	"let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } }; sum(100, 0)",
+	"let f = fn() { 1; 2; 3 }; f()",
+	"let g = fn(b: bool) { !b }; g(g(true))",
+	"9223372036854775807",
+	"let f = fn(x: int, x: int) { x }; f(1, 2)",
+	"let f = fn(x: int, x: bool) { x }; if (f(1, true)) { 1 } else { 0 }",
+}
+
+// run runs main of m with the interpreter and returns its output in the
+// format of the evaluator.
+func run(t *testing.T, m *wasm.Module) string {
+	t.Helper()
+	in, err := wasm.Instantiate(m)
+	if err != nil {
+		t.Fatalf("invalid module: %s", err)
+	}
+	results, err := in.Invoke(Main)
+	if err != nil {
+		return err.Error()
+	}
+	if len(results) == 0 {
+		return ""
+	}
+	ft := m.Types[m.Funcs[len(m.Funcs)-1].Type]
+	if ft.Results[0] == wasm.I32 {
+		return fmt.Sprint(results[0] != 0)
+	}
+	return fmt.Sprint(int64(results[0]))
+}
+
+func evaluate(program *ast.Program) string {
+	switch result := evaluator.Eval(program, object.NewEnvironment()).(type) {
+	case *object.Null:
+		return ""
+	default:
+		return result.Inspect()
+	}
+}
+
+// TestRun compiles programs, encodes and decodes the modules, and compares
+// the results of the interpreter with those of the evaluator.
+func TestRun(t *testing.T) {
+	for _, input := range programs {
+		m := compile(t, input)
+		data := wasm.Encode(m)
+		decoded, err := wasm.Decode(data)
+		if err != nil {
+			t.Errorf("decode error for %q: %s", input, err)
+			continue
+		}
+		if !bytes.Equal(wasm.Encode(decoded), data) {
+			t.Errorf("module of %q changed by decoding", input)
+		}
+		want := evaluate(parse(t, input))
+		if got := run(t, decoded); got != want {
+			t.Errorf("result of %q wrong. want=%q, got=%q", input, want, got)
+		}
+	}
+}
+
+func TestTraps(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 / 0", "wasm trap: integer divide by zero"},
+		{"let f = fn(x) { 10 / x }; f(0)", "wasm trap: integer divide by zero"},
+		{"let f = fn(n) -> int { f(n + 1) }; f(0)", "wasm trap: call stack exhausted"},
+	}
+	for _, tt := range tests {
+		if got := run(t, compile(t, tt.input)); got != tt.expected {
+			t.Errorf("result of %q wrong. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"1 + true", []string{"1:5: type mismatch: expected int, got bool"}},
+		{"let id = fn(x) { x }; id(1)",
+			[]string{"1:13: cannot determine the type of parameter x; annotate it as int or bool"}},
+		{"let f = fn() { let x = 1; }; 1",
+			[]string{"1:5: the result of f is null, which is not supported"}},
+		{"let add = fn(a) { fn(b) { a + b } }; add(1)(2)",
+			[]string{"1:5: the result of add is a function, which is not supported as a value",
+				"1:38: unsupported call of add(1); only top-level functions can be called"}},
+		{"let f = fn(x: int) { x }; let g = f; 1",
+			[]string{"1:35: f is a function, which is not supported as a value"}},
+		{"let x = 2; let f = fn(y: int) { x * y }; f(3)",
+			[]string{"1:33: x is declared outside the function; functions can use only their parameters, local variables and top-level functions"}},
+		{"if (true) { let f = fn(x: int) { x }; 1 } else { 2 }",
+			[]string{"1:21: unsupported function literal; functions must be declared by top-level let statements"}},
+		{"99999999999999999999", []string{"1:1: integer 99999999999999999999 overflows int64"}},
+		{"let x = if (true) { 1 }; 2", []string{"1:9: the value of the if expression is null, which is not supported"}},
+		{"let x = 1; if (true) { let x = false }; 2", []string{"1:32: type mismatch: expected int, got bool"}},
+		{"let main = fn() { 1 }; main()", []string{"1:5: main is reserved for the top-level statements"}},
+		{"let f = fn() { 1 }; let f = fn() { 2 }; f()", []string{"1:25: f redeclared; functions must have unique names"}},
+		{"let f = fn() { g() }; let x = f(); let g = fn() { 1 }; x",
+			[]string{"1:31: call of f before the declaration of g"}},
+		{"if (true) { return 1 }; false", []string{"1:13: return of int from a program of bool"}},
+	}
+	for _, tt := range tests {
+		_, errs := Compile(parse(t, tt.input))
+		got := make([]string, len(errs))
+		for i, err := range errs {
+			got[i] = err.Error()
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("errors of %q wrong.\nwant=%q\ngot= %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	input := "let fib = fn(n) { if (n < 2) { return n } fib(n - 1) + fib(n - 2) };\n" +
+		"let x = fib(10); x > 50"
+	expected := `(module
+  (type (;0;) (func (param i64) (result i64)))
+  (type (;1;) (func (result i32)))
+  (func $fib (type 0) (param $n i64) (result i64)
+    local.get $n
+    i64.const 2
+    i64.lt_s
+    if
+      local.get $n
+      return
+    end
+    local.get $n
+    i64.const 1
+    i64.sub
+    call $fib
+    local.get $n
+    i64.const 2
+    i64.sub
+    call $fib
+    i64.add)
+  (func $main (type 1) (result i32) (local $x i64)
+    i64.const 10
+    call $fib
+    local.set $x
+    local.get $x
+    i64.const 50
+    i64.gt_s)
+  (export "fib" (func $fib))
+  (export "main" (func $main)))
+`
+	var out strings.Builder
+	if err := wasm.WriteText(&out, compile(t, input)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != expected {
+		t.Errorf("text wrong. want=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+// TestNode runs the modules with the WebAssembly engine of Node.js to check
+// the encoder against an independent implementation.
+func TestNode(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+	for _, input := range programs {
+		data := wasm.Encode(compile(t, input))
+		script := fmt.Sprintf(`const bytes = Buffer.from(%q, "base64");
+const { main } = new WebAssembly.Instance(new WebAssembly.Module(bytes)).exports;
+const result = main();
+if (result !== undefined) console.log(String(result));
+`, base64.StdEncoding.EncodeToString(data))
+		cmd := exec.Command(node, "-e", script)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Errorf("node failed for %q: %s\n%s", input, err, out)
+			continue
+		}
+		want := evaluate(parse(t, input))
+		// booleans are i32, which JavaScript sees as numbers
+		want = strings.NewReplacer("true", "1", "false", "0").Replace(want)
+		if got := strings.TrimSuffix(string(out), "\n"); got != want {
+			t.Errorf("result of %q wrong. want=%q, got=%q", input, want, got)
+		}
+	}
+}